@@ -1,63 +1,579 @@
 package main
 
 import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/shenjing023/rbac-api-gateway/internal/apikey"
+	"github.com/shenjing023/rbac-api-gateway/internal/audit"
 	"github.com/shenjing023/rbac-api-gateway/internal/auth"
+	"github.com/shenjing023/rbac-api-gateway/internal/config"
+	"github.com/shenjing023/rbac-api-gateway/internal/extauthz"
 	"github.com/shenjing023/rbac-api-gateway/internal/gateway"
 	"github.com/shenjing023/rbac-api-gateway/internal/post"
 	"github.com/shenjing023/rbac-api-gateway/internal/rbac"
 	"github.com/shenjing023/rbac-api-gateway/internal/user"
 	"github.com/shenjing023/rbac-api-gateway/pkg/cache"
+	"github.com/shenjing023/rbac-api-gateway/pkg/captcha"
 	"github.com/shenjing023/rbac-api-gateway/pkg/database"
+	"github.com/shenjing023/rbac-api-gateway/pkg/directory"
+	"github.com/shenjing023/rbac-api-gateway/pkg/jwt"
+	"github.com/shenjing023/rbac-api-gateway/pkg/notify"
+	"github.com/shenjing023/rbac-api-gateway/pkg/oidc"
+	"github.com/shenjing023/rbac-api-gateway/pkg/opa"
+	"github.com/shenjing023/rbac-api-gateway/pkg/password"
+	"github.com/shenjing023/rbac-api-gateway/pkg/secrets"
+	"github.com/shenjing023/rbac-api-gateway/pkg/server"
+	"google.golang.org/grpc"
+	"gorm.io/gorm"
 )
 
 func main() {
+	// 策略相关的子命令不需要数据库和 HTTP 服务
+	if len(os.Args) > 1 && os.Args[1] == "policy" {
+		os.Exit(runPolicyCommand(os.Args[2:]))
+	}
+
+	configPath := flag.String("config", os.Getenv("GATEWAY_CONFIG"), "配置文件路径，也可以通过 GATEWAY_CONFIG 环境变量指定")
+	flag.Parse()
+
+	// 加载配置，配置无效时拒绝启动
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	gin.SetMode(cfg.Server.Mode)
+
+	// 收到 SIGINT/SIGTERM 后开始优雅退出；后台任务在请求处理完之后再停止
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	background, cancelBackground := context.WithCancel(context.Background())
+	defer cancelBackground()
+	cache.Configure(cfg.Cache.DefaultExpiration, cfg.Cache.CleanupInterval)
+
+	// 读取密钥，来源变化时自动重新加载
+	secretManager, err := newSecretManager(cfg.Secrets)
+	if err != nil {
+		log.Fatalf("Failed to initialize secret providers: %v", err)
+	}
+	jwtSecret, err := secretManager.Watch(background, cfg.JWT.Secret, func(secret []byte) {
+		if err := jwt.SetSecret(secret); err != nil {
+			log.Printf("Failed to rotate jwt secret: %v", err)
+		}
+	})
+	if err != nil {
+		log.Fatalf("Failed to load jwt secret: %v", err)
+	}
+	if err := jwt.Init(jwt.Config{
+		Secret:     jwtSecret,
+		Issuer:     cfg.JWT.Issuer,
+		Expiration: cfg.JWT.Expiration,
+	}); err != nil {
+		log.Fatalf("Failed to initialize jwt: %v", err)
+	}
+
+	var dbPassword atomic.Pointer[string]
+	password, err := secretManager.Watch(background, cfg.Database.Password, func(p []byte) {
+		dbPassword.Store(ptr(string(p)))
+	})
+	if err != nil {
+		log.Fatalf("Failed to load database password: %v", err)
+	}
+	dbPassword.Store(ptr(string(password)))
+
 	// 初始化数据库连接
-	db, err := database.InitDB("127.0.0.1", "postgres", "123456", "postgres", 5432)
+	db, err := database.InitDB(database.Config{
+		DSN:             cfg.Database.DSN(),
+		Password:        func() string { return *dbPassword.Load() },
+		MaxOpenConns:    cfg.Database.MaxOpenConns,
+		MaxIdleConns:    cfg.Database.MaxIdleConns,
+		ConnMaxLifetime: cfg.Database.ConnMaxLifetime,
+	})
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 	// 数据库迁移
-	err = db.AutoMigrate(&user.User{}, &rbac.Role{}, &rbac.Permission{}, &rbac.UserRole{}, &post.Post{})
+	err = db.AutoMigrate(&user.User{}, &rbac.Role{}, &rbac.Permission{}, &rbac.UserRole{}, &rbac.PolicyRevision{}, &rbac.PolicyActivation{}, &post.Post{}, &apikey.APIKey{},
+		&auth.OAuthClient{}, &auth.AuthorizationCode{}, &auth.RefreshToken{}, &auth.RevokedToken{}, &auth.FederatedIdentity{},
+		&auth.TOTPFactor{}, &auth.RecoveryCode{}, &auth.LoginThrottle{}, &audit.Event{},
+		&auth.SessionRevocation{}, &auth.PasswordResetToken{}, &auth.Session{}, &auth.Invitation{})
 	if err != nil {
 		log.Fatalf("Failed to perform database migration: %v", err)
 	}
 
-	// 初始化 OPA
-	if err := rbac.InitOPA(); err != nil {
+	// 初始化策略引擎
+	engine, err := newPolicyEngine(cfg.Policy)
+	if err != nil {
 		log.Fatalf("Failed to initialize OPA: %v", err)
 	}
+	permissionChecker := rbac.NewPermissionChecker(engine)
+	permissionChecker.SetMFARequiredRoles(cfg.Auth.MFA.RequiredRoles)
 
 	// 初始化路由
 	r := gin.Default()
+	// gin 默认信任所有代理的 X-Forwarded-For，登录失败次数按客户端 IP 统计，必须只信任配置的代理
+	if err := r.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		log.Fatalf("Failed to set trusted proxies: %v", err)
+	}
 
 	// 初始化服务
+	auditService := audit.NewService(db)
 	authService := auth.NewService(db)
+	authService.SetAudit(auditService)
+	go authService.RunCleanup(background, time.Hour)
+	if l := cfg.Auth.Lockout; l.Enabled {
+		lockout := auth.NewLockout(db, auth.LockoutConfig{
+			Window:           l.Window,
+			DelayAfter:       l.DelayAfter,
+			BaseDelay:        l.BaseDelay,
+			MaxDelay:         l.MaxDelay,
+			AccountThreshold: l.AccountThreshold,
+			IPThreshold:      l.IPThreshold,
+			LockoutDuration:  l.LockoutDuration,
+		})
+		authService.SetLockout(lockout)
+		go lockout.Run(background)
+	}
 	userService := user.NewService(db)
-	rbacService := rbac.NewService(db)
+	passwordPolicy, err := newPasswordPolicy(cfg.Auth.Password)
+	if err != nil {
+		log.Fatalf("Failed to initialize password policy: %v", err)
+	}
+	authService.SetPasswordPolicy(passwordPolicy)
+	userService.SetPasswordPolicy(passwordPolicy)
+	notifier, err := newNotifier(background, secretManager, cfg.Notifier)
+	if err != nil {
+		log.Fatalf("Failed to initialize notifier: %v", err)
+	}
+	if notifier != nil {
+		authService.SetPasswordReset(notifier, auth.PasswordResetConfig{TTL: cfg.Auth.Password.ResetTTL, URL: cfg.Auth.Password.ResetURL})
+	}
+	if cfg.Auth.Verification.Enabled {
+		authService.SetEmailVerification(notifier, auth.VerificationConfig{TTL: cfg.Auth.Verification.TTL, URL: cfg.Auth.Verification.URL})
+	}
+	registration, err := newRegistration(background, secretManager, cfg.Auth.Registration)
+	if err != nil {
+		log.Fatalf("Failed to initialize registration: %v", err)
+	}
+	authService.SetRegistration(registration)
+	if cfg.Auth.Impersonation.Enabled {
+		authService.SetImpersonation(auth.ImpersonationConfig{MaxTTL: cfg.Auth.Impersonation.MaxTTL})
+	}
+	rbacService := rbac.NewService(db, permissionChecker)
 	// postService := post.NewService(db)
 
-	permissionChecker := rbac.NewPermissionChecker()
+	// 加载数据库中生效的策略版本
+	if err := rbacService.InitPolicy(); err != nil {
+		log.Fatalf("Failed to load policy revision: %v", err)
+	}
+	go rbacService.WatchPolicy(background, cfg.Policy.WatchInterval)
 
 	postService := post.NewService(db)
 	postChecker := post.NewPostChecker(postService, cache.GetInstance())
 	permissionChecker.RegisterResourceChecker("posts", postChecker)
 
+	// 服务间调用可以使用客户端证书代替 bearer token 认证
+	var certAuthenticator *gateway.CertificateAuthenticator
+	if cfg.Auth.MTLS.Enabled {
+		certAuthenticator = gateway.NewCertificateAuthenticator(authService, gateway.IdentitySource(cfg.Auth.MTLS.Identity))
+	}
+	apiKeyService := apikey.NewService(db, authService)
+	authenticator := gateway.NewAuthenticator(certAuthenticator, apiKeyService, authService, authService)
+	var sessionCookie *auth.SessionCookieConfig
+	if sc := cfg.Auth.SessionCookie; sc.Enabled {
+		sessionCookie = &auth.SessionCookieConfig{
+			Name:          sc.Name,
+			CSRFName:      sc.CSRFName,
+			Domain:        sc.Domain,
+			Path:          sc.Path,
+			Secure:        sc.Secure,
+			SameSite:      sameSiteMode(sc.SameSite),
+			LoginRedirect: sc.LoginRedirect,
+		}
+		authService.SetSessionCookie(*sessionCookie)
+		authenticator.SetSessionCookie(sc.Name)
+	}
+	if cfg.Auth.OIDC.Issuer != "" {
+		if err := loadSigningKey(background, secretManager, cfg.Auth.OIDC.SigningKey); err != nil {
+			log.Fatalf("Failed to load oidc signing key: %v", err)
+		}
+	}
+	if cfg.Auth.LDAP.Enabled {
+		ldapDirectory, err := newDirectory(background, db, secretManager, cfg.Auth.LDAP)
+		if err != nil {
+			log.Fatalf("Failed to initialize ldap: %v", err)
+		}
+		authService.SetDirectory(ldapDirectory)
+		if cfg.Auth.LDAP.SyncInterval > 0 {
+			go ldapDirectory.Run(background, cfg.Auth.LDAP.SyncInterval)
+		}
+	}
+	federatedProviders, err := newFederatedProviders(background, secretManager, cfg.Auth.Federation)
+	if err != nil {
+		log.Fatalf("Failed to initialize federated identity providers: %v", err)
+	}
+	federationService := auth.NewFederationService(db, federatedProviders)
+	if sessionCookie != nil {
+		federationService.SetSessionCookie(*sessionCookie)
+	}
+	mfaService := auth.NewMFAService(db, authService, auth.MFAConfig{Issuer: cfg.Auth.MFA.Issuer})
+	oauthService := auth.NewOAuthService(db, authService, userService, auth.OAuthConfig{
+		AccessTokenTTL:  cfg.Auth.OAuth.AccessTokenTTL,
+		RefreshTokenTTL: cfg.Auth.OAuth.RefreshTokenTTL,
+		CodeTTL:         cfg.Auth.OAuth.CodeTTL,
+		Issuer:          cfg.Auth.OIDC.Issuer,
+		IDTokenTTL:      cfg.Auth.OIDC.IDTokenTTL,
+	})
+
 	// 添加网关中间件
-	r.Use(gateway.CORSMiddleware())
-	r.Use(gateway.AuthMiddleware())
+	r.Use(gateway.CORSMiddleware(cfg.CORS))
+	r.Use(gateway.AuthMiddleware(authenticator))
 	r.Use(gateway.RBACMiddleware(permissionChecker))
 
 	// 设置路由
 	auth.RegisterRoutes(r, authService)
+	auth.RegisterOAuthRoutes(r, oauthService)
+	auth.RegisterFederationRoutes(r, federationService)
+	auth.RegisterMFARoutes(r, mfaService)
 	user.RegisterRoutes(r, userService)
 	rbac.RegisterRoutes(r, rbacService)
 	post.RegisterRoutes(r, postService)
+	apikey.RegisterRoutes(r, apiKeyService)
+	audit.RegisterRoutes(r, auditService)
+
+	// 外部代理使用的鉴权入口，与 gin 中间件使用相同的鉴权逻辑
+	authorizer := gateway.NewAuthorizer(authenticator, permissionChecker, gateway.NewRouteMatcher(r.Routes()))
+	// 供 nginx auth_request 和 Traefik ForwardAuth 使用的鉴权接口
+	r.Any("/auth/verify", gateway.ForwardAuthHandler(authorizer))
+	// 供 Envoy/Istio 使用的 ext_authz gRPC 服务
+	var grpcServer *grpc.Server
+	if cfg.Server.ExtAuthzAddr != "" {
+		var extAuthzTLS *tls.Config
+		if tlsCfg := newTLSConfig(cfg.Server.ExtAuthzTLS); tlsCfg != nil {
+			if extAuthzTLS, err = server.NewTLSConfig(background, *tlsCfg); err != nil {
+				log.Fatalf("Failed to load ext_authz TLS certificate: %v", err)
+			}
+		}
+		grpcServer, err = extauthz.ListenAndServe(cfg.Server.ExtAuthzAddr, authorizer, extAuthzTLS)
+		if err != nil {
+			log.Fatalf("Failed to start ext_authz server: %v", err)
+		}
+	}
+
+	srv, err := server.New(newServerConfig(cfg.Server), r)
+	if err != nil {
+		log.Fatalf("Failed to initialize server: %v", err)
+	}
+	// 存活和就绪检查，退出过程中就绪检查返回 503
+	r.GET("/healthz", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/readyz", gin.WrapH(srv.ReadyHandler()))
+
+	// 启动服务器，阻塞到收到退出信号并处理完进行中的请求
+	runErr := srv.Run(ctx)
+	if runErr != nil {
+		log.Printf("Server stopped with error: %v", runErr)
+	}
+
+	// 依次停止 ext_authz 服务、后台任务和数据库连接池
+	if grpcServer != nil {
+		stopGRPC(grpcServer, cfg.Server.ShutdownTimeout)
+	}
+	cancelBackground()
+	if sqlDB, err := db.DB(); err == nil {
+		if err := sqlDB.Close(); err != nil {
+			log.Printf("Failed to close database: %v", err)
+		}
+	}
+	// 监听失败或者没能在超时时间内退出时返回非 0 状态码，让进程管理器感知到异常
+	if runErr != nil {
+		log.Fatalf("Shutdown complete with error: %v", runErr)
+	}
+	log.Printf("Shutdown complete")
+}
+
+// newPolicyEngine 按 policy.engine 配置创建策略引擎
+func newPolicyEngine(cfg config.PolicyConfig) (opa.PolicyEngine, error) {
+	switch cfg.Engine {
+	case "file":
+		return opa.NewFileEngine(rbac.Queries(), cfg.Paths, opa.WithTimeout(cfg.Timeout))
+	case "bundle":
+		opts := []opa.Option{opa.WithTimeout(cfg.Timeout)}
+		if v := cfg.BundleVerification; v.Insecure {
+			log.Printf("WARNING: policy bundle signatures are not verified")
+			opts = append(opts, opa.WithInsecureSkipBundleVerification())
+		} else {
+			key, err := os.ReadFile(v.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("read bundle verification key: %w", err)
+			}
+			opts = append(opts, opa.WithBundleVerification(v.KeyID, string(key), v.Algorithm, v.Scope))
+		}
+		return opa.NewBundleEngine(rbac.Queries(), cfg.Bundle, opts...)
+	case "remote":
+		opts := []opa.Option{
+			opa.WithTimeout(cfg.Timeout),
+			opa.WithRetries(cfg.Remote.Retries, cfg.Remote.Backoff),
+			opa.WithFallback(opa.FallbackMode(cfg.Remote.Fallback), cfg.Remote.CacheTTL),
+			opa.WithCacheSize(cfg.Remote.CacheSize),
+			opa.WithMaxConns(cfg.Remote.MaxConns),
+		}
+		if cfg.Remote.Token != "" {
+			opts = append(opts, opa.WithHeader("Authorization", "Bearer "+cfg.Remote.Token))
+		}
+		return opa.NewHTTPEngine(cfg.Remote.URL, rbac.Queries(), opts...)
+	default:
+		return rbac.NewEmbeddedEngine(opa.WithTimeout(cfg.Timeout))
+	}
+}
+
+// newSecretManager 创建读取 file://、env:// 引用的密钥管理器，配置了 Vault 时同时支持 vault:// 引用
+func newSecretManager(cfg config.SecretsConfig) (*secrets.Manager, error) {
+	manager := secrets.NewManager(cfg.RefreshInterval)
+	if cfg.Vault.Address != "" {
+		vault, err := secrets.NewVaultProvider(secrets.VaultConfig{
+			Address:   cfg.Vault.Address,
+			Token:     cfg.Vault.Token,
+			TokenFile: cfg.Vault.TokenFile,
+			Namespace: cfg.Vault.Namespace,
+			KVVersion: cfg.Vault.KVVersion,
+			Timeout:   cfg.Vault.Timeout,
+		})
+		if err != nil {
+			return nil, err
+		}
+		manager.Register("vault", vault)
+	}
+	return manager, nil
+}
+
+// loadSigningKey 加载 ID token 的 RS256 签名密钥，密钥来源变化时自动轮换
+func loadSigningKey(ctx context.Context, manager *secrets.Manager, ref string) error {
+	if ref == "" {
+		log.Printf("auth.oidc.signing_key is not configured, using a temporary key; id tokens will not survive restarts")
+		key, err := jwt.GenerateRSAKey()
+		if err != nil {
+			return err
+		}
+		return jwt.SetRSAKey(key)
+	}
+
+	data, err := manager.Watch(ctx, ref, func(data []byte) {
+		key, err := jwt.ParseRSAKey(data)
+		if err == nil {
+			err = jwt.SetRSAKey(key)
+		}
+		if err != nil {
+			log.Printf("Failed to rotate oidc signing key: %v", err)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	key, err := jwt.ParseRSAKey(data)
+	if err != nil {
+		return err
+	}
+	return jwt.SetRSAKey(key)
+}
+
+// newFederatedProviders 根据配置创建上游身份提供方，发现文档在第一次登录时读取
+func newFederatedProviders(ctx context.Context, manager *secrets.Manager, cfgs []config.FederationProviderConfig) ([]*auth.FederatedProvider, error) {
+	var providers []*auth.FederatedProvider
+	for _, cfg := range cfgs {
+		secret, err := manager.Resolve(ctx, cfg.ClientSecret)
+		if err != nil {
+			return nil, err
+		}
+		client, err := oidc.NewClient(oidc.Config{
+			Issuer:       cfg.Issuer,
+			ClientID:     cfg.ClientID,
+			ClientSecret: string(secret),
+			RedirectURL:  cfg.RedirectURL,
+			Scopes:       cfg.Scopes,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", cfg.Name, err)
+		}
+
+		provider := &auth.FederatedProvider{
+			Name:          cfg.Name,
+			Client:        client,
+			UsernameClaim: cmp.Or(cfg.UsernameClaim, "preferred_username"),
+			GroupsClaim:   cmp.Or(cfg.GroupsClaim, "groups"),
+			DefaultRole:   cfg.DefaultRole,
+		}
+		for _, rule := range cfg.RoleRules {
+			provider.RoleRules = append(provider.RoleRules, auth.RoleRule{Group: rule.Group, Role: rule.Role})
+		}
+		providers = append(providers, provider)
+	}
+	return providers, nil
+}
+
+// newDirectory 根据配置创建 LDAP 认证和角色同步
+func newDirectory(ctx context.Context, db *gorm.DB, manager *secrets.Manager, cfg config.LDAPConfig) (*auth.Directory, error) {
+	bindPassword, err := manager.Resolve(ctx, cfg.BindPassword)
+	if err != nil {
+		return nil, err
+	}
+	client, err := directory.NewClient(directory.Config{
+		URL:               cfg.URL,
+		StartTLS:          cfg.StartTLS,
+		CAFile:            cfg.CAFile,
+		BindDN:            cfg.BindDN,
+		BindPassword:      string(bindPassword),
+		BaseDN:            cfg.BaseDN,
+		UserFilter:        cfg.UserFilter,
+		SyncFilter:        cfg.SyncFilter,
+		UsernameAttribute: cfg.UsernameAttribute,
+		GroupAttribute:    cfg.GroupAttribute,
+		Timeout:           cfg.Timeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []auth.RoleRule
+	for _, rule := range cfg.RoleRules {
+		rules = append(rules, auth.RoleRule{Group: rule.Group, Role: rule.Role})
+	}
+	return auth.NewDirectory(db, client, rules, cfg.DefaultRole), nil
+}
+
+func newPasswordPolicy(cfg config.PasswordConfig) (*password.Policy, error) {
+	policy := &password.Policy{MinLength: cfg.MinLength, MaxLength: cfg.MaxLength, MinStrength: cfg.MinStrength}
+	if cfg.BreachedDir != "" {
+		breached, err := password.NewRangeDir(cfg.BreachedDir, cfg.BreachedMinCount)
+		if err != nil {
+			return nil, err
+		}
+		policy.Breached = breached
+	}
+	return policy, nil
+}
+
+// newNotifier 按配置创建通知方式，没有配置时返回 nil
+func newNotifier(ctx context.Context, manager *secrets.Manager, cfg config.NotifierConfig) (notify.Notifier, error) {
+	switch cfg.Type {
+	case "file":
+		return notify.NewFileNotifier(cfg.File), nil
+	case "smtp":
+		smtpPassword, err := manager.Resolve(ctx, cfg.SMTP.Password)
+		if err != nil {
+			return nil, err
+		}
+		return notify.NewSMTPNotifier(notify.SMTPConfig{
+			Addr:     cfg.SMTP.Addr,
+			From:     cfg.SMTP.From,
+			Username: cfg.SMTP.Username,
+			Password: string(smtpPassword),
+		})
+	}
+	return nil, nil
+}
+
+// newRegistration 按配置创建自助注册的设置，配置了人机验证时解析 secret 引用
+func newRegistration(ctx context.Context, manager *secrets.Manager, cfg config.RegistrationConfig) (auth.RegistrationConfig, error) {
+	registration := auth.RegistrationConfig{
+		Mode:           auth.RegistrationMode(cfg.Mode),
+		AllowedDomains: cfg.AllowedDomains,
+		InviteTTL:      cfg.InviteTTL,
+	}
+	if cfg.Captcha.Provider == "" {
+		return registration, nil
+	}
+
+	secret, err := manager.Resolve(ctx, cfg.Captcha.Secret)
+	if err != nil {
+		return registration, err
+	}
+	verifier, err := captcha.NewSiteVerify(cfg.Captcha.Provider, cfg.Captcha.VerifyURL, string(secret))
+	if err != nil {
+		return registration, err
+	}
+	registration.Captcha = verifier
+	registration.CaptchaProvider = cfg.Captcha.Provider
+	registration.CaptchaSiteKey = cfg.Captcha.SiteKey
+	return registration, nil
+}
+
+// sameSiteMode 把配置中的 same_site 转换为 cookie 的 SameSite 属性，取值已在加载配置时校验
+func sameSiteMode(mode string) http.SameSite {
+	switch mode {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	}
+	return http.SameSiteLaxMode
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// newServerConfig 把配置文件中的 server 配置转换为 HTTP 服务的配置
+func newServerConfig(cfg config.ServerConfig) server.Config {
+	serverCfg := server.Config{
+		Addr:              cfg.Addr,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		ShutdownDelay:     cfg.ShutdownDelay,
+		ShutdownTimeout:   cfg.ShutdownTimeout,
+		H2C:               cfg.H2C,
+	}
+	serverCfg.TLS = newTLSConfig(cfg.TLS)
+	return serverCfg
+}
+
+// newTLSConfig 把配置文件中的 TLS 配置转换为服务使用的配置，没有启用 TLS 时返回 nil
+func newTLSConfig(cfg config.TLSConfig) *server.TLSConfig {
+	if !cfg.Enabled() {
+		return nil
+	}
+	clientAuth := map[string]tls.ClientAuthType{
+		"request":         tls.RequestClientCert,
+		"verify_if_given": tls.VerifyClientCertIfGiven,
+		"require":         tls.RequireAndVerifyClientCert,
+	}
+	minVersion := map[string]uint16{
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+	return &server.TLSConfig{
+		CertFile:       cfg.CertFile,
+		KeyFile:        cfg.KeyFile,
+		ClientCAFile:   cfg.ClientCAFile,
+		ClientAuth:     clientAuth[cfg.ClientAuth],
+		MinVersion:     minVersion[cfg.MinVersion],
+		ReloadInterval: cfg.ReloadInterval,
+	}
+}
 
-	// 启动服务器
-	if err := r.Run(":8080"); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+// stopGRPC 等待进行中的 gRPC 调用完成，超时后强制关闭
+func stopGRPC(srv *grpc.Server, timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		srv.Stop()
 	}
 }
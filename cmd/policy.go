@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/cover"
+	"github.com/open-policy-agent/opa/loader"
+	"github.com/open-policy-agent/opa/tester"
+	"github.com/shenjing023/rbac-api-gateway/internal/rbac"
+	"github.com/shenjing023/rbac-api-gateway/pkg/opa"
+)
+
+const policyUsage = `用法:
+  gateway policy test [--policy file] [--coverage] [--threshold N] [-v] [--run regex] [path...]
+  gateway policy eval --input file.json [--policy file] [--query data.rbac.allow] [--fail-on-deny]
+`
+
+// runPolicyCommand 处理 `gateway policy ...` 子命令，返回进程退出码
+func runPolicyCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, policyUsage)
+		return 2
+	}
+
+	switch args[0] {
+	case "test":
+		return runPolicyTest(args[1:])
+	case "eval":
+		return runPolicyEval(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "未知的子命令: %s\n%s", args[0], policyUsage)
+		return 2
+	}
+}
+
+// runPolicyTest 针对当前策略运行 *_test.rego 中的测试用例，并可输出覆盖率
+func runPolicyTest(args []string) int {
+	fset := flag.NewFlagSet("policy test", flag.ContinueOnError)
+	policyFile := fset.String("policy", "", "策略文件路径，默认使用内置的 rbac.rego")
+	coverage := fset.Bool("coverage", false, "输出策略覆盖率")
+	threshold := fset.Float64("threshold", 0, "覆盖率低于该百分比时视为失败")
+	verbose := fset.Bool("v", false, "输出每个测试用例的结果")
+	run := fset.String("run", "", "只运行名称匹配该正则的测试")
+	if err := fset.Parse(args); err != nil {
+		return 2
+	}
+
+	paths := fset.Args()
+	if len(paths) == 0 {
+		paths = []string{"internal/rbac"}
+	}
+
+	policyModules, err := loadPolicyModules(*policyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "加载策略失败: %v\n", err)
+		return 1
+	}
+
+	testModules, err := loadTestModules(paths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "加载测试文件失败: %v\n", err)
+		return 1
+	}
+	if len(testModules) == 0 {
+		fmt.Fprintf(os.Stderr, "在 %s 中没有找到 *_test.rego 文件\n", strings.Join(paths, ", "))
+		return 1
+	}
+
+	modules := make(map[string]*ast.Module, len(policyModules)+len(testModules))
+	for name, m := range policyModules {
+		modules[name] = m
+	}
+	for name, m := range testModules {
+		modules[name] = m
+	}
+
+	cov := cover.New()
+	runner := tester.NewRunner().
+		SetModules(modules).
+		SetCompiler(ast.NewCompiler().WithEnablePrintStatements(true)).
+		CapturePrintOutput(true).
+		Filter(*run)
+	if *coverage || *threshold > 0 {
+		runner = runner.SetCoverageQueryTracer(cov)
+	}
+
+	ch, err := runner.RunTests(context.Background(), nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "运行策略测试失败: %v\n", err)
+		return 1
+	}
+
+	// 先收集结果，确保覆盖率统计完整后再输出
+	var results []*tester.Result
+	failed := false
+	for r := range ch {
+		if !r.Pass() && !r.Skip {
+			failed = true
+		}
+		results = append(results, r)
+	}
+
+	resultCh := make(chan *tester.Result, len(results))
+	for _, r := range results {
+		resultCh <- r
+	}
+	close(resultCh)
+
+	reporter := tester.PrettyReporter{Output: os.Stdout, Verbose: *verbose, FailureLine: true}
+	if err := reporter.Report(resultCh); err != nil {
+		fmt.Fprintf(os.Stderr, "输出测试结果失败: %v\n", err)
+		return 1
+	}
+
+	if *coverage || *threshold > 0 {
+		report := policyCoverage(cov, policyModules)
+		printCoverage(os.Stdout, report)
+		if report.Coverage < *threshold {
+			fmt.Fprintf(os.Stderr, "策略覆盖率 %.2f%% 低于阈值 %.2f%%\n", report.Coverage, *threshold)
+			failed = true
+		}
+	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// runPolicyEval 对单个输入文件执行一次策略评估
+func runPolicyEval(args []string) int {
+	fset := flag.NewFlagSet("policy eval", flag.ContinueOnError)
+	inputFile := fset.String("input", "", "JSON 格式的输入文件，\"-\" 表示从标准输入读取")
+	policyFile := fset.String("policy", "", "策略文件路径，默认使用内置的 rbac.rego")
+	query := fset.String("query", rbac.AllowQuery, "要评估的查询")
+	failOnDeny := fset.Bool("fail-on-deny", false, "结果不为 true 时以非零状态码退出")
+	if err := fset.Parse(args); err != nil {
+		return 2
+	}
+	if *inputFile == "" {
+		fmt.Fprintf(os.Stderr, "必须通过 --input 指定输入文件\n%s", policyUsage)
+		return 2
+	}
+
+	input, err := readInput(*inputFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "读取输入失败: %v\n", err)
+		return 1
+	}
+
+	name, content, err := readPolicy(*policyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "加载策略失败: %v\n", err)
+		return 1
+	}
+
+	engine, err := opa.NewRegoEngine(opa.Queries{"eval": *query}, map[string]string{name: content}, "")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "编译策略失败: %v\n", err)
+		return 1
+	}
+
+	decision, err := engine.Eval(context.Background(), "eval", input)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "评估策略失败: %v\n", err)
+		return 1
+	}
+	value := decision.Result
+
+	out, err := json.MarshalIndent(map[string]interface{}{"result": value}, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "输出结果失败: %v\n", err)
+		return 1
+	}
+	fmt.Println(string(out))
+
+	if *failOnDeny && value != true {
+		return 1
+	}
+	return 0
+}
+
+// readPolicy 读取被测策略，未指定文件时使用内置策略
+func readPolicy(policyFile string) (string, string, error) {
+	if policyFile == "" {
+		return rbac.PolicyFile, rbac.DefaultPolicy(), nil
+	}
+	data, err := os.ReadFile(policyFile)
+	if err != nil {
+		return "", "", err
+	}
+	return policyFile, string(data), nil
+}
+
+// loadPolicyModules 加载并解析被测策略
+func loadPolicyModules(policyFile string) (map[string]*ast.Module, error) {
+	name, content, err := readPolicy(policyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	module, err := ast.ParseModule(name, content)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]*ast.Module{name: module}, nil
+}
+
+// loadTestModules 从给定路径加载所有 *_test.rego 文件
+func loadTestModules(paths []string) (map[string]*ast.Module, error) {
+	result, err := loader.NewFileLoader().Filtered(paths, func(_ string, info fs.FileInfo, _ int) bool {
+		return !info.IsDir() && !strings.HasSuffix(info.Name(), "_test.rego")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	modules := make(map[string]*ast.Module, len(result.Modules))
+	for _, m := range result.Modules {
+		modules[m.Name] = m.Parsed
+	}
+	return modules, nil
+}
+
+func readInput(path string) (interface{}, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var input interface{}
+	if err := json.Unmarshal(data, &input); err != nil {
+		return nil, fmt.Errorf("输入不是合法的 JSON: %w", err)
+	}
+	return input, nil
+}
+
+// policyCoverage 只统计被测策略的覆盖率，测试文件本身不计入
+func policyCoverage(cov *cover.Cover, policyModules map[string]*ast.Module) cover.Report {
+	report := cov.Report(policyModules)
+	var covered, notCovered int
+	for file, fr := range report.Files {
+		if _, ok := policyModules[file]; !ok {
+			delete(report.Files, file)
+			continue
+		}
+		covered += fr.CoveredLines
+		notCovered += fr.NotCoveredLines
+	}
+
+	report.CoveredLines = covered
+	report.NotCoveredLines = notCovered
+	report.Coverage = 0
+	if total := covered + notCovered; total > 0 {
+		report.Coverage = 100.0 * float64(covered) / float64(total)
+	}
+	return report
+}
+
+func printCoverage(w io.Writer, report cover.Report) {
+	files := make([]string, 0, len(report.Files))
+	for file := range report.Files {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	fmt.Fprintln(w, "覆盖率:")
+	for _, file := range files {
+		fr := report.Files[file]
+		fmt.Fprintf(w, "  %s: %.2f%%", file, fr.Coverage)
+		if len(fr.NotCovered) > 0 {
+			var rows []string
+			for _, r := range fr.NotCovered {
+				if r.Start.Row == r.End.Row {
+					rows = append(rows, fmt.Sprintf("%d", r.Start.Row))
+				} else {
+					rows = append(rows, fmt.Sprintf("%d-%d", r.Start.Row, r.End.Row))
+				}
+			}
+			fmt.Fprintf(w, " (未覆盖行: %s)", strings.Join(rows, ", "))
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "  总计: %.2f%%\n", report.Coverage)
+}
@@ -0,0 +1,168 @@
+package apikey
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+type createKeyRequest struct {
+	Name      string     `json:"name" binding:"required"`
+	Scopes    []string   `json:"scopes" binding:"required"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreateKey 为当前用户创建 API key
+func (h *Handler) CreateKey(c *gin.Context) {
+	h.createKey(c, c.GetUint("user_id"))
+}
+
+func (h *Handler) ListKeys(c *gin.Context) {
+	h.list(c, KindAPIKey, c.GetUint("user_id"))
+}
+
+func (h *Handler) RevokeKey(c *gin.Context) {
+	h.revoke(c, KindAPIKey, c.GetUint("user_id"), c.Param("id"))
+}
+
+// CreateToken 为当前用户创建个人访问令牌
+func (h *Handler) CreateToken(c *gin.Context) {
+	var req createKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, plaintext, err := h.service.CreateToken(c.GetUint("user_id"), c.GetStringSlice("amr"), req.Name, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 明文令牌只在创建时返回一次
+	c.JSON(http.StatusCreated, gin.H{"token": plaintext, "personal_access_token": token})
+}
+
+func (h *Handler) ListTokens(c *gin.Context) {
+	h.list(c, KindPersonal, c.GetUint("user_id"))
+}
+
+func (h *Handler) RevokeToken(c *gin.Context) {
+	h.revoke(c, KindPersonal, c.GetUint("user_id"), c.Param("id"))
+}
+
+// CreateUserKey 由管理员为指定用户或服务账号创建 API key
+func (h *Handler) CreateUserKey(c *gin.Context) {
+	userID, ok := parseID(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	h.createKey(c, userID)
+}
+
+func (h *Handler) ListUserKeys(c *gin.Context) {
+	userID, ok := parseID(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	h.list(c, KindAPIKey, userID)
+}
+
+func (h *Handler) RevokeUserKey(c *gin.Context) {
+	userID, ok := parseID(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	h.revoke(c, KindAPIKey, userID, c.Param("key_id"))
+}
+
+func (h *Handler) createKey(c *gin.Context, userID uint) {
+	var req createKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	key, plaintext, err := h.service.CreateKey(userID, req.Name, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 明文 key 只在创建时返回一次
+	c.JSON(http.StatusCreated, gin.H{"key": plaintext, "api_key": key})
+}
+
+func (h *Handler) list(c *gin.Context, kind Kind, userID uint) {
+	keys, err := h.service.List(kind, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取列表失败"})
+		return
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+func (h *Handler) revoke(c *gin.Context, kind Kind, userID uint, rawKeyID string) {
+	keyID, ok := parseID(c, rawKeyID)
+	if !ok {
+		return
+	}
+
+	if err := h.service.Revoke(kind, userID, keyID); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "吊销失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已吊销"})
+}
+
+func parseID(c *gin.Context, raw string) (uint, bool) {
+	id, err := strconv.ParseUint(raw, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的ID"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func RegisterRoutes(r *gin.Engine, service *Service) {
+	handler := NewHandler(service)
+
+	// 用户管理自己的 API key
+	keys := r.Group("/api-keys")
+	{
+		keys.POST("", handler.CreateKey)
+		keys.GET("", handler.ListKeys)
+		keys.DELETE("/:id", handler.RevokeKey)
+	}
+
+	// 管理员管理其他用户和服务账号的 API key
+	users := r.Group("/users/:id/api-keys")
+	{
+		users.POST("", handler.CreateUserKey)
+		users.GET("", handler.ListUserKeys)
+		users.DELETE("/:key_id", handler.RevokeUserKey)
+	}
+
+	// 用户管理自己的个人访问令牌
+	tokens := r.Group("/auth/tokens")
+	{
+		tokens.POST("", handler.CreateToken)
+		tokens.GET("", handler.ListTokens)
+		tokens.DELETE("/:id", handler.RevokeToken)
+	}
+}
@@ -0,0 +1,41 @@
+package apikey
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// APIKey 是供 CI 和集成使用的长期凭据，只保存哈希值，明文只在创建时返回一次。
+// 用户在 /auth/tokens 创建的个人访问令牌（PAT）也保存在这里，Kind 为 personal
+type APIKey struct {
+	gorm.Model
+	Kind       string   `gorm:"index;not null;default:'api_key'"`
+	UserID     uint     `gorm:"index;not null"` // 所属的用户或服务账号
+	Name       string   `gorm:"not null"`
+	Prefix     string   `gorm:"uniqueIndex;not null"` // 明文的前缀部分，用于查找和在列表中识别 key
+	Hash       string   `gorm:"not null" json:"-"`
+	Scopes     []string `gorm:"serializer:json;not null"`
+	AMR        []string `gorm:"serializer:json" json:"-"` // 个人访问令牌继承创建它的会话的认证方式
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
+
+// Kind 区分 API key 和个人访问令牌，两者的前缀和传递方式不同
+type Kind string
+
+const (
+	// KindAPIKey 通过 X-API-Key 或 "Authorization: ApiKey <key>" 传递
+	KindAPIKey Kind = "api_key"
+	// KindPersonal 通过 "Authorization: Bearer <token>" 传递，必须设置过期时间
+	KindPersonal Kind = "personal"
+)
+
+// prefix 标识网关签发的凭据类型，便于密钥扫描工具识别泄露的 key
+func (k Kind) prefix() string {
+	if k == KindPersonal {
+		return "gwp"
+	}
+	return "gwk"
+}
@@ -0,0 +1,186 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/shenjing023/rbac-api-gateway/internal/rbac"
+	"github.com/shenjing023/rbac-api-gateway/pkg/jwt"
+	"gorm.io/gorm"
+)
+
+// lastUsedInterval 内重复使用同一个 key 不再更新 LastUsedAt，避免每个请求都写数据库
+const lastUsedInterval = time.Minute
+
+var (
+	ErrNotFound   = errors.New("API key 或令牌不存在")
+	ErrInvalidKey = errors.New("无效的 API key")
+)
+
+// IdentityResolver 返回 key 所属用户当前的身份和角色
+type IdentityResolver interface {
+	Identity(ctx context.Context, userID uint) (*jwt.Claims, error)
+}
+
+type Service struct {
+	db         *gorm.DB
+	identities IdentityResolver
+}
+
+func NewService(db *gorm.DB, identities IdentityResolver) *Service {
+	return &Service{db: db, identities: identities}
+}
+
+// MaxTokenLifetime 是个人访问令牌的最长有效期
+const MaxTokenLifetime = 366 * 24 * time.Hour
+
+// IsPersonalToken 判断 bearer token 是否是个人访问令牌而不是 JWT
+func IsPersonalToken(token string) bool {
+	return strings.HasPrefix(token, KindPersonal.prefix()+"_")
+}
+
+// CreateKey 为 userID 创建 API key，返回的明文 key 不会被保存，只能在此时交给调用方
+func (s *Service) CreateKey(userID uint, name string, scopes []string, expiresAt *time.Time) (*APIKey, string, error) {
+	return s.create(KindAPIKey, userID, nil, name, scopes, expiresAt)
+}
+
+// CreateToken 为用户创建个人访问令牌，令牌必须设置不超过 MaxTokenLifetime 的过期时间。
+// amr 是创建令牌的会话的认证方式，令牌不能比创建它的会话拥有更强的认证
+func (s *Service) CreateToken(userID uint, amr []string, name string, scopes []string, expiresAt *time.Time) (*APIKey, string, error) {
+	if expiresAt == nil {
+		return nil, "", errors.New("个人访问令牌必须设置过期时间")
+	}
+	if expiresAt.After(time.Now().Add(MaxTokenLifetime)) {
+		return nil, "", errors.New("个人访问令牌的有效期不能超过一年")
+	}
+	return s.create(KindPersonal, userID, amr, name, scopes, expiresAt)
+}
+
+func (s *Service) create(kind Kind, userID uint, amr []string, name string, scopes []string, expiresAt *time.Time) (*APIKey, string, error) {
+	if err := rbac.ValidateScopes(scopes); err != nil {
+		return nil, "", err
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, "", errors.New("过期时间必须晚于当前时间")
+	}
+
+	id, err := randomString(6)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomString(32)
+	if err != nil {
+		return nil, "", err
+	}
+	prefix := kind.prefix() + "_" + id
+	plaintext := prefix + "_" + secret
+
+	key := &APIKey{
+		Kind:      string(kind),
+		UserID:    userID,
+		Name:      name,
+		Prefix:    prefix,
+		Hash:      hashKey(plaintext),
+		Scopes:    scopes,
+		AMR:       amr,
+		ExpiresAt: expiresAt,
+	}
+	if err := s.db.Create(key).Error; err != nil {
+		return nil, "", err
+	}
+	return key, plaintext, nil
+}
+
+// List 返回 userID 名下指定类型的 key
+func (s *Service) List(kind Kind, userID uint) ([]APIKey, error) {
+	var keys []APIKey
+	err := s.db.Where("kind = ? AND user_id = ?", kind, userID).Order("id").Find(&keys).Error
+	return keys, err
+}
+
+// Revoke 吊销 userID 名下的 key，吊销后立即失效，记录保留用于审计
+func (s *Service) Revoke(kind Kind, userID, keyID uint) error {
+	result := s.db.Model(&APIKey{}).
+		Where("id = ? AND kind = ? AND user_id = ? AND revoked_at IS NULL", keyID, kind, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ResolveAPIKey 校验明文 API key，返回所属用户的身份，Scopes 为 key 的权限范围
+func (s *Service) ResolveAPIKey(ctx context.Context, plaintext string) (*jwt.Claims, error) {
+	return s.resolve(ctx, KindAPIKey, plaintext)
+}
+
+// ResolveToken 校验个人访问令牌，返回所属用户的身份，Scopes 为令牌的权限范围
+func (s *Service) ResolveToken(ctx context.Context, plaintext string) (*jwt.Claims, error) {
+	return s.resolve(ctx, KindPersonal, plaintext)
+}
+
+func (s *Service) resolve(ctx context.Context, kind Kind, plaintext string) (*jwt.Claims, error) {
+	i := strings.LastIndexByte(plaintext, '_')
+	if i <= 0 || !strings.HasPrefix(plaintext, kind.prefix()+"_") {
+		return nil, ErrInvalidKey
+	}
+
+	var key APIKey
+	if err := s.db.WithContext(ctx).Where("prefix = ? AND kind = ?", plaintext[:i], kind).First(&key).Error; err != nil {
+		return nil, ErrInvalidKey
+	}
+	if subtle.ConstantTimeCompare([]byte(hashKey(plaintext)), []byte(key.Hash)) != 1 {
+		return nil, ErrInvalidKey
+	}
+	now := time.Now()
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && now.After(*key.ExpiresAt)) {
+		return nil, ErrInvalidKey
+	}
+
+	claims, err := s.identities.Identity(ctx, key.UserID)
+	if err != nil {
+		return nil, err
+	}
+	claims.Scopes = key.Scopes
+	claims.AMR = key.AMR
+	if kind == KindAPIKey {
+		claims.AMR = []string{jwt.AMRKey}
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > lastUsedInterval {
+		go s.touch(key.ID, now)
+	}
+	return claims, nil
+}
+
+func (s *Service) touch(keyID uint, now time.Time) {
+	err := s.db.Model(&APIKey{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", keyID, now.Add(-lastUsedInterval)).
+		Update("last_used_at", now).Error
+	if err != nil {
+		log.Printf("update api key last used failed: %v", err)
+	}
+}
+
+func hashKey(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
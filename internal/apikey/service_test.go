@@ -0,0 +1,250 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/shenjing023/rbac-api-gateway/internal/rbac"
+	"github.com/shenjing023/rbac-api-gateway/pkg/jwt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var testDBSeq atomic.Int64
+
+// fakeIdentities 返回用户当前的角色，没有出现的用户不存在
+type fakeIdentities map[uint]string
+
+func (f fakeIdentities) Identity(ctx context.Context, userID uint) (*jwt.Claims, error) {
+	role, ok := f[userID]
+	if !ok {
+		return nil, errors.New("用户不存在")
+	}
+	return &jwt.Claims{UserID: userID, Username: fmt.Sprintf("user%d", userID), Role: role}, nil
+}
+
+func newTestService(t *testing.T) (*Service, *gorm.DB) {
+	t.Helper()
+	dsn := fmt.Sprintf("file:apikey_test_%d?mode=memory&cache=shared", testDBSeq.Add(1))
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&APIKey{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return NewService(db, fakeIdentities{1: "user", 2: "moderator"}), db
+}
+
+func TestResolveAPIKey(t *testing.T) {
+	s, db := newTestService(t)
+	expiresAt := time.Now().Add(time.Hour)
+	key, plaintext, err := s.CreateKey(1, "ci", []string{"posts:read"}, &expiresAt)
+	if err != nil {
+		t.Fatalf("CreateKey: %v", err)
+	}
+	if key.Hash == plaintext || key.Hash != hashKey(plaintext) {
+		t.Fatal("the plaintext key is stored instead of its hash")
+	}
+
+	claims, err := s.ResolveAPIKey(context.Background(), plaintext)
+	if err != nil {
+		t.Fatalf("ResolveAPIKey: %v", err)
+	}
+	// API key 的身份来自用户当前的角色，权限范围是 key 的 scope，认证方式固定为 key
+	if claims.UserID != 1 || claims.Role != "user" || !slices.Equal(claims.Scopes, []string{"posts:read"}) || !slices.Equal(claims.AMR, []string{jwt.AMRKey}) {
+		t.Errorf("api key claims = %+v", claims)
+	}
+
+	tests := []struct {
+		name      string
+		plaintext string
+		resolve   func(context.Context, string) (*jwt.Claims, error)
+	}{
+		{"wrong secret", plaintext[:len(plaintext)-1] + "0", s.ResolveAPIKey},
+		{"unknown prefix", "gwk_000000_" + plaintext[len(key.Prefix)+1:], s.ResolveAPIKey},
+		{"missing secret", key.Prefix, s.ResolveAPIKey},
+		{"empty", "", s.ResolveAPIKey},
+	}
+	for _, tt := range tests {
+		if _, err := tt.resolve(context.Background(), tt.plaintext); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("%s: error = %v, want %v", tt.name, err, ErrInvalidKey)
+		}
+	}
+
+	// 所属用户不存在时不能使用
+	if err := db.Model(key).Update("user_id", 99).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ResolveAPIKey(context.Background(), plaintext); err == nil {
+		t.Error("api key of a deleted user was accepted")
+	}
+}
+
+func TestResolveRevokedOrExpired(t *testing.T) {
+	tests := []struct {
+		name string
+		// change 修改已创建的 key
+		change func(t *testing.T, s *Service, db *gorm.DB, key *APIKey)
+	}{
+		{"revoked", func(t *testing.T, s *Service, db *gorm.DB, key *APIKey) {
+			if err := s.Revoke(KindAPIKey, key.UserID, key.ID); err != nil {
+				t.Fatalf("Revoke: %v", err)
+			}
+		}},
+		{"expired", func(t *testing.T, s *Service, db *gorm.DB, key *APIKey) {
+			if err := db.Model(key).Update("expires_at", time.Now().Add(-time.Second)).Error; err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, db := newTestService(t)
+			key, plaintext, err := s.CreateKey(1, "ci", []string{rbac.ScopeAll}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.ResolveAPIKey(context.Background(), plaintext); err != nil {
+				t.Fatalf("ResolveAPIKey before %s: %v", tt.name, err)
+			}
+			tt.change(t, s, db, key)
+			if _, err := s.ResolveAPIKey(context.Background(), plaintext); !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("ResolveAPIKey after %s error = %v, want %v", tt.name, err, ErrInvalidKey)
+			}
+		})
+	}
+}
+
+func TestRevokeOwnership(t *testing.T) {
+	s, _ := newTestService(t)
+	key, plaintext, err := s.CreateKey(1, "ci", []string{"posts:read"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		kind    Kind
+		userID  uint
+		wantErr error
+	}{
+		// 其他用户不能吊销，也不能通过错误的类型吊销
+		{"other user", KindAPIKey, 2, ErrNotFound},
+		{"wrong kind", KindPersonal, 1, ErrNotFound},
+		{"owner", KindAPIKey, 1, nil},
+		{"already revoked", KindAPIKey, 1, ErrNotFound},
+	}
+	for _, tt := range tests {
+		if err := s.Revoke(tt.kind, tt.userID, key.ID); !errors.Is(err, tt.wantErr) {
+			t.Errorf("Revoke %s error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+	if _, err := s.ResolveAPIKey(context.Background(), plaintext); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("revoked key error = %v, want %v", err, ErrInvalidKey)
+	}
+	// 吊销的 key 保留在列表中用于审计
+	keys, err := s.List(KindAPIKey, 1)
+	if err != nil || len(keys) != 1 || keys[0].RevokedAt == nil {
+		t.Errorf("List = %+v (%v), want the revoked key", keys, err)
+	}
+}
+
+func TestCreateValidation(t *testing.T) {
+	s, _ := newTestService(t)
+	past := time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		name    string
+		create  func() error
+		wantErr bool
+	}{
+		{"api key without expiry", func() error { _, _, err := s.CreateKey(1, "ci", []string{"posts:read"}, nil); return err }, false},
+		{"api key expired", func() error { _, _, err := s.CreateKey(1, "ci", []string{"posts:read"}, &past); return err }, true},
+		{"api key without scopes", func() error { _, _, err := s.CreateKey(1, "ci", nil, nil); return err }, true},
+		{"api key invalid scope", func() error { _, _, err := s.CreateKey(1, "ci", []string{"posts:delete"}, nil); return err }, true},
+	}
+	for _, tt := range tests {
+		if err := tt.create(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestResolvePersonalToken(t *testing.T) {
+	s, db := newTestService(t)
+	expiresAt := time.Now().Add(time.Hour)
+	key, token, err := s.CreateToken(2, []string{"pwd", "mfa"}, "laptop", []string{"posts:*"}, &expiresAt)
+	if err != nil {
+		t.Fatalf("CreateToken: %v", err)
+	}
+	_, apiKey, err := s.CreateKey(2, "ci", []string{"posts:*"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsPersonalToken(token) || IsPersonalToken(apiKey) {
+		t.Error("IsPersonalToken does not tell tokens from api keys")
+	}
+
+	// 个人访问令牌继承创建它的会话的认证方式，角色仍然是用户当前的角色
+	claims, err := s.ResolveToken(context.Background(), token)
+	if err != nil {
+		t.Fatalf("ResolveToken: %v", err)
+	}
+	if claims.UserID != 2 || claims.Role != "moderator" || !slices.Equal(claims.Scopes, []string{"posts:*"}) || !slices.Equal(claims.AMR, []string{"pwd", "mfa"}) {
+		t.Errorf("token claims = %+v", claims)
+	}
+
+	// 两种凭据不能互换使用
+	if _, err := s.ResolveAPIKey(context.Background(), token); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("token used as api key error = %v, want %v", err, ErrInvalidKey)
+	}
+	if _, err := s.ResolveToken(context.Background(), apiKey); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("api key used as token error = %v, want %v", err, ErrInvalidKey)
+	}
+
+	// 令牌只能通过 /auth/tokens 吊销，不能当作 API key 吊销
+	if err := s.Revoke(KindAPIKey, 2, key.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Revoke as api key error = %v, want %v", err, ErrNotFound)
+	}
+	if err := db.Model(key).Update("expires_at", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ResolveToken(context.Background(), token); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("expired token error = %v, want %v", err, ErrInvalidKey)
+	}
+}
+
+func TestCreateTokenLifetime(t *testing.T) {
+	s, _ := newTestService(t)
+	month := time.Now().Add(30 * 24 * time.Hour)
+	tooLong := time.Now().Add(MaxTokenLifetime + time.Hour)
+	past := time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		name      string
+		expiresAt *time.Time
+		wantErr   bool
+	}{
+		{"one month", &month, false},
+		{"without expiry", nil, true},
+		{"longer than a year", &tooLong, true},
+		{"expired", &past, true},
+	}
+	for _, tt := range tests {
+		if _, _, err := s.CreateToken(1, nil, "cli", []string{"posts:read"}, tt.expiresAt); (err != nil) != tt.wantErr {
+			t.Errorf("CreateToken %s: error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
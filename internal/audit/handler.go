@@ -0,0 +1,54 @@
+package audit
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+// ListEvents 查询审计事件，支持 type、target、since（RFC 3339）和 limit 参数
+func (h *Handler) ListEvents(c *gin.Context) {
+	filter := Filter{Type: c.Query("type"), Actor: c.Query("actor"), Target: c.Query("target")}
+	if since := c.Query("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的 since"})
+			return
+		}
+		filter.Since = t
+	}
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的 limit"})
+			return
+		}
+		filter.Limit = n
+	}
+
+	events, err := h.service.List(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取审计事件失败"})
+		return
+	}
+	c.JSON(http.StatusOK, events)
+}
+
+func RegisterRoutes(r *gin.Engine, service *Service) {
+	handler := NewHandler(service)
+
+	audit := r.Group("/audit")
+	{
+		audit.GET("/events", handler.ListEvents)
+	}
+}
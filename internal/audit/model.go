@@ -0,0 +1,44 @@
+package audit
+
+import (
+	"gorm.io/gorm"
+)
+
+// 审计事件类型
+const (
+	TypeLoginSucceeded  = "login.succeeded"
+	TypeLoginFailed     = "login.failed"
+	TypeLoginThrottled  = "login.throttled"
+	TypeAccountLocked   = "account.locked"
+	TypeAccountUnlocked = "account.unlocked"
+
+	TypeAccountRegistered    = "account.registered"
+	TypeAccountVerified      = "account.verified"
+	TypeAccountStatusChanged = "account.status_changed"
+	TypeSessionRevoked       = "session.revoked"
+
+	TypePasswordChanged        = "password.changed"
+	TypePasswordResetRequested = "password.reset_requested"
+	TypePasswordReset          = "password.reset"
+
+	TypeImpersonationStarted = "impersonation.started"
+	TypeImpersonationEnded   = "impersonation.ended"
+
+	TypeInvitationCreated = "invitation.created"
+	TypeInvitationRevoked = "invitation.revoked"
+)
+
+// Event 是一条审计记录，CreatedAt 是事件发生的时间
+type Event struct {
+	gorm.Model
+	Type string `gorm:"index;not null"`
+	// ActorID 和 Actor 是执行操作的用户，未登录时为空
+	ActorID uint `gorm:"index"`
+	Actor   string
+	// OnBehalfOf 是管理员模拟用户时被模拟的用户，此时 Actor 是管理员本人
+	OnBehalfOf string
+	// Target 是受影响的账号，例如登录时使用的用户名
+	Target string `gorm:"index"`
+	IP     string
+	Detail string
+}
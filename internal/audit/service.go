@@ -0,0 +1,66 @@
+package audit
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// maxListLimit 是一次查询返回的最大记录数
+const maxListLimit = 500
+
+type Service struct {
+	db *gorm.DB
+}
+
+func NewService(db *gorm.DB) *Service {
+	return &Service{db: db}
+}
+
+// Record 保存审计事件。写入失败只记录日志，不影响正在执行的操作；
+// Service 为 nil 时什么也不做，方便在未启用审计的组件中直接调用
+func (s *Service) Record(ctx context.Context, event Event) {
+	if s == nil {
+		return
+	}
+	log.Printf("audit: type=%s actor=%q on_behalf_of=%q target=%q ip=%s detail=%q",
+		event.Type, event.Actor, event.OnBehalfOf, event.Target, event.IP, event.Detail)
+	if err := s.db.WithContext(ctx).Create(&event).Error; err != nil {
+		log.Printf("audit: failed to record event: %v", err)
+	}
+}
+
+// Filter 是查询审计事件的条件，零值表示不限制
+type Filter struct {
+	Type   string
+	Actor  string
+	Target string
+	Since  time.Time
+	Limit  int
+}
+
+// List 按时间倒序返回审计事件
+func (s *Service) List(ctx context.Context, filter Filter) ([]Event, error) {
+	query := s.db.WithContext(ctx).Order("id DESC")
+	if filter.Type != "" {
+		query = query.Where("type = ?", filter.Type)
+	}
+	if filter.Actor != "" {
+		query = query.Where("actor = ?", filter.Actor)
+	}
+	if filter.Target != "" {
+		query = query.Where("target = ?", filter.Target)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("created_at >= ?", filter.Since)
+	}
+	if filter.Limit <= 0 || filter.Limit > maxListLimit {
+		filter.Limit = maxListLimit
+	}
+
+	var events []Event
+	err := query.Limit(filter.Limit).Find(&events).Error
+	return events, err
+}
@@ -0,0 +1,212 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shenjing023/rbac-api-gateway/internal/audit"
+	"github.com/shenjing023/rbac-api-gateway/internal/user"
+	"github.com/shenjing023/rbac-api-gateway/pkg/cache"
+	"github.com/shenjing023/rbac-api-gateway/pkg/jwt"
+	"github.com/shenjing023/rbac-api-gateway/pkg/notify"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrAccountPending     = errors.New("账号尚未验证邮箱")
+	ErrAccountSuspended   = errors.New("账号已被停用")
+	ErrAccountDeactivated = errors.New("账号已注销")
+	ErrInvalidStatus      = errors.New("无效的账号状态")
+	ErrEmailRequired      = errors.New("注册需要提供邮箱")
+	ErrInvalidVerifyToken = errors.New("验证链接无效或已过期")
+	// ErrEmailVerificationDisabled 表示没有启用邮箱验证
+	ErrEmailVerificationDisabled = errors.New("未启用邮箱验证")
+)
+
+// PurposeEmailVerification 是邮箱验证链接中 token 的用途
+const PurposeEmailVerification = "email_verification"
+
+// resendInterval 是同一个用户两次重新发送验证邮件的最短间隔
+const resendInterval = time.Minute
+
+// VerificationConfig 是注册时邮箱验证的配置
+type VerificationConfig struct {
+	TTL time.Duration
+	// URL 是前端的邮箱验证页面，其中的 {token} 会被替换为验证 token，为空时通知中只包含 token
+	URL string
+}
+
+type emailVerification struct {
+	notifier notify.Notifier
+	cfg      VerificationConfig
+}
+
+// SetEmailVerification 要求注册的用户通过 notifier 发送的签名链接验证邮箱后才能登录
+func (s *Service) SetEmailVerification(notifier notify.Notifier, cfg VerificationConfig) {
+	s.verification = &emailVerification{notifier: notifier, cfg: cfg}
+}
+
+// EmailVerificationEnabled 返回注册后是否需要验证邮箱
+func (s *Service) EmailVerificationEnabled() bool {
+	return s.verification != nil
+}
+
+// accountStatusError 返回账号状态对应的错误，active 的账号返回 nil
+func accountStatusError(status string) error {
+	switch user.Status(status) {
+	case user.StatusActive:
+		return nil
+	case user.StatusPending:
+		return ErrAccountPending
+	case user.StatusSuspended:
+		return ErrAccountSuspended
+	case user.StatusDeactivated:
+		return ErrAccountDeactivated
+	}
+	return ErrInvalidStatus
+}
+
+// isAccountStatusError 判断 err 是否是账号状态不允许登录的错误
+func isAccountStatusError(err error) bool {
+	return errors.Is(err, ErrAccountPending) || errors.Is(err, ErrAccountSuspended) ||
+		errors.Is(err, ErrAccountDeactivated) || errors.Is(err, ErrInvalidStatus)
+}
+
+// IsActive 判断账号当前是否可以访问接口，账号不存在时返回 false
+func (s *Service) IsActive(ctx context.Context, userID uint) (bool, error) {
+	var u user.User
+	err := s.db.WithContext(ctx).Select("status").Take(&u, userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return u.Status == string(user.StatusActive), nil
+}
+
+// SetStatus 修改账号状态。停用或注销账号时立即吊销账号的所有会话，actor 是执行操作的管理员
+func (s *Service) SetStatus(ctx context.Context, userID uint, status user.Status, reason string, actor audit.Event) error {
+	switch status {
+	case user.StatusActive, user.StatusSuspended, user.StatusDeactivated:
+	default:
+		return ErrInvalidStatus
+	}
+
+	var u user.User
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&u, userID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&user.User{}).Where("id = ?", userID).Update("status", string(status)).Error; err != nil {
+			return err
+		}
+		if status != user.StatusActive {
+			return revokeSessions(tx, userID)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	actor.Type = audit.TypeAccountStatusChanged
+	actor.Target = u.Username
+	actor.Detail = fmt.Sprintf("%s -> %s", u.Status, status)
+	if reason != "" {
+		actor.Detail += ": " + reason
+	}
+	s.audit.Record(ctx, actor)
+	return nil
+}
+
+// sendVerification 向用户的邮箱发送验证链接，链接中是绑定了用户和邮箱的签名 token，不需要保存在数据库中
+func (s *Service) sendVerification(ctx context.Context, u *user.User) error {
+	claims := jwt.Claims{UserID: u.ID, Username: u.Username}
+	claims.Subject = u.Email
+	token, err := jwt.IssuePurposeToken(claims, PurposeEmailVerification, s.verification.cfg.TTL)
+	if err != nil {
+		return err
+	}
+
+	link := token
+	if s.verification.cfg.URL != "" {
+		link = strings.ReplaceAll(s.verification.cfg.URL, "{token}", token)
+	}
+	return s.verification.notifier.Notify(ctx, notify.Message{
+		To:       u.Email,
+		Username: u.Username,
+		Subject:  "验证邮箱",
+		Body: fmt.Sprintf("%s，你好：\n\n请在 %d 小时内使用以下链接验证邮箱并激活账号：\n\n%s\n\n如果不是你本人注册的账号，请忽略这封邮件。\n",
+			u.Username, int(s.verification.cfg.TTL.Hours()), link),
+	})
+}
+
+// VerifyEmail 校验验证链接中的 token 并激活账号。邮箱在发送链接后被修改时链接失效，
+// 已经激活的账号再次验证直接返回成功
+func (s *Service) VerifyEmail(ctx context.Context, token, ip string) error {
+	claims, err := jwt.ValidatePurposeToken(token, PurposeEmailVerification)
+	if err != nil {
+		return ErrInvalidVerifyToken
+	}
+
+	var u user.User
+	if err := s.db.WithContext(ctx).First(&u, claims.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidVerifyToken
+		}
+		return err
+	}
+	if u.Email == "" || !strings.EqualFold(u.Email, claims.Subject) {
+		return ErrInvalidVerifyToken
+	}
+	if u.Status != string(user.StatusPending) {
+		return accountStatusError(u.Status)
+	}
+
+	// 只激活仍处于 pending 的账号，避免与管理员停用账号的操作互相覆盖
+	result := s.db.WithContext(ctx).Model(&user.User{}).
+		Where("id = ? AND status = ?", u.ID, user.StatusPending).
+		Update("status", string(user.StatusActive))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		s.audit.Record(ctx, audit.Event{Type: audit.TypeAccountVerified, ActorID: u.ID, Actor: u.Username, Target: u.Username, IP: ip})
+	}
+	return nil
+}
+
+// ResendVerification 重新发送验证邮件。为了不泄露用户名是否存在，
+// 用户不存在、已经激活或者发送过于频繁时也返回 nil
+func (s *Service) ResendVerification(ctx context.Context, username string) error {
+	if s.verification == nil {
+		return ErrEmailVerificationDisabled
+	}
+
+	var u user.User
+	err := s.db.WithContext(ctx).Where("username = ? AND status = ?", username, user.StatusPending).First(&u).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	key := "auth:verification_resend:" + strconv.FormatUint(uint64(u.ID), 10)
+	c := cache.GetInstance()
+	if _, found := c.Get(key); found {
+		return nil
+	}
+	c.Set(key, true, resendInterval)
+
+	if err := s.sendVerification(ctx, &u); err != nil {
+		log.Printf("email verification: failed to notify user %d: %v", u.ID, err)
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shenjing023/rbac-api-gateway/internal/audit"
+	"github.com/shenjing023/rbac-api-gateway/internal/user"
+	"github.com/shenjing023/rbac-api-gateway/pkg/cache"
+	"github.com/shenjing023/rbac-api-gateway/pkg/jwt"
+	"github.com/shenjing023/rbac-api-gateway/pkg/notify"
+)
+
+const testVerifyURL = "https://app.example.com/verify?token="
+
+// outbox 记录发送的通知
+type outbox struct {
+	mu       sync.Mutex
+	messages []notify.Message
+}
+
+func (o *outbox) Notify(ctx context.Context, msg notify.Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.messages = append(o.messages, msg)
+	return nil
+}
+
+// verifyToken 从最近一封验证邮件的链接中取出 token
+func (o *outbox) verifyToken(t *testing.T) string {
+	t.Helper()
+	return o.linkToken(t, testVerifyURL)
+}
+
+// linkToken 从最近一封通知中以 prefix 开头的链接里取出 token
+func (o *outbox) linkToken(t *testing.T, prefix string) string {
+	t.Helper()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.messages) == 0 {
+		t.Fatal("no message was sent")
+	}
+	for _, field := range strings.Fields(o.messages[len(o.messages)-1].Body) {
+		if token, ok := strings.CutPrefix(field, prefix); ok {
+			return token
+		}
+	}
+	t.Fatal("message has no link")
+	return ""
+}
+
+func (o *outbox) count() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.messages)
+}
+
+func setStatus(t *testing.T, s *Service, userID uint, status user.Status) {
+	t.Helper()
+	if err := s.SetStatus(context.Background(), userID, status, "", audit.Event{}); err != nil {
+		t.Fatalf("SetStatus %s: %v", status, err)
+	}
+}
+
+func TestLoginAccountStatus(t *testing.T) {
+	tests := []struct {
+		status   user.Status
+		password string
+		wantErr  error
+	}{
+		{user.StatusActive, "password", nil},
+		{user.StatusPending, "password", ErrAccountPending},
+		{user.StatusSuspended, "password", ErrAccountSuspended},
+		{user.StatusDeactivated, "password", ErrAccountDeactivated},
+		{"unknown", "password", ErrInvalidStatus},
+		// 密码错误时不透露账号状态
+		{user.StatusSuspended, "wrong", ErrInvalidCredentials},
+		{user.StatusPending, "wrong", ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.status)+"/"+tt.password, func(t *testing.T) {
+			db := newTestDB(t)
+			u := createUser(t, db, "alice", "password", "")
+			if err := db.Model(u).Update("status", string(tt.status)).Error; err != nil {
+				t.Fatal(err)
+			}
+			s := NewService(db)
+
+			_, err := s.Login(context.Background(), LoginRequest{Username: "alice", Password: tt.password})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Login error = %v, want %v", err, tt.wantErr)
+			}
+			active, err := s.IsActive(context.Background(), u.ID)
+			if err != nil || active != (tt.status == user.StatusActive) {
+				t.Errorf("IsActive = %v (%v)", active, err)
+			}
+		})
+	}
+}
+
+func TestEmailVerification(t *testing.T) {
+	db := newTestDB(t)
+	s := NewService(db)
+	mail := &outbox{}
+	s.SetEmailVerification(mail, VerificationConfig{TTL: time.Hour, URL: testVerifyURL + "{token}"})
+
+	if err := s.Register(context.Background(), RegisterRequest{Username: "alice", Password: "password"}); !errors.Is(err, ErrEmailRequired) {
+		t.Fatalf("Register without email error = %v, want %v", err, ErrEmailRequired)
+	}
+	if err := s.Register(context.Background(), RegisterRequest{Username: "alice", Email: "alice@example.com", Password: "password"}); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	login := func() error {
+		_, err := s.Login(context.Background(), LoginRequest{Username: "alice", Password: "password"})
+		return err
+	}
+	if err := login(); !errors.Is(err, ErrAccountPending) {
+		t.Fatalf("Login before verification error = %v, want %v", err, ErrAccountPending)
+	}
+	token := mail.verifyToken(t)
+
+	// 验证 token 不能用来访问 API，其他用途的 token 也不能用来验证邮箱
+	if _, err := jwt.ValidateToken(token); err == nil {
+		t.Error("verification token must not be accepted as an access token")
+	}
+	var u user.User
+	if err := db.Where("username = ?", "alice").First(&u).Error; err != nil {
+		t.Fatal(err)
+	}
+	accessClaims := jwt.Claims{UserID: u.ID, Username: "alice"}
+	accessClaims.Subject = u.Email
+	forged, err := jwt.IssueToken(accessClaims, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.VerifyEmail(context.Background(), forged, ""); !errors.Is(err, ErrInvalidVerifyToken) {
+		t.Errorf("VerifyEmail with an access token error = %v, want %v", err, ErrInvalidVerifyToken)
+	}
+
+	if err := s.VerifyEmail(context.Background(), token, ""); err != nil {
+		t.Fatalf("VerifyEmail: %v", err)
+	}
+	if err := login(); err != nil {
+		t.Fatalf("Login after verification: %v", err)
+	}
+	// 已经激活的账号再次验证直接成功
+	if err := s.VerifyEmail(context.Background(), token, ""); err != nil {
+		t.Errorf("second VerifyEmail: %v", err)
+	}
+	// 停用的账号不能通过旧的验证链接重新激活
+	setStatus(t, s, u.ID, user.StatusSuspended)
+	if err := s.VerifyEmail(context.Background(), token, ""); !errors.Is(err, ErrAccountSuspended) {
+		t.Errorf("VerifyEmail on a suspended account error = %v, want %v", err, ErrAccountSuspended)
+	}
+}
+
+func TestVerifyEmailAfterEmailChange(t *testing.T) {
+	db := newTestDB(t)
+	s := NewService(db)
+	mail := &outbox{}
+	s.SetEmailVerification(mail, VerificationConfig{TTL: time.Hour, URL: testVerifyURL + "{token}"})
+	if err := s.Register(context.Background(), RegisterRequest{Username: "alice", Email: "alice@example.com", Password: "password"}); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	token := mail.verifyToken(t)
+
+	if err := db.Model(&user.User{}).Where("username = ?", "alice").Update("email", "mallory@example.com").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := s.VerifyEmail(context.Background(), token, ""); !errors.Is(err, ErrInvalidVerifyToken) {
+		t.Errorf("VerifyEmail after email change error = %v, want %v", err, ErrInvalidVerifyToken)
+	}
+}
+
+func TestResendVerification(t *testing.T) {
+	db := newTestDB(t)
+	s := NewService(db)
+	if err := s.ResendVerification(context.Background(), "alice"); !errors.Is(err, ErrEmailVerificationDisabled) {
+		t.Fatalf("ResendVerification without verification error = %v, want %v", err, ErrEmailVerificationDisabled)
+	}
+
+	mail := &outbox{}
+	s.SetEmailVerification(mail, VerificationConfig{TTL: time.Hour, URL: testVerifyURL + "{token}"})
+	if err := s.Register(context.Background(), RegisterRequest{Username: "alice", Email: "alice@example.com", Password: "password"}); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	createUser(t, db, "bob", "password", "")
+	// 发送间隔记录在进程内的缓存中，清除之前的测试留下的记录
+	cache.GetInstance().Delete("auth:verification_resend:1")
+
+	// 不存在的用户、已经激活的用户和过于频繁的请求都返回成功但不发送邮件
+	tests := []struct {
+		username  string
+		wantCount int
+	}{
+		{"alice", 2},
+		{"alice", 2},
+		{"bob", 2},
+		{"mallory", 2},
+	}
+	for _, tt := range tests {
+		if err := s.ResendVerification(context.Background(), tt.username); err != nil {
+			t.Errorf("ResendVerification(%s): %v", tt.username, err)
+		}
+		if got := mail.count(); got != tt.wantCount {
+			t.Errorf("after ResendVerification(%s) sent %d messages, want %d", tt.username, got, tt.wantCount)
+		}
+	}
+}
+
+func TestSetStatusRevokesSessions(t *testing.T) {
+	db := newTestDB(t)
+	u := createUser(t, db, "alice", "password", "")
+	s := NewService(db)
+
+	result, err := s.Login(context.Background(), LoginRequest{Username: "alice", Password: "password"})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	claims, err := jwt.ValidateToken(result.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.SetStatus(context.Background(), u.ID, user.StatusPending, "", audit.Event{}); !errors.Is(err, ErrInvalidStatus) {
+		t.Errorf("SetStatus pending error = %v, want %v", err, ErrInvalidStatus)
+	}
+	setStatus(t, s, u.ID, user.StatusSuspended)
+	if revoked, err := s.IsRevoked(context.Background(), claims); err != nil || !revoked {
+		t.Errorf("IsRevoked after suspension = %v (%v), want true", revoked, err)
+	}
+	if active, _ := s.IsActive(context.Background(), u.ID); active {
+		t.Error("suspended account is still active")
+	}
+
+	// 恢复账号后可以重新登录，但停用之前签发的 token 仍然无效
+	setStatus(t, s, u.ID, user.StatusActive)
+	if revoked, _ := s.IsRevoked(context.Background(), claims); !revoked {
+		t.Error("token issued before the suspension is valid again")
+	}
+	if _, err := s.Login(context.Background(), LoginRequest{Username: "alice", Password: "password"}); err != nil {
+		t.Errorf("Login after reactivation: %v", err)
+	}
+}
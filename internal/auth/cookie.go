@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shenjing023/rbac-api-gateway/pkg/jwt"
+)
+
+// ErrSessionCookieDisabled 表示客户端要求使用会话 cookie，但没有启用
+var ErrSessionCookieDisabled = errors.New("未启用会话 cookie")
+
+// SessionCookieConfig 是浏览器会话 cookie 的配置
+type SessionCookieConfig struct {
+	// Name 是保存登录 token 的 HttpOnly cookie
+	Name string
+	// CSRFName 是保存 CSRF token 的 cookie，前端脚本读取后放在 X-CSRF-Token 请求头中
+	CSRFName string
+	Domain   string
+	Path     string
+	Secure   bool
+	SameSite http.SameSite
+	// LoginRedirect 是通过上游身份提供方登录成功后跳转的前端页面，为空时跳转到 /
+	LoginRedirect string
+}
+
+// SetSessionCookie 允许浏览器客户端在登录时要求把 token 保存在 HttpOnly cookie 中，
+// 网关需要同时通过 Authenticator.SetSessionCookie 启用对应的认证方式
+func (s *Service) SetSessionCookie(cfg SessionCookieConfig) {
+	s.cookie = &cfg
+}
+
+// SessionCookieEnabled 返回是否可以使用会话 cookie
+func (s *Service) SessionCookieEnabled() bool {
+	return s.cookie != nil
+}
+
+// setSessionCookies 把登录 token 写入 HttpOnly cookie，CSRF token 写入前端可以读取的 cookie，
+// 两个 cookie 与 token 同时过期。返回需要在响应中告诉前端的 CSRF token
+func (cfg *SessionCookieConfig) set(c *gin.Context, token string) (string, error) {
+	claims, err := jwt.ValidateToken(token)
+	if err != nil {
+		return "", err
+	}
+	if claims.CSRF == "" {
+		return "", errors.New("token has no csrf token")
+	}
+
+	expires := claims.ExpiresAt.Time
+	http.SetCookie(c.Writer, cfg.cookie(cfg.Name, token, expires, true))
+	http.SetCookie(c.Writer, cfg.cookie(cfg.CSRFName, claims.CSRF, expires, false))
+	return claims.CSRF, nil
+}
+
+// clear 删除浏览器中的会话 cookie
+func (cfg *SessionCookieConfig) clear(c *gin.Context) {
+	expired := time.Unix(0, 0)
+	http.SetCookie(c.Writer, cfg.cookie(cfg.Name, "", expired, true))
+	http.SetCookie(c.Writer, cfg.cookie(cfg.CSRFName, "", expired, false))
+}
+
+func (cfg *SessionCookieConfig) cookie(name, value string, expires time.Time, httpOnly bool) *http.Cookie {
+	maxAge := int(time.Until(expires).Seconds())
+	if maxAge <= 0 {
+		maxAge = -1
+	}
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     cfg.Path,
+		Domain:   cfg.Domain,
+		Expires:  expires,
+		MaxAge:   maxAge,
+		Secure:   cfg.Secure,
+		HttpOnly: httpOnly,
+		SameSite: cfg.SameSite,
+	}
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/shenjing023/rbac-api-gateway/internal/user"
+	"github.com/shenjing023/rbac-api-gateway/pkg/directory"
+	"gorm.io/gorm"
+)
+
+// directoryProvider 是 LDAP 用户在 FederatedIdentity 中的 provider
+const directoryProvider = "ldap"
+
+// ErrDirectoryUnavailable 表示无法连接目录服务，此时不计入登录失败次数
+var ErrDirectoryUnavailable = errors.New("目录服务暂时不可用")
+
+// Directory 使用 LDAP / Active Directory 认证用户，并按目录中的组同步用户的角色
+type Directory struct {
+	db     *gorm.DB
+	client *directory.Client
+	// RoleRules 中的 group 是组的 DN，不区分大小写，按顺序取第一个匹配的规则
+	roleRules   []RoleRule
+	defaultRole string
+}
+
+func NewDirectory(db *gorm.DB, client *directory.Client, roleRules []RoleRule, defaultRole string) *Directory {
+	return &Directory{db: db, client: client, roleRules: roleRules, defaultRole: defaultRole}
+}
+
+// Login 通过 LDAP 绑定校验密码，第一次登录时创建本地用户
+func (d *Directory) Login(ctx context.Context, username, password string) (*user.User, string, error) {
+	entry, err := d.client.Authenticate(username, password)
+	if err != nil {
+		if errors.Is(err, directory.ErrInvalidCredentials) {
+			return nil, "", ErrInvalidCredentials
+		}
+		log.Printf("ldap: %v", err)
+		return nil, "", ErrDirectoryUnavailable
+	}
+
+	role := d.role(entry.Groups)
+	if role == "" {
+		return nil, "", ErrNoMatchingRole
+	}
+	u, err := provisionExternalUser(ctx, d.db, directoryProvider, strings.ToLower(entry.Username), entry.Username, role)
+	if err != nil {
+		return nil, "", err
+	}
+	return u, role, nil
+}
+
+// Sync 按目录中当前的组重新计算登录过的 LDAP 用户的角色，
+// 已从目录中删除或不再属于任何映射组的用户会失去所有角色
+func (d *Directory) Sync(ctx context.Context) error {
+	entries, err := d.client.Users()
+	if err != nil {
+		return err
+	}
+	groups := make(map[string][]string, len(entries))
+	for _, e := range entries {
+		groups[strings.ToLower(e.Username)] = e.Groups
+	}
+
+	var identities []FederatedIdentity
+	if err := d.db.WithContext(ctx).Where("provider = ?", directoryProvider).Find(&identities).Error; err != nil {
+		return err
+	}
+	// 查询条件配置错误时目录会返回空结果，此时不能清除所有人的角色
+	if len(entries) == 0 && len(identities) > 0 {
+		return errors.New("目录中没有匹配的用户，跳过同步")
+	}
+
+	var failed int
+	for _, identity := range identities {
+		role := ""
+		if userGroups, ok := groups[identity.Subject]; ok {
+			role = d.role(userGroups)
+		}
+		err := d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return syncUserRole(tx, identity.UserID, role)
+		})
+		if err != nil {
+			failed++
+			log.Printf("ldap sync: user %d (%s): %v", identity.UserID, identity.Subject, err)
+		}
+	}
+	log.Printf("ldap sync: %d users synced, %d failed", len(identities)-failed, failed)
+	return nil
+}
+
+// Run 立即同步一次，之后按间隔同步，直到 ctx 取消
+func (d *Directory) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := d.Sync(ctx); err != nil {
+			log.Printf("ldap sync: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *Directory) role(groups []string) string {
+	for _, rule := range d.roleRules {
+		for _, group := range groups {
+			if strings.EqualFold(group, rule.Group) {
+				return rule.Role
+			}
+		}
+	}
+	return d.defaultRole
+}
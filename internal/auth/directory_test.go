@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shenjing023/rbac-api-gateway/pkg/directory"
+	"github.com/shenjing023/rbac-api-gateway/pkg/directory/directorytest"
+	"gorm.io/gorm"
+)
+
+const (
+	testLDAPServiceDN = "cn=gateway,dc=example,dc=com"
+	testLDAPAdminsDN  = "cn=Admins,ou=groups,dc=example,dc=com"
+	testLDAPStaffDN   = "cn=Staff,ou=groups,dc=example,dc=com"
+)
+
+func ldapPerson(uid string, groups ...string) directorytest.Entry {
+	return directorytest.Entry{
+		DN:         "uid=" + uid + ",ou=people,dc=example,dc=com",
+		Password:   uid + "-pass",
+		Attributes: map[string][]string{"objectClass": {"person"}, "uid": {uid}, "memberOf": groups},
+	}
+}
+
+func ldapService() directorytest.Entry {
+	return directorytest.Entry{DN: testLDAPServiceDN, Password: "service-pass"}
+}
+
+func newTestLDAPDirectory(t *testing.T, db *gorm.DB, defaultRole string, entries ...directorytest.Entry) (*Directory, *directorytest.Server) {
+	t.Helper()
+	srv, err := directorytest.NewServer(append([]directorytest.Entry{ldapService()}, entries...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+	client, err := directory.NewClient(directory.Config{
+		URL:               srv.URL,
+		BindDN:            testLDAPServiceDN,
+		BindPassword:      "service-pass",
+		BaseDN:            "dc=example,dc=com",
+		UserFilter:        "(uid={username})",
+		SyncFilter:        "(objectClass=person)",
+		UsernameAttribute: "uid",
+		GroupAttribute:    "memberOf",
+		Timeout:           time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 规则中的组 DN 与目录中的大小写不同，匹配时不区分大小写
+	rules := []RoleRule{{Group: "CN=ADMINS,OU=GROUPS,DC=EXAMPLE,DC=COM", Role: "admin"}, {Group: testLDAPStaffDN, Role: "moderator"}}
+	return NewDirectory(db, client, rules, defaultRole), srv
+}
+
+func TestDirectoryLogin(t *testing.T) {
+	tests := []struct {
+		name        string
+		defaultRole string
+		username    string
+		password    string
+		wantErr     error
+		wantRole    string
+	}{
+		{name: "admin group", username: "alice", password: "alice-pass", wantRole: "admin"},
+		{name: "rule order decides", username: "dave", password: "dave-pass", wantRole: "admin"},
+		{name: "staff group", username: "bob", password: "bob-pass", wantRole: "moderator"},
+		{name: "default role", defaultRole: "user", username: "carol", password: "carol-pass", wantRole: "user"},
+		{name: "no matching role", username: "carol", password: "carol-pass", wantErr: ErrNoMatchingRole},
+		{name: "wrong password", username: "alice", password: "wrong", wantErr: ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			createRoles(t, db, "admin", "moderator", "user")
+			d, _ := newTestLDAPDirectory(t, db, tt.defaultRole,
+				ldapPerson("alice", testLDAPAdminsDN),
+				ldapPerson("bob", testLDAPStaffDN),
+				ldapPerson("carol"),
+				ldapPerson("dave", testLDAPStaffDN, testLDAPAdminsDN))
+
+			u, role, err := d.Login(context.Background(), tt.username, tt.password)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Login error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Login: %v", err)
+			}
+			if u.Username != tt.username || role != tt.wantRole {
+				t.Errorf("Login = %s/%s, want %s/%s", u.Username, role, tt.username, tt.wantRole)
+			}
+			stored, err := NewService(db).userRole(u.ID)
+			if err != nil || string(stored) != tt.wantRole {
+				t.Errorf("stored role = %q (%v), want %q", stored, err, tt.wantRole)
+			}
+		})
+	}
+}
+
+func TestDirectoryLoginUnavailable(t *testing.T) {
+	db := newTestDB(t)
+	d, srv := newTestLDAPDirectory(t, db, "", ldapPerson("alice", testLDAPAdminsDN))
+	srv.Close()
+
+	// 目录不可用与密码错误要区分开，前者不计入登录失败次数
+	if _, _, err := d.Login(context.Background(), "alice", "alice-pass"); !errors.Is(err, ErrDirectoryUnavailable) {
+		t.Fatalf("Login error = %v, want %v", err, ErrDirectoryUnavailable)
+	}
+}
+
+func TestDirectorySync(t *testing.T) {
+	db := newTestDB(t)
+	createRoles(t, db, "admin", "moderator")
+	d, srv := newTestLDAPDirectory(t, db, "",
+		ldapPerson("alice", testLDAPAdminsDN),
+		ldapPerson("bob", testLDAPStaffDN))
+
+	users := make(map[string]uint)
+	for _, name := range []string{"alice", "bob"} {
+		u, _, err := d.Login(context.Background(), name, name+"-pass")
+		if err != nil {
+			t.Fatalf("Login %s: %v", name, err)
+		}
+		users[name] = u.ID
+	}
+
+	// alice 被移到 staff 组，bob 从目录中删除
+	srv.SetEntries(ldapService(), ldapPerson("alice", testLDAPStaffDN))
+	if err := d.Sync(context.Background()); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	s := NewService(db)
+	tests := []struct {
+		username string
+		wantRole string // 为空表示没有角色
+	}{
+		{"alice", "moderator"},
+		{"bob", ""},
+	}
+	for _, tt := range tests {
+		var count int64
+		db.Table("user_roles").Where("user_id = ? AND deleted_at IS NULL", users[tt.username]).Count(&count)
+		if tt.wantRole == "" {
+			if count != 0 {
+				t.Errorf("%s still has %d roles", tt.username, count)
+			}
+			continue
+		}
+		if role, err := s.userRole(users[tt.username]); err != nil || string(role) != tt.wantRole || count != 1 {
+			t.Errorf("%s role = %q (%d roles, %v), want %q", tt.username, role, count, err, tt.wantRole)
+		}
+	}
+
+	// 目录返回空结果时多半是查询条件配置错误，不能清除所有人的角色
+	srv.SetEntries(ldapService())
+	if err := d.Sync(context.Background()); err == nil {
+		t.Fatal("Sync with an empty directory should fail")
+	}
+	if role, _ := s.userRole(users["alice"]); role != "moderator" {
+		t.Errorf("alice role after empty sync = %q, want moderator", role)
+	}
+}
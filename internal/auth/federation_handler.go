@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// federationStateCookie 把 state 绑定到发起登录的浏览器，防止登录 CSRF
+const federationStateCookie = "gw_federation_state"
+
+type FederationHandler struct {
+	service *FederationService
+}
+
+func NewFederationHandler(service *FederationService) *FederationHandler {
+	return &FederationHandler{service: service}
+}
+
+func (h *FederationHandler) ListProviders(c *gin.Context) {
+	providers := make([]gin.H, 0, len(h.service.Providers()))
+	for _, name := range h.service.Providers() {
+		providers = append(providers, gin.H{"name": name, "login_url": "/auth/login/" + name})
+	}
+	c.JSON(http.StatusOK, gin.H{"providers": providers})
+}
+
+// Login 跳转到上游身份提供方的登录页面
+func (h *FederationHandler) Login(c *gin.Context) {
+	authURL, state, err := h.service.Begin(c.Request.Context(), c.Param("provider"))
+	if err != nil {
+		if errors.Is(err, ErrUnknownProvider) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("federation: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "身份提供方暂时不可用"})
+		return
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(federationStateCookie, state, int(federationStateTTL.Seconds()), "/auth/login/", "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// Callback 处理上游身份提供方的回调，登录成功后返回与 /auth/login 相同的 token。
+// 启用会话 cookie 时把 token 保存在 cookie 中并跳转到前端页面
+func (h *FederationHandler) Callback(c *gin.Context) {
+	if errCode := c.Query("error"); errCode != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "身份提供方拒绝了登录: " + errCode})
+		return
+	}
+
+	state := c.Query("state")
+	cookie, err := c.Cookie(federationStateCookie)
+	if err != nil || state == "" || cookie != state {
+		c.JSON(http.StatusBadRequest, gin.H{"error": ErrInvalidState.Error()})
+		return
+	}
+	c.SetCookie(federationStateCookie, "", -1, "/auth/login/", "", c.Request.TLS != nil, true)
+
+	client := clientInfo(c, "")
+	client.Cookie = h.service.cookie != nil
+	token, err := h.service.Complete(c.Request.Context(), c.Param("provider"), state, c.Query("code"), client)
+	switch {
+	case err == nil && client.Cookie:
+		if _, err := h.service.cookie.set(c, token); err != nil {
+			log.Printf("federation: set session cookie: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "单点登录失败"})
+			return
+		}
+		redirect := h.service.cookie.LoginRedirect
+		if redirect == "" {
+			redirect = "/"
+		}
+		c.Redirect(http.StatusFound, redirect)
+	case err == nil:
+		c.JSON(http.StatusOK, gin.H{"token": token})
+	case errors.Is(err, ErrInvalidState):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrNoMatchingRole), isAccountStatusError(err):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrUsernameTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		log.Printf("federation: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "单点登录失败"})
+	}
+}
+
+// RegisterFederationRoutes 注册通过上游身份提供方登录的路由，没有配置身份提供方时不注册
+func RegisterFederationRoutes(r *gin.Engine, service *FederationService) {
+	if len(service.Providers()) == 0 {
+		return
+	}
+	handler := NewFederationHandler(service)
+
+	auth := r.Group("/auth")
+	{
+		auth.GET("/providers", handler.ListProviders)
+		auth.GET("/login/:provider", handler.Login)
+		auth.GET("/login/:provider/callback", handler.Callback)
+	}
+}
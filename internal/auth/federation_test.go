@@ -0,0 +1,362 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	jwtlib "github.com/golang-jwt/jwt/v4"
+	"github.com/shenjing023/rbac-api-gateway/internal/rbac"
+	"github.com/shenjing023/rbac-api-gateway/pkg/jwt"
+	"github.com/shenjing023/rbac-api-gateway/pkg/oidc"
+	"gorm.io/gorm"
+)
+
+// fakeIssuer 是一个最小的上游 OIDC 身份提供方：发现文档、JWKS 和令牌端点，
+// 授权码对应的 ID token 声明由测试通过 authorize 预先登记
+type fakeIssuer struct {
+	srv *httptest.Server
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]jwtlib.MapClaims
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeIssuer{key: key, codes: make(map[string]jwtlib.MapClaims)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 f.srv.URL,
+			"authorization_endpoint": f.srv.URL + "/authorize",
+			"token_endpoint":         f.srv.URL + "/token",
+			"jwks_uri":               f.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"kid": "k1",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		claims, ok := f.codes[r.PostFormValue("code")]
+		delete(f.codes, r.PostFormValue("code"))
+		f.mu.Unlock()
+		if !ok || r.PostFormValue("code_verifier") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		token := jwtlib.NewWithClaims(jwtlib.SigningMethodRS256, claims)
+		token.Header["kid"] = "k1"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "at", "token_type": "Bearer", "id_token": idToken})
+	})
+	f.srv = httptest.NewServer(mux)
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+// authorize 模拟用户在上游登录成功，返回上游回调给网关的授权码
+func (f *fakeIssuer) authorize(code string, claims jwtlib.MapClaims) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.codes[code] = claims
+	return code
+}
+
+func (f *fakeIssuer) provider(t *testing.T, name string) *FederatedProvider {
+	t.Helper()
+	client, err := oidc.NewClient(oidc.Config{Issuer: f.srv.URL, ClientID: "gateway", ClientSecret: "secret", RedirectURL: "https://gw.example.com/cb", Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &FederatedProvider{
+		Name:          name,
+		Client:        client,
+		UsernameClaim: "preferred_username",
+		GroupsClaim:   "groups",
+		RoleRules:     []RoleRule{{Group: "admins", Role: "admin"}, {Group: "staff", Role: "moderator"}},
+	}
+}
+
+func createRoles(t *testing.T, db *gorm.DB, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if err := db.Create(&rbac.Role{Name: name}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFederationComplete(t *testing.T) {
+	tests := []struct {
+		name        string
+		defaultRole string
+		claims      jwtlib.MapClaims // 覆盖默认的声明，值为 nil 表示删除该声明
+		state       string           // 回调的 state，为空时使用 Begin 返回的值
+		provider    string           // 回调的提供方，为空时与 Begin 相同
+		wantErr     error            // 为 nil 且 wantAnyErr 为 false 表示登录成功
+		wantAnyErr  bool
+		wantRole    string
+		wantAMR     []string
+	}{
+		{name: "group mapped to role", wantRole: "admin", wantAMR: []string{"fed", "fed:corp:pwd", "fed:corp:mfa"}},
+		{name: "rule order decides", claims: jwtlib.MapClaims{"groups": []string{"staff", "admins"}}, wantRole: "admin", wantAMR: []string{"fed", "fed:corp:pwd", "fed:corp:mfa"}},
+		{name: "string groups claim", claims: jwtlib.MapClaims{"groups": "staff"}, wantRole: "moderator", wantAMR: []string{"fed", "fed:corp:pwd", "fed:corp:mfa"}},
+		{name: "default role", defaultRole: "user", claims: jwtlib.MapClaims{"groups": []string{"contractors"}}, wantRole: "user", wantAMR: []string{"fed", "fed:corp:pwd", "fed:corp:mfa"}},
+		{name: "no matching role", claims: jwtlib.MapClaims{"groups": []string{"contractors"}}, wantErr: ErrNoMatchingRole},
+		{name: "missing amr is fed", claims: jwtlib.MapClaims{"amr": nil}, wantRole: "admin", wantAMR: []string{"fed"}},
+		{name: "unknown state", state: "forged", wantErr: ErrInvalidState},
+		{name: "state from another provider", provider: "other", wantErr: ErrInvalidState},
+		{name: "nonce mismatch", claims: jwtlib.MapClaims{"nonce": "replayed"}, wantAnyErr: true},
+		{name: "wrong audience", claims: jwtlib.MapClaims{"aud": "another-client"}, wantAnyErr: true},
+		{name: "expired id token", claims: jwtlib.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}, wantAnyErr: true},
+		{name: "missing username", claims: jwtlib.MapClaims{"preferred_username": nil}, wantAnyErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newFakeIssuer(t)
+			db := newTestDB(t)
+			createRoles(t, db, "admin", "moderator", "user")
+			corp := issuer.provider(t, "corp")
+			corp.DefaultRole = tt.defaultRole
+			s := NewFederationService(db, []*FederatedProvider{corp, issuer.provider(t, "other")})
+
+			authURL, state, err := s.Begin(context.Background(), "corp")
+			if err != nil {
+				t.Fatalf("Begin: %v", err)
+			}
+			u, err := url.Parse(authURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			query := u.Query()
+			if query.Get("state") != state || query.Get("code_challenge_method") != "S256" {
+				t.Fatalf("unexpected authorization url %s", authURL)
+			}
+
+			claims := jwtlib.MapClaims{
+				"iss":                issuer.srv.URL,
+				"aud":                "gateway",
+				"sub":                "u-1",
+				"exp":                time.Now().Add(time.Minute).Unix(),
+				"nonce":              query.Get("nonce"),
+				"preferred_username": "alice",
+				"groups":             []string{"admins"},
+				"amr":                []string{"pwd", "mfa"},
+			}
+			for k, v := range tt.claims {
+				if v == nil {
+					delete(claims, k)
+				} else {
+					claims[k] = v
+				}
+			}
+			code := issuer.authorize("code-1", claims)
+
+			if tt.state != "" {
+				state = tt.state
+			}
+			provider := "corp"
+			if tt.provider != "" {
+				provider = tt.provider
+			}
+			token, err := s.Complete(context.Background(), provider, state, code, ClientInfo{IP: "192.0.2.1"})
+			if tt.wantErr != nil || tt.wantAnyErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Complete error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Complete: %v", err)
+			}
+
+			got, err := jwt.ValidateToken(token)
+			if err != nil {
+				t.Fatalf("ValidateToken: %v", err)
+			}
+			if got.Username != "alice" || got.Role != tt.wantRole {
+				t.Errorf("token user = %s/%s, want alice/%s", got.Username, got.Role, tt.wantRole)
+			}
+			if !slices.Equal(got.AMR, tt.wantAMR) {
+				t.Errorf("amr = %v, want %v", got.AMR, tt.wantAMR)
+			}
+			role, err := NewService(db).userRole(got.UserID)
+			if err != nil || string(role) != tt.wantRole {
+				t.Errorf("stored role = %q (%v), want %q", role, err, tt.wantRole)
+			}
+
+			// state 只能使用一次
+			if _, err := s.Complete(context.Background(), provider, state, issuer.authorize("code-2", claims), ClientInfo{}); !errors.Is(err, ErrInvalidState) {
+				t.Errorf("reused state error = %v, want %v", err, ErrInvalidState)
+			}
+		})
+	}
+}
+
+func TestFederationResyncsRoleAndProtectsLocalAccounts(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	db := newTestDB(t)
+	createRoles(t, db, "admin", "moderator")
+	s := NewFederationService(db, []*FederatedProvider{issuer.provider(t, "corp")})
+
+	login := func(t *testing.T, subject, username string, groups ...string) (*jwt.Claims, error) {
+		t.Helper()
+		authURL, state, err := s.Begin(context.Background(), "corp")
+		if err != nil {
+			t.Fatalf("Begin: %v", err)
+		}
+		u, _ := url.Parse(authURL)
+		code, _ := randomToken(8)
+		issuer.authorize(code, jwtlib.MapClaims{
+			"iss": issuer.srv.URL, "aud": "gateway", "sub": subject, "exp": time.Now().Add(time.Minute).Unix(),
+			"nonce": u.Query().Get("nonce"), "preferred_username": username, "groups": groups,
+		})
+		token, err := s.Complete(context.Background(), "corp", state, code, ClientInfo{})
+		if err != nil {
+			return nil, err
+		}
+		return jwt.ValidateToken(token)
+	}
+
+	first, err := login(t, "u-1", "alice", "admins")
+	if err != nil {
+		t.Fatalf("first login: %v", err)
+	}
+	second, err := login(t, "u-1", "alice", "staff")
+	if err != nil {
+		t.Fatalf("second login: %v", err)
+	}
+	if second.UserID != first.UserID || second.Role != "moderator" {
+		t.Errorf("second login = user %d role %s, want user %d role moderator", second.UserID, second.Role, first.UserID)
+	}
+	var count int64
+	db.Model(&rbac.UserRole{}).Where("user_id = ?", first.UserID).Count(&count)
+	if count != 1 {
+		t.Errorf("user has %d roles after resync, want 1", count)
+	}
+
+	createUser(t, db, "bob", "password", "")
+	if _, err := login(t, "u-2", "bob", "admins"); !errors.Is(err, ErrUsernameTaken) {
+		t.Errorf("login as local username error = %v, want %v", err, ErrUsernameTaken)
+	}
+}
+
+func TestFederationCallback(t *testing.T) {
+	tests := []struct {
+		name   string
+		cookie *SessionCookieConfig
+	}{
+		{"json", nil},
+		{"session cookie", &SessionCookieConfig{Name: "gw_session", CSRFName: "gw_csrf", Path: "/", LoginRedirect: "/app"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newFakeIssuer(t)
+			db := newTestDB(t)
+			createRoles(t, db, "admin")
+			s := NewFederationService(db, []*FederatedProvider{issuer.provider(t, "corp")})
+			if tt.cookie != nil {
+				s.SetSessionCookie(*tt.cookie)
+			}
+			r := gin.New()
+			RegisterFederationRoutes(r, s)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/login/corp", nil))
+			if w.Code != http.StatusFound {
+				t.Fatalf("login status = %d, want %d", w.Code, http.StatusFound)
+			}
+			authURL, err := url.Parse(w.Header().Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			state := authURL.Query().Get("state")
+			code := issuer.authorize("code-1", jwtlib.MapClaims{
+				"iss": issuer.srv.URL, "aud": "gateway", "sub": "u-1", "exp": time.Now().Add(time.Minute).Unix(),
+				"nonce": authURL.Query().Get("nonce"), "preferred_username": "alice", "groups": []string{"admins"},
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/auth/login/corp/callback?state="+state+"&code="+code, nil)
+			for _, c := range w.Result().Cookies() {
+				req.AddCookie(c)
+			}
+			w = httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			cookies := make(map[string]string)
+			for _, c := range w.Result().Cookies() {
+				cookies[c.Name] = c.Value
+			}
+			var token string
+			if tt.cookie == nil {
+				var body struct {
+					Token string `json:"token"`
+				}
+				if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &body) != nil {
+					t.Fatalf("callback = %d %s, want 200 with a token", w.Code, w.Body)
+				}
+				if _, ok := cookies["gw_session"]; ok {
+					t.Error("session cookie set although session cookies are disabled")
+				}
+				token = body.Token
+			} else {
+				// 与 /auth/login 的 cookie 模式一样，token 只保存在 HttpOnly cookie 中
+				if w.Code != http.StatusFound || w.Header().Get("Location") != tt.cookie.LoginRedirect {
+					t.Fatalf("callback = %d to %q, want a redirect to %s", w.Code, w.Header().Get("Location"), tt.cookie.LoginRedirect)
+				}
+				if strings.Contains(w.Body.String(), cookies["gw_session"]) {
+					t.Error("response body contains the session token")
+				}
+				token = cookies["gw_session"]
+			}
+
+			claims, err := jwt.ValidateToken(token)
+			if err != nil {
+				t.Fatalf("ValidateToken: %v", err)
+			}
+			if claims.Username != "alice" {
+				t.Errorf("token user = %s, want alice", claims.Username)
+			}
+			if tt.cookie != nil && (claims.CSRF == "" || cookies["gw_csrf"] != claims.CSRF) {
+				t.Errorf("csrf cookie = %q, token csrf = %q", cookies["gw_csrf"], claims.CSRF)
+			}
+			if tt.cookie == nil && claims.CSRF != "" {
+				t.Error("json token carries a csrf token")
+			}
+		})
+	}
+}
@@ -1,9 +1,19 @@
 package auth
 
 import (
+	"errors"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/shenjing023/rbac-api-gateway/internal/audit"
+	"github.com/shenjing023/rbac-api-gateway/internal/user"
+	"github.com/shenjing023/rbac-api-gateway/pkg/jwt"
+	"github.com/shenjing023/rbac-api-gateway/pkg/password"
+	"gorm.io/gorm"
 )
 
 type Handler struct {
@@ -18,6 +28,11 @@ func (h *Handler) Register(c *gin.Context) {
 	var req struct {
 		Username string `json:"username" binding:"required"`
 		Password string `json:"password" binding:"required"`
+		Email    string `json:"email" binding:"omitempty,email"`
+		// InviteCode 在 invite 模式下必须提供
+		InviteCode string `json:"invite_code"`
+		// CaptchaToken 是前端人机验证组件返回的 token，见 GET /auth/registration
+		CaptchaToken string `json:"captcha_token"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -25,45 +40,536 @@ func (h *Handler) Register(c *gin.Context) {
 		return
 	}
 
-	if err := h.service.Register(req.Username, req.Password); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "注册失败"})
+	err := h.service.Register(c.Request.Context(), RegisterRequest{
+		Username:     req.Username,
+		Email:        req.Email,
+		Password:     req.Password,
+		InviteCode:   req.InviteCode,
+		CaptchaToken: req.CaptchaToken,
+		IP:           c.ClientIP(),
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, password.ErrPolicyViolation), errors.Is(err, ErrEmailRequired),
+			errors.Is(err, ErrInvalidInvite), errors.Is(err, ErrCaptchaFailed):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, ErrRegistrationClosed), errors.Is(err, ErrInviteRequired),
+			errors.Is(err, ErrEmailDomainNotAllowed), errors.Is(err, ErrNoMatchingRole):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, ErrCaptchaUnavailable):
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "注册失败"})
+		}
 		return
 	}
 
+	if h.service.EmailVerificationEnabled() {
+		c.JSON(http.StatusCreated, gin.H{"message": "注册成功，请通过邮件中的链接验证邮箱"})
+		return
+	}
 	c.JSON(http.StatusCreated, gin.H{"message": "注册成功"})
 }
 
+// RegistrationInfo 返回当前的注册方式，前端据此显示邀请码输入框和人机验证组件
+func (h *Handler) RegistrationInfo(c *gin.Context) {
+	c.JSON(http.StatusOK, h.service.RegistrationInfo())
+}
+
+// CreateInvitation 创建邀请码，明文邀请码只在这次响应中返回
+func (h *Handler) CreateInvitation(c *gin.Context) {
+	var req struct {
+		Role  string `json:"role" binding:"required"`
+		Email string `json:"email" binding:"omitempty,email"`
+		Note  string `json:"note"`
+		// TTL 是有效期，例如 "72h"，为空时使用配置的默认值
+		TTL string `json:"ttl"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var ttl time.Duration
+	if req.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(req.TTL); err != nil || ttl <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的有效期"})
+			return
+		}
+	}
+
+	invitation, code, err := h.service.CreateInvitation(c.Request.Context(), CreateInvitationRequest{
+		Role:  req.Role,
+		Email: req.Email,
+		Note:  req.Note,
+		TTL:   ttl,
+		Actor: auditActor(c),
+	})
+	if err != nil {
+		if errors.Is(err, ErrInvalidInviteRole) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建邀请失败"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"invitation": invitation, "code": code})
+}
+
+func (h *Handler) ListInvitations(c *gin.Context) {
+	invitations, err := h.service.ListInvitations(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取邀请失败"})
+		return
+	}
+	c.JSON(http.StatusOK, invitations)
+}
+
+// RevokeInvitation 作废还没有使用的邀请码
+func (h *Handler) RevokeInvitation(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的邀请ID"})
+		return
+	}
+	if err := h.service.RevokeInvitation(c.Request.Context(), uint(id), auditActor(c)); err != nil {
+		if errors.Is(err, ErrInvitationNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "作废邀请失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "邀请已作废"})
+}
+
 func (h *Handler) Login(c *gin.Context) {
 	var req struct {
 		Username string `json:"username" binding:"required"`
 		Password string `json:"password" binding:"required"`
+		// Device 是客户端自己的名称，显示在会话列表中，为空时根据 User-Agent 识别
+		Device string `json:"device"`
+		// Cookie 为 true 时 token 保存在 HttpOnly 的会话 cookie 中，响应只返回 CSRF token，供浏览器客户端使用
+		Cookie bool `json:"cookie"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if req.Cookie && h.service.cookie == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": ErrSessionCookieDisabled.Error()})
+		return
+	}
 
-	token, err := h.service.Login(req.Username, req.Password)
+	client := clientInfo(c, req.Device)
+	client.Cookie = req.Cookie
+	result, err := h.service.Login(c.Request.Context(), LoginRequest{
+		Username: req.Username,
+		Password: req.Password,
+		Client:   client,
+	})
 	if err != nil {
+		writeLoginError(c, err)
+		return
+	}
+
+	if req.Cookie && result.Token != "" {
+		csrf, err := h.service.cookie.set(c, result.Token)
+		if err != nil {
+			log.Printf("login: set session cookie: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "登录失败"})
+			return
+		}
+		result.Token, result.CSRFToken = "", csrf
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// clientInfo 返回记录在会话中的客户端信息
+func clientInfo(c *gin.Context, device string) ClientInfo {
+	return ClientInfo{IP: c.ClientIP(), UserAgent: c.Request.UserAgent(), Device: device}
+}
+
+// writeLoginError 把登录错误转换为响应，凭据错误统一返回相同的提示
+func writeLoginError(c *gin.Context, err error) {
+	var throttled *ThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrInvalidCredentials):
 		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrNoMatchingRole), errors.Is(err, ErrUsernameTaken), isAccountStatusError(err):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrDirectoryUnavailable):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		log.Printf("login: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "登录失败"})
+	}
+}
+
+// ChangePassword 修改当前用户的密码，成功后所有会话（包括当前的）都需要重新登录
+func (h *Handler) ChangePassword(c *gin.Context) {
+	var req struct {
+		CurrentPassword string `json:"current_password" binding:"required"`
+		NewPassword     string `json:"new_password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"token": token})
+	err := h.service.ChangePassword(c.Request.Context(), ChangePasswordRequest{
+		UserID:          c.GetUint("user_id"),
+		CurrentPassword: req.CurrentPassword,
+		NewPassword:     req.NewPassword,
+		IP:              c.ClientIP(),
+	})
+	if err != nil {
+		writePasswordError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "密码已修改，请重新登录"})
 }
 
-func (h *Handler) Logout(c *gin.Context) {
-	token := c.GetHeader("Authorization")
+// ForgotPassword 申请重置密码。无论用户是否存在都返回相同的响应
+func (h *Handler) ForgotPassword(c *gin.Context) {
+	var req struct {
+		Username string `json:"username" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.service.RequestPasswordReset(c.Request.Context(), req.Username, c.ClientIP()); err != nil {
+		writePasswordError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "如果账号存在，重置密码的链接已经发送"})
+}
+
+// ResetPassword 使用通知中的令牌设置新密码
+func (h *Handler) ResetPassword(c *gin.Context) {
+	var req struct {
+		Token       string `json:"token" binding:"required"`
+		NewPassword string `json:"new_password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.service.ResetPassword(c.Request.Context(), req.Token, req.NewPassword, c.ClientIP()); err != nil {
+		writePasswordError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "密码已重置，请重新登录"})
+}
+
+func writePasswordError(c *gin.Context, err error) {
+	var throttled *ThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, password.ErrPolicyViolation), errors.Is(err, ErrInvalidResetToken), errors.Is(err, ErrPasswordNotSet):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrInvalidCredentials):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "当前密码错误"})
+	default:
+		log.Printf("password: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "操作失败"})
+	}
+}
+
+// VerifyEmail 使用验证链接中的 token 激活账号，支持 GET ?token= 和 POST {"token": ...}
+func (h *Handler) VerifyEmail(c *gin.Context) {
+	token := c.Query("token")
+	if c.Request.Method == http.MethodPost {
+		var req struct {
+			Token string `json:"token" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		token = req.Token
+	}
 	if token == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "未提供token"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少 token"})
+		return
+	}
+
+	if err := h.service.VerifyEmail(c.Request.Context(), token, c.ClientIP()); err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidVerifyToken):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case isAccountStatusError(err):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		default:
+			log.Printf("email verification: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "验证失败"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "邮箱已验证，账号已激活"})
+}
+
+// ResendVerification 重新发送验证邮件。无论用户是否存在都返回相同的响应
+func (h *Handler) ResendVerification(c *gin.Context) {
+	var req struct {
+		Username string `json:"username" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.service.ResendVerification(c.Request.Context(), req.Username); err != nil {
+		log.Printf("email verification: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "发送失败"})
 		return
 	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "如果账号存在且尚未验证，验证邮件已经发送"})
+}
 
-	if err := h.service.Logout(token); err != nil {
+// SetStatus 修改账号状态，停用或注销账号会立即使其所有会话失效
+func (h *Handler) SetStatus(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的用户ID"})
+		return
+	}
+	var req struct {
+		Status string `json:"status" binding:"required"`
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	actor := auditActor(c)
+	if err := h.service.SetStatus(c.Request.Context(), uint(id), user.Status(req.Status), req.Reason, actor); err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidStatus):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status 必须是 active、suspended 或 deactivated"})
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "修改账号状态失败"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "账号状态已修改"})
+}
+
+// ListSessions 列出当前用户有效的登录会话
+func (h *Handler) ListSessions(c *gin.Context) {
+	h.listSessions(c, c.GetUint("user_id"))
+}
+
+// RevokeSession 吊销当前用户的一个会话，可以是当前会话
+func (h *Handler) RevokeSession(c *gin.Context) {
+	h.revokeSession(c, c.GetUint("user_id"), c.Param("id"))
+}
+
+// RevokeOtherSessions 吊销当前用户除当前会话以外的所有会话
+func (h *Handler) RevokeOtherSessions(c *gin.Context) {
+	h.revokeOtherSessions(c, c.GetUint("user_id"), c.GetString("jti"))
+}
+
+// ListUserSessions 是管理员查看任意用户的会话
+func (h *Handler) ListUserSessions(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的用户ID"})
+		return
+	}
+	h.listSessions(c, uint(userID))
+}
+
+func (h *Handler) RevokeUserSession(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的用户ID"})
+		return
+	}
+	h.revokeSession(c, uint(userID), c.Param("session_id"))
+}
+
+// RevokeUserSessions 是管理员吊销用户的所有会话
+func (h *Handler) RevokeUserSessions(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的用户ID"})
+		return
+	}
+	h.revokeOtherSessions(c, uint(userID), "")
+}
+
+func (h *Handler) listSessions(c *gin.Context, userID uint) {
+	sessions, err := h.service.ListSessions(c.Request.Context(), userID, c.GetString("jti"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取会话失败"})
+		return
+	}
+	c.JSON(http.StatusOK, sessions)
+}
+
+func (h *Handler) revokeSession(c *gin.Context, userID uint, rawSessionID string) {
+	sessionID, err := strconv.ParseUint(rawSessionID, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的会话ID"})
+		return
+	}
+
+	if err := h.service.RevokeSession(c.Request.Context(), userID, uint(sessionID), auditActor(c)); err != nil {
+		if errors.Is(err, ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "吊销会话失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "会话已吊销"})
+}
+
+func (h *Handler) revokeOtherSessions(c *gin.Context, userID uint, keepJTI string) {
+	count, err := h.service.RevokeOtherSessions(c.Request.Context(), userID, keepJTI, auditActor(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "吊销会话失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "会话已吊销", "revoked": count})
+}
+
+// auditActor 返回审计事件中执行操作的当前用户。管理员模拟用户时记录管理员本人，被模拟的用户记录在 OnBehalfOf 中
+func auditActor(c *gin.Context) audit.Event {
+	event := audit.Event{ActorID: c.GetUint("user_id"), Actor: c.GetString("username"), IP: c.ClientIP()}
+	if act, ok := c.Get("act"); ok {
+		actor := act.(*jwt.Actor)
+		event.OnBehalfOf = event.Actor
+		event.ActorID, event.Actor = actor.UserID, actor.Username
+	}
+	return event
+}
+
+// Impersonate 为管理员签发以指定用户身份访问接口的短期 token
+func (h *Handler) Impersonate(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的用户ID"})
+		return
+	}
+	var req struct {
+		Reason string `json:"reason" binding:"required"`
+		// TTL 是申请的有效期，例如 "10m"，不能超过配置的上限
+		TTL string `json:"ttl"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var ttl time.Duration
+	if req.TTL != "" {
+		if ttl, err = time.ParseDuration(req.TTL); err != nil || ttl <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的有效期"})
+			return
+		}
+	}
+
+	actor := &jwt.Claims{UserID: c.GetUint("user_id"), Username: c.GetString("username"), AMR: c.GetStringSlice("amr")}
+	if act, ok := c.Get("act"); ok {
+		actor.Act = act.(*jwt.Actor)
+	}
+	result, err := h.service.Impersonate(c.Request.Context(), ImpersonateRequest{
+		Actor:    actor,
+		TargetID: uint(id),
+		Reason:   req.Reason,
+		TTL:      ttl,
+		Client:   clientInfo(c, ""),
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		case errors.Is(err, ErrImpersonationDisabled):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, ErrReasonRequired):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, ErrCannotImpersonate), errors.Is(err, ErrNestedImpersonation), isAccountStatusError(err):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		default:
+			log.Printf("impersonate: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "模拟用户失败"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// LockoutStatus 查看账号的登录失败记录
+func (h *Handler) LockoutStatus(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的用户ID"})
+		return
+	}
+	status, err := h.service.LockoutStatus(c.Request.Context(), uint(id))
+	if err != nil {
+		writeUnlockError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// Unlock 解除账号的登录锁定
+func (h *Handler) Unlock(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的用户ID"})
+		return
+	}
+	actor := auditActor(c)
+	if err := h.service.Unlock(c.Request.Context(), uint(id), actor); err != nil {
+		writeUnlockError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已解除锁定"})
+}
+
+func writeUnlockError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+	case errors.Is(err, ErrLockoutDisabled):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "解除锁定失败"})
+	}
+}
+
+// Logout 吊销当前的 token，使用会话 cookie 登录的同时删除 cookie。
+// API key 和个人访问令牌没有 jti，需要通过各自的吊销接口删除
+func (h *Handler) Logout(c *gin.Context) {
+	value, _ := c.Get("claims")
+	claims, ok := value.(*jwt.Claims)
+	if !ok || c.GetString("jti") == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "当前凭据不支持登出"})
+		return
+	}
+
+	if err := h.service.Logout(c.Request.Context(), claims, c.ClientIP()); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "登出失败"})
 		return
 	}
+	if h.service.cookie != nil {
+		h.service.cookie.clear(c)
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "登出成功"})
 }
@@ -74,7 +580,43 @@ func RegisterRoutes(r *gin.Engine, service *Service) {
 	auth := r.Group("/auth")
 	{
 		auth.POST("/register", handler.Register)
+		auth.GET("/registration", handler.RegistrationInfo)
 		auth.POST("/login", handler.Login)
 		auth.POST("/logout", handler.Logout)
+		auth.POST("/password/change", handler.ChangePassword)
+		// 没有配置通知方式时无法发送重置令牌，不提供找回密码
+		if service.PasswordResetEnabled() {
+			auth.POST("/password/forgot", handler.ForgotPassword)
+			auth.POST("/password/reset", handler.ResetPassword)
+		}
+		auth.GET("/sessions", handler.ListSessions)
+		auth.DELETE("/sessions", handler.RevokeOtherSessions)
+		auth.DELETE("/sessions/:id", handler.RevokeSession)
+		if service.EmailVerificationEnabled() {
+			auth.GET("/email/verify", handler.VerifyEmail)
+			auth.POST("/email/verify", handler.VerifyEmail)
+			auth.POST("/email/resend", handler.ResendVerification)
+		}
+	}
+
+	users := r.Group("/users")
+	{
+		users.GET("/:id/lockout", handler.LockoutStatus)
+		users.POST("/:id/unlock", handler.Unlock)
+		users.PUT("/:id/status", handler.SetStatus)
+		users.GET("/:id/sessions", handler.ListUserSessions)
+		users.DELETE("/:id/sessions", handler.RevokeUserSessions)
+		users.DELETE("/:id/sessions/:session_id", handler.RevokeUserSession)
+		if service.ImpersonationEnabled() {
+			users.POST("/:id/impersonate", handler.Impersonate)
+		}
+	}
+
+	// 管理员管理邀请注册的邀请码
+	invitations := r.Group("/invitations")
+	{
+		invitations.POST("", handler.CreateInvitation)
+		invitations.GET("", handler.ListInvitations)
+		invitations.DELETE("/:id", handler.RevokeInvitation)
 	}
 }
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shenjing023/rbac-api-gateway/pkg/jwt"
+)
+
+// authenticated 模拟网关的 AuthMiddleware，把认证后的 claims 放入上下文
+func authenticated(claims *jwt.Claims) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if claims != nil {
+			c.Set("claims", claims)
+			c.Set("user_id", claims.UserID)
+			c.Set("jti", claims.ID)
+		}
+		c.Next()
+	}
+}
+
+func TestLogout(t *testing.T) {
+	db := newTestDB(t)
+	u := createUser(t, db, "alice", "password", "user")
+	s := NewService(db)
+	s.SetSessionCookie(SessionCookieConfig{Name: "gw_session", CSRFName: "gw_csrf", Path: "/"})
+
+	login := func(t *testing.T) *jwt.Claims {
+		t.Helper()
+		result, err := s.Login(context.Background(), LoginRequest{Username: "alice", Password: "password"})
+		if err != nil {
+			t.Fatalf("Login: %v", err)
+		}
+		claims, err := jwt.ValidateToken(result.Token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name       string
+		claims     *jwt.Claims
+		wantStatus int
+	}{
+		{"session token", login(t), http.StatusOK},
+		// API key 和个人访问令牌的身份没有 jti，不能通过登出吊销
+		{"api key", &jwt.Claims{UserID: u.ID, Username: "alice", Role: "user", AMR: []string{jwt.AMRKey}}, http.StatusBadRequest},
+		{"unauthenticated", nil, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.Use(authenticated(tt.claims))
+			RegisterRoutes(r, s)
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/auth/logout", nil)
+			req.Header.Set("Authorization", "ApiKey gwk_000000_secret")
+			r.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d %s, want %d", w.Code, w.Body, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			revoked, err := s.IsRevoked(context.Background(), tt.claims)
+			if err != nil || !revoked {
+				t.Errorf("IsRevoked after logout = %v (%v), want true", revoked, err)
+			}
+			cleared := 0
+			for _, c := range w.Result().Cookies() {
+				if (c.Name == "gw_session" || c.Name == "gw_csrf") && c.MaxAge < 0 {
+					cleared++
+				}
+			}
+			if cleared != 2 {
+				t.Errorf("logout cleared %d session cookies, want 2", cleared)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LockoutConfig 是登录失败的限制。账号连续失败 DelayAfter 次后，每次失败都要等待成倍增加的时间才能再试，
+// 达到 AccountThreshold 次后锁定 LockoutDuration；同一个 IP 失败 IPThreshold 次后同样锁定
+type LockoutConfig struct {
+	// Window 内没有新的失败时计数清零
+	Window           time.Duration
+	DelayAfter       int
+	BaseDelay        time.Duration
+	MaxDelay         time.Duration
+	AccountThreshold int
+	IPThreshold      int
+	LockoutDuration  time.Duration
+}
+
+// ErrLockoutDisabled 表示没有启用登录锁定
+var ErrLockoutDisabled = errors.New("未启用登录锁定")
+
+// ThrottledError 表示登录请求因为失败次数过多被拒绝
+type ThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("登录失败次数过多，请在 %d 秒后重试", int(math.Ceil(e.RetryAfter.Seconds())))
+}
+
+// Lockout 按账号和 IP 记录登录失败。不存在的用户名同样计数，攻击者无法通过是否被锁定判断用户名是否存在
+type Lockout struct {
+	db  *gorm.DB
+	cfg LockoutConfig
+}
+
+func NewLockout(db *gorm.DB, cfg LockoutConfig) *Lockout {
+	return &Lockout{db: db, cfg: cfg}
+}
+
+// Check 返回账号或 IP 还需要等待的时间，为 0 表示可以尝试登录
+func (l *Lockout) Check(ctx context.Context, username, ip string) (time.Duration, error) {
+	if l == nil {
+		return 0, nil
+	}
+	var throttles []LoginThrottle
+	if err := l.db.WithContext(ctx).Where("key IN ?", l.keys(username, ip)).Find(&throttles).Error; err != nil {
+		return 0, err
+	}
+
+	var wait time.Duration
+	for _, t := range throttles {
+		if t.BlockedUntil != nil {
+			wait = max(wait, time.Until(*t.BlockedUntil))
+		}
+	}
+	return wait, nil
+}
+
+// Failure 记录一次失败，返回账号是否因此被锁定
+func (l *Lockout) Failure(ctx context.Context, username, ip string) (bool, error) {
+	if l == nil {
+		return false, nil
+	}
+	accountLocked, err := l.failure(ctx, accountKey(username), l.cfg.AccountThreshold, true)
+	if err != nil {
+		return false, err
+	}
+	if ip != "" {
+		if _, err := l.failure(ctx, ipKey(ip), l.cfg.IPThreshold, false); err != nil {
+			return false, err
+		}
+	}
+	return accountLocked, nil
+}
+
+func (l *Lockout) failure(ctx context.Context, key string, threshold int, progressive bool) (bool, error) {
+	var locked bool
+	err := l.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&LoginThrottle{Key: key}).Error; err != nil {
+			return err
+		}
+		var t LoginThrottle
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", key).First(&t).Error; err != nil {
+			return err
+		}
+
+		// 锁定到期或者 Window 内没有新的失败时重新计数
+		now := time.Now()
+		expired := t.BlockedUntil == nil || now.After(*t.BlockedUntil)
+		if expired && (t.Locked || now.Sub(t.LastFailureAt) > l.cfg.Window) {
+			t.Failures = 0
+			t.Locked = false
+		}
+		t.Failures++
+		t.LastFailureAt = now
+
+		switch {
+		case threshold > 0 && t.Failures >= threshold:
+			// 锁定期间的失败不会延长锁定时间，避免攻击者让账号一直处于锁定状态
+			if !t.Locked {
+				until := now.Add(l.cfg.LockoutDuration)
+				t.BlockedUntil = &until
+				t.Locked = true
+				locked = true
+			}
+		case progressive && t.Failures >= l.cfg.DelayAfter:
+			delay := l.cfg.BaseDelay << (t.Failures - l.cfg.DelayAfter)
+			if delay <= 0 || delay > l.cfg.MaxDelay {
+				delay = l.cfg.MaxDelay
+			}
+			until := now.Add(delay)
+			t.BlockedUntil = &until
+		}
+		return tx.Save(&t).Error
+	})
+	return locked, err
+}
+
+// Success 在登录成功后清除账号的失败计数，IP 的计数不清除，
+// 否则攻击者可以穿插登录自己的账号来绕过 IP 限制
+func (l *Lockout) Success(ctx context.Context, username string) error {
+	if l == nil {
+		return nil
+	}
+	return l.db.WithContext(ctx).Unscoped().Where("key = ?", accountKey(username)).Delete(&LoginThrottle{}).Error
+}
+
+// Unlock 解除账号的锁定，返回账号之前是否有失败记录
+func (l *Lockout) Unlock(ctx context.Context, username string) (bool, error) {
+	if l == nil {
+		return false, ErrLockoutDisabled
+	}
+	result := l.db.WithContext(ctx).Unscoped().Where("key = ?", accountKey(username)).Delete(&LoginThrottle{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// Status 返回账号当前的失败记录，没有记录时返回 nil
+func (l *Lockout) Status(ctx context.Context, username string) (*LoginThrottle, error) {
+	if l == nil {
+		return nil, nil
+	}
+	var t LoginThrottle
+	err := l.db.WithContext(ctx).Where("key = ?", accountKey(username)).First(&t).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &t, err
+}
+
+// Run 定期清理已经过期的失败记录，直到 ctx 取消
+func (l *Lockout) Run(ctx context.Context) {
+	ticker := time.NewTicker(l.cfg.Window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		err := l.db.WithContext(ctx).Unscoped().
+			Where("last_failure_at < ? AND (blocked_until IS NULL OR blocked_until < ?)", now.Add(-l.cfg.Window), now).
+			Delete(&LoginThrottle{}).Error
+		if err != nil {
+			log.Printf("lockout: failed to purge expired records: %v", err)
+		}
+	}
+}
+
+func (l *Lockout) keys(username, ip string) []string {
+	keys := []string{accountKey(username)}
+	if ip != "" {
+		keys = append(keys, ipKey(ip))
+	}
+	return keys
+}
+
+// accountKey 统一用户名的大小写，并限制长度，避免超长的用户名写入数据库
+func accountKey(username string) string {
+	username = strings.ToLower(strings.TrimSpace(username))
+	if len(username) > 200 {
+		username = username[:200]
+	}
+	return "user:" + username
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
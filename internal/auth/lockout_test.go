@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var testLockoutConfig = LockoutConfig{
+	Window:           time.Hour,
+	DelayAfter:       2,
+	BaseDelay:        time.Second,
+	MaxDelay:         4 * time.Second,
+	AccountThreshold: 6,
+	IPThreshold:      10,
+	LockoutDuration:  time.Hour,
+}
+
+// checkWait 断言 Check 返回的等待时间在 (want-1s, want] 之间，want 为 0 时必须为 0
+func checkWait(t *testing.T, l *Lockout, username, ip string, want time.Duration) {
+	t.Helper()
+	wait, err := l.Check(context.Background(), username, ip)
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if want == 0 && wait > 0 || want > 0 && (wait > want || wait <= want-time.Second) {
+		t.Errorf("Check(%s, %s) = %v, want about %v", username, ip, wait, want)
+	}
+}
+
+func TestLockoutAccountThreshold(t *testing.T) {
+	l := NewLockout(newTestDB(t), testLockoutConfig)
+
+	// 第 DelayAfter 次失败开始等待时间成倍增加，不超过 MaxDelay，第 AccountThreshold 次失败锁定账号
+	tests := []struct {
+		failures   int
+		wantWait   time.Duration
+		wantLocked bool
+	}{
+		{1, 0, false},
+		{2, time.Second, false},
+		{3, 2 * time.Second, false},
+		{4, 4 * time.Second, false},
+		{5, 4 * time.Second, false},
+		{6, time.Hour, true},
+		// 锁定期间的失败不再返回 locked，也不延长锁定时间
+		{7, time.Hour, false},
+	}
+	for _, tt := range tests {
+		locked, err := l.Failure(context.Background(), "alice", "")
+		if err != nil {
+			t.Fatalf("Failure %d: %v", tt.failures, err)
+		}
+		if locked != tt.wantLocked {
+			t.Errorf("Failure %d locked = %v, want %v", tt.failures, locked, tt.wantLocked)
+		}
+		checkWait(t, l, "alice", "", tt.wantWait)
+	}
+
+	status, err := l.Status(context.Background(), "alice")
+	if err != nil || status == nil || !status.Locked || status.Failures != 7 {
+		t.Fatalf("Status = %+v (%v), want locked with 7 failures", status, err)
+	}
+	// 用户名不区分大小写，其他账号不受影响
+	checkWait(t, l, " ALICE", "", time.Hour)
+	checkWait(t, l, "bob", "", 0)
+
+	if existed, err := l.Unlock(context.Background(), "alice"); err != nil || !existed {
+		t.Fatalf("Unlock = %v, %v", existed, err)
+	}
+	checkWait(t, l, "alice", "", 0)
+}
+
+func TestLockoutIPThreshold(t *testing.T) {
+	cfg := testLockoutConfig
+	cfg.IPThreshold = 3
+	l := NewLockout(newTestDB(t), cfg)
+
+	// 每个用户名只失败一次，但来自同一个 IP
+	for i, username := range []string{"alice", "bob", "carol"} {
+		locked, err := l.Failure(context.Background(), username, "192.0.2.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if locked {
+			t.Errorf("Failure %d locked the account, only the ip should be locked", i+1)
+		}
+	}
+
+	// 账号登录成功只清除账号的计数，不清除 IP 的计数
+	if err := l.Success(context.Background(), "alice"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		username string
+		ip       string
+		wantWait time.Duration
+	}{
+		{"alice", "192.0.2.1", time.Hour},
+		{"dave", "192.0.2.1", time.Hour},
+		{"dave", "192.0.2.2", 0},
+		{"alice", "", 0},
+	}
+	for _, tt := range tests {
+		checkWait(t, l, tt.username, tt.ip, tt.wantWait)
+	}
+}
+
+func TestLockoutResetsCount(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int // 修改记录之前的失败次数，达到 6 次时账号已锁定
+		// age 修改已有的失败记录，模拟时间流逝
+		age          func(t *LoginThrottle)
+		wantFailures int
+	}{
+		{"within window", 4, func(t *LoginThrottle) { t.LastFailureAt = time.Now().Add(-time.Minute) }, 5},
+		{"window elapsed", 4, func(t *LoginThrottle) {
+			t.LastFailureAt = time.Now().Add(-2 * time.Hour)
+			t.BlockedUntil = nil
+		}, 1},
+		{"lockout expired", 6, func(t *LoginThrottle) {
+			expired := time.Now().Add(-time.Second)
+			t.BlockedUntil = &expired
+		}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			l := NewLockout(db, testLockoutConfig)
+			for i := 0; i < tt.failures; i++ {
+				if _, err := l.Failure(context.Background(), "alice", ""); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var throttle LoginThrottle
+			if err := db.Where("key = ?", accountKey("alice")).First(&throttle).Error; err != nil {
+				t.Fatal(err)
+			}
+			tt.age(&throttle)
+			if err := db.Save(&throttle).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			locked, err := l.Failure(context.Background(), "alice", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			status, _ := l.Status(context.Background(), "alice")
+			if status.Failures != tt.wantFailures {
+				t.Errorf("failures = %d, want %d", status.Failures, tt.wantFailures)
+			}
+			if locked || status.Locked {
+				t.Errorf("account locked after %d failures", status.Failures)
+			}
+		})
+	}
+}
+
+func TestLockoutDisabled(t *testing.T) {
+	var l *Lockout
+	if wait, err := l.Check(context.Background(), "alice", "192.0.2.1"); wait != 0 || err != nil {
+		t.Errorf("Check = %v, %v", wait, err)
+	}
+	if locked, err := l.Failure(context.Background(), "alice", "192.0.2.1"); locked || err != nil {
+		t.Errorf("Failure = %v, %v", locked, err)
+	}
+	if _, err := l.Unlock(context.Background(), "alice"); !errors.Is(err, ErrLockoutDisabled) {
+		t.Errorf("Unlock error = %v, want %v", err, ErrLockoutDisabled)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+	}{
+		{"existing user", "alice"},
+		// 不存在的用户名同样会被锁定，锁定与否不能用来判断用户名是否存在
+		{"unknown user", "mallory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			createUser(t, db, "alice", "password", "")
+			cfg := testLockoutConfig
+			cfg.DelayAfter = 100
+			cfg.AccountThreshold = 3
+			s := NewService(db)
+			s.SetLockout(NewLockout(db, cfg))
+			client := ClientInfo{IP: "192.0.2.1"}
+
+			for i := 1; i <= cfg.AccountThreshold; i++ {
+				_, err := s.Login(context.Background(), LoginRequest{Username: tt.username, Password: "wrong", Client: client})
+				if !errors.Is(err, ErrInvalidCredentials) {
+					t.Fatalf("attempt %d error = %v, want %v", i, err, ErrInvalidCredentials)
+				}
+			}
+			// 锁定后正确的密码也不能登录
+			var throttled *ThrottledError
+			_, err := s.Login(context.Background(), LoginRequest{Username: tt.username, Password: "password", Client: client})
+			if !errors.As(err, &throttled) || throttled.RetryAfter <= cfg.LockoutDuration-time.Second {
+				t.Fatalf("Login after lock error = %v, want *ThrottledError for about %v", err, cfg.LockoutDuration)
+			}
+		})
+	}
+}
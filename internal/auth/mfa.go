@@ -0,0 +1,320 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/shenjing023/rbac-api-gateway/internal/audit"
+	"github.com/shenjing023/rbac-api-gateway/internal/user"
+	"github.com/shenjing023/rbac-api-gateway/pkg/jwt"
+	"github.com/shenjing023/rbac-api-gateway/pkg/totp"
+	"gorm.io/gorm"
+)
+
+const (
+	// mfaPendingTTL 是完成第一步认证后提交第二因素的期限
+	mfaPendingTTL = 5 * time.Minute
+	// recoveryCodeCount 是每次生成的恢复码数量
+	recoveryCodeCount = 10
+)
+
+// RFC 8176 定义的认证方式
+const (
+	AMRPassword = "pwd"
+	AMROTP      = "otp"
+	AMRMFA      = "mfa"
+	// AMRFederated 表示通过上游身份提供方登录，见 federatedAMR
+	AMRFederated = "fed"
+)
+
+var (
+	ErrMFAAlreadyEnabled = errors.New("已启用 TOTP")
+	ErrMFANotEnabled     = errors.New("未启用 TOTP")
+	ErrInvalidMFACode    = errors.New("验证码无效")
+	ErrInvalidMFAToken   = errors.New("登录请求已失效，请重新登录")
+	ErrMFAUnsupported    = errors.New("服务账号不能启用多因素认证")
+)
+
+type MFAConfig struct {
+	// Issuer 是验证器应用中显示的服务名称
+	Issuer string
+}
+
+// MFAStatus 是用户的多因素认证状态
+type MFAStatus struct {
+	TOTPEnabled            bool  `json:"totp_enabled"`
+	RecoveryCodesRemaining int64 `json:"recovery_codes_remaining"`
+}
+
+// MFAService 管理 TOTP 验证器和恢复码，并完成两步登录的第二步
+type MFAService struct {
+	db   *gorm.DB
+	auth *Service
+	cfg  MFAConfig
+}
+
+func NewMFAService(db *gorm.DB, auth *Service, cfg MFAConfig) *MFAService {
+	return &MFAService{db: db, auth: auth, cfg: cfg}
+}
+
+func (s *MFAService) Status(ctx context.Context, userID uint) (*MFAStatus, error) {
+	enabled, err := s.auth.mfaEnabled(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	status := &MFAStatus{TOTPEnabled: enabled}
+	err = s.db.WithContext(ctx).Model(&RecoveryCode{}).
+		Where("user_id = ? AND used_at IS NULL", userID).Count(&status.RecoveryCodesRemaining).Error
+	return status, err
+}
+
+// EnrollTOTP 为用户生成新的 TOTP 密钥，返回密钥和用于生成二维码的 otpauth:// 地址。
+// 验证器需要通过 ConfirmTOTP 确认后才会生效
+func (s *MFAService) EnrollTOTP(ctx context.Context, userID uint) (string, string, error) {
+	var u user.User
+	if err := s.db.WithContext(ctx).First(&u, userID).Error; err != nil {
+		return "", "", err
+	}
+	if u.Kind == string(user.KindService) {
+		return "", "", ErrMFAUnsupported
+	}
+
+	var factor TOTPFactor
+	err := s.db.WithContext(ctx).Where("user_id = ?", userID).First(&factor).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", "", err
+	}
+	if factor.ConfirmedAt != nil {
+		return "", "", ErrMFAAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", "", err
+	}
+	factor.UserID = userID
+	factor.Secret = secret
+	factor.LastUsedStep = 0
+	if err := s.db.WithContext(ctx).Save(&factor).Error; err != nil {
+		return "", "", err
+	}
+	return secret, totp.ProvisioningURI(s.cfg.Issuer, u.Username, secret), nil
+}
+
+// ConfirmTOTP 用验证器生成的验证码确认绑定，返回只展示一次的恢复码
+func (s *MFAService) ConfirmTOTP(ctx context.Context, userID uint, code string) ([]string, error) {
+	var factor TOTPFactor
+	if err := s.db.WithContext(ctx).Where("user_id = ?", userID).First(&factor).Error; err != nil {
+		return nil, ErrMFANotEnabled
+	}
+	if factor.ConfirmedAt != nil {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if err := s.useTOTP(ctx, &factor, code); err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&factor).Update("confirmed_at", time.Now()).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = newRecoveryCodes(tx, userID)
+		return err
+	})
+	return codes, err
+}
+
+// DisableTOTP 关闭多因素认证，需要提供当前的验证码或恢复码
+func (s *MFAService) DisableTOTP(ctx context.Context, userID uint, code string) error {
+	if _, err := s.verifyFactor(ctx, userID, code); err != nil {
+		return err
+	}
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&TOTPFactor{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error
+	})
+}
+
+// RegenerateRecoveryCodes 生成新的恢复码，旧的恢复码全部失效
+func (s *MFAService) RegenerateRecoveryCodes(ctx context.Context, userID uint, code string) ([]string, error) {
+	factor, err := s.confirmedFactor(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.useTOTP(ctx, factor, code); err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = newRecoveryCodes(tx, userID)
+		return err
+	})
+	return codes, err
+}
+
+// Verify 完成两步登录：校验等待多因素认证的 token 和验证码（或恢复码），签发正式的 token 并记录会话。
+// 验证码错误与密码错误一样按账号和 IP 计入登录失败，账号的失败计数在第二因素通过后才清除
+func (s *MFAService) Verify(ctx context.Context, mfaToken, code string, client ClientInfo) (string, error) {
+	claims, err := jwt.ValidateMFAToken(mfaToken)
+	if err != nil {
+		return "", ErrInvalidMFAToken
+	}
+	if revoked, err := s.auth.IsRevoked(ctx, claims); err != nil || revoked {
+		return "", ErrInvalidMFAToken
+	}
+
+	lockout := s.auth.lockout
+	wait, err := lockout.Check(ctx, claims.Username, client.IP)
+	if err != nil {
+		return "", err
+	}
+	if wait > 0 {
+		return "", &ThrottledError{RetryAfter: wait}
+	}
+
+	methods, err := s.verifyFactor(ctx, claims.UserID, code)
+	if err != nil {
+		if errors.Is(err, ErrInvalidMFACode) {
+			return "", s.failedAttempt(ctx, claims, client)
+		}
+		return "", err
+	}
+
+	// 等待多因素认证的 token 只能使用一次
+	if err := s.auth.RevokeToken(ctx, claims); err != nil {
+		return "", err
+	}
+	if err := lockout.Success(ctx, claims.Username); err != nil {
+		log.Printf("lockout: %v", err)
+	}
+
+	// 重新读取角色，第一步之后角色可能已经变化
+	identity, err := s.auth.Identity(ctx, claims.UserID)
+	if err != nil {
+		return "", err
+	}
+	identity.AMR = append(slices.Clone(claims.AMR), methods...)
+	return issueSession(ctx, s.db, *identity, jwt.Expiration(), client)
+}
+
+// verifyFactor 依次尝试 TOTP 验证码和恢复码，返回对应的认证方式
+func (s *MFAService) verifyFactor(ctx context.Context, userID uint, code string) ([]string, error) {
+	factor, err := s.confirmedFactor(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	code = strings.TrimSpace(code)
+	if len(code) == totp.Digits {
+		if err := s.useTOTP(ctx, factor, code); err != nil {
+			return nil, err
+		}
+		return []string{AMROTP, AMRMFA}, nil
+	}
+	if err := s.useRecoveryCode(ctx, userID, code); err != nil {
+		return nil, err
+	}
+	return []string{AMRMFA}, nil
+}
+
+func (s *MFAService) confirmedFactor(ctx context.Context, userID uint) (*TOTPFactor, error) {
+	var factor TOTPFactor
+	if err := s.db.WithContext(ctx).Where("user_id = ? AND confirmed_at IS NOT NULL", userID).First(&factor).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMFANotEnabled
+		}
+		return nil, err
+	}
+	return &factor, nil
+}
+
+// useTOTP 校验验证码，并通过条件更新记录使用的时间步，并发提交同一个验证码时只有一个成功
+func (s *MFAService) useTOTP(ctx context.Context, factor *TOTPFactor, code string) error {
+	step, ok := totp.Validate(factor.Secret, code, time.Now())
+	if !ok {
+		return ErrInvalidMFACode
+	}
+	result := s.db.WithContext(ctx).Model(&TOTPFactor{}).
+		Where("id = ? AND last_used_step < ?", factor.ID, step).
+		Update("last_used_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+func (s *MFAService) useRecoveryCode(ctx context.Context, userID uint, code string) error {
+	result := s.db.WithContext(ctx).Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashToken(normalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+// failedAttempt 把第二因素的错误计入账号和 IP 的登录失败，账号因此被锁定时作废这次登录，
+// 锁定期满后需要重新输入密码
+func (s *MFAService) failedAttempt(ctx context.Context, claims *jwt.Claims, client ClientInfo) error {
+	event := audit.Event{Type: audit.TypeLoginFailed, Target: claims.Username, IP: client.IP, Detail: "mfa"}
+	s.auth.audit.Record(ctx, event)
+
+	locked, err := s.auth.lockout.Failure(ctx, claims.Username, client.IP)
+	if err != nil {
+		log.Printf("lockout: %v", err)
+	}
+	if !locked {
+		return ErrInvalidMFACode
+	}
+	event.Type = audit.TypeAccountLocked
+	s.auth.audit.Record(ctx, event)
+	if err := s.auth.RevokeToken(ctx, claims); err != nil {
+		return err
+	}
+	return ErrInvalidMFAToken
+}
+
+// newRecoveryCodes 删除用户旧的恢复码并生成新的，格式为 XXXX-XXXX-XXXX-XXXX
+func newRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	records := make([]RecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		raw := base32.StdEncoding.EncodeToString(b)
+		codes = append(codes, raw[0:4]+"-"+raw[4:8]+"-"+raw[8:12]+"-"+raw[12:16])
+		records = append(records, RecoveryCode{UserID: userID, CodeHash: hashToken(raw)})
+	}
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode 去掉用户输入中的分隔符并统一为大写
+func normalizeRecoveryCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MFAHandler struct {
+	service *MFAService
+}
+
+func NewMFAHandler(service *MFAService) *MFAHandler {
+	return &MFAHandler{service: service}
+}
+
+type mfaCodeRequest struct {
+	// Code 是验证器生成的 6 位验证码，部分接口也接受恢复码
+	Code string `json:"code" binding:"required"`
+}
+
+func (h *MFAHandler) Status(c *gin.Context) {
+	status, err := h.service.Status(c.Request.Context(), c.GetUint("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取多因素认证状态失败"})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// EnrollTOTP 生成 TOTP 密钥，客户端把 provisioning_uri 渲染为二维码供验证器应用扫描
+func (h *MFAHandler) EnrollTOTP(c *gin.Context) {
+	secret, uri, err := h.service.EnrollTOTP(c.Request.Context(), c.GetUint("user_id"))
+	if err != nil {
+		writeMFAError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"secret": secret, "provisioning_uri": uri})
+}
+
+func (h *MFAHandler) ConfirmTOTP(c *gin.Context) {
+	var req mfaCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := h.service.ConfirmTOTP(c.Request.Context(), c.GetUint("user_id"), req.Code)
+	if err != nil {
+		writeMFAError(c, err)
+		return
+	}
+	// 恢复码只在生成时返回一次
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+func (h *MFAHandler) DisableTOTP(c *gin.Context) {
+	var req mfaCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.service.DisableTOTP(c.Request.Context(), c.GetUint("user_id"), req.Code); err != nil {
+		writeMFAError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已关闭多因素认证"})
+}
+
+func (h *MFAHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	var req mfaCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := h.service.RegenerateRecoveryCodes(c.Request.Context(), c.GetUint("user_id"), req.Code)
+	if err != nil {
+		writeMFAError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// Verify 是两步登录的第二步，提交登录返回的 mfa_token 和验证码或恢复码
+func (h *MFAHandler) Verify(c *gin.Context) {
+	var req struct {
+		MFAToken string `json:"mfa_token" binding:"required"`
+		Code     string `json:"code" binding:"required"`
+		Device   string `json:"device"`
+		// Cookie 与 /auth/login 相同，为 true 时 token 保存在会话 cookie 中
+		Cookie bool `json:"cookie"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cookie := h.service.auth.cookie
+	if req.Cookie && cookie == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": ErrSessionCookieDisabled.Error()})
+		return
+	}
+
+	client := clientInfo(c, req.Device)
+	client.Cookie = req.Cookie
+	token, err := h.service.Verify(c.Request.Context(), req.MFAToken, req.Code, client)
+	if err != nil {
+		writeMFAError(c, err)
+		return
+	}
+
+	if req.Cookie {
+		csrf, err := cookie.set(c, token)
+		if err != nil {
+			writeMFAError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"csrf_token": csrf})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"token": token})
+}
+
+func writeMFAError(c *gin.Context, err error) {
+	var throttled *ThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrInvalidMFACode), errors.Is(err, ErrInvalidMFAToken):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrMFAAlreadyEnabled), errors.Is(err, ErrMFANotEnabled):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrMFAUnsupported):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		log.Printf("mfa: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "多因素认证操作失败"})
+	}
+}
+
+func RegisterMFARoutes(r *gin.Engine, service *MFAService) {
+	handler := NewMFAHandler(service)
+
+	mfa := r.Group("/auth/mfa")
+	{
+		mfa.GET("", handler.Status)
+		mfa.POST("/totp", handler.EnrollTOTP)
+		mfa.POST("/totp/confirm", handler.ConfirmTOTP)
+		mfa.DELETE("/totp", handler.DisableTOTP)
+		mfa.POST("/recovery-codes", handler.RegenerateRecoveryCodes)
+		mfa.POST("/verify", handler.Verify)
+	}
+}
@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/shenjing023/rbac-api-gateway/pkg/jwt"
+	"github.com/shenjing023/rbac-api-gateway/pkg/totp"
+)
+
+// newTestMFA 创建启用了 TOTP 的用户 alice，返回 TOTP 密钥和恢复码。
+// 账号连续失败 3 次后锁定，不启用递增的等待时间
+func newTestMFA(t *testing.T) (*Service, *MFAService, string, []string) {
+	t.Helper()
+	db := newTestDB(t)
+	s := NewService(db)
+	s.SetLockout(NewLockout(db, LockoutConfig{Window: time.Hour, DelayAfter: 100, AccountThreshold: 3, IPThreshold: 100, LockoutDuration: time.Hour}))
+	mfa := NewMFAService(db, s, MFAConfig{Issuer: "test"})
+
+	u := createUser(t, db, "alice", "password", "")
+	secret, _, err := mfa.EnrollTOTP(context.Background(), u.ID)
+	if err != nil {
+		t.Fatalf("EnrollTOTP: %v", err)
+	}
+	code, _ := totp.Code(secret, time.Now())
+	recoveryCodes, err := mfa.ConfirmTOTP(context.Background(), u.ID, code)
+	if err != nil {
+		t.Fatalf("ConfirmTOTP: %v", err)
+	}
+	return s, mfa, secret, recoveryCodes
+}
+
+// passwordStep 完成密码这一步，返回等待多因素认证的 token
+func passwordStep(t *testing.T, s *Service) string {
+	t.Helper()
+	result, err := s.Login(context.Background(), LoginRequest{Username: "alice", Password: "password", Client: ClientInfo{IP: "192.0.2.1"}})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if !result.MFARequired {
+		t.Fatal("expected MFA to be required")
+	}
+	return result.MFAToken
+}
+
+func accountFailures(t *testing.T, s *Service) int {
+	t.Helper()
+	status, err := s.lockout.Status(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status == nil {
+		return 0
+	}
+	return status.Failures
+}
+
+func TestMFAVerify(t *testing.T) {
+	client := ClientInfo{IP: "192.0.2.1"}
+	tests := []struct {
+		name string
+		// code 返回第二步提交的验证码，ConfirmTOTP 已经用掉了当前时间步，所以正确的 TOTP 取下一个时间步
+		code         func(secret string, recoveryCodes []string) string
+		wantErr      error
+		wantAMR      []string
+		wantFailures int // 第二步之后账号的失败次数，登录前已经有一次密码错误
+	}{
+		{"totp", func(secret string, _ []string) string {
+			code, _ := totp.Code(secret, time.Now().Add(totp.Period))
+			return code
+		}, nil, []string{AMRPassword, AMROTP, AMRMFA}, 0},
+		{"recovery code", func(_ string, codes []string) string { return codes[0] }, nil, []string{AMRPassword, AMRMFA}, 0},
+		{"wrong totp", func(string, []string) string { return "000000" }, ErrInvalidMFACode, nil, 2},
+		{"wrong recovery code", func(string, []string) string { return "AAAA-BBBB-CCCC-DDDD" }, ErrInvalidMFACode, nil, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mfa, secret, recoveryCodes := newTestMFA(t)
+			if _, err := s.Login(context.Background(), LoginRequest{Username: "alice", Password: "wrong", Client: client}); !errors.Is(err, ErrInvalidCredentials) {
+				t.Fatalf("Login with wrong password error = %v", err)
+			}
+			mfaToken := passwordStep(t, s)
+			// 密码正确但还没有完成第二因素，失败计数不能被清除
+			if got := accountFailures(t, s); got != 1 {
+				t.Fatalf("failures after password step = %d, want 1", got)
+			}
+
+			token, err := mfa.Verify(context.Background(), mfaToken, tt.code(secret, recoveryCodes), client)
+			if got := accountFailures(t, s); got != tt.wantFailures {
+				t.Errorf("failures after second factor = %d, want %d", got, tt.wantFailures)
+			}
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Verify error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			claims, err := jwt.ValidateToken(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(claims.AMR, tt.wantAMR) {
+				t.Errorf("amr = %v, want %v", claims.AMR, tt.wantAMR)
+			}
+			// 等待多因素认证的 token 只能使用一次
+			if _, err := mfa.Verify(context.Background(), mfaToken, tt.code(secret, recoveryCodes), client); !errors.Is(err, ErrInvalidMFAToken) {
+				t.Errorf("reused mfa token error = %v, want %v", err, ErrInvalidMFAToken)
+			}
+		})
+	}
+}
+
+func TestMFAFailuresLockAccount(t *testing.T) {
+	s, mfa, secret, _ := newTestMFA(t)
+	client := ClientInfo{IP: "192.0.2.1"}
+
+	// 每次都重新输入正确的密码，也不能绕过验证码的错误次数限制
+	for i := 1; i < 3; i++ {
+		_, err := mfa.Verify(context.Background(), passwordStep(t, s), "000000", client)
+		if !errors.Is(err, ErrInvalidMFACode) {
+			t.Fatalf("attempt %d: Verify error = %v, want %v", i, err, ErrInvalidMFACode)
+		}
+	}
+	mfaToken := passwordStep(t, s)
+	if _, err := mfa.Verify(context.Background(), mfaToken, "000000", client); !errors.Is(err, ErrInvalidMFAToken) {
+		t.Fatalf("locking attempt error = %v, want %v", err, ErrInvalidMFAToken)
+	}
+
+	// 账号已锁定：密码登录和其他等待中的登录都不能继续
+	var throttled *ThrottledError
+	if _, err := s.Login(context.Background(), LoginRequest{Username: "alice", Password: "password", Client: client}); !errors.As(err, &throttled) {
+		t.Errorf("Login after lock error = %v, want *ThrottledError", err)
+	}
+	pending, err := jwt.IssueToken(jwt.Claims{UserID: 1, Username: "alice", AMR: []string{AMRPassword}, MFAPending: true}, mfaPendingTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := totp.Code(secret, time.Now().Add(totp.Period))
+	if _, err := mfa.Verify(context.Background(), pending, code, client); !errors.As(err, &throttled) {
+		t.Errorf("Verify after lock error = %v, want *ThrottledError", err)
+	}
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// FederatedIdentity 把上游身份提供方中的用户（provider + sub）关联到本地用户
+type FederatedIdentity struct {
+	gorm.Model
+	Provider    string `gorm:"uniqueIndex:idx_federated_subject;not null"`
+	Subject     string `gorm:"uniqueIndex:idx_federated_subject;not null"`
+	UserID      uint   `gorm:"index;not null"`
+	LastLoginAt time.Time
+}
+
+// LoginThrottle 记录一个账号或 IP 连续登录失败的情况，Key 形如 user:<用户名> 或 ip:<地址>
+type LoginThrottle struct {
+	gorm.Model
+	Key           string    `gorm:"uniqueIndex;not null"`
+	Failures      int       `gorm:"not null;default:0"`
+	LastFailureAt time.Time `gorm:"index"`
+	// BlockedUntil 之前的登录请求直接拒绝，用于逐步增加的等待时间和临时锁定
+	BlockedUntil *time.Time
+	Locked       bool `gorm:"not null;default:false"`
+}
+
+// TOTPFactor 是用户的 TOTP 验证器，确认之前不参与登录
+type TOTPFactor struct {
+	gorm.Model
+	UserID      uint   `gorm:"uniqueIndex;not null"`
+	Secret      string `gorm:"not null" json:"-"`
+	ConfirmedAt *time.Time
+	// LastUsedStep 是最后一次使用的时间步，同一个验证码不能使用两次
+	LastUsedStep int64 `gorm:"not null;default:0" json:"-"`
+}
+
+// RecoveryCode 是丢失验证器时使用的一次性恢复码，只保存哈希值
+type RecoveryCode struct {
+	gorm.Model
+	UserID   uint   `gorm:"index;not null"`
+	CodeHash string `gorm:"uniqueIndex;not null"`
+	UsedAt   *time.Time
+}
+
+// OAuthClient 是注册的 OAuth2 客户端。公开客户端（SPA、移动端）没有密钥，必须使用 PKCE
+type OAuthClient struct {
+	gorm.Model
+	ClientID     string   `gorm:"uniqueIndex;not null"`
+	SecretHash   string   `json:"-"`
+	Name         string   `gorm:"not null"`
+	Public       bool     `gorm:"not null;default:false"`
+	RedirectURIs []string `gorm:"serializer:json"`
+	GrantTypes   []string `gorm:"serializer:json;not null"`
+	// Scopes 是客户端可以申请的最大权限范围，未指定 scope 的请求获得全部范围
+	Scopes []string `gorm:"serializer:json;not null"`
+	// OwnerID 是 client_credentials 模式下令牌代表的服务账号，角色来自 rbac.UserRole
+	OwnerID uint
+}
+
+// AuthorizationCode 是授权码模式中一次性使用的授权码，只保存哈希值
+type AuthorizationCode struct {
+	gorm.Model
+	CodeHash            string   `gorm:"uniqueIndex;not null"`
+	ClientID            string   `gorm:"index;not null"`
+	UserID              uint     `gorm:"not null"`
+	RedirectURI         string   `gorm:"not null"`
+	RedirectURISupplied bool     // 授权请求中带了 redirect_uri，而不是使用唯一注册的地址
+	Scopes              []string `gorm:"serializer:json;not null"`
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+	AMR                 []string  `gorm:"serializer:json"` // 批准授权的会话的认证方式，换取的令牌继承这些认证方式
+	ExpiresAt           time.Time `gorm:"not null"`
+	UsedAt              *time.Time
+}
+
+// RefreshToken 每次使用后轮换，同一授权下的令牌属于同一个 FamilyID。
+// 已轮换的令牌被再次使用时说明令牌可能泄露，整个 family 都会被吊销
+type RefreshToken struct {
+	gorm.Model
+	TokenHash string    `gorm:"uniqueIndex;not null"`
+	FamilyID  string    `gorm:"index;not null"`
+	ClientID  string    `gorm:"index;not null"`
+	UserID    uint      `gorm:"index;not null"`
+	Scopes    []string  `gorm:"serializer:json;not null"`
+	AMR       []string  `gorm:"serializer:json"`
+	ExpiresAt time.Time `gorm:"not null"`
+	RotatedAt *time.Time
+	RevokedAt *time.Time
+}
+
+// RevokedToken 记录在过期前被吊销的 JWT，过期后可以清理
+type RevokedToken struct {
+	gorm.Model
+	JTI       string    `gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time `gorm:"index;not null"`
+}
+
+// SessionRevocation 记录用户的会话在什么时候被整体吊销，之前签发的 JWT 都不能再使用，
+// 修改或重置密码时更新
+type SessionRevocation struct {
+	gorm.Model
+	UserID        uint      `gorm:"uniqueIndex;not null"`
+	RevokedBefore time.Time `gorm:"not null"`
+}
+
+// PasswordResetToken 是通过通知发送给用户的一次性密码重置令牌，只保存哈希
+type PasswordResetToken struct {
+	gorm.Model
+	UserID    uint      `gorm:"index;not null"`
+	TokenHash string    `gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+}
+
+// Session 是一次登录签发的 JWT，通过 JTI 关联，用于列出和吊销用户的登录会话
+type Session struct {
+	gorm.Model
+	UserID    uint     `gorm:"index;not null"`
+	JTI       string   `gorm:"uniqueIndex;not null" json:"-"`
+	Device    string   `gorm:"not null"`
+	IP        string   `gorm:"not null"`
+	UserAgent string   `gorm:"not null"`
+	AMR       []string `gorm:"serializer:json"`
+	// Actor 是模拟该用户的管理员，普通登录的会话为空
+	Actor string `json:",omitempty"`
+	// LastSeenAt 是最后一次使用该会话访问接口的时间，精确到 sessionTouchInterval
+	LastSeenAt time.Time `gorm:"not null"`
+	ExpiresAt  time.Time `gorm:"index;not null"`
+	RevokedAt  *time.Time
+}
+
+// Invitation 是邀请注册使用的邀请码，只能使用一次，注册的用户获得 Role。
+// 只保存邀请码的哈希，创建时返回的明文不能再次查看
+type Invitation struct {
+	gorm.Model
+	CodeHash string `gorm:"uniqueIndex;not null" json:"-"`
+	Role     string `gorm:"not null"`
+	// Email 不为空时只能使用这个邮箱注册
+	Email     string
+	Note      string
+	CreatedBy string
+	ExpiresAt time.Time `gorm:"index;not null"`
+	UsedAt    *time.Time
+	// UsedBy 是使用邀请码注册的用户
+	UsedBy uint
+}
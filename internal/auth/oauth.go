@@ -0,0 +1,574 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shenjing023/rbac-api-gateway/internal/user"
+	"github.com/shenjing023/rbac-api-gateway/pkg/jwt"
+	"gorm.io/gorm"
+)
+
+// OAuth2 授权类型
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantClientCredentials = "client_credentials"
+)
+
+// OAuthConfig 是各类令牌的有效期和 OpenID Connect 配置
+type OAuthConfig struct {
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	CodeTTL         time.Duration
+	// Issuer 是 OIDC 签发方的地址，例如 https://gateway.example.com，为空时不启用 OIDC
+	Issuer     string
+	IDTokenTTL time.Duration
+}
+
+// OAuthError 是 RFC 6749 第 5.2 节定义的错误响应
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	Status      int    `json:"-"`
+}
+
+func (e *OAuthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func oauthError(status int, code, description string) *OAuthError {
+	return &OAuthError{Code: code, Description: description, Status: status}
+}
+
+func invalidRequest(description string) *OAuthError {
+	return oauthError(http.StatusBadRequest, "invalid_request", description)
+}
+
+func invalidGrant(description string) *OAuthError {
+	return oauthError(http.StatusBadRequest, "invalid_grant", description)
+}
+
+var errInvalidClient = oauthError(http.StatusUnauthorized, "invalid_client", "客户端认证失败")
+
+// TokenResponse 是令牌端点的成功响应
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope"`
+}
+
+// Introspection 是 RFC 7662 定义的令牌信息，令牌无效时只有 Active 为 false
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	Iss       string `json:"iss,omitempty"`
+	JTI       string `json:"jti,omitempty"`
+}
+
+// ClientRegistration 是注册客户端时提交的信息
+type ClientRegistration struct {
+	Name         string   `json:"name" binding:"required"`
+	Public       bool     `json:"public"`
+	RedirectURIs []string `json:"redirect_uris"`
+	GrantTypes   []string `json:"grant_types" binding:"required"`
+	Scopes       []string `json:"scopes" binding:"required"`
+	OwnerID      uint     `json:"owner_id"`
+}
+
+// AuthorizeRequest 是授权端点的请求参数
+type AuthorizeRequest struct {
+	ResponseType        string `form:"response_type"`
+	ClientID            string `form:"client_id"`
+	RedirectURI         string `form:"redirect_uri"`
+	Scope               string `form:"scope"`
+	State               string `form:"state"`
+	CodeChallenge       string `form:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method"`
+	Nonce               string `form:"nonce"`
+	// Deny 表示用户在确认页面拒绝授权
+	Deny bool `form:"deny"`
+}
+
+// OAuthService 实现 OAuth2 授权服务器：授权码 + PKCE、客户端凭据、刷新令牌、令牌内省和吊销，
+// 配置了 Issuer 时同时作为 OpenID Connect 身份提供方
+type OAuthService struct {
+	db    *gorm.DB
+	auth  *Service
+	users *user.Service
+	cfg   OAuthConfig
+}
+
+func NewOAuthService(db *gorm.DB, auth *Service, users *user.Service, cfg OAuthConfig) *OAuthService {
+	return &OAuthService{db: db, auth: auth, users: users, cfg: cfg}
+}
+
+// RegisterClient 注册客户端，机密客户端的密钥只在此时返回一次
+func (s *OAuthService) RegisterClient(reg ClientRegistration) (*OAuthClient, string, error) {
+	if err := validateClientScopes(reg.Scopes); err != nil {
+		return nil, "", err
+	}
+	for _, grant := range reg.GrantTypes {
+		switch grant {
+		case GrantAuthorizationCode, GrantRefreshToken:
+		case GrantClientCredentials:
+			if reg.Public {
+				return nil, "", errors.New("公开客户端不能使用 client_credentials")
+			}
+			if reg.OwnerID == 0 {
+				return nil, "", errors.New("使用 client_credentials 时必须指定 owner_id")
+			}
+		default:
+			return nil, "", fmt.Errorf("不支持的授权类型 %q", grant)
+		}
+	}
+	if slices.Contains(reg.GrantTypes, GrantAuthorizationCode) && len(reg.RedirectURIs) == 0 {
+		return nil, "", errors.New("使用 authorization_code 时必须配置 redirect_uris")
+	}
+	for _, uri := range reg.RedirectURIs {
+		if err := validateRedirectURI(uri); err != nil {
+			return nil, "", err
+		}
+	}
+
+	clientID, err := randomToken(16)
+	if err != nil {
+		return nil, "", err
+	}
+	client := &OAuthClient{
+		ClientID:     clientID,
+		Name:         reg.Name,
+		Public:       reg.Public,
+		RedirectURIs: reg.RedirectURIs,
+		GrantTypes:   reg.GrantTypes,
+		Scopes:       reg.Scopes,
+		OwnerID:      reg.OwnerID,
+	}
+
+	var secret string
+	if !reg.Public {
+		if secret, err = randomToken(32); err != nil {
+			return nil, "", err
+		}
+		client.SecretHash = hashToken(secret)
+	}
+
+	if err := s.db.Create(client).Error; err != nil {
+		return nil, "", err
+	}
+	return client, secret, nil
+}
+
+func (s *OAuthService) ListClients() ([]OAuthClient, error) {
+	var clients []OAuthClient
+	err := s.db.Order("id").Find(&clients).Error
+	return clients, err
+}
+
+// DeleteClient 删除客户端并吊销其所有刷新令牌
+func (s *OAuthService) DeleteClient(id uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var client OAuthClient
+		if err := tx.First(&client, id).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&RefreshToken{}).
+			Where("client_id = ? AND revoked_at IS NULL", client.ClientID).
+			Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&client).Error
+	})
+}
+
+// AuthenticateClient 校验客户端身份，公开客户端只需要 client_id
+func (s *OAuthService) AuthenticateClient(clientID, secret string) (*OAuthClient, error) {
+	if clientID == "" {
+		return nil, errInvalidClient
+	}
+	var client OAuthClient
+	if err := s.db.Where("client_id = ?", clientID).First(&client).Error; err != nil {
+		return nil, errInvalidClient
+	}
+	if client.Public {
+		if secret != "" {
+			return nil, errInvalidClient
+		}
+		return &client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(client.SecretHash)) != 1 {
+		return nil, errInvalidClient
+	}
+	return &client, nil
+}
+
+// ValidateAuthorizeRequest 检查授权请求。返回的 redirectURI 为空时错误不能重定向给客户端，
+// 只能直接展示给用户，避免把用户带到未注册的地址
+func (s *OAuthService) ValidateAuthorizeRequest(req *AuthorizeRequest) (*OAuthClient, string, []string, *OAuthError) {
+	var client OAuthClient
+	if req.ClientID == "" || s.db.Where("client_id = ?", req.ClientID).First(&client).Error != nil {
+		return nil, "", nil, invalidRequest("未知的 client_id")
+	}
+
+	redirectURI := req.RedirectURI
+	if redirectURI == "" && len(client.RedirectURIs) == 1 {
+		redirectURI = client.RedirectURIs[0]
+	}
+	if !slices.Contains(client.RedirectURIs, redirectURI) {
+		return nil, "", nil, invalidRequest("redirect_uri 与注册的地址不一致")
+	}
+
+	if req.ResponseType != "code" {
+		return nil, redirectURI, nil, invalidRequest("response_type 必须是 code")
+	}
+	if !slices.Contains(client.GrantTypes, GrantAuthorizationCode) {
+		return nil, redirectURI, nil, oauthError(http.StatusBadRequest, "unauthorized_client", "客户端不允许使用授权码模式")
+	}
+	scopes, oerr := grantedScopes(&client, req.Scope)
+	if oerr != nil {
+		return nil, redirectURI, nil, oerr
+	}
+	if slices.Contains(scopes, ScopeOpenID) && s.cfg.Issuer == "" {
+		return nil, redirectURI, nil, oauthError(http.StatusBadRequest, "invalid_scope", "未启用 OpenID Connect")
+	}
+
+	// 公开客户端必须使用 PKCE，只支持 S256
+	if req.CodeChallenge == "" && client.Public {
+		return nil, redirectURI, nil, invalidRequest("公开客户端必须提供 code_challenge")
+	}
+	if req.CodeChallenge != "" && req.CodeChallengeMethod != "S256" {
+		return nil, redirectURI, nil, invalidRequest("code_challenge_method 必须是 S256")
+	}
+	return &client, redirectURI, scopes, nil
+}
+
+// IssueCode 为已登录并同意授权的用户签发授权码，amr 是用户当前会话的认证方式
+func (s *OAuthService) IssueCode(client *OAuthClient, userID uint, amr []string, redirectURI string, scopes []string, req *AuthorizeRequest) (string, error) {
+	code, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	record := &AuthorizationCode{
+		CodeHash:            hashToken(code),
+		ClientID:            client.ClientID,
+		UserID:              userID,
+		RedirectURI:         redirectURI,
+		RedirectURISupplied: req.RedirectURI != "",
+		Scopes:              scopes,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		Nonce:               req.Nonce,
+		AMR:                 amr,
+		ExpiresAt:           time.Now().Add(s.cfg.CodeTTL),
+	}
+	if err := s.db.Create(record).Error; err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// ExchangeCode 用授权码换取令牌
+func (s *OAuthService) ExchangeCode(ctx context.Context, client *OAuthClient, code, redirectURI, verifier string) (*TokenResponse, error) {
+	if !slices.Contains(client.GrantTypes, GrantAuthorizationCode) {
+		return nil, oauthError(http.StatusBadRequest, "unauthorized_client", "客户端不允许使用授权码模式")
+	}
+
+	var record AuthorizationCode
+	if err := s.db.Where("code_hash = ?", hashToken(code)).First(&record).Error; err != nil {
+		return nil, invalidGrant("无效的授权码")
+	}
+	// RFC 6749 4.1.3：只有授权请求中带了 redirect_uri 时令牌请求才必须带上相同的值
+	if record.ClientID != client.ClientID ||
+		((record.RedirectURISupplied || redirectURI != "") && record.RedirectURI != redirectURI) {
+		return nil, invalidGrant("授权码与客户端或 redirect_uri 不匹配")
+	}
+	if time.Now().After(record.ExpiresAt) {
+		return nil, invalidGrant("授权码已过期")
+	}
+	if record.CodeChallenge != "" && !verifyPKCE(record.CodeChallenge, verifier) {
+		return nil, invalidGrant("code_verifier 校验失败")
+	}
+
+	// 授权码只能使用一次，重复使用时吊销用它换取的刷新令牌
+	family := "code:" + strconv.FormatUint(uint64(record.ID), 10)
+	result := s.db.Model(&AuthorizationCode{}).
+		Where("id = ? AND used_at IS NULL", record.ID).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		s.revokeFamily(family)
+		return nil, invalidGrant("授权码已被使用")
+	}
+
+	return s.issueTokens(ctx, client, tokenGrant{userID: record.UserID, scopes: record.Scopes, amr: record.AMR, family: family, nonce: record.Nonce})
+}
+
+// RefreshTokens 轮换刷新令牌并签发新的访问令牌，scope 只能缩小不能扩大
+func (s *OAuthService) RefreshTokens(ctx context.Context, client *OAuthClient, refreshToken, scope string) (*TokenResponse, error) {
+	if !slices.Contains(client.GrantTypes, GrantRefreshToken) {
+		return nil, oauthError(http.StatusBadRequest, "unauthorized_client", "客户端不允许使用刷新令牌")
+	}
+
+	var record RefreshToken
+	if err := s.db.Where("token_hash = ?", hashToken(refreshToken)).First(&record).Error; err != nil {
+		return nil, invalidGrant("无效的刷新令牌")
+	}
+	if record.ClientID != client.ClientID {
+		return nil, invalidGrant("刷新令牌不属于该客户端")
+	}
+	if record.RevokedAt != nil || time.Now().After(record.ExpiresAt) {
+		return nil, invalidGrant("刷新令牌已失效")
+	}
+
+	scopes := record.Scopes
+	if scope != "" {
+		scopes = strings.Fields(scope)
+		for _, sc := range scopes {
+			if !slices.Contains(record.Scopes, sc) {
+				return nil, oauthError(http.StatusBadRequest, "invalid_scope", "scope 超出了原授权范围")
+			}
+		}
+	}
+
+	result := s.db.Model(&RefreshToken{}).
+		Where("id = ? AND rotated_at IS NULL AND revoked_at IS NULL", record.ID).
+		Update("rotated_at", time.Now())
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		// 已轮换的令牌被再次使用，可能已经泄露
+		s.revokeFamily(record.FamilyID)
+		return nil, invalidGrant("刷新令牌已被使用")
+	}
+
+	return s.issueTokens(ctx, client, tokenGrant{userID: record.UserID, scopes: scopes, amr: record.AMR, family: record.FamilyID})
+}
+
+// ClientCredentials 签发代表客户端所属服务账号的访问令牌，不签发刷新令牌
+func (s *OAuthService) ClientCredentials(ctx context.Context, client *OAuthClient, scope string) (*TokenResponse, error) {
+	if client.Public || !slices.Contains(client.GrantTypes, GrantClientCredentials) {
+		return nil, oauthError(http.StatusBadRequest, "unauthorized_client", "客户端不允许使用 client_credentials")
+	}
+	scopes, oerr := grantedScopes(client, scope)
+	if oerr != nil {
+		return nil, oerr
+	}
+	return s.issueTokens(ctx, client, tokenGrant{userID: client.OwnerID, scopes: scopes, amr: []string{jwt.AMRKey}})
+}
+
+// tokenGrant 是签发令牌所依据的授权
+type tokenGrant struct {
+	userID uint
+	scopes []string
+	// amr 是批准授权的会话的认证方式，客户端凭据为 key
+	amr []string
+	// family 不为空表示代表用户的授权
+	family string
+	nonce  string
+}
+
+// issueTokens 签发访问令牌，代表用户授权且客户端允许时同时签发刷新令牌，
+// 授权包含 openid 时签发 ID token
+func (s *OAuthService) issueTokens(ctx context.Context, client *OAuthClient, grant tokenGrant) (*TokenResponse, error) {
+	claims, err := s.auth.Identity(ctx, grant.userID)
+	if err != nil {
+		return nil, invalidGrant("用户不存在")
+	}
+	scopes := grant.scopes
+	claims.Scopes = scopes
+	claims.ClientID = client.ClientID
+	claims.AMR = grant.amr
+	claims.Subject = strconv.FormatUint(uint64(grant.userID), 10)
+
+	accessToken, err := jwt.IssueToken(*claims, s.cfg.AccessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	resp := &TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(s.cfg.AccessTokenTTL / time.Second),
+		Scope:       strings.Join(scopes, " "),
+	}
+
+	if grant.family == "" {
+		return resp, nil
+	}
+	if resp.IDToken, err = s.issueIDToken(client, claims, scopes, grant.nonce); err != nil {
+		return nil, err
+	}
+	if !slices.Contains(client.GrantTypes, GrantRefreshToken) {
+		return resp, nil
+	}
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	record := &RefreshToken{
+		TokenHash: hashToken(refreshToken),
+		FamilyID:  grant.family,
+		ClientID:  client.ClientID,
+		UserID:    grant.userID,
+		Scopes:    scopes,
+		AMR:       grant.amr,
+		ExpiresAt: time.Now().Add(s.cfg.RefreshTokenTTL),
+	}
+	if err := s.db.Create(record).Error; err != nil {
+		return nil, err
+	}
+	resp.RefreshToken = refreshToken
+	return resp, nil
+}
+
+// Introspect 返回令牌的状态，刷新令牌只能由其所属客户端内省
+func (s *OAuthService) Introspect(ctx context.Context, client *OAuthClient, token, hint string) *Introspection {
+	if hint != GrantRefreshToken {
+		if info := s.introspectAccessToken(ctx, token); info != nil {
+			return info
+		}
+	}
+
+	var record RefreshToken
+	if err := s.db.Where("token_hash = ?", hashToken(token)).First(&record).Error; err != nil {
+		return &Introspection{}
+	}
+	if record.ClientID != client.ClientID || record.RevokedAt != nil || record.RotatedAt != nil || time.Now().After(record.ExpiresAt) {
+		return &Introspection{}
+	}
+	return &Introspection{
+		Active:    true,
+		Scope:     strings.Join(record.Scopes, " "),
+		ClientID:  record.ClientID,
+		TokenType: "refresh_token",
+		Exp:       record.ExpiresAt.Unix(),
+		Iat:       record.CreatedAt.Unix(),
+		Sub:       strconv.FormatUint(uint64(record.UserID), 10),
+	}
+}
+
+func (s *OAuthService) introspectAccessToken(ctx context.Context, token string) *Introspection {
+	claims, err := jwt.ValidateToken(token)
+	if err != nil {
+		return nil
+	}
+	if revoked, err := s.auth.IsRevoked(ctx, claims); err != nil || revoked {
+		return &Introspection{}
+	}
+
+	info := &Introspection{
+		Active:    true,
+		Scope:     strings.Join(claims.Scopes, " "),
+		ClientID:  claims.ClientID,
+		Username:  claims.Username,
+		TokenType: "Bearer",
+		Sub:       strconv.FormatUint(uint64(claims.UserID), 10),
+		Iss:       claims.Issuer,
+		JTI:       claims.ID,
+	}
+	if claims.ExpiresAt != nil {
+		info.Exp = claims.ExpiresAt.Unix()
+	}
+	if claims.IssuedAt != nil {
+		info.Iat = claims.IssuedAt.Unix()
+	}
+	return info
+}
+
+// Revoke 按 RFC 7009 吊销令牌。无效的令牌或不属于该客户端的令牌直接忽略
+func (s *OAuthService) Revoke(ctx context.Context, client *OAuthClient, token, hint string) error {
+	if hint != "access_token" {
+		var record RefreshToken
+		if err := s.db.Where("token_hash = ?", hashToken(token)).First(&record).Error; err == nil {
+			if record.ClientID == client.ClientID {
+				return s.revokeFamily(record.FamilyID)
+			}
+			return nil
+		}
+	}
+
+	claims, err := jwt.ValidateToken(token)
+	if err != nil || claims.ClientID != client.ClientID {
+		return nil
+	}
+	return s.auth.RevokeToken(ctx, claims)
+}
+
+func (s *OAuthService) revokeFamily(family string) error {
+	return s.db.Model(&RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", family).
+		Update("revoked_at", time.Now()).Error
+}
+
+// grantedScopes 解析以空格分隔的 scope，不能超出客户端注册的范围，为空时授予全部范围
+func grantedScopes(client *OAuthClient, scope string) ([]string, *OAuthError) {
+	if scope == "" {
+		return client.Scopes, nil
+	}
+	scopes := strings.Fields(scope)
+	for _, sc := range scopes {
+		if !slices.Contains(client.Scopes, sc) {
+			return nil, oauthError(http.StatusBadRequest, "invalid_scope", fmt.Sprintf("客户端不允许申请 scope %q", sc))
+		}
+	}
+	return scopes, nil
+}
+
+// validateRedirectURI 要求使用绝对地址且不带 fragment，除本机回环地址外必须使用 https
+func validateRedirectURI(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() || u.Host == "" || u.Fragment != "" {
+		return fmt.Errorf("无效的 redirect_uri %q", raw)
+	}
+	host := u.Hostname()
+	if u.Scheme != "https" && !(u.Scheme == "http" && (host == "localhost" || host == "127.0.0.1" || host == "::1")) {
+		return fmt.Errorf("redirect_uri %q 必须使用 https", raw)
+	}
+	return nil
+}
+
+// verifyPKCE 校验 RFC 7636 的 S256 code_verifier
+func verifyPKCE(challenge, verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
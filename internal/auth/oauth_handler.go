@@ -0,0 +1,293 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shenjing023/rbac-api-gateway/pkg/jwt"
+)
+
+type OAuthHandler struct {
+	service *OAuthService
+}
+
+func NewOAuthHandler(service *OAuthService) *OAuthHandler {
+	return &OAuthHandler{service: service}
+}
+
+func (h *OAuthHandler) RegisterClient(c *gin.Context) {
+	var req ClientRegistration
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	client, secret, err := h.service.RegisterClient(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 客户端密钥只在注册时返回一次
+	resp := gin.H{"client": client, "client_id": client.ClientID}
+	if secret != "" {
+		resp["client_secret"] = secret
+	}
+	c.JSON(http.StatusCreated, resp)
+}
+
+func (h *OAuthHandler) ListClients(c *gin.Context) {
+	clients, err := h.service.ListClients()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取客户端列表失败"})
+		return
+	}
+	c.JSON(http.StatusOK, clients)
+}
+
+func (h *OAuthHandler) DeleteClient(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的客户端ID"})
+		return
+	}
+	if err := h.service.DeleteClient(uint(id)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "客户端不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "客户端已删除"})
+}
+
+// AuthorizeConsent 是授权确认页面需要展示的信息
+type AuthorizeConsent struct {
+	ClientID    string   `json:"client_id"`
+	ClientName  string   `json:"client_name"`
+	Scopes      []string `json:"scopes"`
+	RedirectURI string   `json:"redirect_uri"`
+	State       string   `json:"state,omitempty"`
+}
+
+// AuthorizeConsent 只校验授权请求并返回确认页面需要的信息，不签发授权码。
+// GET 请求不做 CSRF 校验，浏览器在跨站跳转时也会带上会话 cookie，所以授权码只能由 POST 签发
+func (h *OAuthHandler) AuthorizeConsent(c *gin.Context) {
+	var req AuthorizeRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, invalidRequest(err.Error()))
+		return
+	}
+
+	client, redirectURI, scopes, oerr := h.service.ValidateAuthorizeRequest(&req)
+	if oerr != nil {
+		writeAuthorizeError(c, oerr, redirectURI, req.State)
+		return
+	}
+	c.JSON(http.StatusOK, AuthorizeConsent{
+		ClientID:    client.ClientID,
+		ClientName:  client.Name,
+		Scopes:      scopes,
+		RedirectURI: redirectURI,
+		State:       req.State,
+	})
+}
+
+// Authorize 在用户确认授权后签发授权码，deny 为 true 时表示用户拒绝。
+// 使用会话 cookie 时网关要求请求带有 X-CSRF-Token。前端通过 fetch 调用，
+// 因此不直接重定向，而是返回 redirect_to 由前端跳转
+func (h *OAuthHandler) Authorize(c *gin.Context) {
+	var req AuthorizeRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, invalidRequest(err.Error()))
+		return
+	}
+
+	client, redirectURI, scopes, oerr := h.service.ValidateAuthorizeRequest(&req)
+	if oerr != nil {
+		writeAuthorizeError(c, oerr, redirectURI, req.State)
+		return
+	}
+	if req.Deny {
+		writeAuthorizeError(c, oauthError(http.StatusForbidden, "access_denied", "用户拒绝授权"), redirectURI, req.State)
+		return
+	}
+
+	code, err := h.service.IssueCode(client, c.GetUint("user_id"), c.GetStringSlice("amr"), redirectURI, scopes, &req)
+	if err != nil {
+		writeAuthorizeError(c, oauthError(http.StatusInternalServerError, "server_error", ""), redirectURI, req.State)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"redirect_to": redirectURL(redirectURI, url.Values{"code": {code}}, req.State)})
+}
+
+// writeAuthorizeError 返回授权请求的错误，redirectURI 已校验时同时返回带错误参数的 redirect_to，
+// 由前端跳转回客户端
+func writeAuthorizeError(c *gin.Context, oerr *OAuthError, redirectURI, state string) {
+	if redirectURI == "" {
+		c.JSON(oerr.Status, oerr)
+		return
+	}
+	params := url.Values{"error": {oerr.Code}}
+	if oerr.Description != "" {
+		params.Set("error_description", oerr.Description)
+	}
+	c.JSON(oerr.Status, gin.H{
+		"error":             oerr.Code,
+		"error_description": oerr.Description,
+		"redirect_to":       redirectURL(redirectURI, params, state),
+	})
+}
+
+func redirectURL(redirectURI string, params url.Values, state string) string {
+	if state != "" {
+		params.Set("state", state)
+	}
+	u, _ := url.Parse(redirectURI)
+	query := u.Query()
+	for k, v := range params {
+		query[k] = v
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// Token 是令牌端点，按 RFC 6749 使用表单参数，客户端通过 HTTP Basic 或表单参数认证
+func (h *OAuthHandler) Token(c *gin.Context) {
+	client, ok := h.authenticateClient(c)
+	if !ok {
+		return
+	}
+
+	var (
+		resp *TokenResponse
+		err  error
+	)
+	ctx := c.Request.Context()
+	switch c.PostForm("grant_type") {
+	case GrantAuthorizationCode:
+		resp, err = h.service.ExchangeCode(ctx, client, c.PostForm("code"), c.PostForm("redirect_uri"), c.PostForm("code_verifier"))
+	case GrantRefreshToken:
+		resp, err = h.service.RefreshTokens(ctx, client, c.PostForm("refresh_token"), c.PostForm("scope"))
+	case GrantClientCredentials:
+		resp, err = h.service.ClientCredentials(ctx, client, c.PostForm("scope"))
+	default:
+		err = oauthError(http.StatusBadRequest, "unsupported_grant_type", "不支持的 grant_type")
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+	if err != nil {
+		writeOAuthError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// Introspect 实现 RFC 7662 令牌内省
+func (h *OAuthHandler) Introspect(c *gin.Context) {
+	client, ok := h.authenticateClient(c)
+	if !ok {
+		return
+	}
+	if client.Public {
+		writeOAuthError(c, errInvalidClient)
+		return
+	}
+	c.JSON(http.StatusOK, h.service.Introspect(c.Request.Context(), client, c.PostForm("token"), c.PostForm("token_type_hint")))
+}
+
+// Revoke 实现 RFC 7009 令牌吊销，令牌无效时也返回 200
+func (h *OAuthHandler) Revoke(c *gin.Context) {
+	client, ok := h.authenticateClient(c)
+	if !ok {
+		return
+	}
+	if err := h.service.Revoke(c.Request.Context(), client, c.PostForm("token"), c.PostForm("token_type_hint")); err != nil {
+		writeOAuthError(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func (h *OAuthHandler) authenticateClient(c *gin.Context) (*OAuthClient, bool) {
+	clientID, secret, ok := c.Request.BasicAuth()
+	if ok {
+		// RFC 6749 2.3.1 要求 Basic 认证中的 client_id 和密钥先做表单编码
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = c.PostForm("client_id"), c.PostForm("client_secret")
+	}
+
+	client, err := h.service.AuthenticateClient(clientID, secret)
+	if err != nil {
+		if ok {
+			c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		}
+		writeOAuthError(c, err)
+		return nil, false
+	}
+	return client, true
+}
+
+// UserInfo 是 OIDC 的 userinfo 端点，按 RFC 6750 从 Authorization 请求头读取访问令牌
+func (h *OAuthHandler) UserInfo(c *gin.Context) {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		c.Header("WWW-Authenticate", `Bearer realm="userinfo"`)
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	info, oerr := h.service.UserInfo(c.Request.Context(), token)
+	if oerr != nil {
+		c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error=%q, error_description=%q`, oerr.Code, oerr.Description))
+		c.JSON(oerr.Status, oerr)
+		return
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+func (h *OAuthHandler) Discovery(c *gin.Context) {
+	c.JSON(http.StatusOK, h.service.Discovery())
+}
+
+func (h *OAuthHandler) JWKS(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"keys": jwt.JWKS()})
+}
+
+func writeOAuthError(c *gin.Context, err error) {
+	var oerr *OAuthError
+	if !errors.As(err, &oerr) {
+		oerr = oauthError(http.StatusInternalServerError, "server_error", "")
+	}
+	c.JSON(oerr.Status, oerr)
+}
+
+func RegisterOAuthRoutes(r *gin.Engine, service *OAuthService) {
+	handler := NewOAuthHandler(service)
+
+	oauth := r.Group("/oauth")
+	{
+		oauth.POST("/clients", handler.RegisterClient)
+		oauth.GET("/clients", handler.ListClients)
+		oauth.DELETE("/clients/:id", handler.DeleteClient)
+
+		oauth.GET("/authorize", handler.AuthorizeConsent)
+		oauth.POST("/authorize", handler.Authorize)
+		oauth.POST("/token", handler.Token)
+		oauth.POST("/introspect", handler.Introspect)
+		oauth.POST("/revoke", handler.Revoke)
+	}
+
+	// OpenID Connect
+	if service.cfg.Issuer != "" {
+		r.GET("/userinfo", handler.UserInfo)
+		r.POST("/userinfo", handler.UserInfo)
+		r.GET("/.well-known/openid-configuration", handler.Discovery)
+		r.GET("/.well-known/jwks.json", handler.JWKS)
+	}
+}
@@ -0,0 +1,313 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shenjing023/rbac-api-gateway/pkg/jwt"
+	"gorm.io/gorm"
+)
+
+const (
+	testRedirectURI  = "https://app.example.com/callback"
+	testCodeVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+func newTestOAuthService(t *testing.T) (*OAuthService, *gorm.DB) {
+	t.Helper()
+	db := newTestDB(t)
+	s := NewOAuthService(db, NewService(db), nil, OAuthConfig{
+		AccessTokenTTL:  time.Minute,
+		RefreshTokenTTL: time.Hour,
+		CodeTTL:         time.Minute,
+	})
+	return s, db
+}
+
+func registerTestClient(t *testing.T, s *OAuthService, redirectURIs ...string) *OAuthClient {
+	t.Helper()
+	client, _, err := s.RegisterClient(ClientRegistration{
+		Name:         "app",
+		RedirectURIs: redirectURIs,
+		GrantTypes:   []string{GrantAuthorizationCode, GrantRefreshToken},
+		Scopes:       []string{"posts:read"},
+	})
+	if err != nil {
+		t.Fatalf("RegisterClient: %v", err)
+	}
+	return client
+}
+
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func TestExchangeCodeRedirectURI(t *testing.T) {
+	tests := []struct {
+		name         string
+		registered   []string
+		authorizeURI string // 授权请求中的 redirect_uri，为空表示省略
+		tokenURI     string // 令牌请求中的 redirect_uri
+		wantErr      bool
+	}{
+		{"omitted in both requests", []string{testRedirectURI}, "", "", false},
+		{"omitted in authorize, matching in token", []string{testRedirectURI}, "", testRedirectURI, false},
+		{"omitted in authorize, different in token", []string{testRedirectURI}, "", "https://evil.example.com/cb", true},
+		{"supplied in both", []string{testRedirectURI}, testRedirectURI, testRedirectURI, false},
+		{"supplied in authorize, omitted in token", []string{testRedirectURI}, testRedirectURI, "", true},
+		{"supplied in authorize, different in token", []string{testRedirectURI, "https://app.example.com/other"}, testRedirectURI, "https://app.example.com/other", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, db := newTestOAuthService(t)
+			u := createUser(t, db, "alice", "password", "")
+			client := registerTestClient(t, s, tt.registered...)
+
+			req := &AuthorizeRequest{ResponseType: "code", ClientID: client.ClientID, RedirectURI: tt.authorizeURI, Scope: "posts:read"}
+			_, redirectURI, scopes, oerr := s.ValidateAuthorizeRequest(req)
+			if oerr != nil {
+				t.Fatalf("ValidateAuthorizeRequest: %v", oerr)
+			}
+			code, err := s.IssueCode(client, u.ID, nil, redirectURI, scopes, req)
+			if err != nil {
+				t.Fatalf("IssueCode: %v", err)
+			}
+
+			resp, err := s.ExchangeCode(context.Background(), client, code, tt.tokenURI, "")
+			if tt.wantErr {
+				var oerr *OAuthError
+				if !errors.As(err, &oerr) || oerr.Code != "invalid_grant" {
+					t.Fatalf("ExchangeCode error = %v, want invalid_grant", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ExchangeCode: %v", err)
+			}
+			if resp.AccessToken == "" || resp.RefreshToken == "" {
+				t.Errorf("expected access and refresh tokens, got %+v", resp)
+			}
+		})
+	}
+}
+
+func TestExchangeCodePKCEAndReuse(t *testing.T) {
+	s, db := newTestOAuthService(t)
+	u := createUser(t, db, "alice", "password", "")
+	client := registerTestClient(t, s, testRedirectURI)
+
+	issue := func(t *testing.T) string {
+		t.Helper()
+		req := &AuthorizeRequest{ResponseType: "code", ClientID: client.ClientID, CodeChallenge: codeChallenge(testCodeVerifier), CodeChallengeMethod: "S256"}
+		code, err := s.IssueCode(client, u.ID, nil, testRedirectURI, []string{"posts:read"}, req)
+		if err != nil {
+			t.Fatalf("IssueCode: %v", err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name     string
+		verifier string
+		wantErr  bool
+	}{
+		{"missing verifier", "", true},
+		{"wrong verifier", strings.Repeat("x", 43), true},
+		{"matching verifier", testCodeVerifier, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.ExchangeCode(context.Background(), client, issue(t), "", tt.verifier)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExchangeCode error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("reused code revokes refresh token", func(t *testing.T) {
+		code := issue(t)
+		resp, err := s.ExchangeCode(context.Background(), client, code, "", testCodeVerifier)
+		if err != nil {
+			t.Fatalf("ExchangeCode: %v", err)
+		}
+		if _, err := s.ExchangeCode(context.Background(), client, code, "", testCodeVerifier); err == nil {
+			t.Fatal("second exchange of the same code should fail")
+		}
+		if _, err := s.RefreshTokens(context.Background(), client, resp.RefreshToken, ""); err == nil {
+			t.Error("refresh token issued for a reused code should be revoked")
+		}
+	})
+}
+
+func TestTokenAMR(t *testing.T) {
+	s, db := newTestOAuthService(t)
+	u := createUser(t, db, "alice", "password", "")
+	client := registerTestClient(t, s, testRedirectURI)
+	service, _, err := s.RegisterClient(ClientRegistration{Name: "worker", GrantTypes: []string{GrantClientCredentials}, Scopes: []string{"posts:read"}, OwnerID: u.ID})
+	if err != nil {
+		t.Fatalf("RegisterClient: %v", err)
+	}
+
+	// 授权码和刷新得到的令牌都沿用批准授权的会话的认证方式，策略据此判断是否满足多因素认证
+	exchange := func(t *testing.T, amr []string) *TokenResponse {
+		t.Helper()
+		req := &AuthorizeRequest{ResponseType: "code", ClientID: client.ClientID, CodeChallenge: codeChallenge(testCodeVerifier), CodeChallengeMethod: "S256"}
+		code, err := s.IssueCode(client, u.ID, amr, testRedirectURI, []string{"posts:read"}, req)
+		if err != nil {
+			t.Fatalf("IssueCode: %v", err)
+		}
+		resp, err := s.ExchangeCode(context.Background(), client, code, "", testCodeVerifier)
+		if err != nil {
+			t.Fatalf("ExchangeCode: %v", err)
+		}
+		return resp
+	}
+	refresh := func(t *testing.T, amr []string) *TokenResponse {
+		t.Helper()
+		resp, err := s.RefreshTokens(context.Background(), client, exchange(t, amr).RefreshToken, "")
+		if err != nil {
+			t.Fatalf("RefreshTokens: %v", err)
+		}
+		return resp
+	}
+	clientCredentials := func(t *testing.T, _ []string) *TokenResponse {
+		t.Helper()
+		resp, err := s.ClientCredentials(context.Background(), service, "")
+		if err != nil {
+			t.Fatalf("ClientCredentials: %v", err)
+		}
+		return resp
+	}
+
+	tests := []struct {
+		name    string
+		issue   func(t *testing.T, amr []string) *TokenResponse
+		amr     []string
+		wantAMR []string
+	}{
+		{"code from mfa session", exchange, []string{"pwd", "otp", "mfa"}, []string{"pwd", "otp", "mfa"}},
+		{"code from password session", exchange, []string{"pwd"}, []string{"pwd"}},
+		{"refreshed token", refresh, []string{"pwd", "mfa"}, []string{"pwd", "mfa"}},
+		{"client credentials", clientCredentials, nil, []string{jwt.AMRKey}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := jwt.ValidateToken(tt.issue(t, tt.amr).AccessToken)
+			if err != nil {
+				t.Fatalf("ValidateToken: %v", err)
+			}
+			if !slices.Equal(claims.AMR, tt.wantAMR) {
+				t.Errorf("amr = %v, want %v", claims.AMR, tt.wantAMR)
+			}
+		})
+	}
+}
+
+// newTestOAuthRouter 模拟网关已经认证了 alice 的请求
+func newTestOAuthRouter(s *OAuthService, userID uint) *gin.Engine {
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("user_id", userID) })
+	handler := NewOAuthHandler(s)
+	r.GET("/oauth/authorize", handler.AuthorizeConsent)
+	r.POST("/oauth/authorize", handler.Authorize)
+	return r
+}
+
+func TestAuthorizeEndpoint(t *testing.T) {
+	s, db := newTestOAuthService(t)
+	u := createUser(t, db, "alice", "password", "")
+	client := registerTestClient(t, s, testRedirectURI)
+	router := newTestOAuthRouter(s, u.ID)
+
+	params := url.Values{"response_type": {"code"}, "client_id": {client.ClientID}, "scope": {"posts:read"}, "state": {"xyz"}}
+
+	tests := []struct {
+		name       string
+		method     string
+		params     url.Values
+		wantStatus int
+		wantCode   bool // redirect_to 中是否带有授权码
+		wantError  string
+	}{
+		{"consent page", http.MethodGet, params, http.StatusOK, false, ""},
+		{"consent page with unknown client", http.MethodGet, url.Values{"response_type": {"code"}, "client_id": {"unknown"}}, http.StatusBadRequest, false, "invalid_request"},
+		{"approve", http.MethodPost, params, http.StatusOK, true, ""},
+		{"deny", http.MethodPost, withParam(params, "deny", "true"), http.StatusForbidden, false, "access_denied"},
+		{"invalid scope", http.MethodPost, withParam(params, "scope", "users:write"), http.StatusBadRequest, false, "invalid_scope"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var before int64
+			db.Model(&AuthorizationCode{}).Count(&before)
+
+			var req *http.Request
+			if tt.method == http.MethodGet {
+				req = httptest.NewRequest(tt.method, "/oauth/authorize?"+tt.params.Encode(), nil)
+			} else {
+				req = httptest.NewRequest(tt.method, "/oauth/authorize", strings.NewReader(tt.params.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body %s", w.Code, tt.wantStatus, w.Body)
+			}
+			var body struct {
+				Error      string `json:"error"`
+				RedirectTo string `json:"redirect_to"`
+				ClientID   string `json:"client_id"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decode body: %v", err)
+			}
+			if body.Error != tt.wantError {
+				t.Errorf("error = %q, want %q", body.Error, tt.wantError)
+			}
+
+			var after int64
+			db.Model(&AuthorizationCode{}).Count(&after)
+			if issued := after > before; issued != tt.wantCode {
+				t.Errorf("authorization code issued = %v, want %v", issued, tt.wantCode)
+			}
+			if tt.method == http.MethodGet && tt.wantStatus == http.StatusOK && body.ClientID != client.ClientID {
+				t.Errorf("consent client_id = %q, want %q", body.ClientID, client.ClientID)
+			}
+			if body.RedirectTo == "" {
+				return
+			}
+			redirect, err := url.Parse(body.RedirectTo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			query := redirect.Query()
+			if query.Get("state") != "xyz" {
+				t.Errorf("state = %q, want xyz", query.Get("state"))
+			}
+			if (query.Get("code") != "") != tt.wantCode || query.Get("error") != tt.wantError {
+				t.Errorf("unexpected redirect_to %s", body.RedirectTo)
+			}
+		})
+	}
+}
+
+func withParam(params url.Values, key, value string) url.Values {
+	out := url.Values{}
+	for k, v := range params {
+		out[k] = v
+	}
+	out.Set(key, value)
+	return out
+}
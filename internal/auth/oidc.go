@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/shenjing023/rbac-api-gateway/internal/rbac"
+	"github.com/shenjing023/rbac-api-gateway/pkg/jwt"
+)
+
+// OpenID Connect 定义的身份 scope，它们不对应任何资源，不会放宽 API 的访问权限
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+var identityScopes = []string{ScopeOpenID, ScopeProfile, ScopeEmail}
+
+// validateClientScopes 检查客户端注册的 scope，身份 scope 之外的都必须是资源 scope
+func validateClientScopes(scopes []string) error {
+	var resourceScopes []string
+	for _, scope := range scopes {
+		if !slices.Contains(identityScopes, scope) {
+			resourceScopes = append(resourceScopes, scope)
+		}
+	}
+	if len(resourceScopes) == 0 && slices.Contains(scopes, ScopeOpenID) {
+		return nil
+	}
+	return rbac.ValidateScopes(resourceScopes)
+}
+
+// issueIDToken 在授权包含 openid 时签发 ID token
+func (s *OAuthService) issueIDToken(client *OAuthClient, claims *jwt.Claims, scopes []string, nonce string) (string, error) {
+	if s.cfg.Issuer == "" || !slices.Contains(scopes, ScopeOpenID) {
+		return "", nil
+	}
+
+	idClaims := jwt.IDClaims{Nonce: nonce}
+	idClaims.Subject = claims.Subject
+	if slices.Contains(scopes, ScopeProfile) {
+		idClaims.PreferredUsername = claims.Username
+		idClaims.Name = claims.Username
+		idClaims.Role = claims.Role
+	}
+	return jwt.IssueIDToken(idClaims, s.issuer(), client.ClientID, s.cfg.IDTokenTTL)
+}
+
+// issuer 返回 discovery 中发布的签发方地址，ID token 的 iss 必须与它完全一致
+func (s *OAuthService) issuer() string {
+	return strings.TrimRight(s.cfg.Issuer, "/")
+}
+
+// UserInfo 返回访问令牌对应用户的信息，令牌必须包含 openid scope
+func (s *OAuthService) UserInfo(ctx context.Context, token string) (map[string]interface{}, *OAuthError) {
+	invalidToken := oauthError(http.StatusUnauthorized, "invalid_token", "无效的访问令牌")
+
+	claims, err := jwt.ValidateToken(token)
+	if err != nil || claims.ClientID == "" {
+		return nil, invalidToken
+	}
+	if revoked, err := s.auth.IsRevoked(ctx, claims); err != nil || revoked {
+		return nil, invalidToken
+	}
+	if !slices.Contains(claims.Scopes, ScopeOpenID) {
+		return nil, oauthError(http.StatusForbidden, "insufficient_scope", "访问令牌不包含 openid scope")
+	}
+
+	u, err := s.users.GetUserByID(claims.UserID)
+	if err != nil {
+		return nil, invalidToken
+	}
+
+	info := map[string]interface{}{"sub": strconv.FormatUint(uint64(u.ID), 10)}
+	if slices.Contains(claims.Scopes, ScopeProfile) {
+		info["preferred_username"] = u.Username
+		info["name"] = u.Username
+		info["role"] = claims.Role
+		info["updated_at"] = u.UpdatedAt.Unix()
+	}
+	return info, nil
+}
+
+// Discovery 返回 /.well-known/openid-configuration 的内容
+func (s *OAuthService) Discovery() map[string]interface{} {
+	issuer := s.issuer()
+	return map[string]interface{}{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/oauth/authorize",
+		"token_endpoint":                        issuer + "/oauth/token",
+		"userinfo_endpoint":                     issuer + "/userinfo",
+		"jwks_uri":                              issuer + "/.well-known/jwks.json",
+		"introspection_endpoint":                issuer + "/oauth/introspect",
+		"revocation_endpoint":                   issuer + "/oauth/revoke",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{GrantAuthorizationCode, GrantRefreshToken, GrantClientCredentials},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      identityScopes,
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported":                      []string{"sub", "iss", "aud", "exp", "iat", "nonce", "azp", "preferred_username", "name", "role"},
+	}
+}
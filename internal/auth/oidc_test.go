@@ -0,0 +1,286 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	jwtlib "github.com/golang-jwt/jwt/v4"
+	"github.com/shenjing023/rbac-api-gateway/internal/user"
+	"github.com/shenjing023/rbac-api-gateway/pkg/jwt"
+	"gorm.io/gorm"
+)
+
+const testIssuer = "https://gateway.example.com"
+
+var (
+	testRSAKeyOnce sync.Once
+	testRSAKey     *rsa.PrivateKey
+)
+
+// setTestRSAKey 设置签发 ID token 的密钥，所有测试共用一个，避免重复生成
+func setTestRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	testRSAKeyOnce.Do(func() {
+		key, err := jwt.GenerateRSAKey()
+		if err != nil {
+			panic(err)
+		}
+		if err := jwt.SetRSAKey(key); err != nil {
+			panic(err)
+		}
+		testRSAKey = key
+	})
+	return testRSAKey
+}
+
+func newTestOIDCService(t *testing.T) (*OAuthService, *gorm.DB) {
+	t.Helper()
+	setTestRSAKey(t)
+	db := newTestDB(t)
+	s := NewOAuthService(db, NewService(db), user.NewService(db), OAuthConfig{
+		AccessTokenTTL:  time.Minute,
+		RefreshTokenTTL: time.Hour,
+		CodeTTL:         time.Minute,
+		Issuer:          testIssuer + "/",
+		IDTokenTTL:      time.Minute,
+	})
+	return s, db
+}
+
+// oidcTokens 走完授权码流程，返回用 scopes 和 nonce 换取的令牌
+func oidcTokens(t *testing.T, s *OAuthService, client *OAuthClient, userID uint, scopes []string, nonce string) *TokenResponse {
+	t.Helper()
+	req := &AuthorizeRequest{ResponseType: "code", ClientID: client.ClientID, Nonce: nonce, CodeChallenge: codeChallenge(testCodeVerifier), CodeChallengeMethod: "S256"}
+	code, err := s.IssueCode(client, userID, []string{"pwd"}, testRedirectURI, scopes, req)
+	if err != nil {
+		t.Fatalf("IssueCode: %v", err)
+	}
+	resp, err := s.ExchangeCode(context.Background(), client, code, "", testCodeVerifier)
+	if err != nil {
+		t.Fatalf("ExchangeCode: %v", err)
+	}
+	return resp
+}
+
+func registerOIDCClient(t *testing.T, s *OAuthService) *OAuthClient {
+	t.Helper()
+	client, _, err := s.RegisterClient(ClientRegistration{
+		Name:         "app",
+		RedirectURIs: []string{testRedirectURI},
+		GrantTypes:   []string{GrantAuthorizationCode, GrantRefreshToken},
+		Scopes:       []string{ScopeOpenID, ScopeProfile, "posts:read"},
+	})
+	if err != nil {
+		t.Fatalf("RegisterClient: %v", err)
+	}
+	return client
+}
+
+func TestValidateClientScopes(t *testing.T) {
+	tests := []struct {
+		scopes  []string
+		wantErr bool
+	}{
+		{[]string{ScopeOpenID}, false},
+		{[]string{ScopeOpenID, ScopeProfile, ScopeEmail}, false},
+		{[]string{ScopeOpenID, "posts:read"}, false},
+		{[]string{"posts:read"}, false},
+		// 只有 profile 没有 openid，也没有资源 scope
+		{[]string{ScopeProfile}, true},
+		{[]string{ScopeOpenID, "posts:delete"}, true},
+		{nil, true},
+	}
+	for _, tt := range tests {
+		if err := validateClientScopes(tt.scopes); (err != nil) != tt.wantErr {
+			t.Errorf("validateClientScopes(%q) = %v, want error %v", tt.scopes, err, tt.wantErr)
+		}
+	}
+}
+
+func TestIDToken(t *testing.T) {
+	key := setTestRSAKey(t)
+	s, db := newTestOIDCService(t)
+	u := createUser(t, db, "alice", "password", "moderator")
+	client := registerOIDCClient(t, s)
+
+	tests := []struct {
+		name        string
+		scopes      []string
+		nonce       string
+		wantIDToken bool
+		wantProfile bool
+	}{
+		{"openid", []string{ScopeOpenID}, "n-1", true, false},
+		{"openid and profile", []string{ScopeOpenID, ScopeProfile}, "n-2", true, true},
+		{"without nonce", []string{ScopeOpenID, "posts:read"}, "", true, false},
+		// 没有请求 openid 时是普通的 OAuth2 授权
+		{"without openid", []string{"posts:read"}, "n-3", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := oidcTokens(t, s, client, u.ID, tt.scopes, tt.nonce)
+			if (resp.IDToken != "") != tt.wantIDToken {
+				t.Fatalf("id_token = %q, want present %v", resp.IDToken, tt.wantIDToken)
+			}
+			if !tt.wantIDToken {
+				return
+			}
+
+			var claims jwt.IDClaims
+			_, err := jwtlib.ParseWithClaims(resp.IDToken, &claims, func(token *jwtlib.Token) (interface{}, error) {
+				if token.Method != jwtlib.SigningMethodRS256 {
+					t.Errorf("id_token alg = %v, want RS256", token.Header["alg"])
+				}
+				return &key.PublicKey, nil
+			})
+			if err != nil {
+				t.Fatalf("parse id_token: %v", err)
+			}
+			// iss 与 discovery 中发布的一致，不带末尾的斜杠
+			if claims.Issuer != testIssuer {
+				t.Errorf("iss = %q, want %q", claims.Issuer, testIssuer)
+			}
+			if !slices.Equal([]string(claims.Audience), []string{client.ClientID}) || claims.AuthorizedParty != client.ClientID {
+				t.Errorf("aud = %v, azp = %q, want %s", claims.Audience, claims.AuthorizedParty, client.ClientID)
+			}
+			if claims.Nonce != tt.nonce {
+				t.Errorf("nonce = %q, want %q", claims.Nonce, tt.nonce)
+			}
+			if claims.Subject != strconv.FormatUint(uint64(u.ID), 10) {
+				t.Errorf("sub = %q, want %d", claims.Subject, u.ID)
+			}
+			if got := claims.PreferredUsername == "alice" && claims.Role == "moderator"; got != tt.wantProfile {
+				t.Errorf("profile claims = %q/%q, want present %v", claims.PreferredUsername, claims.Role, tt.wantProfile)
+			}
+			// ID token 不能当作访问令牌使用
+			if _, err := jwt.ValidateToken(resp.IDToken); err == nil {
+				t.Error("id_token was accepted as an access token")
+			}
+		})
+	}
+}
+
+func TestUserInfo(t *testing.T) {
+	s, db := newTestOIDCService(t)
+	u := createUser(t, db, "alice", "password", "moderator")
+	client := registerOIDCClient(t, s)
+	r := gin.New()
+	RegisterOAuthRoutes(r, s)
+
+	// 登录签发的 token 没有 client_id，不是 OAuth2 访问令牌
+	loginToken, err := jwt.IssueToken(jwt.Claims{UserID: u.ID, Username: "alice", Role: "moderator", Scopes: []string{ScopeOpenID}}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantCode      int
+		wantError     string
+		wantProfile   bool
+	}{
+		{"openid", "Bearer " + oidcTokens(t, s, client, u.ID, []string{ScopeOpenID}, "").AccessToken, http.StatusOK, "", false},
+		{"openid and profile", "Bearer " + oidcTokens(t, s, client, u.ID, []string{ScopeOpenID, ScopeProfile}, "").AccessToken, http.StatusOK, "", true},
+		// profile 必须与 openid 一起请求才能读取用户信息
+		{"profile without openid", "Bearer " + oidcTokens(t, s, client, u.ID, []string{ScopeProfile, "posts:read"}, "").AccessToken, http.StatusForbidden, "insufficient_scope", false},
+		{"resource scope only", "Bearer " + oidcTokens(t, s, client, u.ID, []string{"posts:read"}, "").AccessToken, http.StatusForbidden, "insufficient_scope", false},
+		{"login token", "Bearer " + loginToken, http.StatusUnauthorized, "invalid_token", false},
+		{"invalid token", "Bearer garbage", http.StatusUnauthorized, "invalid_token", false},
+		{"missing token", "", http.StatusUnauthorized, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/userinfo", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Fatalf("GET /userinfo = %d %s, want %d", w.Code, w.Body.String(), tt.wantCode)
+			}
+			// RFC 6750：错误通过 WWW-Authenticate 返回
+			if challenge := w.Header().Get("WWW-Authenticate"); tt.wantCode != http.StatusOK && !strings.Contains(challenge, tt.wantError) {
+				t.Errorf("WWW-Authenticate = %q, want error %q", challenge, tt.wantError)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var info map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+				t.Fatal(err)
+			}
+			if info["sub"] != strconv.FormatUint(uint64(u.ID), 10) {
+				t.Errorf("sub = %v, want %d", info["sub"], u.ID)
+			}
+			_, hasProfile := info["preferred_username"]
+			if hasProfile != tt.wantProfile || (tt.wantProfile && (info["preferred_username"] != "alice" || info["role"] != "moderator")) {
+				t.Errorf("userinfo = %v, want profile %v", info, tt.wantProfile)
+			}
+		})
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	s, _ := newTestOIDCService(t)
+	r := gin.New()
+	RegisterOAuthRoutes(r, s)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.well-known/openid-configuration", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("discovery = %d", w.Code)
+	}
+	var doc struct {
+		Issuer           string   `json:"issuer"`
+		TokenEndpoint    string   `json:"token_endpoint"`
+		UserInfoEndpoint string   `json:"userinfo_endpoint"`
+		JWKSURI          string   `json:"jwks_uri"`
+		ScopesSupported  []string `json:"scopes_supported"`
+		SigningAlgs      []string `json:"id_token_signing_alg_values_supported"`
+		ChallengeMethods []string `json:"code_challenge_methods_supported"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	// 配置中的 issuer 带有末尾的斜杠，发布时去掉，与 ID token 的 iss 一致
+	if doc.Issuer != testIssuer || doc.TokenEndpoint != testIssuer+"/oauth/token" ||
+		doc.UserInfoEndpoint != testIssuer+"/userinfo" || doc.JWKSURI != testIssuer+"/.well-known/jwks.json" {
+		t.Errorf("discovery = %+v", doc)
+	}
+	if !slices.Contains(doc.ScopesSupported, ScopeOpenID) || !slices.Equal(doc.SigningAlgs, []string{"RS256"}) || !slices.Equal(doc.ChallengeMethods, []string{"S256"}) {
+		t.Errorf("discovery = %+v", doc)
+	}
+
+	// 发布的公钥能校验 ID token
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	var jwks struct {
+		Keys []jwt.JWK `json:"keys"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &jwks); err != nil || len(jwks.Keys) == 0 || jwks.Keys[0].Algorithm != "RS256" {
+		t.Errorf("jwks = %s", w.Body.String())
+	}
+
+	// 没有配置 issuer 时不注册 OIDC 端点
+	plain, _ := newTestOAuthService(t)
+	r = gin.New()
+	RegisterOAuthRoutes(r, plain)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.well-known/openid-configuration", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("discovery without issuer = %d, want 404", w.Code)
+	}
+}
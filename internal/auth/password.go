@@ -0,0 +1,217 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/shenjing023/rbac-api-gateway/internal/audit"
+	"github.com/shenjing023/rbac-api-gateway/internal/user"
+	"github.com/shenjing023/rbac-api-gateway/pkg/notify"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidResetToken     = errors.New("重置链接无效或已过期")
+	ErrPasswordResetDisabled = errors.New("未启用密码重置")
+	// ErrPasswordNotSet 表示通过单点登录或 LDAP 创建的用户没有本地密码
+	ErrPasswordNotSet = errors.New("该账号没有本地密码")
+)
+
+// resetRequestInterval 是同一个用户两次申请重置密码的最短间隔，防止被用来向用户发送大量通知
+const resetRequestInterval = time.Minute
+
+// PasswordResetConfig 是密码重置的配置
+type PasswordResetConfig struct {
+	TTL time.Duration
+	// URL 是前端的重置密码页面，其中的 {token} 会被替换为重置令牌，为空时通知中只包含令牌
+	URL string
+}
+
+type passwordReset struct {
+	notifier notify.Notifier
+	cfg      PasswordResetConfig
+}
+
+// SetPasswordReset 启用通过 notifier 发送重置令牌的找回密码流程
+func (s *Service) SetPasswordReset(notifier notify.Notifier, cfg PasswordResetConfig) {
+	s.reset = &passwordReset{notifier: notifier, cfg: cfg}
+}
+
+// PasswordResetEnabled 返回是否启用了找回密码
+func (s *Service) PasswordResetEnabled() bool {
+	return s.reset != nil
+}
+
+// ChangePasswordRequest 是已登录用户修改自己的密码
+type ChangePasswordRequest struct {
+	UserID          uint
+	CurrentPassword string
+	NewPassword     string
+	IP              string
+}
+
+// ChangePassword 校验当前密码后设置新密码，并吊销用户所有的会话，包括发起请求的这一个。
+// 当前密码错误与登录失败一样计入失败次数
+func (s *Service) ChangePassword(ctx context.Context, req ChangePasswordRequest) error {
+	var u user.User
+	if err := s.db.WithContext(ctx).First(&u, req.UserID).Error; err != nil {
+		return err
+	}
+	if u.Password == "" {
+		return ErrPasswordNotSet
+	}
+
+	wait, err := s.lockout.Check(ctx, u.Username, req.IP)
+	if err != nil {
+		return err
+	}
+	if wait > 0 {
+		return &ThrottledError{RetryAfter: wait}
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(req.CurrentPassword)); err != nil {
+		if _, err := s.lockout.Failure(ctx, u.Username, req.IP); err != nil {
+			log.Printf("lockout: %v", err)
+		}
+		return ErrInvalidCredentials
+	}
+
+	if err := s.setPassword(ctx, &u, req.NewPassword, nil); err != nil {
+		return err
+	}
+	s.audit.Record(ctx, audit.Event{Type: audit.TypePasswordChanged, ActorID: u.ID, Actor: u.Username, Target: u.Username, IP: req.IP})
+	return nil
+}
+
+// RequestPasswordReset 为用户生成重置令牌并通过 notifier 发送。为了不泄露用户名是否存在，
+// 用户不存在、没有本地密码或者申请过于频繁时也返回 nil
+func (s *Service) RequestPasswordReset(ctx context.Context, username, ip string) error {
+	if s.reset == nil {
+		return ErrPasswordResetDisabled
+	}
+
+	var u user.User
+	err := s.db.WithContext(ctx).Where("username = ? AND kind = ?", username, user.KindUser).First(&u).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) || err == nil && u.Password == "" {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var recent int64
+	if err := s.db.WithContext(ctx).Model(&PasswordResetToken{}).
+		Where("user_id = ? AND used_at IS NULL AND created_at > ?", u.ID, time.Now().Add(-resetRequestInterval)).
+		Count(&recent).Error; err != nil {
+		return err
+	}
+	if recent > 0 {
+		return nil
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+	// 每个用户只保留最新的一个令牌
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND used_at IS NULL", u.ID).Delete(&PasswordResetToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&PasswordResetToken{
+			UserID:    u.ID,
+			TokenHash: hashToken(token),
+			ExpiresAt: time.Now().Add(s.reset.cfg.TTL),
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	link := token
+	if s.reset.cfg.URL != "" {
+		link = strings.ReplaceAll(s.reset.cfg.URL, "{token}", token)
+	}
+	msg := notify.Message{
+		To:       u.Email,
+		Username: u.Username,
+		Subject:  "重置密码",
+		Body: fmt.Sprintf("%s，你好：\n\n请在 %d 分钟内使用以下链接重置密码：\n\n%s\n\n如果不是你本人的操作，请忽略这封邮件。\n",
+			u.Username, int(s.reset.cfg.TTL.Minutes()), link),
+	}
+	if err := s.reset.notifier.Notify(ctx, msg); err != nil {
+		// 发送失败时不返回错误，避免泄露用户名是否存在
+		log.Printf("password reset: failed to notify user %d: %v", u.ID, err)
+		return nil
+	}
+	s.audit.Record(ctx, audit.Event{Type: audit.TypePasswordResetRequested, Target: u.Username, IP: ip})
+	return nil
+}
+
+// ResetPassword 使用重置令牌设置新密码，令牌只能使用一次。重置后解除账号的登录锁定并吊销所有会话
+func (s *Service) ResetPassword(ctx context.Context, token, newPassword, ip string) error {
+	if s.reset == nil {
+		return ErrPasswordResetDisabled
+	}
+
+	var record PasswordResetToken
+	err := s.db.WithContext(ctx).
+		Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hashToken(token), time.Now()).
+		First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+	var u user.User
+	if err := s.db.WithContext(ctx).First(&u, record.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidResetToken
+		}
+		return err
+	}
+
+	if err := s.setPassword(ctx, &u, newPassword, &record); err != nil {
+		return err
+	}
+	if _, err := s.lockout.Unlock(ctx, u.Username); err != nil && !errors.Is(err, ErrLockoutDisabled) {
+		log.Printf("lockout: %v", err)
+	}
+	s.audit.Record(ctx, audit.Event{Type: audit.TypePasswordReset, Target: u.Username, IP: ip})
+	return nil
+}
+
+// setPassword 检查密码策略后更新密码并吊销用户的所有会话，resetToken 不为 nil 时在同一个事务中将其标记为已使用
+func (s *Service) setPassword(ctx context.Context, u *user.User, newPassword string, resetToken *PasswordResetToken) error {
+	if err := s.policy.Validate(ctx, newPassword, u.Username, u.Email); err != nil {
+		return err
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if resetToken != nil {
+			// 并发使用同一个令牌时只有一个请求能成功
+			result := tx.Model(&PasswordResetToken{}).
+				Where("id = ? AND used_at IS NULL", resetToken.ID).
+				Update("used_at", time.Now())
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return ErrInvalidResetToken
+			}
+		}
+		if err := tx.Model(&user.User{}).Where("id = ?", u.ID).Update("password", string(hashed)).Error; err != nil {
+			return err
+		}
+		return revokeSessions(tx, u.ID)
+	})
+}
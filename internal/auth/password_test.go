@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shenjing023/rbac-api-gateway/internal/user"
+	"github.com/shenjing023/rbac-api-gateway/pkg/jwt"
+	"github.com/shenjing023/rbac-api-gateway/pkg/password"
+)
+
+const testResetURL = "https://app.example.com/reset?token="
+
+// newResetService 返回启用了找回密码的服务，重置令牌发送到返回的 outbox
+func newResetService(t *testing.T) (*Service, *outbox) {
+	t.Helper()
+	db := newTestDB(t)
+	createUser(t, db, "alice", "old-password", "")
+	s := NewService(db)
+	s.SetPasswordPolicy(&password.Policy{MinLength: 12})
+	mail := &outbox{}
+	s.SetPasswordReset(mail, PasswordResetConfig{TTL: 15 * time.Minute, URL: testResetURL + "{token}"})
+	return s, mail
+}
+
+// createSSOUser 创建一个通过单点登录或 LDAP 创建、没有本地密码的用户
+func createSSOUser(t *testing.T, s *Service, username string) *user.User {
+	t.Helper()
+	u := createUser(t, s.db, username, "unused", "")
+	if err := s.db.Model(u).Update("password", "").Error; err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+// loginClaims 使用密码登录并返回签发的 token 的 claims
+func loginClaims(t *testing.T, s *Service, username, pw string) *jwt.Claims {
+	t.Helper()
+	result, err := s.Login(context.Background(), LoginRequest{Username: username, Password: pw})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	claims, err := jwt.ValidateToken(result.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return claims
+}
+
+func TestResetPassword(t *testing.T) {
+	s, mail := newResetService(t)
+	session := loginClaims(t, s, "alice", "old-password")
+
+	if err := s.RequestPasswordReset(context.Background(), "alice", ""); err != nil {
+		t.Fatalf("RequestPasswordReset: %v", err)
+	}
+	token := mail.linkToken(t, testResetURL)
+
+	// 不符合策略的新密码不会消耗令牌
+	if err := s.ResetPassword(context.Background(), token, "short", ""); !errors.Is(err, password.ErrPolicyViolation) {
+		t.Fatalf("ResetPassword with a short password error = %v, want %v", err, password.ErrPolicyViolation)
+	}
+	if err := s.ResetPassword(context.Background(), "forged", "new-password-123", ""); !errors.Is(err, ErrInvalidResetToken) {
+		t.Errorf("ResetPassword with a forged token error = %v, want %v", err, ErrInvalidResetToken)
+	}
+	if err := s.ResetPassword(context.Background(), token, "new-password-123", ""); err != nil {
+		t.Fatalf("ResetPassword: %v", err)
+	}
+
+	// 令牌只能使用一次
+	if err := s.ResetPassword(context.Background(), token, "another-password-456", ""); !errors.Is(err, ErrInvalidResetToken) {
+		t.Errorf("reused token error = %v, want %v", err, ErrInvalidResetToken)
+	}
+	if revoked, err := s.IsRevoked(context.Background(), session); err != nil || !revoked {
+		t.Errorf("IsRevoked after reset = %v (%v), want true", revoked, err)
+	}
+	if _, err := s.Login(context.Background(), LoginRequest{Username: "alice", Password: "old-password"}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Login with the old password error = %v, want %v", err, ErrInvalidCredentials)
+	}
+	loginClaims(t, s, "alice", "new-password-123")
+}
+
+func TestResetPasswordExpired(t *testing.T) {
+	s, mail := newResetService(t)
+	if err := s.RequestPasswordReset(context.Background(), "alice", ""); err != nil {
+		t.Fatal(err)
+	}
+	token := mail.linkToken(t, testResetURL)
+	if err := s.db.Model(&PasswordResetToken{}).Where("token_hash = ?", hashToken(token)).
+		Update("expires_at", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ResetPassword(context.Background(), token, "new-password-123", ""); !errors.Is(err, ErrInvalidResetToken) {
+		t.Errorf("expired token error = %v, want %v", err, ErrInvalidResetToken)
+	}
+}
+
+func TestRequestPasswordReset(t *testing.T) {
+	s, mail := newResetService(t)
+	createSSOUser(t, s, "bob")
+
+	// 用户不存在、没有本地密码和申请过于频繁时都不返回错误，只是不发送通知
+	tests := []struct {
+		username  string
+		wantCount int
+	}{
+		{"alice", 1},
+		{"alice", 1},
+		{"mallory", 1},
+		{"bob", 1},
+	}
+	for _, tt := range tests {
+		if err := s.RequestPasswordReset(context.Background(), tt.username, ""); err != nil {
+			t.Errorf("RequestPasswordReset(%s): %v", tt.username, err)
+		}
+		if got := mail.count(); got != tt.wantCount {
+			t.Errorf("after RequestPasswordReset(%s) sent %d messages, want %d", tt.username, got, tt.wantCount)
+		}
+	}
+
+	// 每个用户只保留最新的一个令牌，重新申请后旧的令牌失效
+	first := mail.linkToken(t, testResetURL)
+	if err := s.db.Model(&PasswordResetToken{}).Where("token_hash = ?", hashToken(first)).
+		Update("created_at", time.Now().Add(-2*resetRequestInterval)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RequestPasswordReset(context.Background(), "alice", ""); err != nil || mail.count() != 2 {
+		t.Fatalf("second RequestPasswordReset = %v, sent %d messages", err, mail.count())
+	}
+	if err := s.ResetPassword(context.Background(), first, "new-password-123", ""); !errors.Is(err, ErrInvalidResetToken) {
+		t.Errorf("replaced token error = %v, want %v", err, ErrInvalidResetToken)
+	}
+	if err := s.ResetPassword(context.Background(), mail.linkToken(t, testResetURL), "new-password-123", ""); err != nil {
+		t.Errorf("ResetPassword with the latest token: %v", err)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	s, _ := newResetService(t)
+	session := loginClaims(t, s, "alice", "old-password")
+
+	tests := []struct {
+		name    string
+		current string
+		new     string
+		wantErr error
+	}{
+		{"wrong current password", "wrong", "new-password-123", ErrInvalidCredentials},
+		{"policy violation", "old-password", "short", password.ErrPolicyViolation},
+		{"changed", "old-password", "new-password-123", nil},
+	}
+	for _, tt := range tests {
+		err := s.ChangePassword(context.Background(), ChangePasswordRequest{UserID: session.UserID, CurrentPassword: tt.current, NewPassword: tt.new})
+		if !errors.Is(err, tt.wantErr) {
+			t.Fatalf("ChangePassword %s error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+	// 修改密码吊销所有会话，包括发起修改的这一个
+	if revoked, err := s.IsRevoked(context.Background(), session); err != nil || !revoked {
+		t.Errorf("IsRevoked after password change = %v (%v), want true", revoked, err)
+	}
+	loginClaims(t, s, "alice", "new-password-123")
+
+	sso := createSSOUser(t, s, "carol")
+	err := s.ChangePassword(context.Background(), ChangePasswordRequest{UserID: sso.ID, CurrentPassword: "", NewPassword: "new-password-123"})
+	if !errors.Is(err, ErrPasswordNotSet) {
+		t.Errorf("ChangePassword without a local password error = %v, want %v", err, ErrPasswordNotSet)
+	}
+}
@@ -0,0 +1,227 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/shenjing023/rbac-api-gateway/internal/audit"
+	"github.com/shenjing023/rbac-api-gateway/internal/rbac"
+	"github.com/shenjing023/rbac-api-gateway/pkg/captcha"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrRegistrationClosed    = errors.New("未开放注册")
+	ErrInviteRequired        = errors.New("注册需要邀请码")
+	ErrInvalidInvite         = errors.New("邀请码无效或已过期")
+	ErrEmailDomainNotAllowed = errors.New("不允许使用该邮箱注册")
+	ErrCaptchaFailed         = errors.New("人机验证失败")
+	// ErrCaptchaUnavailable 表示人机验证服务暂时不可用，注册会被拒绝
+	ErrCaptchaUnavailable = errors.New("人机验证服务暂时不可用")
+	ErrInvitationNotFound = errors.New("邀请不存在或已使用")
+	ErrInvalidInviteRole  = errors.New("邀请的角色不存在")
+)
+
+// RegistrationMode 决定谁可以通过 /auth/register 自助注册
+type RegistrationMode string
+
+const (
+	// RegistrationOpen 允许任何人注册
+	RegistrationOpen RegistrationMode = "open"
+	// RegistrationInvite 只允许持有邀请码的人注册，角色由邀请码决定
+	RegistrationInvite RegistrationMode = "invite"
+	// RegistrationDomain 只允许使用指定域名的邮箱注册，需要同时启用邮箱验证
+	RegistrationDomain RegistrationMode = "domain"
+	// RegistrationClosed 不允许自助注册，账号只能由管理员创建
+	RegistrationClosed RegistrationMode = "closed"
+)
+
+// RegistrationConfig 是自助注册的配置
+type RegistrationConfig struct {
+	Mode RegistrationMode
+	// AllowedDomains 是 domain 模式下允许的邮箱域名，不包含子域名
+	AllowedDomains []string
+	// InviteTTL 是创建邀请时没有指定有效期时使用的默认值
+	InviteTTL time.Duration
+	// Captcha 不为 nil 时注册前需要通过人机验证，CaptchaProvider 和 CaptchaSiteKey 返回给前端用于渲染验证组件
+	Captcha         captcha.Verifier
+	CaptchaProvider string
+	CaptchaSiteKey  string
+}
+
+// SetRegistration 设置自助注册的方式，没有设置时任何人都可以注册
+func (s *Service) SetRegistration(cfg RegistrationConfig) {
+	s.registration = &cfg
+}
+
+func (s *Service) registrationConfig() RegistrationConfig {
+	if s.registration == nil {
+		return RegistrationConfig{Mode: RegistrationOpen}
+	}
+	return *s.registration
+}
+
+// RegistrationInfo 是前端渲染注册页面需要的信息
+type RegistrationInfo struct {
+	Mode          RegistrationMode `json:"mode"`
+	EmailRequired bool             `json:"email_required"`
+	Captcha       *CaptchaInfo     `json:"captcha,omitempty"`
+}
+
+type CaptchaInfo struct {
+	Provider string `json:"provider"`
+	SiteKey  string `json:"site_key"`
+}
+
+// RegistrationInfo 返回当前的注册方式，不包含允许的邮箱域名
+func (s *Service) RegistrationInfo() RegistrationInfo {
+	cfg := s.registrationConfig()
+	info := RegistrationInfo{
+		Mode:          cfg.Mode,
+		EmailRequired: s.verification != nil || cfg.Mode == RegistrationDomain,
+	}
+	if cfg.Captcha != nil {
+		info.Captcha = &CaptchaInfo{Provider: cfg.CaptchaProvider, SiteKey: cfg.CaptchaSiteKey}
+	}
+	return info
+}
+
+// checkRegistration 按注册方式检查是否允许注册，invite 模式下返回要使用的邀请
+func (s *Service) checkRegistration(ctx context.Context, req RegisterRequest) (*Invitation, error) {
+	cfg := s.registrationConfig()
+	if cfg.Mode == RegistrationClosed {
+		return nil, ErrRegistrationClosed
+	}
+	if cfg.Mode == RegistrationInvite && req.InviteCode == "" {
+		return nil, ErrInviteRequired
+	}
+
+	// 人机验证在查询邀请码之前，避免被用来猜测邀请码
+	if cfg.Captcha != nil {
+		if err := cfg.Captcha.Verify(ctx, req.CaptchaToken, req.IP); err != nil {
+			if errors.Is(err, captcha.ErrRejected) {
+				return nil, ErrCaptchaFailed
+			}
+			log.Printf("captcha: %v", err)
+			return nil, ErrCaptchaUnavailable
+		}
+	}
+
+	switch cfg.Mode {
+	case RegistrationDomain:
+		if !emailDomainAllowed(req.Email, cfg.AllowedDomains) {
+			return nil, ErrEmailDomainNotAllowed
+		}
+	case RegistrationInvite:
+		var invitation Invitation
+		err := s.db.WithContext(ctx).
+			Where("code_hash = ? AND used_at IS NULL AND expires_at > ?", hashToken(req.InviteCode), time.Now()).
+			First(&invitation).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidInvite
+		}
+		if err != nil {
+			return nil, err
+		}
+		if invitation.Email != "" && !strings.EqualFold(invitation.Email, req.Email) {
+			return nil, ErrInvalidInvite
+		}
+		return &invitation, nil
+	}
+	return nil, nil
+}
+
+// emailDomainAllowed 判断邮箱的域名是否在 domains 中，不区分大小写
+func emailDomainAllowed(email string, domains []string) bool {
+	i := strings.LastIndexByte(email, '@')
+	if i < 0 {
+		return false
+	}
+	domain := email[i+1:]
+	for _, allowed := range domains {
+		if strings.EqualFold(domain, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateInvitationRequest 是管理员创建邀请
+type CreateInvitationRequest struct {
+	// Role 是使用邀请码注册的用户获得的角色，必须已经存在
+	Role string
+	// Email 不为空时只能使用这个邮箱注册
+	Email string
+	Note  string
+	// TTL 为 0 时使用配置的默认有效期
+	TTL   time.Duration
+	Actor audit.Event
+}
+
+// CreateInvitation 创建一次性的邀请码，返回的明文邀请码只有这一次可以看到
+func (s *Service) CreateInvitation(ctx context.Context, req CreateInvitationRequest) (*Invitation, string, error) {
+	if err := s.db.WithContext(ctx).Where("name = ?", req.Role).First(&rbac.Role{}).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, "", ErrInvalidInviteRole
+		}
+		return nil, "", err
+	}
+
+	ttl := req.TTL
+	if ttl <= 0 {
+		ttl = s.registrationConfig().InviteTTL
+	}
+	code, err := randomToken(24)
+	if err != nil {
+		return nil, "", err
+	}
+	invitation := Invitation{
+		CodeHash:  hashToken(code),
+		Role:      req.Role,
+		Email:     strings.TrimSpace(req.Email),
+		Note:      req.Note,
+		CreatedBy: req.Actor.Actor,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := s.db.WithContext(ctx).Create(&invitation).Error; err != nil {
+		return nil, "", err
+	}
+
+	event := req.Actor
+	event.Type = audit.TypeInvitationCreated
+	event.Target = invitation.Email
+	event.Detail = fmt.Sprintf("invitation %d role %s", invitation.ID, invitation.Role)
+	s.audit.Record(ctx, event)
+	return &invitation, code, nil
+}
+
+// ListInvitations 按创建时间倒序返回所有邀请，包括已经使用和过期的
+func (s *Service) ListInvitations(ctx context.Context) ([]Invitation, error) {
+	var invitations []Invitation
+	err := s.db.WithContext(ctx).Order("id DESC").Find(&invitations).Error
+	return invitations, err
+}
+
+// RevokeInvitation 删除还没有使用的邀请，actor 是执行操作的管理员
+func (s *Service) RevokeInvitation(ctx context.Context, id uint, actor audit.Event) error {
+	var invitation Invitation
+	if err := s.db.WithContext(ctx).Where("id = ? AND used_at IS NULL", id).First(&invitation).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvitationNotFound
+		}
+		return err
+	}
+	if err := s.db.WithContext(ctx).Delete(&invitation).Error; err != nil {
+		return err
+	}
+
+	actor.Type = audit.TypeInvitationRevoked
+	actor.Target = invitation.Email
+	actor.Detail = fmt.Sprintf("invitation %d role %s", invitation.ID, invitation.Role)
+	s.audit.Record(ctx, actor)
+	return nil
+}
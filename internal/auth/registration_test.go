@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/shenjing023/rbac-api-gateway/internal/user"
+	"github.com/shenjing023/rbac-api-gateway/pkg/captcha"
+	"gorm.io/gorm"
+)
+
+// fakeCaptcha 只接受 token 为 "pass" 的验证，unavailable 为 true 时模拟校验服务不可用
+type fakeCaptcha struct {
+	unavailable bool
+	calls       int
+}
+
+func (f *fakeCaptcha) Verify(ctx context.Context, token, remoteIP string) error {
+	f.calls++
+	if f.unavailable {
+		return errors.New("connection refused")
+	}
+	if token != "pass" {
+		return fmt.Errorf("%w: invalid-input-response", captcha.ErrRejected)
+	}
+	return nil
+}
+
+// userExists 判断用户名是否已经注册
+func userExists(t *testing.T, db *gorm.DB, username string) bool {
+	t.Helper()
+	var count int64
+	if err := db.Model(&user.User{}).Where("username = ?", username).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	return count > 0
+}
+
+func TestRegistrationModes(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     *RegistrationConfig
+		req     RegisterRequest
+		wantErr error
+	}{
+		{"default is open", nil, RegisterRequest{}, nil},
+		{"open", &RegistrationConfig{Mode: RegistrationOpen}, RegisterRequest{}, nil},
+		{"closed", &RegistrationConfig{Mode: RegistrationClosed}, RegisterRequest{}, ErrRegistrationClosed},
+		{"closed with invite code", &RegistrationConfig{Mode: RegistrationClosed}, RegisterRequest{InviteCode: "code"}, ErrRegistrationClosed},
+		{"invite without code", &RegistrationConfig{Mode: RegistrationInvite}, RegisterRequest{}, ErrInviteRequired},
+		{"invite with unknown code", &RegistrationConfig{Mode: RegistrationInvite}, RegisterRequest{InviteCode: "forged"}, ErrInvalidInvite},
+		{"domain allowed", &RegistrationConfig{Mode: RegistrationDomain, AllowedDomains: []string{"example.com"}}, RegisterRequest{Email: "alice@Example.com"}, nil},
+		{"domain without email", &RegistrationConfig{Mode: RegistrationDomain, AllowedDomains: []string{"example.com"}}, RegisterRequest{}, ErrEmailRequired},
+		{"domain not allowed", &RegistrationConfig{Mode: RegistrationDomain, AllowedDomains: []string{"example.com"}}, RegisterRequest{Email: "alice@evil.com"}, ErrEmailDomainNotAllowed},
+		// 子域名需要单独允许
+		{"subdomain not allowed", &RegistrationConfig{Mode: RegistrationDomain, AllowedDomains: []string{"example.com"}}, RegisterRequest{Email: "alice@mail.example.com"}, ErrEmailDomainNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			s := NewService(db)
+			if tt.cfg != nil {
+				s.SetRegistration(*tt.cfg)
+			}
+			req := tt.req
+			req.Username, req.Password = "alice", "password"
+			if err := s.Register(context.Background(), req); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Register error = %v, want %v", err, tt.wantErr)
+			}
+			if got := userExists(t, db, "alice"); got != (tt.wantErr == nil) {
+				t.Errorf("user created = %v, want %v", got, tt.wantErr == nil)
+			}
+		})
+	}
+}
+
+// newInviteService 返回 invite 模式的服务和一个 moderator 角色的邀请码
+func newInviteService(t *testing.T, email string) (*Service, *Invitation, string) {
+	t.Helper()
+	db := newTestDB(t)
+	createRoles(t, db, "moderator")
+	s := NewService(db)
+	s.SetRegistration(RegistrationConfig{Mode: RegistrationInvite, InviteTTL: time.Hour})
+	invitation, code, err := s.CreateInvitation(context.Background(), CreateInvitationRequest{Role: "moderator", Email: email})
+	if err != nil {
+		t.Fatalf("CreateInvitation: %v", err)
+	}
+	return s, invitation, code
+}
+
+func TestRegisterWithInvitation(t *testing.T) {
+	s, invitation, code := newInviteService(t, "")
+	if err := s.Register(context.Background(), RegisterRequest{Username: "alice", Password: "password", InviteCode: code}); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	var u user.User
+	if err := s.db.Where("username = ?", "alice").First(&u).Error; err != nil {
+		t.Fatal(err)
+	}
+	if role, err := s.userRole(u.ID); err != nil || role != "moderator" {
+		t.Errorf("registered role = %q (%v), want moderator", role, err)
+	}
+	if err := s.db.First(invitation, invitation.ID).Error; err != nil || invitation.UsedAt == nil || invitation.UsedBy != u.ID {
+		t.Errorf("invitation after use = %+v (%v), want used by %d", invitation, err, u.ID)
+	}
+
+	// 邀请码只能使用一次
+	if err := s.Register(context.Background(), RegisterRequest{Username: "bob", Password: "password", InviteCode: code}); !errors.Is(err, ErrInvalidInvite) {
+		t.Errorf("reused invitation error = %v, want %v", err, ErrInvalidInvite)
+	}
+	if userExists(t, s.db, "bob") {
+		t.Error("user registered with a used invitation")
+	}
+}
+
+func TestRegisterInvitationRestrictions(t *testing.T) {
+	tests := []struct {
+		name    string
+		email   string // 邀请限定的邮箱
+		expired bool
+		req     RegisterRequest
+		wantErr error
+	}{
+		{"bound email", "alice@example.com", false, RegisterRequest{Email: "Alice@example.com"}, nil},
+		{"other email", "alice@example.com", false, RegisterRequest{Email: "mallory@example.com"}, ErrInvalidInvite},
+		{"missing email", "alice@example.com", false, RegisterRequest{}, ErrInvalidInvite},
+		{"expired", "", true, RegisterRequest{}, ErrInvalidInvite},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, invitation, code := newInviteService(t, tt.email)
+			if tt.expired {
+				if err := s.db.Model(invitation).Update("expires_at", time.Now().Add(-time.Second)).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+			req := tt.req
+			req.Username, req.Password, req.InviteCode = "alice", "password", code
+			if err := s.Register(context.Background(), req); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Register error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// 检查通过之后、事务提交之前邀请码被另一个请求用掉时，注册失败并回滚已经创建的用户
+func TestRegisterInvitationUsedConcurrently(t *testing.T) {
+	s, invitation, code := newInviteService(t, "")
+	err := s.db.Callback().Create().After("gorm:create").Register("test:use_invitation", func(tx *gorm.DB) {
+		if tx.Statement.Table != "users" {
+			return
+		}
+		tx.Session(&gorm.Session{NewDB: true}).Model(&Invitation{}).Where("id = ?", invitation.ID).Update("used_at", time.Now())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Register(context.Background(), RegisterRequest{Username: "alice", Password: "password", InviteCode: code}); !errors.Is(err, ErrInvalidInvite) {
+		t.Fatalf("Register error = %v, want %v", err, ErrInvalidInvite)
+	}
+	if userExists(t, s.db, "alice") {
+		t.Error("user was created although the invitation was already used")
+	}
+}
+
+func TestRegisterCaptcha(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        RegistrationMode
+		token       string
+		unavailable bool
+		wantErr     error
+	}{
+		{"passed", RegistrationOpen, "pass", false, nil},
+		{"rejected", RegistrationOpen, "bot", false, ErrCaptchaFailed},
+		{"missing token", RegistrationOpen, "", false, ErrCaptchaFailed},
+		{"service unavailable", RegistrationOpen, "pass", true, ErrCaptchaUnavailable},
+		// 人机验证在查询邀请码之前，未通过验证时不会暴露邀请码是否有效
+		{"before invite lookup", RegistrationInvite, "bot", false, ErrCaptchaFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			s := NewService(db)
+			verifier := &fakeCaptcha{unavailable: tt.unavailable}
+			s.SetRegistration(RegistrationConfig{Mode: tt.mode, Captcha: verifier, CaptchaProvider: "turnstile", CaptchaSiteKey: "site-key"})
+
+			req := RegisterRequest{Username: "alice", Password: "password", CaptchaToken: tt.token, InviteCode: "forged"}
+			if err := s.Register(context.Background(), req); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Register error = %v, want %v", err, tt.wantErr)
+			}
+			if verifier.calls != 1 {
+				t.Errorf("captcha verified %d times, want 1", verifier.calls)
+			}
+			if got := userExists(t, db, "alice"); got != (tt.wantErr == nil) {
+				t.Errorf("user created = %v, want %v", got, tt.wantErr == nil)
+			}
+			if info := s.RegistrationInfo(); info.Captcha == nil || info.Captcha.SiteKey != "site-key" {
+				t.Errorf("RegistrationInfo captcha = %+v", info.Captcha)
+			}
+		})
+	}
+}
@@ -1,72 +1,446 @@
 package auth
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
 
+	"github.com/shenjing023/rbac-api-gateway/internal/audit"
 	"github.com/shenjing023/rbac-api-gateway/internal/user"
 	"github.com/shenjing023/rbac-api-gateway/pkg/jwt"
+	"github.com/shenjing023/rbac-api-gateway/pkg/password"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// ErrInvalidCredentials 是所有密码登录失败的统一错误，不区分用户不存在和密码错误
+var ErrInvalidCredentials = errors.New("用户名或密码错误")
+
 type Service struct {
-	db *gorm.DB
+	db        *gorm.DB
+	directory *Directory
+	lockout   *Lockout
+	audit     *audit.Service
+	policy    *password.Policy
+	reset     *passwordReset
+	// verification 不为 nil 时注册的账号需要验证邮箱后才能登录
+	verification *emailVerification
+	// impersonation 不为 nil 时管理员可以模拟其他用户
+	impersonation *ImpersonationConfig
+	// cookie 不为 nil 时浏览器客户端可以把登录 token 保存在会话 cookie 中
+	cookie *SessionCookieConfig
+	// registration 为 nil 时任何人都可以注册
+	registration *RegistrationConfig
 }
 
 func NewService(db *gorm.DB) *Service {
 	return &Service{db: db}
 }
 
-func (s *Service) Register(username, password string) error {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+// SetLockout 启用登录失败次数限制
+func (s *Service) SetLockout(lockout *Lockout) {
+	s.lockout = lockout
+}
+
+// SetAudit 记录登录、锁定等审计事件
+func (s *Service) SetAudit(audit *audit.Service) {
+	s.audit = audit
+}
+
+// SetPasswordPolicy 设置注册、修改和重置密码时使用的密码策略
+func (s *Service) SetPasswordPolicy(policy *password.Policy) {
+	s.policy = policy
+}
+
+// SetDirectory 让 Login 在本地没有密码的用户上使用 LDAP 认证
+func (s *Service) SetDirectory(directory *Directory) {
+	s.directory = directory
+}
+
+// RegisterRequest 是一次自助注册
+type RegisterRequest struct {
+	Username string
+	Email    string
+	Password string
+	// InviteCode 是 invite 模式下使用的邀请码
+	InviteCode string
+	// CaptchaToken 是客户端完成人机验证得到的 token，配置了人机验证时必须提供
+	CaptchaToken string
+	IP           string
+}
+
+// Register 按注册方式（见 RegistrationConfig）注册用户，密码需要符合密码策略。
+// 启用了邮箱验证时 email 不能为空，账号在验证邮箱之前处于 pending 状态，否则 email 可以为空，账号直接激活。
+// 使用邀请码注册的用户获得邀请指定的角色
+func (s *Service) Register(ctx context.Context, req RegisterRequest) error {
+	if (s.verification != nil || s.registrationConfig().Mode == RegistrationDomain) && req.Email == "" {
+		return ErrEmailRequired
+	}
+	invitation, err := s.checkRegistration(ctx, req)
+	if err != nil {
+		return err
+	}
+	if err := s.policy.Validate(ctx, req.Password, req.Username, req.Email); err != nil {
+		return err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
 		return err
 	}
 
 	newUser := user.User{
-		Username: username,
+		Username: req.Username,
 		Password: string(hashedPassword),
 		Role:     string(user.RoleUser),
+		Email:    req.Email,
+		Status:   string(user.StatusActive),
 	}
+	if s.verification != nil {
+		newUser.Status = string(user.StatusPending)
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&newUser).Error; err != nil {
+			return err
+		}
+		if invitation == nil {
+			return nil
+		}
+		// 并发使用同一个邀请码时只有一个请求能成功
+		result := tx.Model(&Invitation{}).
+			Where("id = ? AND used_at IS NULL", invitation.ID).
+			Updates(map[string]interface{}{"used_at": time.Now(), "used_by": newUser.ID})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidInvite
+		}
+		return syncUserRole(tx, newUser.ID, invitation.Role)
+	})
+	if err != nil {
+		return err
+	}
+
+	event := audit.Event{Type: audit.TypeAccountRegistered, ActorID: newUser.ID, Actor: newUser.Username, Target: newUser.Username, IP: req.IP}
+	if invitation != nil {
+		event.Detail = fmt.Sprintf("invitation %d role %s", invitation.ID, invitation.Role)
+	}
+	s.audit.Record(ctx, event)
+
+	if s.verification != nil {
+		// 发送失败时账号已经创建，用户可以通过重新发送接口再次获取验证链接
+		if err := s.sendVerification(ctx, &newUser); err != nil {
+			log.Printf("email verification: failed to notify user %d: %v", newUser.ID, err)
+		}
+	}
+	return nil
+}
 
-	result := s.db.Create(&newUser)
-	return result.Error
+// LoginResult 是登录的结果。启用了多因素认证的用户只拿到 MFAToken，
+// 需要通过 /auth/mfa/verify 提交验证码换取 Token
+type LoginResult struct {
+	Token       string `json:"token,omitempty"`
+	MFARequired bool   `json:"mfa_required,omitempty"`
+	MFAToken    string `json:"mfa_token,omitempty"`
+	// CSRFToken 在 token 写入会话 cookie 时返回，此时响应中没有 token
+	CSRFToken string `json:"csrf_token,omitempty"`
 }
 
-func (s *Service) Login(username, password string) (string, error) {
+// LoginRequest 是一次密码登录，Client.IP 用于按来源限制失败次数，Client 同时记录在会话中
+type LoginRequest struct {
+	Username string
+	Password string
+	Client   ClientInfo
+}
+
+// Login 校验用户名和密码。用户不存在、密码错误等情况返回相同的错误，
+// 失败次数过多时返回 *ThrottledError
+func (s *Service) Login(ctx context.Context, req LoginRequest) (*LoginResult, error) {
+	event := audit.Event{Target: req.Username, IP: req.Client.IP}
+
+	wait, err := s.lockout.Check(ctx, req.Username, req.Client.IP)
+	if err != nil {
+		return nil, err
+	}
+	if wait > 0 {
+		event.Type = audit.TypeLoginThrottled
+		s.audit.Record(ctx, event)
+		return nil, &ThrottledError{RetryAfter: wait}
+	}
+
+	u, role, err := s.authenticate(ctx, req.Username, req.Password)
+	if err != nil {
+		if errors.Is(err, ErrInvalidCredentials) {
+			locked, lockErr := s.lockout.Failure(ctx, req.Username, req.Client.IP)
+			if lockErr != nil {
+				log.Printf("lockout: %v", lockErr)
+			}
+			event.Type = audit.TypeLoginFailed
+			s.audit.Record(ctx, event)
+			if locked {
+				event.Type = audit.TypeAccountLocked
+				s.audit.Record(ctx, event)
+			}
+		}
+		return nil, err
+	}
+
+	event.Type = audit.TypeLoginSucceeded
+	event.ActorID, event.Actor = u.ID, u.Username
+	s.audit.Record(ctx, event)
+	result, err := s.completeLogin(ctx, u, role, req.Client)
+	if err != nil {
+		return nil, err
+	}
+	// 需要多因素认证时，失败计数在第二因素通过后才清除，否则知道密码就可以不断重置验证码的尝试次数
+	if !result.MFARequired {
+		if err := s.lockout.Success(ctx, req.Username); err != nil {
+			log.Printf("lockout: %v", err)
+		}
+	}
+	return result, nil
+}
+
+// authenticate 校验密码并返回用户和角色
+func (s *Service) authenticate(ctx context.Context, username, password string) (*user.User, string, error) {
 	var u user.User
-	if err := s.db.Where("username = ?", username).First(&u).Error; err != nil {
-		return "", errors.New("用户不存在")
+	err := s.db.WithContext(ctx).Where("username = ?", username).First(&u).Error
+	notFound := errors.Is(err, gorm.ErrRecordNotFound)
+	if err != nil && !notFound {
+		return nil, "", err
+	}
+	// 本地不存在的用户和没有本地密码的 LDAP 用户由目录服务认证
+	if s.directory != nil && (notFound || u.Password == "" && u.Kind == string(user.KindUser)) {
+		return s.directory.Login(ctx, username, password)
+	}
+
+	// 用户不存在、服务账号和只能单点登录的用户没有可用的密码，
+	// 仍然做一次同样耗时的 bcrypt 比较，防止通过响应时间判断用户名是否存在
+	if notFound || u.Kind == string(user.KindService) || u.Password == "" {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return nil, "", ErrInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password)); err != nil {
+		return nil, "", ErrInvalidCredentials
+	}
+	// 只在密码正确时返回账号状态，避免泄露账号是否存在
+	if err := accountStatusError(u.Status); err != nil {
+		return nil, "", err
+	}
+
+	role, err := s.userRole(u.ID)
+	if err != nil {
+		return nil, "", err
 	}
-	// get user role
+	return &u, string(role), nil
+}
+
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// dummyPasswordHash 返回与真实密码相同 cost 的 bcrypt 哈希，用于不存在的用户
+func dummyPasswordHash() []byte {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("rbac-api-gateway"), bcrypt.DefaultCost)
+	})
+	return dummyHash
+}
+
+// LockoutStatus 返回用户的登录失败记录，没有失败记录时返回 nil
+func (s *Service) LockoutStatus(ctx context.Context, userID uint) (*LoginThrottle, error) {
+	if s.lockout == nil {
+		return nil, ErrLockoutDisabled
+	}
+	var u user.User
+	if err := s.db.WithContext(ctx).First(&u, userID).Error; err != nil {
+		return nil, err
+	}
+	return s.lockout.Status(ctx, u.Username)
+}
+
+// Unlock 清除用户的登录失败记录并记录审计事件，actor 是执行操作的管理员
+func (s *Service) Unlock(ctx context.Context, userID uint, actor audit.Event) error {
+	var u user.User
+	if err := s.db.WithContext(ctx).First(&u, userID).Error; err != nil {
+		return err
+	}
+	if _, err := s.lockout.Unlock(ctx, u.Username); err != nil {
+		return err
+	}
+	actor.Type = audit.TypeAccountUnlocked
+	actor.Target = u.Username
+	s.audit.Record(ctx, actor)
+	return nil
+}
+
+// completeLogin 在密码校验通过后签发 token 并记录会话，启用了多因素认证的用户只拿到等待第二因素的 token，
+// 会话在完成多因素认证后才记录
+func (s *Service) completeLogin(ctx context.Context, u *user.User, role string, client ClientInfo) (*LoginResult, error) {
+	claims := jwt.Claims{UserID: u.ID, Username: u.Username, Role: role, AMR: []string{AMRPassword}}
+
+	enabled, err := s.mfaEnabled(ctx, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		claims.MFAPending = true
+		token, err := jwt.IssueToken(claims, mfaPendingTTL)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{MFARequired: true, MFAToken: token}, nil
+	}
+
+	token, err := issueSession(ctx, s.db, claims, jwt.Expiration(), client)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{Token: token}, nil
+}
+
+// mfaEnabled 判断用户是否已确认绑定 TOTP 验证器
+func (s *Service) mfaEnabled(ctx context.Context, userID uint) (bool, error) {
+	var count int64
+	err := s.db.WithContext(ctx).Model(&TOTPFactor{}).
+		Where("user_id = ? AND confirmed_at IS NOT NULL", userID).Count(&count).Error
+	return count > 0, err
+}
+
+// ResolveServiceAccount 根据客户端证书中的身份查找服务账号，角色与普通用户一样来自 rbac.UserRole
+func (s *Service) ResolveServiceAccount(ctx context.Context, identity string) (*jwt.Claims, error) {
+	var u user.User
+	if err := s.db.WithContext(ctx).Where("username = ? AND kind = ?", identity, user.KindService).First(&u).Error; err != nil {
+		return nil, errors.New("服务账号不存在")
+	}
+	return s.identity(&u)
+}
+
+// Identity 返回用户当前的身份和角色，供 API key 等不经过登录的凭据使用
+func (s *Service) Identity(ctx context.Context, userID uint) (*jwt.Claims, error) {
+	var u user.User
+	if err := s.db.WithContext(ctx).First(&u, userID).Error; err != nil {
+		return nil, errors.New("用户不存在")
+	}
+	return s.identity(&u)
+}
+
+func (s *Service) identity(u *user.User) (*jwt.Claims, error) {
+	role, err := s.userRole(u.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &jwt.Claims{UserID: u.ID, Username: u.Username, Role: string(role)}, nil
+}
+
+// userRole 返回用户在 rbac.UserRole 中的角色，没有分配角色时为普通用户
+func (s *Service) userRole(userID uint) (user.Role, error) {
 	var userRole user.Role
 	if err := s.db.Table("user_roles").
 		Select("roles.name").
 		Joins("JOIN roles ON user_roles.role_id = roles.id").
-		Where("user_roles.user_id = ?", u.ID).
+		Where("user_roles.user_id = ?", userID).
 		Scan(&userRole).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			userRole = user.RoleUser // 如果没有找到角色，默认为普通用户
-		} else {
-			return "", errors.New("获取用户角色失败")
+			return user.RoleUser, nil // 如果没有找到角色，默认为普通用户
 		}
+		return "", errors.New("获取用户角色失败")
 	}
-	u.Role = string(userRole)
+	return userRole, nil
+}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password)); err != nil {
-		return "", errors.New("密码错误")
+// Logout 吊销当前的 token，吊销后在过期前也不能再使用。模拟用户的 token 登出即结束模拟
+func (s *Service) Logout(ctx context.Context, claims *jwt.Claims, ip string) error {
+	if err := s.RevokeToken(ctx, claims); err != nil {
+		return err
 	}
+	if claims.Act != nil {
+		s.audit.Record(ctx, audit.Event{
+			Type:       audit.TypeImpersonationEnded,
+			ActorID:    claims.Act.UserID,
+			Actor:      claims.Act.Username,
+			OnBehalfOf: claims.Username,
+			Target:     claims.Username,
+			IP:         ip,
+		})
+	}
+	return nil
+}
 
-	token, err := jwt.GenerateToken(u.ID, u.Username, u.Role)
-	if err != nil {
-		return "", err
+// RevokeToken 把 token 的 jti 加入吊销列表，没有 jti 的旧 token 无法单独吊销
+func (s *Service) RevokeToken(ctx context.Context, claims *jwt.Claims) error {
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return errors.New("token 不支持吊销")
+	}
+	revoked := RevokedToken{JTI: claims.ID, ExpiresAt: claims.ExpiresAt.Time}
+	if err := s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&revoked).Error; err != nil {
+		return err
+	}
+	return s.db.WithContext(ctx).Model(&Session{}).
+		Where("jti = ? AND revoked_at IS NULL", claims.ID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// IsRevoked 判断 token 是否已被单独吊销，或者签发后用户的所有会话被吊销
+func (s *Service) IsRevoked(ctx context.Context, claims *jwt.Claims) (bool, error) {
+	if claims.ID != "" {
+		var count int64
+		if err := s.db.WithContext(ctx).Model(&RevokedToken{}).Where("jti = ?", claims.ID).Count(&count).Error; err != nil {
+			return false, err
+		}
+		if count > 0 {
+			return true, nil
+		}
+	}
+	if claims.UserID == 0 || claims.IssuedAt == nil {
+		return false, nil
+	}
+
+	// 模拟用户的 token 在管理员本人的会话被吊销时也失效
+	userIDs := []uint{claims.UserID}
+	if claims.Act != nil {
+		userIDs = append(userIDs, claims.Act.UserID)
+	}
+	var revocations []SessionRevocation
+	if err := s.db.WithContext(ctx).Where("user_id IN ?", userIDs).Find(&revocations).Error; err != nil {
+		return false, err
+	}
+	for _, revocation := range revocations {
+		// iat 只精确到秒，与吊销时间在同一秒内签发的 token 也视为已吊销
+		if !claims.IssuedAt.Time.After(revocation.RevokedBefore.Truncate(time.Second)) {
+			return true, nil
+		}
 	}
+	return false, nil
+}
 
-	return token, nil
+// RevokeSessions 吊销用户当前所有的会话（包括没有会话记录的 JWT）和 OAuth2 refresh token，
+// API key 和个人访问令牌不受影响，需要单独吊销
+func (s *Service) RevokeSessions(ctx context.Context, userID uint) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return revokeSessions(tx, userID)
+	})
 }
 
-func (s *Service) Logout(token string) error {
-	// 在实际应用中，你可能需要将token加入黑名单或实现其他登出逻辑
-	// 这里我们简单地返回nil，因为JWT本身是无状态的
-	return nil
+func revokeSessions(tx *gorm.DB, userID uint) error {
+	now := time.Now()
+	err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"revoked_before": now, "updated_at": now}),
+	}).Create(&SessionRevocation{UserID: userID, RevokedBefore: now}).Error
+	if err != nil {
+		return err
+	}
+	if err := tx.Model(&Session{}).Where("user_id = ? AND revoked_at IS NULL", userID).Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+	return tx.Model(&RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", now).Error
 }
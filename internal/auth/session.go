@@ -0,0 +1,256 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/shenjing023/rbac-api-gateway/internal/audit"
+	"github.com/shenjing023/rbac-api-gateway/pkg/cache"
+	"github.com/shenjing023/rbac-api-gateway/pkg/jwt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrSessionNotFound 表示会话不存在、不属于该用户或者已经失效
+var ErrSessionNotFound = errors.New("会话不存在")
+
+// sessionTouchInterval 是更新会话最后活动时间的最小间隔，避免每个请求都写数据库
+const sessionTouchInterval = time.Minute
+
+// ClientInfo 是发起登录的客户端，Device 是客户端自己提供的设备名称，可以为空
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+	Device    string
+	// Cookie 表示浏览器客户端把 token 保存在会话 cookie 中，签发的 token 会带有 CSRF token
+	Cookie bool
+}
+
+// SessionView 是返回给用户的会话，Current 表示发起请求使用的就是这个会话
+type SessionView struct {
+	Session
+	Current bool `json:"current"`
+}
+
+// issueSession 签发有效期为 ttl 的登录 token 并记录会话
+func issueSession(ctx context.Context, db *gorm.DB, claims jwt.Claims, ttl time.Duration, client ClientInfo) (string, error) {
+	jti, err := jwt.NewJTI()
+	if err != nil {
+		return "", err
+	}
+	claims.ID = jti
+	if client.Cookie {
+		if claims.CSRF, err = randomToken(32); err != nil {
+			return "", err
+		}
+	}
+	now := time.Now()
+	token, err := jwt.IssueToken(claims, ttl)
+	if err != nil {
+		return "", err
+	}
+
+	device := truncate(strings.TrimSpace(client.Device), 100)
+	if device == "" {
+		device = describeDevice(client.UserAgent)
+	}
+	session := Session{
+		UserID:     claims.UserID,
+		JTI:        jti,
+		Device:     device,
+		IP:         client.IP,
+		UserAgent:  truncate(client.UserAgent, 512),
+		AMR:        claims.AMR,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(ttl),
+	}
+	if claims.Act != nil {
+		session.Actor = claims.Act.Username
+	}
+	if err := db.WithContext(ctx).Create(&session).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// TouchSession 记录会话的最后活动时间。同一个会话在 sessionTouchInterval 内只写一次数据库，
+// 并且在后台完成，不增加请求的延迟
+func (s *Service) TouchSession(jti string) {
+	if jti == "" {
+		return
+	}
+	key := "auth:session_seen:" + jti
+	c := cache.GetInstance()
+	if _, found := c.Get(key); found {
+		return
+	}
+	now := time.Now()
+	c.Set(key, true, sessionTouchInterval)
+
+	go func() {
+		err := s.db.Model(&Session{}).
+			Where("jti = ? AND last_seen_at < ?", jti, now.Add(-sessionTouchInterval)).
+			Update("last_seen_at", now).Error
+		if err != nil {
+			log.Printf("update session last seen failed: %v", err)
+		}
+	}()
+}
+
+// ListSessions 返回用户当前有效的会话，currentJTI 对应的会话标记为当前会话
+func (s *Service) ListSessions(ctx context.Context, userID uint, currentJTI string) ([]SessionView, error) {
+	var sessions []Session
+	err := s.db.WithContext(ctx).
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	views := make([]SessionView, 0, len(sessions))
+	for _, session := range sessions {
+		views = append(views, SessionView{Session: session, Current: currentJTI != "" && session.JTI == currentJTI})
+	}
+	return views, nil
+}
+
+// RevokeSession 吊销用户的一个会话，actor 是执行操作的用户或管理员
+func (s *Service) RevokeSession(ctx context.Context, userID, sessionID uint, actor audit.Event) error {
+	var session Session
+	err := s.db.WithContext(ctx).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL AND expires_at > ?", sessionID, userID, time.Now()).
+		First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return revokeSession(tx, &session)
+	})
+	if err != nil {
+		return err
+	}
+	s.recordSessionRevoked(ctx, userID, actor, "session "+session.Device)
+	return nil
+}
+
+// RevokeOtherSessions 吊销用户除 keepJTI 以外的所有会话，keepJTI 为空时吊销全部会话
+func (s *Service) RevokeOtherSessions(ctx context.Context, userID uint, keepJTI string, actor audit.Event) (int, error) {
+	var sessions []Session
+	err := s.db.WithContext(ctx).
+		Where("user_id = ? AND jti <> ? AND revoked_at IS NULL AND expires_at > ?", userID, keepJTI, time.Now()).
+		Find(&sessions).Error
+	if err != nil {
+		return 0, err
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for i := range sessions {
+			if err := revokeSession(tx, &sessions[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(sessions) > 0 {
+		s.recordSessionRevoked(ctx, userID, actor, "other sessions")
+	}
+	return len(sessions), nil
+}
+
+// revokeSession 把会话的 jti 加入吊销列表，认证时通过 IsRevoked 拒绝
+func revokeSession(tx *gorm.DB, session *Session) error {
+	revoked := RevokedToken{JTI: session.JTI, ExpiresAt: session.ExpiresAt}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&revoked).Error; err != nil {
+		return err
+	}
+	return tx.Model(&Session{}).Where("id = ?", session.ID).Update("revoked_at", time.Now()).Error
+}
+
+func (s *Service) recordSessionRevoked(ctx context.Context, userID uint, actor audit.Event, detail string) {
+	var username string
+	s.db.WithContext(ctx).Table("users").Select("username").Where("id = ?", userID).Scan(&username)
+	actor.Type = audit.TypeSessionRevoked
+	actor.Target = username
+	actor.Detail = detail
+	s.audit.Record(ctx, actor)
+}
+
+// RunCleanup 定期删除已经过期的会话和吊销记录，直到 ctx 取消
+func (s *Service) RunCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		for _, model := range []interface{}{&Session{}, &RevokedToken{}} {
+			if err := s.db.WithContext(ctx).Unscoped().Where("expires_at < ?", now).Delete(model).Error; err != nil {
+				log.Printf("auth: failed to purge expired records: %v", err)
+			}
+		}
+	}
+}
+
+// describeDevice 从 User-Agent 中粗略识别浏览器和操作系统，例如 "Chrome on macOS"
+func describeDevice(userAgent string) string {
+	browsers := []struct{ token, name string }{
+		// 顺序很重要：Edge 和 Opera 的 User-Agent 中也包含 Chrome，Chrome 的包含 Safari
+		{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"Firefox/", "Firefox"}, {"Chrome/", "Chrome"},
+		{"Safari/", "Safari"}, {"curl/", "curl"}, {"Go-http-client", "Go"}, {"okhttp", "OkHttp"},
+	}
+	systems := []struct{ token, name string }{
+		{"Android", "Android"}, {"iPhone", "iOS"}, {"iPad", "iPadOS"}, {"Mac OS X", "macOS"},
+		{"Windows", "Windows"}, {"CrOS", "ChromeOS"}, {"Linux", "Linux"},
+	}
+
+	browser, system := "", ""
+	for _, b := range browsers {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	for _, o := range systems {
+		if strings.Contains(userAgent, o.token) {
+			system = o.name
+			break
+		}
+	}
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	}
+	return "Unknown"
+}
+
+// truncate 按字节截断字符串，不会截断多字节字符
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	s = s[:n]
+	for len(s) > 0 && !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shenjing023/rbac-api-gateway/internal/audit"
+	"github.com/shenjing023/rbac-api-gateway/pkg/cache"
+)
+
+// lastSeen 返回会话的最后活动时间
+func lastSeen(t *testing.T, s *Service, jti string) time.Time {
+	t.Helper()
+	var session Session
+	if err := s.db.Where("jti = ?", jti).First(&session).Error; err != nil {
+		t.Fatal(err)
+	}
+	return session.LastSeenAt
+}
+
+func setLastSeen(t *testing.T, s *Service, jti string, at time.Time) {
+	t.Helper()
+	if err := s.db.Model(&Session{}).Where("jti = ?", jti).Update("last_seen_at", at).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTouchSession(t *testing.T) {
+	db := newTestDB(t)
+	createUser(t, db, "alice", "password", "")
+	s := NewService(db)
+	jti := loginClaims(t, s, "alice", "password").ID
+	t.Cleanup(func() { cache.GetInstance().Delete("auth:session_seen:" + jti) })
+
+	stale := time.Now().Add(-2 * sessionTouchInterval)
+	setLastSeen(t, s, jti, stale)
+	s.TouchSession(jti)
+	deadline := time.Now().Add(2 * time.Second)
+	for !lastSeen(t, s, jti).After(stale) {
+		if time.Now().After(deadline) {
+			t.Fatal("TouchSession did not update last_seen_at")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 间隔内再次访问不写数据库
+	setLastSeen(t, s, jti, stale)
+	s.TouchSession(jti)
+	time.Sleep(100 * time.Millisecond)
+	if got := lastSeen(t, s, jti); !got.Equal(stale) {
+		t.Errorf("last_seen_at = %v after a throttled touch, want %v", got, stale)
+	}
+
+	// 缓存失效（例如多个实例）时，最近更新过的会话同样不会重复写入
+	cache.GetInstance().Delete("auth:session_seen:" + jti)
+	recent := time.Now().Add(-sessionTouchInterval / 2)
+	setLastSeen(t, s, jti, recent)
+	s.TouchSession(jti)
+	time.Sleep(100 * time.Millisecond)
+	if got := lastSeen(t, s, jti); !got.Equal(recent) {
+		t.Errorf("last_seen_at = %v after touching a recently seen session, want %v", got, recent)
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	db := newTestDB(t)
+	alice := createUser(t, db, "alice", "password", "")
+	bob := createUser(t, db, "bob", "password", "")
+	s := NewService(db)
+	current := loginClaims(t, s, "alice", "password")
+	other := loginClaims(t, s, "alice", "password")
+	loginClaims(t, s, "bob", "password")
+
+	sessions, err := s.ListSessions(context.Background(), alice.ID, current.ID)
+	if err != nil || len(sessions) != 2 {
+		t.Fatalf("ListSessions = %d sessions (%v), want 2", len(sessions), err)
+	}
+	var target uint
+	for _, session := range sessions {
+		if session.Current != (session.JTI == current.ID) {
+			t.Errorf("session %s current = %v", session.JTI, session.Current)
+		}
+		if session.JTI == other.ID {
+			target = session.ID
+		}
+	}
+
+	tests := []struct {
+		name    string
+		userID  uint
+		wantErr error
+	}{
+		// 会话按用户查找，其他用户不能吊销
+		{"other user", bob.ID, ErrSessionNotFound},
+		{"owner", alice.ID, nil},
+		{"already revoked", alice.ID, ErrSessionNotFound},
+	}
+	for _, tt := range tests {
+		if err := s.RevokeSession(context.Background(), tt.userID, target, audit.Event{}); !errors.Is(err, tt.wantErr) {
+			t.Errorf("RevokeSession %s error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	if revoked, err := s.IsRevoked(context.Background(), other); err != nil || !revoked {
+		t.Errorf("IsRevoked for the revoked session = %v (%v), want true", revoked, err)
+	}
+	if revoked, _ := s.IsRevoked(context.Background(), current); revoked {
+		t.Error("revoking one session revoked the current session")
+	}
+	if sessions, _ := s.ListSessions(context.Background(), alice.ID, current.ID); len(sessions) != 1 || !sessions[0].Current {
+		t.Errorf("ListSessions after revoke = %+v, want only the current session", sessions)
+	}
+}
+
+func TestRevokeOtherSessions(t *testing.T) {
+	db := newTestDB(t)
+	alice := createUser(t, db, "alice", "password", "")
+	createUser(t, db, "bob", "password", "")
+	s := NewService(db)
+	current := loginClaims(t, s, "alice", "password")
+	others := []string{loginClaims(t, s, "alice", "password").ID, loginClaims(t, s, "alice", "password").ID}
+	bob := loginClaims(t, s, "bob", "password")
+
+	n, err := s.RevokeOtherSessions(context.Background(), alice.ID, current.ID, audit.Event{})
+	if err != nil || n != 2 {
+		t.Fatalf("RevokeOtherSessions = %d (%v), want 2", n, err)
+	}
+	if revoked, _ := s.IsRevoked(context.Background(), current); revoked {
+		t.Error("the current session was revoked")
+	}
+	if revoked, _ := s.IsRevoked(context.Background(), bob); revoked {
+		t.Error("another user's session was revoked")
+	}
+	for _, jti := range others {
+		var count int64
+		s.db.Model(&RevokedToken{}).Where("jti = ?", jti).Count(&count)
+		if count != 1 {
+			t.Errorf("session %s was not revoked", jti)
+		}
+	}
+	if n, _ := s.RevokeOtherSessions(context.Background(), alice.ID, current.ID, audit.Event{}); n != 0 {
+		t.Errorf("second RevokeOtherSessions revoked %d sessions, want 0", n)
+	}
+}
@@ -0,0 +1,864 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/shenjing023/rbac-api-gateway/pkg/jwt"
+	"gopkg.in/yaml.v3"
+)
+
+var federationNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// EnvPrefix 是环境变量覆盖配置时使用的前缀，例如 GATEWAY_DATABASE_PASSWORD 覆盖 database.password
+const EnvPrefix = "GATEWAY"
+
+type Config struct {
+	Server   ServerConfig   `yaml:"server"`
+	Database DatabaseConfig `yaml:"database"`
+	JWT      JWTConfig      `yaml:"jwt"`
+	Auth     AuthConfig     `yaml:"auth"`
+	CORS     CORSConfig     `yaml:"cors"`
+	Cache    CacheConfig    `yaml:"cache"`
+	Policy   PolicyConfig   `yaml:"policy"`
+	Secrets  SecretsConfig  `yaml:"secrets"`
+	Notifier NotifierConfig `yaml:"notifier"`
+}
+
+// NotifierConfig 配置向用户发送密码重置链接等通知的方式，type 为空时不发送通知
+type NotifierConfig struct {
+	Type string     `yaml:"type"` // file 或 smtp
+	File string     `yaml:"file"` // type 为 file 时通知以 JSON 行追加到该文件
+	SMTP SMTPConfig `yaml:"smtp"`
+}
+
+type SMTPConfig struct {
+	Addr     string `yaml:"addr"` // host:port
+	From     string `yaml:"from"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"` // 字面量或密钥引用，见 SecretsConfig
+}
+
+type ServerConfig struct {
+	Addr              string        `yaml:"addr"`
+	Mode              string        `yaml:"mode"`           // gin 的运行模式：debug、release 或 test
+	ExtAuthzAddr      string        `yaml:"ext_authz_addr"` // Envoy ext_authz gRPC 服务地址，为空时不启动，unix:/path 表示 unix socket
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	// ShutdownDelay 是收到 SIGTERM 后继续处理请求、等待负载均衡器摘除实例的时间
+	ShutdownDelay time.Duration `yaml:"shutdown_delay"`
+	// ShutdownTimeout 是等待进行中的请求完成的最长时间
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	H2C             bool          `yaml:"h2c"` // 未启用 TLS 时支持明文 HTTP/2
+	TLS             TLSConfig     `yaml:"tls"`
+	// ExtAuthzTLS 是 ext_authz gRPC 服务的 TLS 配置，没有配置时 ext_authz_addr 只能是回环地址或 unix socket
+	ExtAuthzTLS TLSConfig `yaml:"ext_authz_tls"`
+	// TrustedProxies 是可信的反向代理地址或网段，只有来自这些地址的 X-Forwarded-For 才会被采用，
+	// 为空时使用连接的对端地址作为客户端 IP
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+// TLSConfig 配置了 cert_file 时启用 TLS，配置了 client_ca_file 时校验客户端证书（mTLS）
+type TLSConfig struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"`
+	// ClientAuth 可选 request、verify_if_given 或 require
+	ClientAuth     string        `yaml:"client_auth"`
+	MinVersion     string        `yaml:"min_version"` // 1.2 或 1.3
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+}
+
+// Enabled 返回是否启用了 TLS
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != ""
+}
+
+type DatabaseConfig struct {
+	Host            string        `yaml:"host"`
+	Port            int           `yaml:"port"`
+	User            string        `yaml:"user"`
+	Password        string        `yaml:"password"` // 字面量或密钥引用，见 SecretsConfig
+	Name            string        `yaml:"name"`
+	SSLMode         string        `yaml:"sslmode"`
+	TimeZone        string        `yaml:"timezone"`
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+}
+
+type JWTConfig struct {
+	Secret     string        `yaml:"secret"` // 字面量或密钥引用，见 SecretsConfig
+	Issuer     string        `yaml:"issuer"`
+	Expiration time.Duration `yaml:"expiration"`
+}
+
+type AuthConfig struct {
+	MTLS  MTLSConfig  `yaml:"mtls"`
+	OAuth OAuthConfig `yaml:"oauth"`
+	OIDC  OIDCConfig  `yaml:"oidc"`
+	// Federation 是可以用来登录的上游 OIDC 身份提供方
+	Federation []FederationProviderConfig `yaml:"federation"`
+	LDAP       LDAPConfig                 `yaml:"ldap"`
+	MFA        MFAConfig                  `yaml:"mfa"`
+	Lockout    LockoutConfig              `yaml:"lockout"`
+	Password   PasswordConfig             `yaml:"password"`
+	// Verification 要求注册的用户验证邮箱后才能登录，需要配置 notifier
+	Verification VerificationConfig `yaml:"verification"`
+	// Impersonation 允许管理员以其他用户的身份访问接口，用于排查用户反馈的问题，默认关闭
+	Impersonation ImpersonationConfig `yaml:"impersonation"`
+	// SessionCookie 允许浏览器客户端登录时把 token 保存在 HttpOnly cookie 中，而不是 localStorage
+	SessionCookie SessionCookieConfig `yaml:"session_cookie"`
+	Registration  RegistrationConfig  `yaml:"registration"`
+}
+
+// RegistrationConfig 控制谁可以通过 /auth/register 自助注册
+type RegistrationConfig struct {
+	Mode string `yaml:"mode"` // open、invite、domain 或 closed
+	// AllowedDomains 是 domain 模式下允许注册的邮箱域名，需要启用 auth.verification
+	AllowedDomains []string      `yaml:"allowed_domains"`
+	InviteTTL      time.Duration `yaml:"invite_ttl"` // 邀请码的默认有效期
+	Captcha        CaptchaConfig `yaml:"captcha"`
+}
+
+// CaptchaConfig 是注册时的人机验证，Provider 为空时不校验
+type CaptchaConfig struct {
+	Provider  string `yaml:"provider"`   // turnstile、hcaptcha、recaptcha 或 custom
+	VerifyURL string `yaml:"verify_url"` // custom 时必须配置，也可以覆盖内置服务的地址
+	SiteKey   string `yaml:"site_key"`   // 通过 GET /auth/registration 返回给前端
+	Secret    string `yaml:"secret"`     // 字面量或密钥引用，见 SecretsConfig
+}
+
+type SessionCookieConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Name     string `yaml:"name"`      // 保存 token 的 HttpOnly cookie
+	CSRFName string `yaml:"csrf_name"` // 保存 CSRF token 的 cookie，前端读取后放在 X-CSRF-Token 请求头中
+	Domain   string `yaml:"domain"`
+	Path     string `yaml:"path"`
+	Secure   bool   `yaml:"secure"`
+	SameSite string `yaml:"same_site"` // lax、strict 或 none
+	// LoginRedirect 是通过上游身份提供方登录成功后跳转的前端页面，只能是本站的路径
+	LoginRedirect string `yaml:"login_redirect"`
+}
+
+type ImpersonationConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	MaxTTL  time.Duration `yaml:"max_ttl"` // 模拟 token 的最长有效期
+}
+
+type VerificationConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	TTL     time.Duration `yaml:"ttl"` // 验证链接的有效期
+	// URL 中的 {token} 会被替换为验证 token，为空时通知中只包含 token
+	URL string `yaml:"url"`
+}
+
+// PasswordConfig 是设置密码时的要求和找回密码的配置
+type PasswordConfig struct {
+	MinLength int `yaml:"min_length"`
+	MaxLength int `yaml:"max_length"` // 按字节计算，bcrypt 最多使用 72 个字节
+	// MinStrength 是 zxcvbn 评分（0-4）的下限，0 表示不检查
+	MinStrength int `yaml:"min_strength"`
+	// BreachedDir 是按 SHA-1 前缀分文件的离线 Pwned Passwords 数据目录，为空时不检查
+	BreachedDir      string `yaml:"breached_dir"`
+	BreachedMinCount int    `yaml:"breached_min_count"`
+	// ResetTTL 是重置令牌的有效期，ResetURL 中的 {token} 会被替换为重置令牌
+	ResetTTL time.Duration `yaml:"reset_ttl"`
+	ResetURL string        `yaml:"reset_url"`
+}
+
+// LockoutConfig 配置密码登录失败后的等待时间和临时锁定
+type LockoutConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Window 内没有新的失败时计数清零
+	Window time.Duration `yaml:"window"`
+	// 账号连续失败 DelayAfter 次后，每次失败需要等待 BaseDelay、2*BaseDelay……最多 MaxDelay
+	DelayAfter int           `yaml:"delay_after"`
+	BaseDelay  time.Duration `yaml:"base_delay"`
+	MaxDelay   time.Duration `yaml:"max_delay"`
+	// 账号或 IP 的失败次数达到阈值后锁定 LockoutDuration，阈值为 0 表示不锁定
+	AccountThreshold int           `yaml:"account_threshold"`
+	IPThreshold      int           `yaml:"ip_threshold"`
+	LockoutDuration  time.Duration `yaml:"lockout_duration"`
+}
+
+// MFAConfig 配置 TOTP 多因素认证
+type MFAConfig struct {
+	// Issuer 是验证器应用中显示的服务名称
+	Issuer string `yaml:"issuer"`
+	// RequiredRoles 是必须完成多因素认证的角色，通过 input.mfa_required_roles 传给 rbac.rego，默认为空。
+	// 加入角色之前需要先让该角色的用户启用 TOTP
+	RequiredRoles []string `yaml:"required_roles"`
+}
+
+// LDAPConfig 配置 LDAP / Active Directory 登录和组到角色的同步
+type LDAPConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	URL      string `yaml:"url"`
+	StartTLS bool   `yaml:"start_tls"`
+	CAFile   string `yaml:"ca_file"`
+	BindDN   string `yaml:"bind_dn"`
+	// BindPassword 可以是密钥引用
+	BindPassword string `yaml:"bind_password"`
+	BaseDN       string `yaml:"base_dn"`
+	// UserFilter 中的 {username} 会被替换为登录的用户名
+	UserFilter        string           `yaml:"user_filter"`
+	SyncFilter        string           `yaml:"sync_filter"`
+	UsernameAttribute string           `yaml:"username_attribute"`
+	GroupAttribute    string           `yaml:"group_attribute"`
+	RoleRules         []RoleRuleConfig `yaml:"role_rules"`
+	DefaultRole       string           `yaml:"default_role"`
+	// SyncInterval 为 0 时不同步，角色只在登录时更新
+	SyncInterval time.Duration `yaml:"sync_interval"`
+	Timeout      time.Duration `yaml:"timeout"`
+}
+
+// FederationProviderConfig 配置一个上游 OIDC 身份提供方，例如 Keycloak 或 Google Workspace
+type FederationProviderConfig struct {
+	// Name 出现在登录地址 /auth/login/<name> 中
+	Name     string `yaml:"name"`
+	Issuer   string `yaml:"issuer"`
+	ClientID string `yaml:"client_id"`
+	// ClientSecret 可以是密钥引用，公开客户端留空
+	ClientSecret string `yaml:"client_secret"`
+	// RedirectURL 是在上游登记的回调地址，即 <网关地址>/auth/login/<name>/callback
+	RedirectURL   string           `yaml:"redirect_url"`
+	Scopes        []string         `yaml:"scopes"`
+	UsernameClaim string           `yaml:"username_claim"`
+	GroupsClaim   string           `yaml:"groups_claim"`
+	RoleRules     []RoleRuleConfig `yaml:"role_rules"`
+	// DefaultRole 是没有规则匹配时分配的角色，为空时拒绝登录
+	DefaultRole string `yaml:"default_role"`
+}
+
+// RoleRuleConfig 把上游用户组映射为角色，按顺序取第一个匹配的规则
+type RoleRuleConfig struct {
+	Group string `yaml:"group"`
+	Role  string `yaml:"role"`
+}
+
+// OIDCConfig 配置网关作为 OpenID Connect 身份提供方，issuer 为空时不启用
+type OIDCConfig struct {
+	// Issuer 是对外可访问的网关地址，ID token 的 iss 和发现文档中的端点都基于它
+	Issuer string `yaml:"issuer"`
+	// SigningKey 是 PEM 格式的 RSA 私钥，可以是密钥引用；为空时每次启动生成临时密钥
+	SigningKey string        `yaml:"signing_key"`
+	IDTokenTTL time.Duration `yaml:"id_token_ttl"`
+}
+
+// OAuthConfig 是 OAuth2 授权服务器签发的各类令牌的有效期
+type OAuthConfig struct {
+	AccessTokenTTL  time.Duration `yaml:"access_token_ttl"`
+	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl"`
+	CodeTTL         time.Duration `yaml:"code_ttl"`
+}
+
+// MTLSConfig 配置服务间调用的客户端证书认证，需要同时配置 server.tls.client_ca_file
+type MTLSConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Identity 指定从证书的哪个字段取服务账号名：subject_cn、dns_san、uri_san 或 email_san
+	Identity string `yaml:"identity"`
+}
+
+type CORSConfig struct {
+	AllowOrigins     []string      `yaml:"allow_origins"`
+	AllowMethods     []string      `yaml:"allow_methods"`
+	AllowHeaders     []string      `yaml:"allow_headers"`
+	ExposeHeaders    []string      `yaml:"expose_headers"`
+	AllowCredentials bool          `yaml:"allow_credentials"`
+	MaxAge           time.Duration `yaml:"max_age"`
+}
+
+type CacheConfig struct {
+	DefaultExpiration time.Duration `yaml:"default_expiration"`
+	CleanupInterval   time.Duration `yaml:"cleanup_interval"`
+}
+
+type PolicyConfig struct {
+	// Engine 可选 embedded（内置 rbac.rego，支持数据库中的策略版本）、file、bundle 或 remote
+	Engine string   `yaml:"engine"`
+	Paths  []string `yaml:"paths"`  // file 引擎加载的策略文件或目录
+	Bundle string   `yaml:"bundle"` // bundle 引擎加载的目录或 .tar.gz 文件
+	// BundleVerification 是校验 bundle 签名（.signatures.json）的密钥
+	BundleVerification BundleVerificationConfig `yaml:"bundle_verification"`
+	Timeout            time.Duration            `yaml:"timeout"`
+	WatchInterval      time.Duration            `yaml:"watch_interval"` // 检查数据库中生效策略版本的间隔
+	Remote             RemoteConfig             `yaml:"remote"`
+}
+
+type BundleVerificationConfig struct {
+	KeyID     string `yaml:"key_id"`
+	KeyFile   string `yaml:"key_file"`  // PEM 格式的公钥，HS256 等算法为共享密钥
+	Algorithm string `yaml:"algorithm"` // 例如 RS256、ES256
+	Scope     string `yaml:"scope"`
+	// Insecure 为 true 时不校验签名，只应该在开发环境中使用
+	Insecure bool `yaml:"insecure"`
+}
+
+type RemoteConfig struct {
+	URL      string        `yaml:"url"`
+	Token    string        `yaml:"token"`
+	Retries  int           `yaml:"retries"`
+	Backoff  time.Duration `yaml:"backoff"`
+	Fallback string        `yaml:"fallback"` // error、deny 或 cached
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+	// CacheSize 是 cached 模式下最多缓存的决策数量
+	CacheSize int `yaml:"cache_size"`
+	MaxConns  int `yaml:"max_conns"`
+}
+
+// SecretsConfig 配置密钥的来源。secretRefs 中列出的配置项（jwt.secret、database.password 等）
+// 除了直接写字面量，还可以写成引用：
+//
+//	file:///run/secrets/jwt-secret      读取文件（Kubernetes Secret 挂载）
+//	env://DB_PASSWORD                   读取环境变量
+//	vault://secret/gateway#jwt_secret   读取 Vault KV，需要配置 secrets.vault
+//
+// 引用的值每隔 RefreshInterval 重新读取一次，变化后自动生效
+type SecretsConfig struct {
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+	Vault           VaultConfig   `yaml:"vault"`
+}
+
+type VaultConfig struct {
+	Address   string        `yaml:"address"`
+	Token     string        `yaml:"token"`
+	TokenFile string        `yaml:"token_file"` // 每次请求都会重新读取，支持 Vault Agent 轮换 token
+	Namespace string        `yaml:"namespace"`
+	KVVersion int           `yaml:"kv_version"`
+	Timeout   time.Duration `yaml:"timeout"`
+}
+
+// Default 返回默认配置，数据库密码和 JWT 密钥没有默认值，必须显式配置
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:              ":8080",
+			Mode:              "release",
+			ReadTimeout:       30 * time.Second,
+			ReadHeaderTimeout: 10 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownDelay:     5 * time.Second,
+			ShutdownTimeout:   30 * time.Second,
+			TLS: TLSConfig{
+				ClientAuth:     "verify_if_given",
+				MinVersion:     "1.2",
+				ReloadInterval: time.Minute,
+			},
+			ExtAuthzTLS: TLSConfig{
+				ClientAuth:     "require",
+				MinVersion:     "1.2",
+				ReloadInterval: time.Minute,
+			},
+		},
+		Database: DatabaseConfig{
+			Host:            "127.0.0.1",
+			Port:            5432,
+			User:            "postgres",
+			Name:            "postgres",
+			SSLMode:         "disable",
+			TimeZone:        "Asia/Shanghai",
+			MaxOpenConns:    25,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: time.Hour,
+		},
+		JWT: JWTConfig{
+			Issuer:     "rbac-api-gateway",
+			Expiration: 24 * time.Hour,
+		},
+		Auth: AuthConfig{
+			MTLS: MTLSConfig{
+				Identity: "subject_cn",
+			},
+			OAuth: OAuthConfig{
+				AccessTokenTTL:  15 * time.Minute,
+				RefreshTokenTTL: 30 * 24 * time.Hour,
+				CodeTTL:         time.Minute,
+			},
+			OIDC: OIDCConfig{
+				IDTokenTTL: time.Hour,
+			},
+			MFA: MFAConfig{
+				Issuer: "rbac-api-gateway",
+			},
+			Lockout: LockoutConfig{
+				Enabled:          true,
+				Window:           15 * time.Minute,
+				DelayAfter:       3,
+				BaseDelay:        time.Second,
+				MaxDelay:         30 * time.Second,
+				AccountThreshold: 10,
+				IPThreshold:      50,
+				LockoutDuration:  15 * time.Minute,
+			},
+			Password: PasswordConfig{
+				MinLength:        10,
+				MaxLength:        72,
+				MinStrength:      3,
+				BreachedMinCount: 1,
+				ResetTTL:         30 * time.Minute,
+			},
+			Verification: VerificationConfig{
+				TTL: 24 * time.Hour,
+			},
+			Impersonation: ImpersonationConfig{
+				MaxTTL: 15 * time.Minute,
+			},
+			Registration: RegistrationConfig{
+				Mode:      "open",
+				InviteTTL: 7 * 24 * time.Hour,
+			},
+			SessionCookie: SessionCookieConfig{
+				Name:          "gw_session",
+				CSRFName:      "gw_csrf",
+				Path:          "/",
+				Secure:        true,
+				SameSite:      "lax",
+				LoginRedirect: "/",
+			},
+			LDAP: LDAPConfig{
+				UserFilter:        "(&(objectCategory=person)(objectClass=user)(sAMAccountName={username}))",
+				SyncFilter:        "(&(objectCategory=person)(objectClass=user))",
+				UsernameAttribute: "sAMAccountName",
+				GroupAttribute:    "memberOf",
+				SyncInterval:      15 * time.Minute,
+				Timeout:           5 * time.Second,
+			},
+		},
+		CORS: CORSConfig{
+			AllowMethods:  []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+			AllowHeaders:  []string{"Origin", "Content-Type", "Accept", "Authorization", "X-CSRF-Token"},
+			ExposeHeaders: []string{"Content-Length", "X-Impersonated-By"},
+			MaxAge:        12 * time.Hour,
+		},
+		Cache: CacheConfig{
+			DefaultExpiration: 5 * time.Minute,
+			CleanupInterval:   10 * time.Minute,
+		},
+		Policy: PolicyConfig{
+			Engine: "embedded",
+			BundleVerification: BundleVerificationConfig{
+				KeyID:     "default",
+				Algorithm: "RS256",
+			},
+			Timeout:       time.Second,
+			WatchInterval: 30 * time.Second,
+			Remote: RemoteConfig{
+				Retries:   2,
+				Backoff:   50 * time.Millisecond,
+				Fallback:  "error",
+				CacheTTL:  10 * time.Minute,
+				CacheSize: 10000,
+				MaxConns:  64,
+			},
+		},
+		Secrets: SecretsConfig{
+			RefreshInterval: time.Minute,
+			Vault: VaultConfig{
+				KVVersion: 2,
+				Timeout:   5 * time.Second,
+			},
+		},
+	}
+}
+
+// Load 依次应用默认值、配置文件和环境变量，并校验最终的配置。path 为空时只使用默认值和环境变量。
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("读取配置文件失败: %w", err)
+		}
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("解析配置文件失败: %w", err)
+		}
+	}
+
+	if err := applyEnv(cfg, EnvPrefix, os.LookupEnv); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate 检查配置是否完整、一致，返回所有发现的问题
+func (c *Config) Validate() error {
+	var errs []error
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Server.Addr == "" {
+		add("server.addr 不能为空")
+	}
+	switch c.Server.Mode {
+	case "debug", "release", "test":
+	default:
+		add("server.mode 必须是 debug、release 或 test")
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		add("server.shutdown_timeout 必须大于 0")
+	}
+	validateTLS("server.tls", c.Server.TLS, add)
+	if c.Server.ExtAuthzAddr != "" {
+		validateTLS("server.ext_authz_tls", c.Server.ExtAuthzTLS, add)
+		// ext_authz 决定请求是否放行并注入身份请求头，明文服务不能暴露给其他主机
+		if !c.Server.ExtAuthzTLS.Enabled() && !isLocalAddr(c.Server.ExtAuthzAddr) {
+			add("server.ext_authz_addr 不是回环地址或 unix socket 时必须配置 server.ext_authz_tls")
+		}
+	}
+
+	if c.Database.Host == "" || c.Database.User == "" || c.Database.Name == "" {
+		add("database.host、database.user 和 database.name 不能为空")
+	}
+	if c.Database.Port <= 0 || c.Database.Port > 65535 {
+		add("database.port 无效: %d", c.Database.Port)
+	}
+
+	// 引用的密钥在启动时读取后再校验长度
+	if c.JWT.Secret == "" {
+		add("jwt.secret 不能为空")
+	} else if !IsSecretRef(c.JWT.Secret) && len(c.JWT.Secret) < jwt.MinSecretLength {
+		add("jwt.secret 至少需要 %d 个字节", jwt.MinSecretLength)
+	}
+	if c.JWT.Expiration <= 0 {
+		add("jwt.expiration 必须大于 0")
+	}
+
+	if c.Auth.MTLS.Enabled {
+		if c.Server.TLS.ClientCAFile == "" {
+			add("启用 auth.mtls 时必须配置 server.tls.client_ca_file")
+		}
+		switch c.Auth.MTLS.Identity {
+		case "subject_cn", "dns_san", "uri_san", "email_san":
+		default:
+			add("auth.mtls.identity 必须是 subject_cn、dns_san、uri_san 或 email_san")
+		}
+	}
+
+	if c.Auth.OAuth.AccessTokenTTL <= 0 || c.Auth.OAuth.RefreshTokenTTL <= 0 || c.Auth.OAuth.CodeTTL <= 0 {
+		add("auth.oauth 中的有效期必须大于 0")
+	}
+	providerNames := make(map[string]bool)
+	for i, p := range c.Auth.Federation {
+		if !federationNamePattern.MatchString(p.Name) {
+			add("auth.federation[%d].name 只能包含小写字母、数字和 -", i)
+		} else if providerNames[p.Name] {
+			add("auth.federation 中的 %s 重复", p.Name)
+		}
+		providerNames[p.Name] = true
+		if p.Issuer == "" || p.ClientID == "" || p.RedirectURL == "" {
+			add("auth.federation[%d] 必须配置 issuer、client_id 和 redirect_url", i)
+		}
+		for _, rule := range p.RoleRules {
+			if rule.Group == "" || rule.Role == "" {
+				add("auth.federation[%d].role_rules 中的 group 和 role 不能为空", i)
+			}
+		}
+	}
+
+	if c.Auth.LDAP.Enabled {
+		if c.Auth.LDAP.URL == "" || c.Auth.LDAP.BaseDN == "" {
+			add("启用 auth.ldap 时必须配置 url 和 base_dn")
+		}
+		if !strings.Contains(c.Auth.LDAP.UserFilter, "{username}") {
+			add("auth.ldap.user_filter 必须包含 {username}")
+		}
+		if c.Auth.LDAP.UsernameAttribute == "" || c.Auth.LDAP.GroupAttribute == "" {
+			add("auth.ldap.username_attribute 和 group_attribute 不能为空")
+		}
+		if c.Auth.LDAP.SyncInterval < 0 {
+			add("auth.ldap.sync_interval 不能小于 0")
+		}
+		for _, rule := range c.Auth.LDAP.RoleRules {
+			if rule.Group == "" || rule.Role == "" {
+				add("auth.ldap.role_rules 中的 group 和 role 不能为空")
+			}
+		}
+	}
+
+	if c.Auth.MFA.Issuer == "" || strings.Contains(c.Auth.MFA.Issuer, ":") {
+		add("auth.mfa.issuer 不能为空，也不能包含冒号")
+	}
+	for _, role := range c.Auth.MFA.RequiredRoles {
+		if strings.TrimSpace(role) == "" {
+			add("auth.mfa.required_roles 中的角色不能为空")
+		}
+	}
+
+	if l := c.Auth.Lockout; l.Enabled {
+		if l.Window <= 0 || l.LockoutDuration <= 0 {
+			add("auth.lockout.window 和 lockout_duration 必须大于 0")
+		}
+		if l.DelayAfter <= 0 || l.BaseDelay <= 0 || l.MaxDelay < l.BaseDelay {
+			add("auth.lockout.delay_after 和 base_delay 必须大于 0，max_delay 不能小于 base_delay")
+		}
+		if l.AccountThreshold < 0 || l.IPThreshold < 0 {
+			add("auth.lockout.account_threshold 和 ip_threshold 不能小于 0")
+		}
+	}
+
+	if p := c.Auth.Password; p.MinLength < 1 || p.MaxLength < p.MinLength || p.MaxLength > 72 {
+		add("auth.password.min_length 必须大于 0，max_length 不能小于 min_length，也不能超过 72")
+	}
+	if c.Auth.Password.MinStrength < 0 || c.Auth.Password.MinStrength > 4 {
+		add("auth.password.min_strength 必须在 0 到 4 之间")
+	}
+	if c.Auth.Password.ResetTTL <= 0 {
+		add("auth.password.reset_ttl 必须大于 0")
+	}
+	if u := c.Auth.Password.ResetURL; u != "" && !strings.Contains(u, "{token}") {
+		add("auth.password.reset_url 必须包含 {token}")
+	}
+
+	if v := c.Auth.Verification; v.Enabled {
+		if c.Notifier.Type == "" {
+			add("启用 auth.verification 时必须配置 notifier")
+		}
+		if v.TTL < time.Hour {
+			add("auth.verification.ttl 不能小于 1h")
+		}
+		if v.URL != "" && !strings.Contains(v.URL, "{token}") {
+			add("auth.verification.url 必须包含 {token}")
+		}
+	}
+
+	if i := c.Auth.Impersonation; i.Enabled && (i.MaxTTL <= 0 || i.MaxTTL > time.Hour) {
+		add("auth.impersonation.max_ttl 必须大于 0 且不超过 1h")
+	}
+
+	switch reg := c.Auth.Registration; reg.Mode {
+	case "open", "closed":
+	case "invite":
+		if reg.InviteTTL <= 0 {
+			add("auth.registration.invite_ttl 必须大于 0")
+		}
+	case "domain":
+		if len(reg.AllowedDomains) == 0 {
+			add("auth.registration.mode 为 domain 时必须配置 allowed_domains")
+		}
+		// 不验证邮箱时任何人都可以填写允许的域名
+		if !c.Auth.Verification.Enabled {
+			add("auth.registration.mode 为 domain 时必须启用 auth.verification")
+		}
+	default:
+		add("auth.registration.mode 必须是 open、invite、domain 或 closed")
+	}
+	if cp := c.Auth.Registration.Captcha; cp.Provider != "" {
+		switch cp.Provider {
+		case "turnstile", "hcaptcha", "recaptcha":
+		case "custom":
+			if cp.VerifyURL == "" {
+				add("auth.registration.captcha.provider 为 custom 时必须配置 verify_url")
+			}
+		default:
+			add("auth.registration.captcha.provider 必须是 turnstile、hcaptcha、recaptcha 或 custom")
+		}
+		if cp.Secret == "" {
+			add("启用人机验证时必须配置 auth.registration.captcha.secret")
+		}
+	}
+
+	if sc := c.Auth.SessionCookie; sc.Enabled {
+		if sc.Name == "" || sc.CSRFName == "" || sc.Name == sc.CSRFName {
+			add("auth.session_cookie.name 和 csrf_name 不能为空且不能相同")
+		}
+		switch sc.SameSite {
+		case "lax", "strict":
+		case "none":
+			if !sc.Secure {
+				add("auth.session_cookie.same_site 为 none 时必须开启 secure")
+			}
+		default:
+			add("auth.session_cookie.same_site 必须是 lax、strict 或 none")
+		}
+		// 以 // 或 /\ 开头的地址会被浏览器当作其他站点
+		if !strings.HasPrefix(sc.LoginRedirect, "/") || strings.HasPrefix(sc.LoginRedirect, "//") || strings.HasPrefix(sc.LoginRedirect, "/\\") {
+			add("auth.session_cookie.login_redirect 必须是以 / 开头的本站路径")
+		}
+	}
+
+	switch c.Notifier.Type {
+	case "":
+	case "file":
+		if c.Notifier.File == "" {
+			add("notifier.type 为 file 时必须配置 notifier.file")
+		}
+	case "smtp":
+		if c.Notifier.SMTP.Addr == "" || c.Notifier.SMTP.From == "" {
+			add("notifier.type 为 smtp 时必须配置 notifier.smtp.addr 和 from")
+		}
+	default:
+		add("notifier.type 必须为空、file 或 smtp")
+	}
+
+	for _, proxy := range c.Server.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			add("server.trusted_proxies 中的 %s 不是有效的 IP 或网段", proxy)
+		}
+	}
+
+	if c.Auth.OIDC.Issuer != "" {
+		if u, err := url.Parse(c.Auth.OIDC.Issuer); err != nil || u.Scheme == "" || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+			add("auth.oidc.issuer 必须是不带查询参数的绝对 URL")
+		}
+		if c.Auth.OIDC.IDTokenTTL <= 0 {
+			add("auth.oidc.id_token_ttl 必须大于 0")
+		}
+	}
+
+	for _, origin := range c.CORS.AllowOrigins {
+		if origin == "*" && c.CORS.AllowCredentials {
+			add("cors.allow_origins 包含 * 时不能开启 cors.allow_credentials")
+		}
+	}
+
+	switch c.Policy.Engine {
+	case "embedded":
+	case "file":
+		if len(c.Policy.Paths) == 0 {
+			add("policy.engine 为 file 时必须配置 policy.paths")
+		}
+	case "bundle":
+		if c.Policy.Bundle == "" {
+			add("policy.engine 为 bundle 时必须配置 policy.bundle")
+		}
+		if v := c.Policy.BundleVerification; !v.Insecure && (v.KeyFile == "" || v.KeyID == "") {
+			add("policy.engine 为 bundle 时必须配置 policy.bundle_verification.key_id 和 key_file，或者明确设置 insecure")
+		}
+	case "remote":
+		if c.Policy.Remote.URL == "" {
+			add("policy.engine 为 remote 时必须配置 policy.remote.url")
+		}
+		switch c.Policy.Remote.Fallback {
+		case "error", "deny", "cached":
+		default:
+			add("policy.remote.fallback 必须是 error、deny 或 cached")
+		}
+	default:
+		add("policy.engine 必须是 embedded、file、bundle 或 remote")
+	}
+
+	if c.Secrets.Vault.Address == "" {
+		for _, ref := range c.secretRefs() {
+			if strings.HasPrefix(ref.value, "vault://") {
+				add("%s 使用 vault:// 引用时必须配置 secrets.vault.address", ref.path)
+			}
+		}
+	}
+	if c.Secrets.Vault.Address != "" {
+		if c.Secrets.Vault.Token == "" && c.Secrets.Vault.TokenFile == "" {
+			add("secrets.vault.token 和 secrets.vault.token_file 不能都为空")
+		}
+		if c.Secrets.Vault.KVVersion != 1 && c.Secrets.Vault.KVVersion != 2 {
+			add("secrets.vault.kv_version 必须是 1 或 2")
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("配置无效: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+type secretRef struct {
+	path  string
+	value string
+}
+
+// secretRefs 返回启动时会读取的、可以写成密钥引用的配置项，新增这类配置项时需要加在这里。
+// 没有启用的功能不会读取对应的密钥，不返回
+func (c *Config) secretRefs() []secretRef {
+	refs := []secretRef{
+		{"jwt.secret", c.JWT.Secret},
+		{"database.password", c.Database.Password},
+		{"auth.oidc.signing_key", c.Auth.OIDC.SigningKey},
+	}
+	if c.Notifier.Type == "smtp" {
+		refs = append(refs, secretRef{"notifier.smtp.password", c.Notifier.SMTP.Password})
+	}
+	if c.Auth.LDAP.Enabled {
+		refs = append(refs, secretRef{"auth.ldap.bind_password", c.Auth.LDAP.BindPassword})
+	}
+	if c.Auth.Registration.Captcha.Provider != "" {
+		refs = append(refs, secretRef{"auth.registration.captcha.secret", c.Auth.Registration.Captcha.Secret})
+	}
+	for i, p := range c.Auth.Federation {
+		refs = append(refs, secretRef{fmt.Sprintf("auth.federation[%d].client_secret", i), p.ClientSecret})
+	}
+	return refs
+}
+
+// validateTLS 检查 prefix 对应的 TLS 配置，没有配置 cert_file 时视为不启用
+func validateTLS(prefix string, cfg TLSConfig, add func(format string, args ...interface{})) {
+	if !cfg.Enabled() {
+		if cfg.ClientCAFile != "" {
+			add("配置 %s.client_ca_file 时必须同时配置 %s.cert_file", prefix, prefix)
+		}
+		return
+	}
+	if cfg.KeyFile == "" {
+		add("启用 TLS 时必须配置 %s.key_file", prefix)
+	}
+	switch cfg.ClientAuth {
+	case "request", "verify_if_given", "require":
+	default:
+		add("%s.client_auth 必须是 request、verify_if_given 或 require", prefix)
+	}
+	switch cfg.MinVersion {
+	case "1.2", "1.3":
+	default:
+		add("%s.min_version 必须是 1.2 或 1.3", prefix)
+	}
+}
+
+// isLocalAddr 判断监听地址是否是 unix socket 或回环地址
+func isLocalAddr(addr string) bool {
+	if strings.HasPrefix(addr, "unix:") {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// DSN 返回不包含密码的 PostgreSQL 连接字符串，密码在建立连接时单独提供，以便支持轮换
+func (c DatabaseConfig) DSN() string {
+	return fmt.Sprintf("host=%s user=%s dbname=%s port=%d sslmode=%s TimeZone=%s",
+		dsnQuote(c.Host), dsnQuote(c.User), dsnQuote(c.Name), c.Port, dsnQuote(c.SSLMode), dsnQuote(c.TimeZone))
+}
+
+// IsSecretRef 判断配置值是否是 file://、env:// 或 vault:// 形式的密钥引用
+func IsSecretRef(value string) bool {
+	for _, scheme := range []string{"file://", "env://", "vault://"} {
+		if strings.HasPrefix(value, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// dsnQuote 按 libpq 的规则给值加引号，避免密码中的空格或引号破坏连接字符串
+func dsnQuote(v string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+}
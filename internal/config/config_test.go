@@ -0,0 +1,210 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadEnvOverrides(t *testing.T) {
+	path := writeConfig(t, `
+server:
+  addr: ":9000"
+jwt:
+  secret: `+testSecret+`
+database:
+  max_open_conns: 10
+cors:
+  allow_origins: [https://file.example.com]
+`)
+
+	t.Setenv("GATEWAY_SERVER_ADDR", ":9100")
+	t.Setenv("GATEWAY_DATABASE_MAX_OPEN_CONNS", "42")
+	t.Setenv("GATEWAY_JWT_EXPIRATION", "90m")
+	t.Setenv("GATEWAY_AUTH_LOCKOUT_ENABLED", "false")
+	t.Setenv("GATEWAY_CORS_ALLOW_ORIGINS", "https://a.example.com, https://b.example.com,")
+	t.Setenv("GATEWAY_SERVER_TLS_MIN_VERSION", "1.3")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"string overrides file", cfg.Server.Addr, ":9100"},
+		{"int overrides file", cfg.Database.MaxOpenConns, 42},
+		{"duration", cfg.JWT.Expiration, 90 * time.Minute},
+		{"bool", cfg.Auth.Lockout.Enabled, false},
+		{"slice is comma separated", cfg.CORS.AllowOrigins, []string{"https://a.example.com", "https://b.example.com"}},
+		{"nested struct", cfg.Server.TLS.MinVersion, "1.3"},
+		{"file value without env", cfg.JWT.Secret, testSecret},
+		{"default without file or env", cfg.Database.Port, 5432},
+		// 用户模拟需要显式开启
+		{"impersonation disabled by default", cfg.Auth.Impersonation.Enabled, false},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s: got %#v, want %#v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	valid := writeConfig(t, "jwt:\n  secret: "+testSecret+"\n")
+
+	tests := []struct {
+		name    string
+		path    string
+		env     map[string]string
+		wantErr string
+	}{
+		{"missing file", filepath.Join(t.TempDir(), "missing.yaml"), nil, "读取配置文件失败"},
+		{"invalid yaml", writeConfig(t, "server: [\n"), nil, "解析配置文件失败"},
+		{"invalid int", valid, map[string]string{"GATEWAY_DATABASE_PORT": "abc"}, "GATEWAY_DATABASE_PORT"},
+		{"invalid duration", valid, map[string]string{"GATEWAY_JWT_EXPIRATION": "1 hour"}, "GATEWAY_JWT_EXPIRATION"},
+		{"invalid bool", valid, map[string]string{"GATEWAY_AUTH_LOCKOUT_ENABLED": "maybe"}, "GATEWAY_AUTH_LOCKOUT_ENABLED"},
+		{"env makes config invalid", valid, map[string]string{"GATEWAY_SERVER_MODE": "prod"}, "server.mode"},
+		{"secret from env only", "", map[string]string{"GATEWAY_JWT_SECRET": testSecret}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			_, err := Load(tt.path)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Load: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Load error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExampleConfigIsValid(t *testing.T) {
+	t.Setenv("GATEWAY_JWT_SECRET", testSecret)
+	if _, err := Load("../../config.example.yaml"); err != nil {
+		t.Fatalf("config.example.yaml: %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		mutate  func(c *Config)
+		wantErr string // 为空表示配置有效
+	}{
+		{"defaults", func(c *Config) {}, ""},
+		{"empty jwt secret", func(c *Config) { c.JWT.Secret = "" }, "jwt.secret 不能为空"},
+		{"short jwt secret", func(c *Config) { c.JWT.Secret = "short" }, "jwt.secret 至少需要"},
+		{"jwt secret reference", func(c *Config) { c.JWT.Secret = "env://JWT_SECRET" }, ""},
+		{"invalid server mode", func(c *Config) { c.Server.Mode = "prod" }, "server.mode"},
+		{"tls without key", func(c *Config) { c.Server.TLS.CertFile = "cert.pem" }, "server.tls.key_file"},
+		{"client ca without cert", func(c *Config) { c.Server.TLS.ClientCAFile = "ca.pem" }, "server.tls.cert_file"},
+		{"ext_authz on all interfaces without tls", func(c *Config) { c.Server.ExtAuthzAddr = ":9191" }, "server.ext_authz_tls"},
+		{"ext_authz on loopback", func(c *Config) { c.Server.ExtAuthzAddr = "127.0.0.1:9191" }, ""},
+		{"ext_authz on unix socket", func(c *Config) { c.Server.ExtAuthzAddr = "unix:/run/ext_authz.sock" }, ""},
+		{"ext_authz with tls", func(c *Config) {
+			c.Server.ExtAuthzAddr = ":9191"
+			c.Server.ExtAuthzTLS.CertFile, c.Server.ExtAuthzTLS.KeyFile = "cert.pem", "key.pem"
+		}, ""},
+		{"invalid database port", func(c *Config) { c.Database.Port = 70000 }, "database.port"},
+		{"mtls without client ca", func(c *Config) { c.Auth.MTLS.Enabled = true }, "server.tls.client_ca_file"},
+		{"duplicate federation provider", func(c *Config) {
+			p := FederationProviderConfig{Name: "corp", Issuer: "https://idp", ClientID: "gw", RedirectURL: "https://gw/cb"}
+			c.Auth.Federation = []FederationProviderConfig{p, p}
+		}, "auth.federation 中的 corp 重复"},
+		{"ldap filter without placeholder", func(c *Config) {
+			c.Auth.LDAP.Enabled, c.Auth.LDAP.URL, c.Auth.LDAP.BaseDN = true, "ldap://ldap", "dc=example"
+			c.Auth.LDAP.UserFilter = "(uid=admin)"
+		}, "{username}"},
+		{"password max length over bcrypt limit", func(c *Config) { c.Auth.Password.MaxLength = 100 }, "auth.password.min_length"},
+		{"verification without notifier", func(c *Config) { c.Auth.Verification.Enabled = true }, "notifier"},
+		{"impersonation ttl too long", func(c *Config) {
+			c.Auth.Impersonation.Enabled, c.Auth.Impersonation.MaxTTL = true, 2*time.Hour
+		}, "auth.impersonation.max_ttl"},
+		{"domain registration without verification", func(c *Config) {
+			c.Auth.Registration.Mode, c.Auth.Registration.AllowedDomains = "domain", []string{"example.com"}
+		}, "auth.verification"},
+		{"captcha without secret", func(c *Config) { c.Auth.Registration.Captcha.Provider = "turnstile" }, "captcha.secret"},
+		{"same site none without secure", func(c *Config) {
+			c.Auth.SessionCookie.Enabled, c.Auth.SessionCookie.SameSite, c.Auth.SessionCookie.Secure = true, "none", false
+		}, "same_site 为 none"},
+		{"login redirect to another site", func(c *Config) {
+			c.Auth.SessionCookie.Enabled, c.Auth.SessionCookie.LoginRedirect = true, "//evil.example.com/"
+		}, "login_redirect"},
+		{"absolute login redirect", func(c *Config) {
+			c.Auth.SessionCookie.Enabled, c.Auth.SessionCookie.LoginRedirect = true, "https://evil.example.com/"
+		}, "login_redirect"},
+		{"wildcard origin with credentials", func(c *Config) {
+			c.CORS.AllowOrigins, c.CORS.AllowCredentials = []string{"*"}, true
+		}, "cors.allow_origins"},
+		{"invalid trusted proxy", func(c *Config) { c.Server.TrustedProxies = []string{"10.0.0.0/33"} }, "trusted_proxies"},
+		{"bundle without verification key", func(c *Config) {
+			c.Policy.Engine, c.Policy.Bundle = "bundle", "/policies/bundle.tar.gz"
+		}, "policy.bundle_verification"},
+		{"insecure bundle", func(c *Config) {
+			c.Policy.Engine, c.Policy.Bundle, c.Policy.BundleVerification.Insecure = "bundle", "/policies/bundle.tar.gz", true
+		}, ""},
+		{"remote fallback", func(c *Config) {
+			c.Policy.Engine, c.Policy.Remote.URL, c.Policy.Remote.Fallback = "remote", "http://opa:8181", "allow"
+		}, "policy.remote.fallback"},
+		{"vault reference without vault", func(c *Config) { c.Database.Password = "vault://secret/db#password" }, "database.password 使用 vault://"},
+		{"vault reference in ldap bind password", func(c *Config) {
+			c.Auth.LDAP.Enabled, c.Auth.LDAP.URL, c.Auth.LDAP.BaseDN = true, "ldap://ldap", "dc=example"
+			c.Auth.LDAP.BindPassword = "vault://secret/ldap"
+		}, "auth.ldap.bind_password"},
+		{"vault reference in disabled ldap", func(c *Config) { c.Auth.LDAP.BindPassword = "vault://secret/ldap" }, ""},
+		{"vault reference in federation secret", func(c *Config) {
+			c.Auth.Federation = []FederationProviderConfig{{Name: "corp", Issuer: "https://idp", ClientID: "gw", RedirectURL: "https://gw/cb", ClientSecret: "vault://secret/idp#secret"}}
+		}, "auth.federation[0].client_secret"},
+		{"vault reference in captcha secret", func(c *Config) {
+			c.Auth.Registration.Captcha.Provider, c.Auth.Registration.Captcha.Secret = "turnstile", "vault://secret/captcha"
+		}, "auth.registration.captcha.secret"},
+		{"vault reference in oidc signing key", func(c *Config) { c.Auth.OIDC.SigningKey = "vault://secret/oidc#key" }, "auth.oidc.signing_key"},
+		{"vault reference with vault", func(c *Config) {
+			c.Auth.OIDC.SigningKey = "vault://secret/oidc#key"
+			c.Secrets.Vault.Address, c.Secrets.Vault.Token = "http://vault:8200", "token"
+		}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			c.JWT.Secret = testSecret
+			tt.mutate(c)
+
+			err := c.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
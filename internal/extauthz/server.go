@@ -0,0 +1,131 @@
+package extauthz
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/shenjing023/rbac-api-gateway/internal/gateway"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// Server 实现 Envoy 的 ext_authz gRPC 服务，鉴权逻辑与 gin 中间件一致
+type Server struct {
+	authorizer *gateway.Authorizer
+}
+
+func NewServer(authorizer *gateway.Authorizer) *Server {
+	return &Server{authorizer: authorizer}
+}
+
+// Check 根据 Envoy 转发的原始请求信息做认证和鉴权。:path 是客户端发送的原始路径，
+// 由 Authorizer 解码和规范化；Envoy 需要开启 normalize_path 和 merge_slashes，
+// 否则上游收到的路径可能与鉴权的路径不同
+func (s *Server) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	httpReq := req.GetAttributes().GetRequest().GetHttp()
+
+	// Envoy 传递的请求头名称均为小写
+	headers := httpReq.GetHeaders()
+	cookies := (&http.Request{Header: http.Header{"Cookie": {headers["cookie"]}}}).Cookies()
+	result := s.authorizer.Authorize(ctx, httpReq.GetMethod(), httpReq.GetPath(), gateway.Credentials{
+		Authorization: headers["authorization"],
+		APIKey:        headers["x-api-key"],
+		Cookies:       cookies,
+		Method:        httpReq.GetMethod(),
+		CSRFToken:     headers["x-csrf-token"],
+	})
+	if result.Status != http.StatusOK {
+		return deniedResponse(result), nil
+	}
+
+	// 覆盖或删除客户端自行携带的身份请求头，上游服务只信任这里注入的值
+	ok := &authv3.OkHttpResponse{}
+	if result.Claims != nil {
+		ok.Headers = []*corev3.HeaderValueOption{
+			header("x-user-id", strconv.FormatUint(uint64(result.Claims.UserID), 10)),
+			header("x-user-role", result.Claims.Role),
+			header("x-username", result.Claims.Username),
+		}
+		if result.Claims.Act != nil {
+			ok.Headers = append(ok.Headers, header("x-impersonated-by", result.Claims.Act.Username))
+		} else {
+			ok.HeadersToRemove = []string{"x-impersonated-by"}
+		}
+	} else {
+		ok.HeadersToRemove = []string{"x-user-id", "x-user-role", "x-username", "x-impersonated-by"}
+	}
+
+	return &authv3.CheckResponse{
+		Status:       &status.Status{Code: int32(code.Code_OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{OkResponse: ok},
+	}, nil
+}
+
+// ListenAndServe 在 addr 上启动 ext_authz gRPC 服务，addr 为 unix:/path 时监听 unix socket。
+// tlsConfig 为 nil 时使用明文连接，这时只应该监听回环地址或 unix socket
+func ListenAndServe(addr string, authorizer *gateway.Authorizer, tlsConfig *tls.Config) (*grpc.Server, error) {
+	network := "tcp"
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		network, addr = "unix", path
+	}
+	lis, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var opts []grpc.ServerOption
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	srv := grpc.NewServer(opts...)
+	authv3.RegisterAuthorizationServer(srv, NewServer(authorizer))
+
+	go func() {
+		if err := srv.Serve(lis); err != nil {
+			log.Printf("ext_authz server stopped: %v", err)
+		}
+	}()
+	return srv, nil
+}
+
+func deniedResponse(result *gateway.AuthResult) *authv3.CheckResponse {
+	rpcCode := code.Code_PERMISSION_DENIED
+	if result.Status == http.StatusUnauthorized {
+		rpcCode = code.Code_UNAUTHENTICATED
+	} else if result.Status == http.StatusBadRequest {
+		rpcCode = code.Code_INVALID_ARGUMENT
+	} else if result.Status == http.StatusInternalServerError {
+		rpcCode = code.Code_INTERNAL
+	}
+
+	body, _ := json.Marshal(map[string]string{"error": result.Message})
+
+	return &authv3.CheckResponse{
+		Status: &status.Status{Code: int32(rpcCode), Message: result.Message},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{
+			DeniedResponse: &authv3.DeniedHttpResponse{
+				Status:  &typev3.HttpStatus{Code: typev3.StatusCode(result.Status)},
+				Headers: []*corev3.HeaderValueOption{header("content-type", "application/json; charset=utf-8")},
+				Body:    string(body),
+			},
+		},
+	}
+}
+
+func header(key, value string) *corev3.HeaderValueOption {
+	return &corev3.HeaderValueOption{
+		Header:       &corev3.HeaderValue{Key: key, Value: value},
+		AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+	}
+}
@@ -0,0 +1,180 @@
+package extauthz
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/gin-gonic/gin"
+	"github.com/shenjing023/rbac-api-gateway/internal/gateway"
+	"github.com/shenjing023/rbac-api-gateway/internal/rbac"
+	"github.com/shenjing023/rbac-api-gateway/pkg/jwt"
+	"google.golang.org/genproto/googleapis/rpc/code"
+)
+
+func TestMain(m *testing.M) {
+	if err := jwt.Init(jwt.Config{Secret: []byte(strings.Repeat("s", 40)), Issuer: "test", Expiration: time.Hour}); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// failingAccounts 模拟账号状态查询失败
+type failingAccounts struct{}
+
+func (failingAccounts) IsActive(ctx context.Context, userID uint) (bool, error) {
+	return false, errors.New("db down")
+}
+
+func newTestServer(t *testing.T, accounts gateway.AccountChecker) *Server {
+	t.Helper()
+	engine, err := rbac.NewEmbeddedEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes := gateway.NewRouteMatcher(gin.RoutesInfo{
+		{Method: http.MethodGet, Path: "/rbac/roles"},
+		{Method: http.MethodGet, Path: "/auth/login/:provider"},
+	})
+	authenticator := gateway.NewAuthenticator(nil, nil, nil, accounts)
+	return NewServer(gateway.NewAuthorizer(authenticator, rbac.NewPermissionChecker(engine), routes))
+}
+
+func bearer(t *testing.T, claims jwt.Claims) string {
+	t.Helper()
+	token, err := jwt.IssueToken(claims, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "Bearer " + token
+}
+
+func checkRequest(path string, headers map[string]string) *authv3.CheckRequest {
+	return &authv3.CheckRequest{Attributes: &authv3.AttributeContext{
+		Request: &authv3.AttributeContext_Request{
+			Http: &authv3.AttributeContext_HttpRequest{Method: http.MethodGet, Path: path, Headers: headers},
+		},
+	}}
+}
+
+func TestCheckDenied(t *testing.T) {
+	user := bearer(t, jwt.Claims{UserID: 2, Username: "alice", Role: "user"})
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		accounts      gateway.AccountChecker
+		wantCode      code.Code
+		wantStatus    int
+	}{
+		{"missing credentials", "/rbac/roles", "", nil, code.Code_UNAUTHENTICATED, http.StatusUnauthorized},
+		{"invalid token", "/rbac/roles", "Bearer garbage", nil, code.Code_UNAUTHENTICATED, http.StatusUnauthorized},
+		{"denied by policy", "/rbac/roles", user, nil, code.Code_PERMISSION_DENIED, http.StatusForbidden},
+		{"account lookup fails", "/rbac/roles", user, failingAccounts{}, code.Code_INTERNAL, http.StatusInternalServerError},
+		// Envoy 没有规范化路径时，免认证路径的前缀加上 ".." 不能绕过认证
+		{"traversal", "/auth/login/../../rbac/roles", "", nil, code.Code_INVALID_ARGUMENT, http.StatusBadRequest},
+		{"encoded dots", "/auth/login/%2e%2e/%2e%2e/rbac/roles", "", nil, code.Code_INVALID_ARGUMENT, http.StatusBadRequest},
+		{"double slash", "/auth/login//rbac/roles", "", nil, code.Code_INVALID_ARGUMENT, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := map[string]string{}
+			if tt.authorization != "" {
+				headers["authorization"] = tt.authorization
+			}
+			resp, err := newTestServer(t, tt.accounts).Check(context.Background(), checkRequest(tt.path, headers))
+			if err != nil {
+				t.Fatalf("Check: %v", err)
+			}
+			if got := code.Code(resp.GetStatus().GetCode()); got != tt.wantCode {
+				t.Errorf("rpc code = %v, want %v", got, tt.wantCode)
+			}
+			denied := resp.GetDeniedResponse()
+			if denied == nil {
+				t.Fatalf("response = %v, want a denied response", resp)
+			}
+			if int(denied.GetStatus().GetCode()) != tt.wantStatus {
+				t.Errorf("http status = %d, want %d", denied.GetStatus().GetCode(), tt.wantStatus)
+			}
+			var body map[string]string
+			if err := json.Unmarshal([]byte(denied.GetBody()), &body); err != nil || body["error"] == "" {
+				t.Errorf("body = %s, want a json error", denied.GetBody())
+			}
+		})
+	}
+}
+
+func TestCheckIdentityHeaders(t *testing.T) {
+	admin := jwt.Claims{UserID: 1, Username: "root", Role: "admin", AMR: []string{"mfa"}}
+	impersonated := jwt.Claims{UserID: 2, Username: "alice", Role: "admin", AMR: []string{"mfa"}, Act: &jwt.Actor{UserID: 1, Username: "root"}}
+	// 客户端伪造的身份请求头
+	forged := map[string]string{"x-user-id": "99", "x-user-role": "admin", "x-username": "mallory", "x-impersonated-by": "mallory"}
+
+	tests := []struct {
+		name       string
+		path       string
+		claims     *jwt.Claims
+		wantSet    map[string]string
+		wantRemove []string
+	}{
+		{"authenticated", "/rbac/roles", &admin,
+			map[string]string{"x-user-id": "1", "x-user-role": "admin", "x-username": "root"}, []string{"x-impersonated-by"}},
+		{"impersonation", "/rbac/roles", &impersonated,
+			map[string]string{"x-user-id": "2", "x-user-role": "admin", "x-username": "alice", "x-impersonated-by": "root"}, nil},
+		// 免认证的路径没有身份，客户端携带的身份请求头全部删除
+		{"excluded path", "/auth/login/github", nil,
+			nil, []string{"x-user-id", "x-user-role", "x-username", "x-impersonated-by"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := map[string]string{}
+			for k, v := range forged {
+				headers[k] = v
+			}
+			if tt.claims != nil {
+				headers["authorization"] = bearer(t, *tt.claims)
+			}
+			resp, err := newTestServer(t, nil).Check(context.Background(), checkRequest(tt.path, headers))
+			if err != nil {
+				t.Fatalf("Check: %v", err)
+			}
+			ok := resp.GetOkResponse()
+			if code.Code(resp.GetStatus().GetCode()) != code.Code_OK || ok == nil {
+				t.Fatalf("response = %v, want ok", resp)
+			}
+
+			set := map[string]string{}
+			for _, h := range ok.GetHeaders() {
+				if h.GetAppendAction() != corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD {
+					t.Errorf("header %s append action = %v, want overwrite", h.GetHeader().GetKey(), h.GetAppendAction())
+				}
+				set[h.GetHeader().GetKey()] = h.GetHeader().GetValue()
+			}
+			if len(set) != len(tt.wantSet) {
+				t.Errorf("headers = %v, want %v", set, tt.wantSet)
+			}
+			for k, v := range tt.wantSet {
+				if set[k] != v {
+					t.Errorf("header %s = %q, want %q", k, set[k], v)
+				}
+			}
+			// 客户端携带的每个身份请求头要么被覆盖，要么被删除
+			for k := range forged {
+				if _, overwritten := set[k]; !overwritten && !slices.Contains(ok.GetHeadersToRemove(), k) {
+					t.Errorf("client header %s is passed to the upstream", k)
+				}
+			}
+			if !slices.Equal(ok.GetHeadersToRemove(), tt.wantRemove) {
+				t.Errorf("headers to remove = %v, want %v", ok.GetHeadersToRemove(), tt.wantRemove)
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package gateway
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/x509"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/shenjing023/rbac-api-gateway/internal/apikey"
+	"github.com/shenjing023/rbac-api-gateway/pkg/jwt"
+)
+
+// Credentials 是一次请求中可用于认证的凭据
+type Credentials struct {
+	Authorization string
+	// APIKey 来自 X-API-Key 请求头，也可以通过 "Authorization: ApiKey <key>" 传递
+	APIKey string
+	// VerifiedChains 是 TLS 握手中已经通过 CA 校验的客户端证书链
+	VerifiedChains [][]*x509.Certificate
+	// Cookies 是请求携带的 cookie，启用了会话 cookie 时在没有其他凭据的情况下使用
+	Cookies []*http.Cookie
+	// Method 和 CSRFToken 用于校验会话 cookie 请求的 CSRF token，CSRFToken 来自 X-CSRF-Token 请求头
+	Method    string
+	CSRFToken string
+}
+
+// CSRFHeader 是浏览器客户端使用会话 cookie 时回传 CSRF token 的请求头
+const CSRFHeader = "X-CSRF-Token"
+
+// APIKeyResolver 校验 API key 和个人访问令牌，并返回所属用户的身份
+type APIKeyResolver interface {
+	ResolveAPIKey(ctx context.Context, key string) (*jwt.Claims, error)
+	ResolveToken(ctx context.Context, token string) (*jwt.Claims, error)
+}
+
+// RevocationChecker 判断 JWT 是否已在过期前被吊销（登出、OAuth2 吊销、修改密码等）
+type RevocationChecker interface {
+	IsRevoked(ctx context.Context, claims *jwt.Claims) (bool, error)
+}
+
+// SessionTracker 是可选的，RevocationChecker 同时实现了它时，每次 JWT 认证成功后记录会话的最后活动时间。
+// 实现需要足够轻量，不能阻塞请求
+type SessionTracker interface {
+	TouchSession(jti string)
+}
+
+// AccountChecker 判断账号当前是否可以访问接口（未验证邮箱、停用或注销的账号不可以）
+type AccountChecker interface {
+	IsActive(ctx context.Context, userID uint) (bool, error)
+}
+
+// Authenticator 依次尝试 API key、bearer token（JWT 或个人访问令牌）、客户端证书和浏览器会话 cookie 认证
+type Authenticator struct {
+	certificates *CertificateAuthenticator
+	apiKeys      APIKeyResolver
+	revocations  RevocationChecker
+	accounts     AccountChecker
+	// sessionCookie 是保存登录 token 的 cookie 名称，为空时不使用 cookie 认证
+	sessionCookie string
+}
+
+// NewAuthenticator 创建认证器，certificates、apiKeys、revocations 或 accounts 为 nil 时不启用对应的功能
+func NewAuthenticator(certificates *CertificateAuthenticator, apiKeys APIKeyResolver, revocations RevocationChecker, accounts AccountChecker) *Authenticator {
+	return &Authenticator{certificates: certificates, apiKeys: apiKeys, revocations: revocations, accounts: accounts}
+}
+
+// SetSessionCookie 启用浏览器会话 cookie 认证，name 是保存登录 token 的 cookie
+func (a *Authenticator) SetSessionCookie(name string) {
+	a.sessionCookie = name
+}
+
+// Authenticate 返回认证得到的身份，失败时返回对应的 HTTP 状态码和错误信息。
+// 请求携带了 API key 或 Authorization 时只使用对应的方式认证，不会退回到客户端证书。
+// 无论使用哪种凭据，账号（以及模拟用户的管理员）不是 active 状态时都会被拒绝
+func (a *Authenticator) Authenticate(ctx context.Context, creds Credentials) (*jwt.Claims, int, string) {
+	claims, code, msg := a.authenticate(ctx, creds)
+	if claims == nil || a.accounts == nil || claims.UserID == 0 {
+		return claims, code, msg
+	}
+
+	userIDs := []uint{claims.UserID}
+	// 模拟用户的 token 还要求管理员本人的账号可用
+	if claims.Act != nil {
+		userIDs = append(userIDs, claims.Act.UserID)
+	}
+	for _, userID := range userIDs {
+		active, err := a.accounts.IsActive(ctx, userID)
+		if err != nil {
+			log.Printf("check account status failed: %v", err)
+			return nil, http.StatusInternalServerError, "认证失败"
+		}
+		if !active {
+			return nil, http.StatusUnauthorized, "账号不可用"
+		}
+	}
+	return claims, code, msg
+}
+
+func (a *Authenticator) authenticate(ctx context.Context, creds Credentials) (*jwt.Claims, int, string) {
+	if key, ok := strings.CutPrefix(creds.Authorization, "ApiKey "); ok {
+		creds.APIKey = key
+	} else if creds.Authorization != "" {
+		return a.authenticateBearer(ctx, creds.Authorization)
+	}
+
+	if creds.APIKey != "" {
+		return a.authenticateAPIKey(ctx, creds.APIKey)
+	}
+	if a.certificates != nil && len(creds.VerifiedChains) > 0 {
+		return a.certificates.Authenticate(ctx, creds.VerifiedChains[0][0])
+	}
+	if token := a.cookieToken(creds.Cookies); token != "" {
+		return a.authenticateCookie(ctx, token, creds)
+	}
+	return a.authenticateBearer(ctx, creds.Authorization)
+}
+
+func (a *Authenticator) cookieToken(cookies []*http.Cookie) string {
+	if a.sessionCookie == "" {
+		return ""
+	}
+	for _, cookie := range cookies {
+		if cookie.Name == a.sessionCookie {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// authenticateCookie 校验会话 cookie 中的 token。浏览器会自动携带 cookie，
+// 所以状态变更的请求必须在 X-CSRF-Token 请求头中回传 token 里的 CSRF token，
+// 跨站的页面读不到这个值；它签名在 token 中，服务端不需要另外保存
+func (a *Authenticator) authenticateCookie(ctx context.Context, token string, creds Credentials) (*jwt.Claims, int, string) {
+	claims, code, msg := a.authenticateJWT(ctx, token)
+	if code != http.StatusOK {
+		return nil, code, msg
+	}
+	// 只接受登录时为 cookie 签发的 token
+	if claims.CSRF == "" {
+		return nil, http.StatusUnauthorized, "无效的token"
+	}
+	if !isSafeMethod(creds.Method) && subtle.ConstantTimeCompare([]byte(creds.CSRFToken), []byte(claims.CSRF)) != 1 {
+		return nil, http.StatusForbidden, "CSRF token 校验失败"
+	}
+	return claims, http.StatusOK, ""
+}
+
+// isSafeMethod 判断请求方法是否不会修改状态，这些请求不需要 CSRF token
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+func (a *Authenticator) authenticateAPIKey(ctx context.Context, key string) (*jwt.Claims, int, string) {
+	if a.apiKeys == nil {
+		return nil, http.StatusUnauthorized, "不支持 API key 认证"
+	}
+	claims, err := a.apiKeys.ResolveAPIKey(ctx, key)
+	if err != nil {
+		return nil, http.StatusUnauthorized, "无效的API key"
+	}
+	return claims, http.StatusOK, ""
+}
+
+// authenticateBearer 校验 Authorization 请求头中的 JWT 或个人访问令牌
+func (a *Authenticator) authenticateBearer(ctx context.Context, authorization string) (*jwt.Claims, int, string) {
+	token := strings.TrimPrefix(authorization, "Bearer ")
+	if token == "" {
+		return nil, http.StatusUnauthorized, "未提供认证token"
+	}
+
+	if apikey.IsPersonalToken(token) {
+		if a.apiKeys == nil {
+			return nil, http.StatusUnauthorized, "无效的token"
+		}
+		claims, err := a.apiKeys.ResolveToken(ctx, token)
+		if err != nil {
+			return nil, http.StatusUnauthorized, "无效的token"
+		}
+		return claims, http.StatusOK, ""
+	}
+
+	return a.authenticateJWT(ctx, token)
+}
+
+// authenticateJWT 校验登录签发的 JWT 是否有效且没有被吊销
+func (a *Authenticator) authenticateJWT(ctx context.Context, token string) (*jwt.Claims, int, string) {
+	claims, err := jwt.ValidateToken(token)
+	if err != nil {
+		return nil, http.StatusUnauthorized, "无效的token"
+	}
+	if a.revocations != nil {
+		revoked, err := a.revocations.IsRevoked(ctx, claims)
+		if err != nil {
+			log.Printf("check token revocation failed: %v", err)
+			return nil, http.StatusInternalServerError, "认证失败"
+		}
+		if revoked {
+			return nil, http.StatusUnauthorized, "token已失效"
+		}
+		if tracker, ok := a.revocations.(SessionTracker); ok {
+			tracker.TouchSession(claims.ID)
+		}
+	}
+	return claims, http.StatusOK, ""
+}
+
+// ServiceAccountResolver 根据证书中的身份查找服务账号及其角色
+type ServiceAccountResolver interface {
+	ResolveServiceAccount(ctx context.Context, identity string) (*jwt.Claims, error)
+}
+
+// IdentitySource 指定从客户端证书的哪个字段取服务账号身份
+type IdentitySource string
+
+const (
+	IdentitySubjectCN IdentitySource = "subject_cn"
+	IdentityDNSSAN    IdentitySource = "dns_san"
+	IdentityURISAN    IdentitySource = "uri_san" // 例如 SPIFFE ID
+	IdentityEmailSAN  IdentitySource = "email_san"
+)
+
+// CertificateAuthenticator 把客户端证书映射为服务账号身份，供服务间调用使用
+type CertificateAuthenticator struct {
+	resolver ServiceAccountResolver
+	source   IdentitySource
+}
+
+func NewCertificateAuthenticator(resolver ServiceAccountResolver, source IdentitySource) *CertificateAuthenticator {
+	return &CertificateAuthenticator{resolver: resolver, source: source}
+}
+
+func (a *CertificateAuthenticator) Authenticate(ctx context.Context, cert *x509.Certificate) (*jwt.Claims, int, string) {
+	identity := a.identity(cert)
+	if identity == "" {
+		return nil, http.StatusUnauthorized, "客户端证书中没有服务账号身份"
+	}
+
+	claims, err := a.resolver.ResolveServiceAccount(ctx, identity)
+	if err != nil {
+		log.Printf("mtls: identity=%s err=%v", identity, err)
+		return nil, http.StatusUnauthorized, "无效的客户端证书"
+	}
+	claims.AMR = []string{jwt.AMRCert}
+	return claims, http.StatusOK, ""
+}
+
+func (a *CertificateAuthenticator) identity(cert *x509.Certificate) string {
+	switch a.source {
+	case IdentityDNSSAN:
+		if len(cert.DNSNames) > 0 {
+			return cert.DNSNames[0]
+		}
+	case IdentityURISAN:
+		if len(cert.URIs) > 0 {
+			return cert.URIs[0].String()
+		}
+	case IdentityEmailSAN:
+		if len(cert.EmailAddresses) > 0 {
+			return cert.EmailAddresses[0]
+		}
+	default:
+		return cert.Subject.CommonName
+	}
+	return ""
+}
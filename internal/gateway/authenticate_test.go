@@ -0,0 +1,277 @@
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shenjing023/rbac-api-gateway/pkg/jwt"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	if err := jwt.Init(jwt.Config{Secret: []byte(strings.Repeat("s", 40)), Issuer: "test", Expiration: time.Hour}); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// fakeAccounts 中没有出现的用户都是 active，值为 false 的用户不可用
+type fakeAccounts struct {
+	active map[uint]bool
+	err    error
+}
+
+func (f fakeAccounts) IsActive(ctx context.Context, userID uint) (bool, error) {
+	if f.err != nil {
+		return false, f.err
+	}
+	active, ok := f.active[userID]
+	return active || !ok, nil
+}
+
+// fakeAPIKeys 接受任意 API key，身份是 claims
+type fakeAPIKeys struct {
+	claims jwt.Claims
+}
+
+func (f fakeAPIKeys) ResolveAPIKey(ctx context.Context, key string) (*jwt.Claims, error) {
+	claims := f.claims
+	return &claims, nil
+}
+
+func (f fakeAPIKeys) ResolveToken(ctx context.Context, token string) (*jwt.Claims, error) {
+	return nil, errors.New("not supported")
+}
+
+func issueTestToken(t *testing.T, claims jwt.Claims) string {
+	t.Helper()
+	token, err := jwt.IssueToken(claims, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestAuthenticateAccountStatus(t *testing.T) {
+	alice := jwt.Claims{UserID: 1, Username: "alice", Role: "user"}
+	impersonated := alice
+	impersonated.Act = &jwt.Actor{UserID: 2, Username: "admin"}
+
+	tests := []struct {
+		name     string
+		accounts fakeAccounts
+		creds    Credentials
+		wantCode int
+	}{
+		{"active user", fakeAccounts{}, Credentials{Authorization: "Bearer " + issueTestToken(t, alice)}, http.StatusOK},
+		{"suspended user", fakeAccounts{active: map[uint]bool{1: false}}, Credentials{Authorization: "Bearer " + issueTestToken(t, alice)}, http.StatusUnauthorized},
+		// 已签发的 API key 在账号停用后同样不能使用
+		{"api key of suspended user", fakeAccounts{active: map[uint]bool{1: false}}, Credentials{APIKey: "key"}, http.StatusUnauthorized},
+		{"impersonation by active admin", fakeAccounts{}, Credentials{Authorization: "Bearer " + issueTestToken(t, impersonated)}, http.StatusOK},
+		{"impersonation by suspended admin", fakeAccounts{active: map[uint]bool{2: false}}, Credentials{Authorization: "Bearer " + issueTestToken(t, impersonated)}, http.StatusUnauthorized},
+		{"status lookup fails", fakeAccounts{err: errors.New("db down")}, Credentials{Authorization: "Bearer " + issueTestToken(t, alice)}, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAuthenticator(nil, fakeAPIKeys{claims: alice}, nil, tt.accounts)
+			claims, code, msg := a.Authenticate(context.Background(), tt.creds)
+			if code != tt.wantCode {
+				t.Fatalf("Authenticate code = %d (%s), want %d", code, msg, tt.wantCode)
+			}
+			if (claims != nil) != (tt.wantCode == http.StatusOK) {
+				t.Errorf("Authenticate claims = %+v with code %d", claims, code)
+			}
+		})
+	}
+}
+
+// fakeRevocations 吊销 jti 在 revoked 中的 token
+type fakeRevocations struct {
+	revoked map[string]bool
+}
+
+func (f fakeRevocations) IsRevoked(ctx context.Context, claims *jwt.Claims) (bool, error) {
+	return f.revoked[claims.ID], nil
+}
+
+func TestCookieCSRF(t *testing.T) {
+	const cookieName = "session"
+	cookieClaims := jwt.Claims{UserID: 1, Username: "alice", Role: "user", CSRF: "csrf-1"}
+	cookieToken := issueTestToken(t, cookieClaims)
+	revokedClaims := cookieClaims
+	revokedClaims.ID = "revoked"
+	// 通过 Authorization 使用的 token 没有 CSRF 声明
+	bearerToken := issueTestToken(t, jwt.Claims{UserID: 1, Username: "alice", Role: "user"})
+
+	tests := []struct {
+		name          string
+		cookieEnabled bool
+		method        string
+		cookie        string // 为空表示不携带会话 cookie
+		csrf          string
+		authorization string
+		wantCode      int
+	}{
+		{"get without csrf header", true, http.MethodGet, cookieToken, "", "", http.StatusOK},
+		{"head without csrf header", true, http.MethodHead, cookieToken, "", "", http.StatusOK},
+		{"options without csrf header", true, http.MethodOptions, cookieToken, "", "", http.StatusOK},
+		{"post with matching csrf header", true, http.MethodPost, cookieToken, "csrf-1", "", http.StatusOK},
+		{"put with matching csrf header", true, http.MethodPut, cookieToken, "csrf-1", "", http.StatusOK},
+		{"patch with matching csrf header", true, http.MethodPatch, cookieToken, "csrf-1", "", http.StatusOK},
+		{"delete with matching csrf header", true, http.MethodDelete, cookieToken, "csrf-1", "", http.StatusOK},
+		{"post without csrf header", true, http.MethodPost, cookieToken, "", "", http.StatusForbidden},
+		{"post with wrong csrf header", true, http.MethodPost, cookieToken, "csrf-2", "", http.StatusForbidden},
+		{"delete without csrf header", true, http.MethodDelete, cookieToken, "", "", http.StatusForbidden},
+		// 非 cookie 登录签发的 token 不能放进 cookie 使用，即使是安全的方法
+		{"token without csrf claim", true, http.MethodGet, bearerToken, "", "", http.StatusUnauthorized},
+		{"token without csrf claim and empty header", true, http.MethodPost, bearerToken, "", "", http.StatusUnauthorized},
+		{"revoked token", true, http.MethodGet, issueTestToken(t, revokedClaims), "", "", http.StatusUnauthorized},
+		{"invalid token", true, http.MethodGet, "garbage", "", "", http.StatusUnauthorized},
+		{"cookie auth disabled", false, http.MethodGet, cookieToken, "", "", http.StatusUnauthorized},
+		// 请求带有 Authorization 时不使用 cookie，浏览器不会自动携带 Authorization，不需要 CSRF token
+		{"authorization takes precedence", true, http.MethodPost, cookieToken, "", "Bearer " + bearerToken, http.StatusOK},
+		{"invalid authorization does not fall back to cookie", true, http.MethodGet, cookieToken, "", "Bearer garbage", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAuthenticator(nil, nil, fakeRevocations{revoked: map[string]bool{"revoked": true}}, nil)
+			if tt.cookieEnabled {
+				a.SetSessionCookie(cookieName)
+			}
+			r := gin.New()
+			r.Use(AuthMiddleware(a))
+			r.Any("/users", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			req := httptest.NewRequest(tt.method, "/users", nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: cookieName, Value: tt.cookie})
+			}
+			if tt.csrf != "" {
+				req.Header.Set(CSRFHeader, tt.csrf)
+			}
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Errorf("%s /users = %d %s, want %d", tt.method, w.Code, w.Body.String(), tt.wantCode)
+			}
+		})
+	}
+}
+
+// fakeServiceAccounts 按证书中的身份查找服务账号，没有出现的身份不存在
+type fakeServiceAccounts map[string]jwt.Claims
+
+func (f fakeServiceAccounts) ResolveServiceAccount(ctx context.Context, identity string) (*jwt.Claims, error) {
+	claims, ok := f[identity]
+	if !ok {
+		return nil, errors.New("服务账号不存在")
+	}
+	return &claims, nil
+}
+
+func TestCertificateAuthentication(t *testing.T) {
+	accounts := fakeServiceAccounts{
+		"billing":                            {UserID: 10, Username: "billing", Role: "admin"},
+		"billing.svc.cluster.local":          {UserID: 11, Username: "billing.svc.cluster.local", Role: "user"},
+		"spiffe://example.org/ns/prod/sa/ci": {UserID: 12, Username: "spiffe://example.org/ns/prod/sa/ci", Role: "user"},
+	}
+	spiffe, _ := url.Parse("spiffe://example.org/ns/prod/sa/ci")
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "billing"},
+		DNSNames: []string{"billing.svc.cluster.local"},
+		URIs:     []*url.URL{spiffe},
+	}
+	unknown := &x509.Certificate{Subject: pkix.Name{CommonName: "mallory"}}
+	chain := func(c *x509.Certificate) [][]*x509.Certificate { return [][]*x509.Certificate{{c}} }
+
+	tests := []struct {
+		name     string
+		source   IdentitySource
+		state    *tls.ConnectionState
+		wantCode int
+		wantUser uint
+	}{
+		{"subject cn", IdentitySubjectCN, &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: chain(cert)}, http.StatusOK, 10},
+		{"dns san", IdentityDNSSAN, &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: chain(cert)}, http.StatusOK, 11},
+		{"uri san", IdentityURISAN, &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: chain(cert)}, http.StatusOK, 12},
+		// 没有通过 CA 校验的证书（例如 client_auth 为 request 时）不能用来认证
+		{"unverified chain", IdentitySubjectCN, &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}, http.StatusUnauthorized, 0},
+		{"unknown subject", IdentitySubjectCN, &tls.ConnectionState{PeerCertificates: []*x509.Certificate{unknown}, VerifiedChains: chain(unknown)}, http.StatusUnauthorized, 0},
+		{"missing identity", IdentityEmailSAN, &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: chain(cert)}, http.StatusUnauthorized, 0},
+		{"plain http", IdentitySubjectCN, nil, http.StatusUnauthorized, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAuthenticator(NewCertificateAuthenticator(accounts, tt.source), nil, nil, nil)
+			r := gin.New()
+			r.Use(AuthMiddleware(a))
+			var gotUser uint
+			var gotAMR []string
+			r.GET("/users", func(c *gin.Context) {
+				gotUser, gotAMR = c.GetUint("user_id"), c.GetStringSlice("amr")
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/users", nil)
+			req.TLS = tt.state
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Fatalf("GET /users = %d %s, want %d", w.Code, w.Body.String(), tt.wantCode)
+			}
+			if gotUser != tt.wantUser {
+				t.Errorf("user_id = %d, want %d", gotUser, tt.wantUser)
+			}
+			// 证书认证的 amr 只有 cert，策略据此免除多因素认证
+			if tt.wantCode == http.StatusOK && !slices.Equal(gotAMR, []string{jwt.AMRCert}) {
+				t.Errorf("amr = %v, want [%s]", gotAMR, jwt.AMRCert)
+			}
+		})
+	}
+}
+
+func TestCertificateDoesNotOverrideExplicitCredentials(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "billing"}}
+	a := NewAuthenticator(
+		NewCertificateAuthenticator(fakeServiceAccounts{"billing": {UserID: 10, Username: "billing", Role: "admin"}}, IdentitySubjectCN),
+		fakeAPIKeys{claims: jwt.Claims{UserID: 1, Username: "alice", Role: "user", AMR: []string{jwt.AMRKey}}}, nil, nil)
+	chains := [][]*x509.Certificate{{cert}}
+
+	tests := []struct {
+		name     string
+		creds    Credentials
+		wantUser uint
+		wantCode int
+	}{
+		{"certificate only", Credentials{VerifiedChains: chains}, 10, http.StatusOK},
+		{"api key", Credentials{APIKey: "key", VerifiedChains: chains}, 1, http.StatusOK},
+		// 携带了无效的 token 时直接拒绝，不退回到客户端证书
+		{"invalid bearer token", Credentials{Authorization: "Bearer garbage", VerifiedChains: chains}, 0, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, code, msg := a.Authenticate(context.Background(), tt.creds)
+			if code != tt.wantCode {
+				t.Fatalf("Authenticate code = %d (%s), want %d", code, msg, tt.wantCode)
+			}
+			if claims != nil && claims.UserID != tt.wantUser {
+				t.Errorf("user = %d, want %d", claims.UserID, tt.wantUser)
+			}
+		})
+	}
+}
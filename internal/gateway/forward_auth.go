@@ -0,0 +1,52 @@
+package gateway
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ForwardAuthHandler 供 nginx auth_request 和 Traefik ForwardAuth 使用，
+// 按代理转发的原始请求方法和 URI 做认证和鉴权
+func ForwardAuthHandler(authorizer *Authorizer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		method := firstHeader(c, "X-Forwarded-Method", "X-Original-Method")
+		uri := firstHeader(c, "X-Original-URI", "X-Forwarded-Uri")
+		if method == "" || uri == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "缺少原始请求的方法或URI"})
+			return
+		}
+
+		result := authorizer.Authorize(c.Request.Context(), method, uri, Credentials{
+			Authorization: c.GetHeader("Authorization"),
+			APIKey:        c.GetHeader("X-API-Key"),
+			Cookies:       c.Request.Cookies(),
+			Method:        method,
+			CSRFToken:     c.GetHeader(CSRFHeader),
+		})
+		if result.Status != http.StatusOK {
+			c.JSON(result.Status, gin.H{"error": result.Message})
+			return
+		}
+
+		if result.Claims != nil {
+			c.Header("X-User-Id", strconv.FormatUint(uint64(result.Claims.UserID), 10))
+			c.Header("X-User-Role", result.Claims.Role)
+			c.Header("X-Username", result.Claims.Username)
+			if result.Claims.Act != nil {
+				c.Header(ImpersonatedByHeader, result.Claims.Act.Username)
+			}
+		}
+		c.Status(http.StatusOK)
+	}
+}
+
+func firstHeader(c *gin.Context, keys ...string) string {
+	for _, key := range keys {
+		if v := c.GetHeader(key); v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,78 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shenjing023/rbac-api-gateway/internal/rbac"
+	"github.com/shenjing023/rbac-api-gateway/pkg/jwt"
+)
+
+// newTestAuthorizer 返回使用内置策略的 Authorizer，只认证 Authorization 中的 token
+func newTestAuthorizer(t *testing.T) *Authorizer {
+	t.Helper()
+	engine, err := rbac.NewEmbeddedEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes := NewRouteMatcher(gin.RoutesInfo{
+		{Method: http.MethodGet, Path: "/rbac/roles"},
+		{Method: http.MethodGet, Path: "/posts/:id"},
+		{Method: http.MethodGet, Path: "/auth/login/:provider"},
+	})
+	return NewAuthorizer(NewAuthenticator(nil, nil, nil, nil), rbac.NewPermissionChecker(engine), routes)
+}
+
+func TestForwardAuthPathNormalization(t *testing.T) {
+	admin := "Bearer " + issueTestToken(t, jwt.Claims{UserID: 1, Username: "root", Role: "admin", AMR: []string{"mfa"}})
+	user := "Bearer " + issueTestToken(t, jwt.Claims{UserID: 2, Username: "alice", Role: "user"})
+
+	tests := []struct {
+		name          string
+		uri           string
+		authorization string
+		wantCode      int
+	}{
+		{"excluded path", "/auth/login/github?redirect=/", "", http.StatusOK},
+		{"excluded exact path", "/posts", "", http.StatusOK},
+		{"protected path", "/rbac/roles", "", http.StatusUnauthorized},
+		{"protected path with query", "/rbac/roles?q=/auth/login/", "", http.StatusUnauthorized},
+		{"allowed role", "/rbac/roles", admin, http.StatusOK},
+		{"denied role", "/rbac/roles", user, http.StatusForbidden},
+		// 解码后按 gin 的路由匹配，编码的普通字符不能绕过策略
+		{"encoded letters", "/%72bac/roles", user, http.StatusForbidden},
+		{"trailing slash", "/rbac/roles/", user, http.StatusForbidden},
+		// 免认证路径的前缀加上 ".." 或 "//" 不能绕过认证
+		{"traversal", "/auth/login/../../rbac/roles", "", http.StatusBadRequest},
+		{"traversal with token", "/auth/login/../../rbac/roles", admin, http.StatusBadRequest},
+		{"encoded dots", "/auth/login/%2e%2e/%2e%2e/rbac/roles", "", http.StatusBadRequest},
+		{"upper case encoded dots", "/auth/login/%2E%2E/%2E%2E/rbac/roles", "", http.StatusBadRequest},
+		{"single dot", "/auth/login/./github", "", http.StatusBadRequest},
+		{"double slash", "/auth/login//rbac/roles", "", http.StatusBadRequest},
+		{"leading double slash", "//rbac/roles", "", http.StatusBadRequest},
+		{"encoded slash", "/auth/login/..%2f..%2frbac/roles", "", http.StatusBadRequest},
+		{"encoded backslash", "/auth/login/..%5c..%5crbac/roles", "", http.StatusBadRequest},
+		{"invalid escape", "/rbac/%zz", "", http.StatusBadRequest},
+		{"relative uri", "rbac/roles", "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.Any("/auth/verify", ForwardAuthHandler(newTestAuthorizer(t)))
+
+			req := httptest.NewRequest(http.MethodGet, "/auth/verify", nil)
+			req.Header.Set("X-Forwarded-Method", http.MethodGet)
+			req.Header.Set("X-Original-URI", tt.uri)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Errorf("forward auth %s = %d %s, want %d", tt.uri, w.Code, w.Body.String(), tt.wantCode)
+			}
+		})
+	}
+}
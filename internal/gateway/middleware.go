@@ -4,27 +4,34 @@ import (
 	"log"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/shenjing023/rbac-api-gateway/internal/config"
 	"github.com/shenjing023/rbac-api-gateway/internal/rbac"
 	"github.com/shenjing023/rbac-api-gateway/pkg/jwt"
 )
 
-// CORSMiddleware 返回一个 CORS 中间件
-func CORSMiddleware() gin.HandlerFunc {
+// CORSMiddleware 返回一个 CORS 中间件，未配置允许的来源时不允许任何跨域请求
+func CORSMiddleware(cfg config.CORSConfig) gin.HandlerFunc {
+	if len(cfg.AllowOrigins) == 0 {
+		return func(c *gin.Context) { c.Next() }
+	}
+
 	return cors.New(cors.Config{
-		AllowOrigins:     []string{"*"}, // 允许所有来源，您可以根据需要限制特定域名
-		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
-		AllowCredentials: true,
-		MaxAge:           12 * time.Hour,
+		AllowOrigins:     cfg.AllowOrigins,
+		AllowMethods:     cfg.AllowMethods,
+		AllowHeaders:     cfg.AllowHeaders,
+		ExposeHeaders:    cfg.ExposeHeaders,
+		AllowCredentials: cfg.AllowCredentials,
+		MaxAge:           cfg.MaxAge,
 	})
 }
 
-func AuthMiddleware() gin.HandlerFunc {
+// ImpersonatedByHeader 是管理员模拟用户时响应中携带的请求头，值为管理员的用户名
+const ImpersonatedByHeader = "X-Impersonated-By"
+
+func AuthMiddleware(authenticator *Authenticator) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 排除不需要认证的路由
 		if isExcludedPath(c.Request.URL.Path) {
@@ -32,32 +39,70 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		token := c.GetHeader("Authorization")
-		if token == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "未提供认证token"})
-			c.Abort()
-			return
-		}
-
-		claims, err := jwt.ValidateToken(token)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的token"})
+		claims, status, msg := authenticator.Authenticate(c.Request.Context(), requestCredentials(c.Request))
+		if status != http.StatusOK {
+			c.JSON(status, gin.H{"error": msg})
 			c.Abort()
 			return
 		}
 
+		// claims 供 RBACMiddleware 构造鉴权输入，其余字段供处理函数直接读取
+		c.Set("claims", claims)
 		c.Set("user_id", claims.UserID)
 		c.Set("username", claims.Username)
 		c.Set("role", claims.Role)
+		c.Set("scopes", claims.Scopes)
+		c.Set("amr", claims.AMR)
+		c.Set("jti", claims.ID)
+		if claims.Act != nil {
+			// 模拟用户的每个响应都带上实际操作的管理员，方便前端明显地提示
+			c.Set("act", claims.Act)
+			c.Header(ImpersonatedByHeader, claims.Act.Username)
+		}
 		c.Next()
 	}
 }
 
+// requestCredentials 取出请求中的 token、cookie 和已校验的客户端证书
+func requestCredentials(r *http.Request) Credentials {
+	creds := Credentials{
+		Authorization: r.Header.Get("Authorization"),
+		APIKey:        r.Header.Get("X-API-Key"),
+		Cookies:       r.Cookies(),
+		Method:        r.Method,
+		CSRFToken:     r.Header.Get(CSRFHeader),
+	}
+	if r.TLS != nil {
+		creds.VerifiedChains = r.TLS.VerifiedChains
+	}
+	return creds
+}
+
 func isExcludedPath(path string) bool {
 	log.Printf("path: %v\n", path)
 	excludedPaths := []string{
 		"/auth/register",
+		"/auth/registration",
 		"/auth/login",
+		"/auth/verify", // 由 ForwardAuthHandler 自行完成认证和鉴权
+		"/auth/providers",
+		"/auth/mfa/verify", // 使用登录第一步返回的 mfa_token 认证
+		// 找回密码使用通知中的重置令牌
+		"/auth/password/forgot",
+		"/auth/password/reset",
+		// 注册后验证邮箱使用链接中的签名 token
+		"/auth/email/verify",
+		"/auth/email/resend",
+		"/healthz",
+		"/readyz",
+		// OAuth2 客户端在处理函数中自行认证
+		"/oauth/token",
+		"/oauth/introspect",
+		"/oauth/revoke",
+		// OpenID Connect 的 userinfo 自行校验访问令牌，发现文档和公钥是公开的
+		"/userinfo",
+		"/.well-known/openid-configuration",
+		"/.well-known/jwks.json",
 		"/posts",
 		"/posts/:id",
 		// 可以添加其他不需要认证的路径
@@ -68,6 +113,14 @@ func isExcludedPath(path string) bool {
 			return true
 		}
 	}
+
+	// 通过上游身份提供方登录的跳转和回调
+	excludedPrefixes := []string{"/auth/login/"}
+	for _, prefix := range excludedPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
 	return false
 }
 
@@ -78,31 +131,12 @@ func RBACMiddleware(permissionChecker *rbac.PermissionChecker) gin.HandlerFunc {
 			c.Next()
 			return
 		}
-		userID, _ := c.Get("user_id")
-		role, _ := c.Get("role")
-
-		input := &rbac.PermissionInput{
-			Action: c.Request.Method + ":" + c.FullPath(),
-			Resource: struct {
-				Type    string `json:"type"`
-				ID      string `json:"id"`
-				IsOwner bool   `json:"is_owner,omitempty"`
-			}{
-				Type: getResourceTypeFromPath(c.Request.URL.Path),
-				ID:   getParamOrDefault(c, "id", "0"),
-			},
-			User: struct {
-				ID   uint   `json:"id"`
-				Role string `json:"role"`
-			}{
-				ID:   userID.(uint),
-				Role: role.(string),
-			},
-		}
+		claims := c.MustGet("claims").(*jwt.Claims)
+		input := newPermissionInput(c.Request.Method, c.FullPath(), c.Request.URL.Path, getParamOrDefault(c, "id", "0"), claims)
 
 		log.Printf("input: %+v\n", input)
 
-		allowed, err := permissionChecker.CheckPermission(c, input)
+		allowed, err := permissionChecker.CheckPermission(c.Request.Context(), input)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "权限检查失败"})
 			log.Printf("err: %v\n", err)
@@ -110,6 +144,9 @@ func RBACMiddleware(permissionChecker *rbac.PermissionChecker) gin.HandlerFunc {
 			return
 		}
 
+		// 候选策略只做对比评估，不影响本次鉴权结果
+		permissionChecker.ShadowCheck(input, allowed)
+
 		if !allowed {
 			c.JSON(http.StatusForbidden, gin.H{"error": "没有权限执行此操作"})
 			c.Abort()
@@ -0,0 +1,84 @@
+package gateway
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RouteMatcher 在 gin 之外把请求路径匹配到注册的路由模板，例如 "/posts/12" 匹配 "/posts/:id"
+type RouteMatcher struct {
+	routes map[string][]route
+}
+
+type route struct {
+	fullPath string
+	segments []string
+}
+
+// NewRouteMatcher 使用 gin 中已注册的路由创建匹配器，需要在所有路由注册完成后调用
+func NewRouteMatcher(routes gin.RoutesInfo) *RouteMatcher {
+	m := &RouteMatcher{routes: map[string][]route{}}
+	for _, r := range routes {
+		m.routes[r.Method] = append(m.routes[r.Method], route{
+			fullPath: r.Path,
+			segments: splitPath(r.Path),
+		})
+	}
+	return m
+}
+
+// Match 返回匹配到的路由模板和路径参数，静态路由优先于带参数的路由
+func (m *RouteMatcher) Match(method, path string) (string, map[string]string, bool) {
+	segments := splitPath(path)
+
+	var (
+		best       *route
+		bestParams map[string]string
+		bestStatic = -1
+	)
+	for i := range m.routes[method] {
+		r := &m.routes[method][i]
+		params, static, ok := r.match(segments)
+		if ok && static > bestStatic {
+			best, bestParams, bestStatic = r, params, static
+		}
+	}
+	if best == nil {
+		return "", nil, false
+	}
+	return best.fullPath, bestParams, true
+}
+
+// match 返回路径参数以及匹配到的静态片段数量
+func (r *route) match(segments []string) (map[string]string, int, bool) {
+	params := map[string]string{}
+	static := 0
+	for i, seg := range r.segments {
+		switch {
+		case strings.HasPrefix(seg, "*"):
+			params[seg[1:]] = "/" + strings.Join(segments[i:], "/")
+			return params, static, true
+		case i >= len(segments):
+			return nil, 0, false
+		case strings.HasPrefix(seg, ":"):
+			params[seg[1:]] = segments[i]
+		case seg == segments[i]:
+			static++
+		default:
+			return nil, 0, false
+		}
+	}
+	if len(r.segments) != len(segments) {
+		return nil, 0, false
+	}
+	return params, static, true
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
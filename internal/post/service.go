@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
-	"time"
 
 	"github.com/shenjing023/rbac-api-gateway/pkg/cache"
 	"gorm.io/gorm"
@@ -106,6 +105,6 @@ func (pc *PostChecker) CheckResourceOwnership(ctx context.Context, resourceID st
 		return false, nil
 	}
 
-	pc.cache.Set(cacheKey, post.AuthorID, 5*time.Minute)
+	pc.cache.SetDefault(cacheKey, post.AuthorID)
 	return post.AuthorID == userID, nil
 }
@@ -1,10 +1,13 @@
 package rbac
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 type Handler struct {
@@ -109,6 +112,159 @@ func (h *Handler) CheckUserPermission(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"has_permission": hasPermission})
 }
 
+func (h *Handler) ListPolicyRevisions(c *gin.Context) {
+	revisions, err := h.service.ListPolicyRevisions()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取策略版本失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"revisions": revisions, "active_revision": h.service.ActiveRevision()})
+}
+
+func (h *Handler) GetPolicyRevision(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的版本ID"})
+		return
+	}
+
+	rev, err := h.service.GetPolicyRevision(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "策略版本不存在"})
+		return
+	}
+
+	c.JSON(http.StatusOK, rev)
+}
+
+func (h *Handler) CreatePolicyRevision(c *gin.Context) {
+	var req struct {
+		Content  string `json:"content" binding:"required"`
+		Comment  string `json:"comment"`
+		Activate bool   `json:"activate"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rev, err := h.service.CreatePolicyRevision(req.Content, c.GetString("username"), req.Comment, req.Activate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "保存策略失败: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "策略保存成功", "revision_id": rev.ID, "hash": rev.Hash})
+}
+
+func (h *Handler) DiffPolicyRevisions(c *gin.Context) {
+	from, err1 := strconv.ParseUint(c.Query("from"), 10, 32)
+	to, err2 := strconv.ParseUint(c.Query("to"), 10, 32)
+	if err1 != nil || err2 != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的版本ID"})
+		return
+	}
+
+	diff, err := h.service.DiffPolicyRevisions(uint(from), uint(to))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "策略版本不存在"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"diff": diff})
+}
+
+func (h *Handler) RollbackPolicy(c *gin.Context) {
+	var req struct {
+		RevisionID uint `json:"revision_id" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rev, err := h.service.RollbackPolicy(req.RevisionID, c.GetString("username"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "策略版本不存在"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "回滚策略失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "策略回滚成功", "revision_id": rev.ID, "hash": rev.Hash})
+}
+
+// GetActivePolicy 查询当前或 at 参数指定时刻生效的策略版本
+func (h *Handler) GetActivePolicy(c *gin.Context) {
+	at := time.Now()
+	if v := c.Query("at"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "时间格式应为 RFC3339"})
+			return
+		}
+		at = t
+	}
+
+	rev, err := h.service.PolicyRevisionAt(at)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "该时间点没有生效的策略"})
+		return
+	}
+
+	c.JSON(http.StatusOK, rev)
+}
+
+// LoadShadowPolicy 加载候选策略，只在当前实例上进行影子评估
+func (h *Handler) LoadShadowPolicy(c *gin.Context) {
+	var req struct {
+		RevisionID uint   `json:"revision_id"`
+		Content    string `json:"content"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.RevisionID == 0 && req.Content == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "需要提供 revision_id 或 content"})
+		return
+	}
+
+	report, err := h.service.LoadShadowPolicy(req.RevisionID, req.Content)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "加载候选策略失败: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "候选策略已加载", "report": report})
+}
+
+func (h *Handler) GetShadowReport(c *gin.Context) {
+	report := h.service.ShadowReport()
+	if report == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "没有正在评估的候选策略"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+func (h *Handler) StopShadowPolicy(c *gin.Context) {
+	report := h.service.StopShadowPolicy()
+	if report == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "没有正在评估的候选策略"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "候选策略已卸载", "report": report})
+}
+
 func RegisterRoutes(r *gin.Engine, service *Service) {
 	handler := NewHandler(service)
 
@@ -119,5 +275,15 @@ func RegisterRoutes(r *gin.Engine, service *Service) {
 		rbac.POST("/permissions", handler.CreatePermission)
 		rbac.POST("/assign-permission", handler.AssignPermissionToRole)
 		rbac.GET("/check-permission", handler.CheckUserPermission)
+
+		rbac.GET("/policies/revisions", handler.ListPolicyRevisions)
+		rbac.GET("/policies/revisions/:id", handler.GetPolicyRevision)
+		rbac.POST("/policies/revisions", handler.CreatePolicyRevision)
+		rbac.GET("/policies/diff", handler.DiffPolicyRevisions)
+		rbac.POST("/policies/rollback", handler.RollbackPolicy)
+		rbac.GET("/policies/active", handler.GetActivePolicy)
+		rbac.POST("/policies/shadow", handler.LoadShadowPolicy)
+		rbac.GET("/policies/shadow", handler.GetShadowReport)
+		rbac.DELETE("/policies/shadow", handler.StopShadowPolicy)
 	}
 }
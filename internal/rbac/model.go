@@ -22,3 +22,22 @@ type UserRole struct {
 	UserID uint `gorm:"uniqueIndex:idx_user_role"`
 	RoleID uint `gorm:"uniqueIndex:idx_user_role"`
 }
+
+// PolicyRevision 记录策略的每一次修改
+type PolicyRevision struct {
+	gorm.Model
+	Content string `gorm:"type:text;not null" json:",omitempty"`
+	Hash    string `gorm:"index;not null"`
+	// BaseHash 是创建该版本时二进制内置策略的哈希，升级后内置策略变化时据此判断数据库中的版本是否过时
+	BaseHash string `gorm:"index"`
+	Author   string `gorm:"not null"`
+	Comment  string
+	Active   bool `gorm:"index"`
+}
+
+// PolicyActivation 记录策略版本的激活历史，用于查询某一时刻生效的策略
+type PolicyActivation struct {
+	gorm.Model
+	RevisionID  uint   `gorm:"index;not null"`
+	ActivatedBy string `gorm:"not null"`
+}
@@ -2,66 +2,106 @@ package rbac
 
 import (
 	"context"
+	"crypto/sha256"
 	_ "embed"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 
-	"github.com/open-policy-agent/opa/rego"
+	"github.com/shenjing023/rbac-api-gateway/pkg/opa"
 )
 
+// PolicyFile 是内置策略的模块名
+const PolicyFile = "rbac.rego"
+
+// AllowQuery 是权限判定使用的查询
+const AllowQuery = "data.rbac.allow"
+
+// BuiltinRevision 是内置策略的版本标识
+const BuiltinRevision = "builtin"
+
+// allowQueryName 是 AllowQuery 在策略引擎中注册的名称
+const allowQueryName = "allow"
+
+// errPolicyNotReloadable 表示当前策略引擎的策略由外部管理，不能通过本服务修改
+var errPolicyNotReloadable = errors.New("当前策略引擎不支持在线更新策略")
+
 //go:embed rbac.rego
 var policyContent string
 
-var opaQuery rego.PreparedEvalQuery
+// DefaultPolicy 返回编译进二进制的策略内容
+func DefaultPolicy() string {
+	return policyContent
+}
 
-func InitOPA() error {
-	ctx := context.Background()
+// Queries 返回权限判定需要在策略引擎中注册的查询
+func Queries() opa.Queries {
+	return opa.Queries{allowQueryName: AllowQuery}
+}
 
-	// 直接使用嵌入的策略内容
-	query, err := rego.New(
-		rego.Query("data.rbac.allow"),
-		rego.Module("rbac.rego", policyContent),
-	).PrepareForEval(ctx)
+// NewEmbeddedEngine 使用内置策略创建进程内策略引擎，数据库中的版本加载后会替换它
+func NewEmbeddedEngine(opts ...opa.Option) (*opa.RegoEngine, error) {
+	return newPolicyEngine(policyContent, BuiltinRevision, opts...)
+}
 
-	if err != nil {
-		return fmt.Errorf("failed to prepare OPA query: %w", err)
-	}
+func newPolicyEngine(content, revision string, opts ...opa.Option) (*opa.RegoEngine, error) {
+	return opa.NewRegoEngine(Queries(), map[string]string{PolicyFile: content}, revision, opts...)
+}
 
-	opaQuery = query
-	return nil
+func policyHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
 }
 
-func evaluateOPAPolicy(input *PermissionInput) (bool, error) {
-	ctx := context.Background()
+// revisionLabel 是策略版本在引擎和决策日志中的标识
+func revisionLabel(rev *PolicyRevision) string {
+	return fmt.Sprintf("%d:%.12s", rev.ID, rev.Hash)
+}
 
-	// 将输入转换为 map[string]interface{}
-	inputJSON, err := json.Marshal(input)
+// evaluatePolicy 使用 engine 评估权限，并记录带策略版本的决策日志
+func evaluatePolicy(ctx context.Context, engine opa.PolicyEngine, input *PermissionInput) (bool, error) {
+	allowed, revision, inputJSON, err := decide(ctx, engine, input)
 	if err != nil {
-		return false, fmt.Errorf("failed to marshal input: %w", err)
+		return false, err
 	}
 
-	log.Printf("inputJSON: %+v\n", string(inputJSON))
+	// 决策日志记录生效的策略版本，便于审计时追溯
+	log.Printf("decision: revision=%s allow=%v input=%s\n", revision, allowed, inputJSON)
 
-	var inputMap map[string]interface{}
-	if err := json.Unmarshal(inputJSON, &inputMap); err != nil {
-		return false, fmt.Errorf("failed to unmarshal input: %w", err)
+	return allowed, nil
+}
+
+// decide 评估 allow 查询，返回结论、策略版本和序列化后的输入
+func decide(ctx context.Context, engine opa.PolicyEngine, input *PermissionInput) (bool, string, []byte, error) {
+	inputJSON, inputMap, err := toInputMap(input)
+	if err != nil {
+		return false, "", nil, err
 	}
 
-	// 评估 OPA 策略
-	results, err := opaQuery.Eval(ctx, rego.EvalInput(inputMap))
+	decision, err := engine.Eval(ctx, allowQueryName, inputMap)
 	if err != nil {
-		return false, fmt.Errorf("failed to evaluate OPA policy: %w", err)
+		return false, "", inputJSON, err
 	}
 
-	if len(results) == 0 || len(results[0].Expressions) == 0 {
-		return false, nil
+	allowed, err := decision.Allowed()
+	if err != nil {
+		return false, "", inputJSON, err
 	}
+	return allowed, decision.Revision, inputJSON, nil
+}
 
-	allowed, ok := results[0].Expressions[0].Value.(bool)
-	if !ok {
-		return false, fmt.Errorf("unexpected result type from OPA evaluation")
+// toInputMap 将输入转换为 map[string]interface{}
+func toInputMap(input *PermissionInput) ([]byte, map[string]interface{}, error) {
+	inputJSON, err := json.Marshal(input)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal input: %w", err)
 	}
 
-	return allowed, nil
+	var inputMap map[string]interface{}
+	if err := json.Unmarshal(inputJSON, &inputMap); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal input: %w", err)
+	}
+	return inputJSON, inputMap, nil
 }
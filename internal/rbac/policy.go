@@ -0,0 +1,277 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/shenjing023/rbac-api-gateway/pkg/opa"
+	"gorm.io/gorm"
+)
+
+// systemAuthor 是网关自动创建的策略版本的作者
+const systemAuthor = "system"
+
+// InitPolicy 从数据库加载当前生效的策略版本，数据库中没有版本时以内置策略作为第一个版本。
+// 策略由外部管理的引擎（file、bundle 和远程 OPA）不使用数据库中的版本。
+// 升级后内置策略发生变化时：生效的是未修改的内置策略则自动激活新的内置策略；
+// 生效的是管理员修改过的策略则保存新的内置策略但不激活，并记录警告，由管理员比较后合并
+func (s *Service) InitPolicy() error {
+	if _, ok := s.checker.engine.(opa.Reloadable); !ok {
+		log.Printf("policy engine is not reloadable, skip loading policy revisions")
+		return nil
+	}
+
+	var rev PolicyRevision
+	err := s.db.Where("active = ?", true).First(&rev).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		created, err := s.CreatePolicyRevision(policyContent, systemAuthor, "内置策略", true)
+		if err != nil {
+			return err
+		}
+		return s.loadRevision(created)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := s.upgradeBuiltinPolicy(&rev); err != nil {
+		return err
+	}
+	return s.loadRevision(&rev)
+}
+
+// upgradeBuiltinPolicy 在内置策略与生效版本创建时的内置策略不同时处理升级，激活了新版本时更新 active
+func (s *Service) upgradeBuiltinPolicy(active *PolicyRevision) error {
+	builtinHash := policyHash(policyContent)
+	if active.BaseHash == builtinHash || active.Hash == builtinHash {
+		return nil
+	}
+
+	// 数据库中已经有当前的内置策略：要么已经升级后被管理员回滚，要么本实例是滚动升级中较旧的二进制，
+	// 都不能自动切换
+	var count int64
+	if err := s.db.Model(&PolicyRevision{}).Where("hash = ?", builtinHash).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		log.Printf("WARNING: active policy revision %d is not based on the built-in policy of this binary (%.12s), "+
+			"which is already stored as a revision; keep the active revision", active.ID, builtinHash)
+		return nil
+	}
+
+	// 没有记录 BaseHash 的旧版本，以作者判断是否是自动创建的内置策略
+	unmodified := active.Author == systemAuthor && (active.BaseHash == "" || active.BaseHash == active.Hash)
+	created, err := s.CreatePolicyRevision(policyContent, systemAuthor, "升级后的内置策略", false)
+	if err != nil {
+		return err
+	}
+	if !unmodified {
+		log.Printf("WARNING: built-in policy changed, but active policy revision %d was modified by %s. "+
+			"The new built-in policy is saved as revision %d and NOT activated: new routes and security rules are missing "+
+			"until it is merged, compare with GET /rbac/policies/diff?from=%d&to=%d", active.ID, active.Author, created.ID, active.ID, created.ID)
+		return nil
+	}
+
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		return activateRevision(tx, created, systemAuthor)
+	}); err != nil {
+		return err
+	}
+	log.Printf("built-in policy changed, activated policy revision %d (was %d)", created.ID, active.ID)
+	*active = *created
+	active.Active = true
+	return nil
+}
+
+// WatchPolicy 定期检查数据库中生效的策略版本，使多个网关实例保持一致。
+// 与 InitPolicy 一样，只有内置引擎使用数据库中的版本
+func (s *Service) WatchPolicy(ctx context.Context, interval time.Duration) {
+	if _, ok := s.checker.engine.(opa.Reloadable); !ok {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			var rev PolicyRevision
+			if err := s.db.Where("active = ?", true).First(&rev).Error; err != nil {
+				log.Printf("failed to load active policy revision: %v", err)
+				continue
+			}
+			if revisionLabel(&rev) == s.checker.ActiveRevision() {
+				continue
+			}
+			if err := s.loadRevision(&rev); err != nil {
+				log.Printf("failed to activate policy revision %d: %v", rev.ID, err)
+			}
+		}
+	}
+}
+
+func (s *Service) loadRevision(rev *PolicyRevision) error {
+	return s.checker.loadPolicy(context.Background(), rev.Content, revisionLabel(rev))
+}
+
+// CreatePolicyRevision 保存一个新的策略版本，activate 为 true 时立即激活
+func (s *Service) CreatePolicyRevision(content, author, comment string, activate bool) (*PolicyRevision, error) {
+	// 无法编译的策略不允许保存
+	if err := validatePolicy(content); err != nil {
+		return nil, err
+	}
+	if _, ok := s.checker.engine.(opa.Reloadable); activate && !ok {
+		return nil, errPolicyNotReloadable
+	}
+
+	rev := PolicyRevision{
+		Content:  content,
+		Hash:     policyHash(content),
+		BaseHash: policyHash(policyContent),
+		Author:   author,
+		Comment:  comment,
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&rev).Error; err != nil {
+			return err
+		}
+		if activate {
+			return activateRevision(tx, &rev, author)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if activate {
+		if err := s.loadRevision(&rev); err != nil {
+			return nil, err
+		}
+	}
+	return &rev, nil
+}
+
+// ListPolicyRevisions 按时间倒序列出所有策略版本，不包含策略内容
+func (s *Service) ListPolicyRevisions() ([]PolicyRevision, error) {
+	var revisions []PolicyRevision
+	err := s.db.Omit("content").Order("id desc").Find(&revisions).Error
+	return revisions, err
+}
+
+func (s *Service) GetPolicyRevision(id uint) (*PolicyRevision, error) {
+	var rev PolicyRevision
+	if err := s.db.First(&rev, id).Error; err != nil {
+		return nil, err
+	}
+	return &rev, nil
+}
+
+// DiffPolicyRevisions 返回两个策略版本之间的 unified diff
+func (s *Service) DiffPolicyRevisions(fromID, toID uint) (string, error) {
+	from, err := s.GetPolicyRevision(fromID)
+	if err != nil {
+		return "", err
+	}
+	to, err := s.GetPolicyRevision(toID)
+	if err != nil {
+		return "", err
+	}
+
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(from.Content),
+		B:        difflib.SplitLines(to.Content),
+		FromFile: fmt.Sprintf("revision-%d", from.ID),
+		ToFile:   fmt.Sprintf("revision-%d", to.ID),
+		Context:  3,
+	})
+}
+
+// RollbackPolicy 重新激活一个历史策略版本
+func (s *Service) RollbackPolicy(revisionID uint, author string) (*PolicyRevision, error) {
+	rev, err := s.GetPolicyRevision(revisionID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 先编译，确保数据库和内存中的状态只会一起切换
+	if err := validatePolicy(rev.Content); err != nil {
+		return nil, err
+	}
+	if _, ok := s.checker.engine.(opa.Reloadable); !ok {
+		return nil, errPolicyNotReloadable
+	}
+
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		return activateRevision(tx, rev, author)
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := s.loadRevision(rev); err != nil {
+		return nil, err
+	}
+	return rev, nil
+}
+
+// PolicyRevisionAt 返回指定时刻生效的策略版本
+func (s *Service) PolicyRevisionAt(at time.Time) (*PolicyRevision, error) {
+	var activation PolicyActivation
+	if err := s.db.Where("created_at <= ?", at).Order("created_at desc").First(&activation).Error; err != nil {
+		return nil, err
+	}
+	return s.GetPolicyRevision(activation.RevisionID)
+}
+
+// LoadShadowPolicy 把一个策略版本或一段策略内容作为候选策略进行影子评估
+func (s *Service) LoadShadowPolicy(revisionID uint, content string) (*ShadowReport, error) {
+	if revisionID != 0 {
+		rev, err := s.GetPolicyRevision(revisionID)
+		if err != nil {
+			return nil, err
+		}
+		content = rev.Content
+	}
+	if content == "" {
+		return nil, errors.New("候选策略内容为空")
+	}
+	return s.checker.LoadShadowPolicy(content, revisionID)
+}
+
+// ShadowReport 返回当前候选策略的评估报告
+func (s *Service) ShadowReport() *ShadowReport {
+	return s.checker.ShadowReport()
+}
+
+// StopShadowPolicy 停止影子评估并返回最终报告
+func (s *Service) StopShadowPolicy() *ShadowReport {
+	return s.checker.StopShadowPolicy()
+}
+
+// ActiveRevision 返回本实例当前生效的策略版本
+func (s *Service) ActiveRevision() string {
+	return s.checker.ActiveRevision()
+}
+
+// validatePolicy 检查策略内容能否编译
+func validatePolicy(content string) error {
+	_, err := newPolicyEngine(content, "")
+	return err
+}
+
+func activateRevision(tx *gorm.DB, rev *PolicyRevision, author string) error {
+	if err := tx.Model(&PolicyRevision{}).Where("active = ?", true).Update("active", false).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(rev).Update("active", true).Error; err != nil {
+		return err
+	}
+	return tx.Create(&PolicyActivation{RevisionID: rev.ID, ActivatedBy: author}).Error
+}
@@ -0,0 +1,147 @@
+package rbac
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+var testDBSeq atomic.Int64
+
+// newTestService 返回使用内置策略引擎和独立内存数据库的 Service
+func newTestService(t *testing.T) (*Service, *gorm.DB) {
+	t.Helper()
+	dsn := fmt.Sprintf("file:rbac_test_%d?mode=memory&cache=shared", testDBSeq.Add(1))
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&PolicyRevision{}, &PolicyActivation{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	engine, err := NewEmbeddedEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewService(db, NewPermissionChecker(engine)), db
+}
+
+// oldBuiltinPolicy 模拟上一个版本的二进制内置的策略
+var oldBuiltinPolicy = policyContent + "\n# previous release\n"
+
+func TestInitPolicyUpgradesBuiltinPolicy(t *testing.T) {
+	builtinHash := policyHash(policyContent)
+	oldHash := policyHash(oldBuiltinPolicy)
+	tests := []struct {
+		name string
+		// existing 是升级前数据库中的版本，最后一个是生效的版本
+		existing      []PolicyRevision
+		wantActive    string // 升级后生效版本的内容哈希
+		wantRevisions int
+	}{
+		{"empty database", nil, builtinHash, 1},
+		{"up to date", []PolicyRevision{
+			{Content: policyContent, Hash: builtinHash, BaseHash: builtinHash, Author: systemAuthor},
+		}, builtinHash, 1},
+		{"unmodified builtin", []PolicyRevision{
+			{Content: oldBuiltinPolicy, Hash: oldHash, BaseHash: oldHash, Author: systemAuthor},
+		}, builtinHash, 2},
+		// 升级之前的版本没有 BaseHash
+		{"unmodified builtin without base hash", []PolicyRevision{
+			{Content: oldBuiltinPolicy, Hash: oldHash, Author: systemAuthor},
+		}, builtinHash, 2},
+		// 管理员修改过的策略不能被覆盖，新的内置策略只保存不激活
+		{"modified by admin", []PolicyRevision{
+			{Content: oldBuiltinPolicy + "# custom\n", Hash: policyHash(oldBuiltinPolicy + "# custom\n"), BaseHash: oldHash, Author: "alice"},
+		}, policyHash(oldBuiltinPolicy + "# custom\n"), 2},
+		// 当前的内置策略已经在数据库中，说明管理员回滚过或者本实例是较旧的二进制
+		{"builtin already stored", []PolicyRevision{
+			{Content: policyContent, Hash: builtinHash, BaseHash: builtinHash, Author: systemAuthor},
+			{Content: oldBuiltinPolicy, Hash: oldHash, BaseHash: oldHash, Author: systemAuthor},
+		}, oldHash, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, db := newTestService(t)
+			for i, rev := range tt.existing {
+				rev.Active = i == len(tt.existing)-1
+				if err := db.Create(&rev).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := s.InitPolicy(); err != nil {
+				t.Fatalf("InitPolicy: %v", err)
+			}
+			var active []PolicyRevision
+			if err := db.Where("active = ?", true).Find(&active).Error; err != nil {
+				t.Fatal(err)
+			}
+			if len(active) != 1 || active[0].Hash != tt.wantActive {
+				t.Fatalf("active revisions = %+v, want one with hash %.12s", active, tt.wantActive)
+			}
+			if got, want := s.ActiveRevision(), revisionLabel(&active[0]); got != want {
+				t.Errorf("engine revision = %s, want %s", got, want)
+			}
+			var count int64
+			db.Model(&PolicyRevision{}).Count(&count)
+			if count != int64(tt.wantRevisions) {
+				t.Errorf("revisions = %d, want %d", count, tt.wantRevisions)
+			}
+
+			// 再次启动不会重复创建版本
+			if err := s.InitPolicy(); err != nil {
+				t.Fatalf("second InitPolicy: %v", err)
+			}
+			db.Model(&PolicyRevision{}).Count(&count)
+			if count != int64(tt.wantRevisions) {
+				t.Errorf("revisions after restart = %d, want %d", count, tt.wantRevisions)
+			}
+		})
+	}
+}
+
+func TestRollbackPolicyHandler(t *testing.T) {
+	s, _ := newTestService(t)
+	if err := s.InitPolicy(); err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	r.POST("/rbac/policies/rollback", NewHandler(s).RollbackPolicy)
+
+	tests := []struct {
+		body     string
+		wantCode int
+	}{
+		{`{"revision_id": 1}`, http.StatusOK},
+		{`{"revision_id": 42}`, http.StatusNotFound},
+		{`{}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/rbac/policies/rollback", bytes.NewBufferString(tt.body)))
+		if w.Code != tt.wantCode {
+			t.Errorf("rollback %s = %d %s, want %d", tt.body, w.Code, w.Body.String(), tt.wantCode)
+		}
+	}
+}
@@ -5,26 +5,94 @@ import future.keywords.in
 
 default allow = false
 
-# 允许管理员执行所有操作
+# 必须完成多因素认证的角色，由网关按配置 auth.mfa.required_roles 通过 input.mfa_required_roles 传入，默认为空。
+# 角色加入之前需要先让该角色的用户启用 TOTP，否则他们只能访问下面的多因素认证管理接口
+mfa_required_roles := {role | some role in input.mfa_required_roles}
+
+# 角色允许的操作还需要满足多因素认证的要求
 allow if {
+    role_allows
+    mfa_satisfied
+    not impersonation_denied
+}
+
+# 管理员模拟用户（input.user.act 不为空）时不能修改用户的凭据或者创建长期有效的凭据，
+# 否则模拟可以在 token 过期后继续
+impersonation_denied_actions := {
+    "POST:/auth/mfa/totp", "POST:/auth/mfa/totp/confirm", "DELETE:/auth/mfa/totp",
+    "POST:/auth/mfa/recovery-codes", "POST:/auth/password/change",
+    "POST:/api-keys", "POST:/auth/tokens", "POST:/oauth/authorize",
+    "DELETE:/auth/sessions", "DELETE:/auth/sessions/:id",
+}
+
+impersonation_denied if {
+    input.user.act
+    input.action in impersonation_denied_actions
+}
+
+# 不经过交互式登录的凭据可以代替多因素认证的认证方式。客户端证书（cert）由 PKI 签发给服务账号，
+# API key 和 OAuth2 客户端凭据（key）是共享密钥，默认不能代替；需要用 key 执行管理操作时在这里加入 "key"。
+# 上游身份提供方声明的认证方式带有 fed:<provider>: 前缀，信任某个提供方的多因素认证时加入例如 "fed:okta:mfa"
+mfa_exempt_methods := {"cert"}
+
+# 登录时完成了多因素认证（amr 包含 mfa），或者角色不要求多因素认证。
+# 没有 amr 的凭据不满足要求
+mfa_satisfied if {
+    not input.user.role in mfa_required_roles
+}
+
+mfa_satisfied if {
+    "mfa" in input.user.amr
+}
+
+mfa_satisfied if {
+    some method in input.user.amr
+    method in mfa_exempt_methods
+}
+
+# 允许管理员执行所有操作
+role_allows if {
     input.user.role == "admin"
 }
 
-# 允许版主管理所有资源
+# 所有用户都可以管理自己的多因素认证、修改密码和登出，包括还没有完成多因素认证的管理员
 allow if {
+    input.user.role in ["user", "moderator", "admin"]
+    input.action in [
+        "GET:/auth/mfa", "POST:/auth/mfa/totp", "POST:/auth/mfa/totp/confirm",
+        "DELETE:/auth/mfa/totp", "POST:/auth/mfa/recovery-codes",
+        "POST:/auth/logout", "POST:/auth/password/change",
+    ]
+    not impersonation_denied
+}
+
+# 允许版主管理所有资源
+role_allows if {
     input.user.role == "moderator"
     input.action in ["POST:/posts", "GET:/posts", "GET:/posts/:id", "PUT:/posts/:id", "DELETE:/posts/:id"]
 }
 
 # 允许普通用户执行基本操作
-allow if {
+role_allows if {
     input.user.role == "user"
     input.action in ["POST:/posts", "GET:/posts", "GET:/posts/:id"]
 }
 
 # 允许用户更新或删除自己的资源
-allow if {
+role_allows if {
     input.user.role == "user"
     input.action in ["PUT:/posts/:id", "DELETE:/posts/:id"]
     input.resource.is_owner == true
-}
\ No newline at end of file
+}
+
+# 允许用户管理自己的 API key、个人访问令牌和登录会话，以及向 OAuth2 客户端授权，
+# 服务层只会操作当前用户名下的数据
+role_allows if {
+    input.user.role in ["user", "moderator"]
+    input.action in [
+        "POST:/api-keys", "GET:/api-keys", "DELETE:/api-keys/:id",
+        "POST:/auth/tokens", "GET:/auth/tokens", "DELETE:/auth/tokens/:id",
+        "GET:/oauth/authorize", "POST:/oauth/authorize",
+        "GET:/auth/sessions", "DELETE:/auth/sessions", "DELETE:/auth/sessions/:id",
+    ]
+}
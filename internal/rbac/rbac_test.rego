@@ -0,0 +1,178 @@
+package rbac_test
+
+import data.rbac
+import future.keywords.if
+import future.keywords.in
+
+# 管理员
+test_admin_allowed_any_action if {
+    rbac.allow with input as {"action": "DELETE:/users/:id", "user": {"id": 1, "role": "admin", "amr": ["pwd", "otp", "mfa"]}}
+}
+
+# 多因素认证，默认没有角色要求多因素认证
+test_mfa_not_required_by_default if {
+    rbac.allow with input as {"action": "DELETE:/users/:id", "user": {"id": 1, "role": "admin", "amr": ["pwd"]}}
+    rbac.allow with input as {"action": "DELETE:/users/:id", "user": {"id": 1, "role": "admin", "amr": ["fed"]}}
+    rbac.allow with input as {"action": "DELETE:/users/:id", "user": {"id": 1, "role": "admin", "amr": ["key"]}}
+}
+
+test_admin_password_only_login_denied if {
+    not rbac.allow with input as mfa_required({"action": "DELETE:/users/:id", "user": {"id": 1, "role": "admin", "amr": ["pwd"]}})
+}
+
+test_admin_with_mfa_allowed if {
+    rbac.allow with input as mfa_required({"action": "DELETE:/users/:id", "user": {"id": 1, "role": "admin", "amr": ["pwd", "otp", "mfa"]}})
+}
+
+test_admin_without_amr_denied if {
+    not rbac.allow with input as mfa_required({"action": "DELETE:/users/:id", "user": {"id": 1, "role": "admin"}})
+}
+
+# 上游身份提供方声明的 mfa 带有前缀，不能直接满足要求
+test_admin_federated_mfa_claim_denied if {
+    not rbac.allow with input as mfa_required({"action": "DELETE:/users/:id", "user": {"id": 1, "role": "admin", "amr": ["fed", "fed:okta:mfa"]}})
+}
+
+# 个人访问令牌和 OAuth2 令牌继承创建它们的会话的 amr
+test_admin_token_from_password_session_denied if {
+    not rbac.allow with input as mfa_required({"action": "DELETE:/users/:id", "user": {"id": 1, "role": "admin", "amr": ["pwd"], "scopes": ["*"]}})
+}
+
+test_admin_api_key_denied if {
+    not rbac.allow with input as mfa_required({"action": "DELETE:/users/:id", "user": {"id": 1, "role": "admin", "amr": ["key"]}})
+}
+
+test_admin_client_certificate_allowed if {
+    rbac.allow with input as mfa_required({"action": "DELETE:/users/:id", "user": {"id": 1, "role": "admin", "amr": ["cert"]}})
+}
+
+test_user_api_key_allowed if {
+    every_allowed_with_amr("user", ["GET:/posts", "POST:/posts"], ["key"])
+}
+
+test_admin_without_mfa_can_enroll if {
+    actions := ["GET:/auth/mfa", "POST:/auth/mfa/totp", "POST:/auth/mfa/totp/confirm", "POST:/auth/logout", "POST:/auth/password/change"]
+    allowed := {a | some a in actions; rbac.allow with input as mfa_required({"action": a, "user": {"id": 1, "role": "admin", "amr": ["pwd"]}})}
+    count(allowed) == count(actions)
+}
+
+test_user_does_not_require_mfa if {
+    every_allowed_with_amr("user", ["GET:/posts", "POST:/api-keys", "DELETE:/auth/mfa/totp"], ["pwd"])
+}
+
+test_configured_role_requires_mfa if {
+    not rbac.allow with input as object.union(request("user", "GET:/posts", false), {"mfa_required_roles": ["user"]})
+}
+
+# 版主
+test_moderator_can_manage_posts if {
+    every_allowed("moderator", ["POST:/posts", "GET:/posts", "GET:/posts/:id", "PUT:/posts/:id", "DELETE:/posts/:id"], false)
+}
+
+test_moderator_cannot_manage_users if {
+    not rbac.allow with input as {"action": "DELETE:/users/:id", "user": {"id": 2, "role": "moderator"}}
+}
+
+# 普通用户
+test_user_basic_post_actions if {
+    every_allowed("user", ["POST:/posts", "GET:/posts", "GET:/posts/:id"], false)
+}
+
+test_user_can_modify_own_post if {
+    every_allowed("user", ["PUT:/posts/:id", "DELETE:/posts/:id"], true)
+}
+
+test_user_cannot_modify_others_post if {
+    not rbac.allow with input as request("user", "PUT:/posts/:id", false)
+    not rbac.allow with input as request("user", "DELETE:/posts/:id", false)
+}
+
+test_user_cannot_manage_rbac if {
+    not rbac.allow with input as request("user", "POST:/rbac/assign-role", false)
+}
+
+test_only_admin_can_manage_accounts if {
+    rbac.allow with input as {"action": "POST:/users/:id/unlock", "user": {"id": 1, "role": "admin", "amr": ["mfa"]}}
+    not rbac.allow with input as request("moderator", "POST:/users/:id/unlock", false)
+    not rbac.allow with input as request("user", "GET:/audit/events", false)
+    not rbac.allow with input as request("moderator", "PUT:/users/:id/status", false)
+    not rbac.allow with input as request("moderator", "POST:/invitations", false)
+}
+
+# API key
+test_users_can_manage_own_api_keys if {
+    every_allowed("user", ["POST:/api-keys", "GET:/api-keys", "DELETE:/api-keys/:id"], false)
+    every_allowed("moderator", ["POST:/api-keys", "GET:/api-keys", "DELETE:/api-keys/:id"], false)
+}
+
+test_users_can_manage_own_tokens if {
+    every_allowed("user", ["POST:/auth/tokens", "GET:/auth/tokens", "DELETE:/auth/tokens/:id"], false)
+    every_allowed("moderator", ["POST:/auth/tokens", "GET:/auth/tokens", "DELETE:/auth/tokens/:id"], false)
+}
+
+test_users_can_manage_own_sessions if {
+    every_allowed("user", ["GET:/auth/sessions", "DELETE:/auth/sessions", "DELETE:/auth/sessions/:id"], false)
+    not rbac.allow with input as request("moderator", "GET:/users/:id/sessions", false)
+    not rbac.allow with input as request("user", "DELETE:/users/:id/sessions/:session_id", false)
+}
+
+test_users_can_authorize_oauth_clients if {
+    every_allowed("user", ["GET:/oauth/authorize", "POST:/oauth/authorize", "POST:/auth/logout", "POST:/auth/password/change"], false)
+}
+
+test_user_cannot_register_oauth_clients if {
+    not rbac.allow with input as request("user", "POST:/oauth/clients", false)
+}
+
+test_user_cannot_manage_others_api_keys if {
+    not rbac.allow with input as request("user", "POST:/users/:id/api-keys", false)
+    not rbac.allow with input as request("moderator", "DELETE:/users/:id/api-keys/:key_id", false)
+}
+
+# 管理员模拟用户
+test_impersonation_evaluated_as_target_user if {
+    rbac.allow with input as impersonated("PUT:/posts/:id", true)
+    rbac.allow with input as impersonated("GET:/auth/sessions", false)
+    rbac.allow with input as impersonated("POST:/auth/logout", false)
+    not rbac.allow with input as impersonated("PUT:/posts/:id", false)
+    not rbac.allow with input as impersonated("DELETE:/users/:id", false)
+}
+
+test_impersonation_cannot_change_credentials if {
+    not rbac.allow with input as impersonated("POST:/auth/password/change", false)
+    not rbac.allow with input as impersonated("POST:/auth/mfa/totp", false)
+    not rbac.allow with input as impersonated("POST:/api-keys", false)
+    not rbac.allow with input as impersonated("DELETE:/auth/sessions", false)
+}
+
+# 未知角色默认拒绝
+test_unknown_role_denied if {
+    not rbac.allow with input as request("guest", "GET:/posts", false)
+}
+
+test_missing_input_denied if {
+    not rbac.allow with input as {}
+}
+
+request(role, action, is_owner) := {
+    "action": action,
+    "resource": {"type": "posts", "id": "1", "is_owner": is_owner},
+    "user": {"id": 3, "role": role},
+}
+
+impersonated(action, is_owner) := object.union(request("user", action, is_owner), {
+    "user": {"id": 3, "role": "user", "amr": ["pwd", "otp", "mfa"], "act": {"id": 1, "username": "admin"}},
+})
+
+# mfa_required 按配置 auth.mfa.required_roles: [admin] 构造输入
+mfa_required(req) := object.union(req, {"mfa_required_roles": ["admin"]})
+
+every_allowed_with_amr(role, actions, amr) if {
+    allowed := {a | some a in actions; rbac.allow with input as object.union(request(role, a, false), {"user": {"id": 3, "role": role, "amr": amr}})}
+    count(allowed) == count(actions)
+}
+
+every_allowed(role, actions, is_owner) if {
+    allowed := {a | some a in actions; rbac.allow with input as request(role, a, is_owner)}
+    count(allowed) == count(actions)
+}
@@ -0,0 +1,58 @@
+package rbac
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 凭据的权限范围（scope）格式为 <资源类型>:<read|write|*>，例如 posts:read、api-keys:*，
+// 单独的 "*" 表示不限制。read 对应 GET、HEAD 和 OPTIONS 请求，其余方法都属于 write。
+// 带 scope 的凭据只能执行其所有者的角色本来就允许、并且 scope 也允许的操作
+const ScopeAll = "*"
+
+// ValidateScopes 检查 scope 的格式
+func ValidateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("至少需要一个 scope")
+	}
+	for _, scope := range scopes {
+		if scope == ScopeAll {
+			continue
+		}
+		resource, access, ok := strings.Cut(scope, ":")
+		if !ok || resource == "" {
+			return fmt.Errorf("无效的 scope %q", scope)
+		}
+		switch access {
+		case "read", "write", "*":
+		default:
+			return fmt.Errorf("无效的 scope %q，访问类型必须是 read、write 或 *", scope)
+		}
+	}
+	return nil
+}
+
+// scopeAllowed 判断凭据的 scope 是否允许本次操作，User.Scopes 为 nil 表示凭据没有 scope 限制
+func (in *PermissionInput) scopeAllowed() bool {
+	if in.User.Scopes == nil {
+		return true
+	}
+
+	method, _, _ := strings.Cut(in.Action, ":")
+	access := "write"
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		access = "read"
+	}
+
+	for _, scope := range in.User.Scopes {
+		if scope == ScopeAll {
+			return true
+		}
+		resource, a, _ := strings.Cut(scope, ":")
+		if resource == in.Resource.Type && (a == "*" || a == access) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package rbac
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestValidateScopes(t *testing.T) {
+	tests := []struct {
+		scopes  []string
+		wantErr bool
+	}{
+		{[]string{"posts:read"}, false},
+		{[]string{"posts:write", "api-keys:*"}, false},
+		{[]string{ScopeAll}, false},
+		{nil, true},
+		{[]string{}, true},
+		{[]string{"posts"}, true},
+		{[]string{":read"}, true},
+		{[]string{"posts:delete"}, true},
+		{[]string{"posts:read", "posts:admin"}, true},
+	}
+	for _, tt := range tests {
+		if err := ValidateScopes(tt.scopes); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateScopes(%q) = %v, want error %v", tt.scopes, err, tt.wantErr)
+		}
+	}
+}
+
+// scopedInput 构造鉴权输入，资源类型与网关一样取路由的第一段
+func scopedInput(action, role string, scopes []string) *PermissionInput {
+	input := &PermissionInput{Action: action}
+	_, path, _ := strings.Cut(action, ":")
+	input.Resource.Type, _, _ = strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	input.User.ID = 1
+	input.User.Role = role
+	input.User.Scopes = scopes
+	return input
+}
+
+func TestScopeAllowed(t *testing.T) {
+	tests := []struct {
+		name   string
+		action string
+		scopes []string
+		want   bool
+	}{
+		{"no scope restriction", "DELETE:/posts/:id", nil, true},
+		{"read scope allows get", "GET:/posts", []string{"posts:read"}, true},
+		{"read scope allows head", "HEAD:/posts", []string{"posts:read"}, true},
+		{"read scope allows options", "OPTIONS:/posts", []string{"posts:read"}, true},
+		{"read scope denies post", "POST:/posts", []string{"posts:read"}, false},
+		{"read scope denies delete", "DELETE:/posts/:id", []string{"posts:read"}, false},
+		{"write scope allows put", "PUT:/posts/:id", []string{"posts:write"}, true},
+		// write 不包含 read
+		{"write scope denies get", "GET:/posts", []string{"posts:write"}, false},
+		{"wildcard access", "DELETE:/posts/:id", []string{"posts:*"}, true},
+		{"wildcard scope", "DELETE:/users/:id", []string{ScopeAll}, true},
+		{"other resource", "GET:/users", []string{"posts:*"}, false},
+		{"one of several scopes", "POST:/posts", []string{"users:read", "posts:write"}, true},
+		// 空的 scope 列表与 nil 不同，不允许任何操作
+		{"empty scopes", "GET:/posts", []string{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := scopedInput(tt.action, "user", tt.scopes)
+			if got := input.scopeAllowed(); got != tt.want {
+				t.Errorf("scopeAllowed(%s, %q) = %v, want %v", tt.action, tt.scopes, got, tt.want)
+			}
+		})
+	}
+}
+
+// 带 scope 的凭据只能在角色允许的范围内进一步收窄，不能扩大角色的权限
+func TestCheckPermissionScopeNarrowing(t *testing.T) {
+	engine, err := NewEmbeddedEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc := NewPermissionChecker(engine)
+
+	tests := []struct {
+		name   string
+		action string
+		role   string
+		scopes []string
+		want   bool
+	}{
+		{"role allows without scopes", "POST:/posts", "user", nil, true},
+		{"scope narrows below role", "POST:/posts", "user", []string{"posts:read"}, false},
+		{"scope within role", "GET:/posts", "user", []string{"posts:read"}, true},
+		// 普通用户的角色不允许管理角色，scope 再宽也不行
+		{"scope wider than role", "GET:/rbac/roles", "user", []string{ScopeAll}, false},
+		{"resource scope wider than role", "DELETE:/users/:id", "user", []string{"users:*"}, false},
+		{"moderator write scope", "PUT:/posts/:id", "moderator", []string{"posts:write"}, true},
+		{"moderator read scope", "PUT:/posts/:id", "moderator", []string{"posts:read"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := scopedInput(tt.action, tt.role, tt.scopes)
+			got, err := pc.CheckPermission(context.Background(), input)
+			if err != nil {
+				t.Fatalf("CheckPermission: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("CheckPermission(%s, %s, %q) = %v, want %v", tt.action, tt.role, tt.scopes, got, tt.want)
+			}
+		})
+	}
+}
+
+// 必须使用多因素认证的角色来自配置，默认没有角色需要
+func TestCheckPermissionMFARequiredRoles(t *testing.T) {
+	engine, err := NewEmbeddedEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		requiredRoles []string
+		amr           []string
+		want          bool
+	}{
+		{"not required by default", nil, []string{"pwd"}, true},
+		{"federated login by default", nil, []string{"fed", "fed:corp:mfa"}, true},
+		{"required without mfa", []string{"admin"}, []string{"pwd"}, false},
+		{"required with mfa", []string{"admin"}, []string{"pwd", "mfa"}, true},
+		// 上游身份提供方声明的 mfa 带有命名空间，不能满足本地的要求
+		{"required with upstream mfa", []string{"admin"}, []string{"fed", "fed:corp:mfa"}, false},
+		{"other role required", []string{"moderator"}, []string{"pwd"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pc := NewPermissionChecker(engine)
+			pc.SetMFARequiredRoles(tt.requiredRoles)
+			input := scopedInput("GET:/rbac/roles", "admin", nil)
+			input.User.AMR = tt.amr
+			got, err := pc.CheckPermission(context.Background(), input)
+			if err != nil {
+				t.Fatalf("CheckPermission: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("CheckPermission with amr %q = %v, want %v", tt.amr, got, tt.want)
+			}
+		})
+	}
+}
@@ -3,18 +3,21 @@ package rbac
 import (
 	"context"
 	"fmt"
+	"log"
 	"sync"
+	"sync/atomic"
 
-	"github.com/gin-gonic/gin"
+	"github.com/shenjing023/rbac-api-gateway/pkg/opa"
 	"gorm.io/gorm"
 )
 
 type Service struct {
-	db *gorm.DB
+	db      *gorm.DB
+	checker *PermissionChecker
 }
 
-func NewService(db *gorm.DB) *Service {
-	return &Service{db: db}
+func NewService(db *gorm.DB, checker *PermissionChecker) *Service {
+	return &Service{db: db, checker: checker}
 }
 
 func (s *Service) CreateRole(name, description string) error {
@@ -90,21 +93,38 @@ type ResourceChecker interface {
 }
 
 type PermissionChecker struct {
+	engine           opa.PolicyEngine
 	resourceCheckers sync.Map
+	shadow           atomic.Pointer[shadowPolicy]
+	// mfaRequiredRoles 通过 input.mfa_required_roles 传给策略，为空时没有角色必须使用多因素认证
+	mfaRequiredRoles []string
 }
 
-func NewPermissionChecker() *PermissionChecker {
-	return &PermissionChecker{}
+func NewPermissionChecker(engine opa.PolicyEngine) *PermissionChecker {
+	return &PermissionChecker{engine: engine}
+}
+
+// SetMFARequiredRoles 设置必须完成多因素认证的角色，需要在开始处理请求之前调用
+func (pc *PermissionChecker) SetMFARequiredRoles(roles []string) {
+	pc.mfaRequiredRoles = roles
 }
 
 func (pc *PermissionChecker) RegisterResourceChecker(resourceType string, checker ResourceChecker) {
 	pc.resourceCheckers.Store(resourceType, checker)
 }
 
-func (pc *PermissionChecker) CheckPermission(c *gin.Context, input *PermissionInput) (bool, error) {
+func (pc *PermissionChecker) CheckPermission(ctx context.Context, input *PermissionInput) (bool, error) {
+	// 影子策略复用同一个输入，在这里填充后两者的评估条件一致
+	input.MFARequiredRoles = pc.mfaRequiredRoles
+
+	// 凭据的 scope 不允许的操作直接拒绝，不需要再评估策略
+	if !input.scopeAllowed() {
+		return false, nil
+	}
+
 	if checkerValue, ok := pc.resourceCheckers.Load(input.Resource.Type); ok {
 		checker := checkerValue.(ResourceChecker)
-		isOwner, err := checker.CheckResourceOwnership(c.Request.Context(), input.Resource.ID, input.User.ID)
+		isOwner, err := checker.CheckResourceOwnership(ctx, input.Resource.ID, input.User.ID)
 		if err != nil {
 			return false, fmt.Errorf("error checking resource ownership: %w", err)
 		}
@@ -112,7 +132,7 @@ func (pc *PermissionChecker) CheckPermission(c *gin.Context, input *PermissionIn
 	}
 
 	// 这里调用 OPA 进行权限评估
-	allowed, err := evaluateOPAPolicy(input)
+	allowed, err := evaluatePolicy(ctx, pc.engine, input)
 	if err != nil {
 		return false, err
 	}
@@ -120,6 +140,28 @@ func (pc *PermissionChecker) CheckPermission(c *gin.Context, input *PermissionIn
 	return allowed, nil
 }
 
+// ActiveRevision 返回策略引擎当前生效的策略版本，引擎不支持时返回空字符串
+func (pc *PermissionChecker) ActiveRevision() string {
+	// file 和 bundle 引擎不能在线更新，但同样有策略版本
+	if r, ok := pc.engine.(interface{ Revision() string }); ok {
+		return r.Revision()
+	}
+	return ""
+}
+
+// loadPolicy 用新的策略内容替换引擎中的策略
+func (pc *PermissionChecker) loadPolicy(ctx context.Context, content, revision string) error {
+	r, ok := pc.engine.(opa.Reloadable)
+	if !ok {
+		return errPolicyNotReloadable
+	}
+	if err := r.LoadModules(ctx, map[string]string{PolicyFile: content}, revision); err != nil {
+		return err
+	}
+	log.Printf("policy revision %s activated", revision)
+	return nil
+}
+
 type PermissionInput struct {
 	Action   string `json:"action"`
 	Resource struct {
@@ -130,5 +172,20 @@ type PermissionInput struct {
 	User struct {
 		ID   uint   `json:"id"`
 		Role string `json:"role"`
+		// Scopes 是 API key 等凭据的权限范围，nil 表示不限制，见 scope.go
+		Scopes []string `json:"scopes,omitempty"`
+		// AMR 是凭据的认证方式：登录会话为 pwd、otp、mfa 等，个人访问令牌和 OAuth2 令牌继承创建它们的会话，
+		// API key 和客户端凭据为 key，客户端证书为 cert
+		AMR []string `json:"amr,omitempty"`
+		// Act 是模拟该用户的管理员，决策日志据此记录实际执行操作的人
+		Act *Actor `json:"act,omitempty"`
 	} `json:"user"`
+	// MFARequiredRoles 是配置中必须完成多因素认证的角色，由 PermissionChecker 填充
+	MFARequiredRoles []string `json:"mfa_required_roles,omitempty"`
+}
+
+// Actor 是模拟用户时实际执行操作的管理员
+type Actor struct {
+	ID       uint   `json:"id"`
+	Username string `json:"username"`
 }
@@ -0,0 +1,223 @@
+package rbac
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/shenjing023/rbac-api-gateway/pkg/opa"
+)
+
+const (
+	// 报告中最多保留的分歧样本数量
+	maxShadowSamples = 100
+	// shadowQueueSize 是等待影子评估的请求数量上限，队列满时丢弃请求并计入 Dropped
+	shadowQueueSize = 1024
+	// shadowWorkers 是执行影子评估的 goroutine 数量
+	shadowWorkers = 4
+)
+
+// ShadowDisagreement 是候选策略与生效策略结论不一致的一次请求
+type ShadowDisagreement struct {
+	Time      time.Time       `json:"time"`
+	Active    bool            `json:"active"`
+	Candidate bool            `json:"candidate"`
+	Input     PermissionInput `json:"input"`
+}
+
+// ShadowReport 汇总候选策略在真实流量上的评估结果
+type ShadowReport struct {
+	RevisionID      uint                 `json:"revision_id"`
+	Hash            string               `json:"hash"`
+	ActiveRevision  string               `json:"active_revision"`
+	StartedAt       time.Time            `json:"started_at"`
+	Evaluations     int64                `json:"evaluations"`
+	Disagreements   int64                `json:"disagreements"`
+	NewlyDenied     int64                `json:"newly_denied"`  // 生效策略允许、候选策略拒绝，即上线后会被拒绝的请求
+	NewlyAllowed    int64                `json:"newly_allowed"` // 生效策略拒绝、候选策略允许
+	Errors          int64                `json:"errors"`
+	Dropped         int64                `json:"dropped"` // 队列已满而没有评估的请求
+	ByAction        map[string]int64     `json:"by_action"`
+	ByRole          map[string]int64     `json:"by_role"`
+	RecentSamples   []ShadowDisagreement `json:"recent_samples"`
+	AffectedUserIDs []uint               `json:"affected_user_ids"`
+}
+
+// shadowPolicy 是与生效策略并行评估、但不参与鉴权的候选策略
+type shadowPolicy struct {
+	engine opa.PolicyEngine
+	queue  chan shadowJob
+	done   chan struct{}
+	once   sync.Once
+
+	mu            sync.Mutex
+	report        ShadowReport
+	affectedUsers map[uint]struct{}
+}
+
+// shadowJob 是一次等待用候选策略评估的请求
+type shadowJob struct {
+	input          PermissionInput
+	activeAllowed  bool
+	activeRevision string
+}
+
+// LoadShadowPolicy 编译候选策略并开始影子评估，会替换已有的候选策略
+func (pc *PermissionChecker) LoadShadowPolicy(content string, revisionID uint) (*ShadowReport, error) {
+	hash := policyHash(content)
+	engine, err := newPolicyEngine(content, "shadow:"+hash[:12])
+	if err != nil {
+		return nil, err
+	}
+
+	shadow := &shadowPolicy{
+		engine: engine,
+		report: ShadowReport{
+			RevisionID:     revisionID,
+			Hash:           hash,
+			ActiveRevision: pc.ActiveRevision(),
+			StartedAt:      time.Now(),
+			ByAction:       map[string]int64{},
+			ByRole:         map[string]int64{},
+		},
+		affectedUsers: map[uint]struct{}{},
+		queue:         make(chan shadowJob, shadowQueueSize),
+		done:          make(chan struct{}),
+	}
+	for i := 0; i < shadowWorkers; i++ {
+		go shadow.run()
+	}
+	if previous := pc.shadow.Swap(shadow); previous != nil {
+		previous.stop()
+	}
+	log.Printf("shadow policy loaded: revision=%d hash=%.12s", revisionID, hash)
+
+	report := shadow.snapshot()
+	return &report, nil
+}
+
+// StopShadowPolicy 停止影子评估并返回最终报告
+func (pc *PermissionChecker) StopShadowPolicy() *ShadowReport {
+	shadow := pc.shadow.Swap(nil)
+	if shadow == nil {
+		return nil
+	}
+	shadow.stop()
+	report := shadow.snapshot()
+	return &report
+}
+
+// ShadowReport 返回当前候选策略的评估报告，没有候选策略时返回 nil
+func (pc *PermissionChecker) ShadowReport() *ShadowReport {
+	shadow := pc.shadow.Load()
+	if shadow == nil {
+		return nil
+	}
+	report := shadow.snapshot()
+	return &report
+}
+
+// ShadowCheck 把同一请求交给候选策略异步评估，结果只记录不参与鉴权。
+// 评估队列已满时直接丢弃，不会阻塞请求
+func (pc *PermissionChecker) ShadowCheck(input *PermissionInput, activeAllowed bool) {
+	shadow := pc.shadow.Load()
+	if shadow == nil {
+		return
+	}
+	select {
+	case shadow.queue <- shadowJob{input: *input, activeAllowed: activeAllowed, activeRevision: pc.ActiveRevision()}:
+	default:
+		shadow.mu.Lock()
+		shadow.report.Dropped++
+		shadow.mu.Unlock()
+	}
+}
+
+// run 从队列中取出请求进行评估，直到候选策略被停止或替换
+func (s *shadowPolicy) run() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case job := <-s.queue:
+			s.evaluate(job.input, job.activeAllowed, job.activeRevision)
+		}
+	}
+}
+
+func (s *shadowPolicy) stop() {
+	s.once.Do(func() { close(s.done) })
+}
+
+// evaluate 用候选策略评估请求，并与生效策略的结论比较。
+// 这里不经过 evaluatePolicy，不会写 decision 日志，只有分歧以 shadow 前缀记录
+func (s *shadowPolicy) evaluate(input PermissionInput, activeAllowed bool, activeRevision string) {
+	allowed, _, inputJSON, err := decide(context.Background(), s.engine, &input)
+	allowed = allowed && input.scopeAllowed()
+	if err != nil {
+		log.Printf("shadow: evaluation failed: %v", err)
+		s.mu.Lock()
+		s.report.Evaluations++
+		s.report.Errors++
+		s.mu.Unlock()
+		return
+	}
+
+	if allowed != activeAllowed {
+		log.Printf("shadow: disagreement active_revision=%s candidate_revision=%d hash=%.12s active=%v candidate=%v input=%s\n",
+			activeRevision, s.report.RevisionID, s.report.Hash, activeAllowed, allowed, inputJSON)
+	}
+	s.record(input, activeAllowed, allowed)
+}
+
+func (s *shadowPolicy) record(input PermissionInput, active, candidate bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.report.Evaluations++
+	if active == candidate {
+		return
+	}
+
+	s.report.Disagreements++
+	if active {
+		s.report.NewlyDenied++
+	} else {
+		s.report.NewlyAllowed++
+	}
+	s.report.ByAction[input.Action]++
+	s.report.ByRole[input.User.Role]++
+	s.affectedUsers[input.User.ID] = struct{}{}
+
+	s.report.RecentSamples = append(s.report.RecentSamples, ShadowDisagreement{
+		Time:      time.Now(),
+		Active:    active,
+		Candidate: candidate,
+		Input:     input,
+	})
+	if len(s.report.RecentSamples) > maxShadowSamples {
+		s.report.RecentSamples = s.report.RecentSamples[len(s.report.RecentSamples)-maxShadowSamples:]
+	}
+}
+
+func (s *shadowPolicy) snapshot() ShadowReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report := s.report
+	report.ByAction = make(map[string]int64, len(s.report.ByAction))
+	for k, v := range s.report.ByAction {
+		report.ByAction[k] = v
+	}
+	report.ByRole = make(map[string]int64, len(s.report.ByRole))
+	for k, v := range s.report.ByRole {
+		report.ByRole[k] = v
+	}
+	report.RecentSamples = append([]ShadowDisagreement(nil), s.report.RecentSamples...)
+	report.AffectedUserIDs = make([]uint, 0, len(s.affectedUsers))
+	for id := range s.affectedUsers {
+		report.AffectedUserIDs = append(report.AffectedUserIDs, id)
+	}
+	return report
+}
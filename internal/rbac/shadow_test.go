@@ -0,0 +1,130 @@
+package rbac
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// denyAllPolicy 是拒绝所有请求的候选策略
+const denyAllPolicy = `package rbac
+
+default allow = false
+`
+
+func shadowInput(action, role string, userID uint) *PermissionInput {
+	input := &PermissionInput{Action: action}
+	input.User.ID = userID
+	input.User.Role = role
+	return input
+}
+
+// waitEvaluations 等待候选策略评估完 n 个请求
+func waitEvaluations(t *testing.T, pc *PermissionChecker, n int64) *ShadowReport {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		report := pc.ShadowReport()
+		if report.Evaluations >= n {
+			return report
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d of %d shadow evaluations finished", report.Evaluations, n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestShadowQueueDropsWhenFull(t *testing.T) {
+	engine, err := newPolicyEngine(denyAllPolicy, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc := NewPermissionChecker(engine)
+	// 不启动 worker，队列只进不出
+	shadow := &shadowPolicy{
+		engine:        engine,
+		queue:         make(chan shadowJob, shadowQueueSize),
+		done:          make(chan struct{}),
+		report:        ShadowReport{ByAction: map[string]int64{}, ByRole: map[string]int64{}},
+		affectedUsers: map[uint]struct{}{},
+	}
+	pc.shadow.Store(shadow)
+
+	const extra = 10
+	for i := 0; i < shadowQueueSize+extra; i++ {
+		pc.ShadowCheck(shadowInput("GET:/posts", "user", 1), true)
+	}
+	report := pc.ShadowReport()
+	if report.Dropped != extra || len(shadow.queue) != shadowQueueSize {
+		t.Fatalf("dropped = %d, queued = %d, want %d and %d", report.Dropped, len(shadow.queue), extra, shadowQueueSize)
+	}
+
+	// 启动 worker 后排队的请求都会被评估，之后的请求不再丢弃
+	for i := 0; i < shadowWorkers; i++ {
+		go shadow.run()
+	}
+	t.Cleanup(shadow.stop)
+	waitEvaluations(t, pc, shadowQueueSize)
+	pc.ShadowCheck(shadowInput("GET:/posts", "user", 1), true)
+	report = waitEvaluations(t, pc, shadowQueueSize+1)
+	if report.Dropped != extra || report.Disagreements != shadowQueueSize+1 {
+		t.Errorf("report = dropped %d disagreements %d, want %d and %d", report.Dropped, report.Disagreements, extra, shadowQueueSize+1)
+	}
+}
+
+func TestShadowReportCountsDisagreements(t *testing.T) {
+	engine, err := NewEmbeddedEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc := NewPermissionChecker(engine)
+	if _, err := pc.LoadShadowPolicy(denyAllPolicy, 7); err != nil {
+		t.Fatalf("LoadShadowPolicy: %v", err)
+	}
+	t.Cleanup(func() { pc.StopShadowPolicy() })
+
+	// 候选策略拒绝所有请求：生效策略允许的请求都是 NewlyDenied，生效策略拒绝的请求没有分歧
+	checks := []struct {
+		input         *PermissionInput
+		activeAllowed bool
+	}{
+		{shadowInput("GET:/posts", "user", 1), true},
+		{shadowInput("PUT:/posts/:id", "moderator", 2), true},
+		{shadowInput("GET:/posts", "user", 1), true},
+		{shadowInput("DELETE:/users/:id", "user", 3), false},
+	}
+	for _, c := range checks {
+		pc.ShadowCheck(c.input, c.activeAllowed)
+	}
+	report := waitEvaluations(t, pc, int64(len(checks)))
+
+	if report.RevisionID != 7 || report.Hash != policyHash(denyAllPolicy) {
+		t.Errorf("report revision = %d/%.12s", report.RevisionID, report.Hash)
+	}
+	if report.Disagreements != 3 || report.NewlyDenied != 3 || report.NewlyAllowed != 0 || report.Errors != 0 {
+		t.Errorf("report = %+v, want 3 newly denied", report)
+	}
+	if report.ByAction["GET:/posts"] != 2 || report.ByAction["PUT:/posts/:id"] != 1 || report.ByRole["user"] != 2 || report.ByRole["moderator"] != 1 {
+		t.Errorf("by action = %v, by role = %v", report.ByAction, report.ByRole)
+	}
+	slices.Sort(report.AffectedUserIDs)
+	if !slices.Equal(report.AffectedUserIDs, []uint{1, 2}) || len(report.RecentSamples) != 3 {
+		t.Errorf("affected users = %v, samples = %d", report.AffectedUserIDs, len(report.RecentSamples))
+	}
+
+	// 报告是快照，修改它不影响之后的报告
+	report.ByAction["GET:/posts"] = 100
+	report.RecentSamples[0].Active = false
+	again := pc.ShadowReport()
+	if again.ByAction["GET:/posts"] != 2 || !again.RecentSamples[0].Active {
+		t.Error("modifying a report changed the shadow policy state")
+	}
+
+	final := pc.StopShadowPolicy()
+	if final == nil || final.Evaluations != int64(len(checks)) || pc.ShadowReport() != nil {
+		t.Errorf("StopShadowPolicy = %+v, report after stop = %+v", final, pc.ShadowReport())
+	}
+	// 停止后的请求不再评估
+	pc.ShadowCheck(shadowInput("GET:/posts", "user", 1), true)
+}
@@ -1,10 +1,12 @@
 package user
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/shenjing023/rbac-api-gateway/pkg/password"
 )
 
 type Handler struct {
@@ -23,6 +25,10 @@ func (h *Handler) CreateUser(c *gin.Context) {
 	}
 
 	if err := h.service.CreateUser(&user); err != nil {
+		if errors.Is(err, password.ErrPolicyViolation) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 		return
 	}
@@ -30,6 +36,24 @@ func (h *Handler) CreateUser(c *gin.Context) {
 	c.JSON(http.StatusCreated, gin.H{"message": "User created successfully", "user": user})
 }
 
+func (h *Handler) CreateServiceAccount(c *gin.Context) {
+	var req struct {
+		Username string `json:"username" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	account, err := h.service.CreateServiceAccount(req.Username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create service account"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Service account created successfully", "user": account})
+}
+
 func (h *Handler) GetUser(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
@@ -89,6 +113,7 @@ func RegisterRoutes(r *gin.Engine, service *Service) {
 	userGroup := r.Group("/users")
 	{
 		userGroup.POST("", handler.CreateUser)
+		userGroup.POST("/service-accounts", handler.CreateServiceAccount)
 		userGroup.GET("/:id", handler.GetUser)
 		userGroup.PUT("/:id", handler.UpdateUser)
 		userGroup.DELETE("/:id", handler.DeleteUser)
@@ -9,8 +9,31 @@ type User struct {
 	Username string `gorm:"uniqueIndex;not null"`
 	Password string `gorm:"not null"`
 	Role     string `gorm:"not null;default:'user'"`
+	Kind     string `gorm:"not null;default:'user'"`
+	// Email 用于发送密码重置等通知，可以为空
+	Email  string `gorm:"index"`
+	Status string `gorm:"index;not null;default:'active'"`
 }
 
+// Status 是账号的状态，只有 active 的账号可以登录和访问接口
+type Status string
+
+const (
+	// StatusPending 是注册后还没有验证邮箱的账号
+	StatusPending     Status = "pending"
+	StatusActive      Status = "active"
+	StatusSuspended   Status = "suspended"
+	StatusDeactivated Status = "deactivated"
+)
+
+// Kind 区分真人用户和服务账号，服务账号没有密码，只能通过客户端证书等方式认证
+type Kind string
+
+const (
+	KindUser    Kind = "user"
+	KindService Kind = "service"
+)
+
 type Role string
 
 const (
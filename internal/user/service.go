@@ -1,21 +1,33 @@
 package user
 
 import (
+	"context"
 	"errors"
 
+	"github.com/shenjing023/rbac-api-gateway/pkg/password"
+
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
 type Service struct {
-	db *gorm.DB
+	db     *gorm.DB
+	policy *password.Policy
 }
 
 func NewService(db *gorm.DB) *Service {
 	return &Service{db: db}
 }
 
+// SetPasswordPolicy 设置管理员创建用户时使用的密码策略
+func (s *Service) SetPasswordPolicy(policy *password.Policy) {
+	s.policy = policy
+}
+
 func (s *Service) CreateUser(user *User) error {
+	if err := s.policy.Validate(context.Background(), user.Password, user.Username, user.Email); err != nil {
+		return err
+	}
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
 	if err != nil {
 		return err
@@ -24,6 +36,19 @@ func (s *Service) CreateUser(user *User) error {
 	return s.db.Create(user).Error
 }
 
+// CreateServiceAccount 创建服务账号，角色通过 rbac.UserRole 分配
+func (s *Service) CreateServiceAccount(username string) (*User, error) {
+	account := &User{
+		Username: username,
+		Role:     string(RoleUser),
+		Kind:     string(KindService),
+	}
+	if err := s.db.Create(account).Error; err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
 func (s *Service) GetUserByID(id uint) (*User, error) {
 	var user User
 	if err := s.db.First(&user, id).Error; err != nil {
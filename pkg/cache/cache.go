@@ -14,12 +14,21 @@ type Cache struct {
 var (
 	instance *Cache
 	once     sync.Once
+
+	defaultExpiration = 5 * time.Minute
+	cleanupInterval   = 10 * time.Minute
 )
 
+// Configure 设置缓存的默认过期时间和清理间隔，需要在第一次调用 GetInstance 之前调用
+func Configure(expiration, cleanup time.Duration) {
+	defaultExpiration = expiration
+	cleanupInterval = cleanup
+}
+
 func GetInstance() *Cache {
 	once.Do(func() {
 		instance = &Cache{
-			c: cache.New(5*time.Minute, 10*time.Minute),
+			c: cache.New(defaultExpiration, cleanupInterval),
 		}
 	})
 	return instance
@@ -29,6 +38,11 @@ func (c *Cache) Set(key string, value interface{}, duration time.Duration) {
 	c.c.Set(key, value, duration)
 }
 
+// SetDefault 使用默认过期时间写入缓存
+func (c *Cache) SetDefault(key string, value interface{}) {
+	c.c.SetDefault(key, value)
+}
+
 func (c *Cache) Get(key string) (interface{}, bool) {
 	return c.c.Get(key)
 }
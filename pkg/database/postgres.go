@@ -1,21 +1,48 @@
 package database
 
 import (
-	"fmt"
-	"log"
+	"context"
+	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
-func InitDB(host, user, password, dbname string, port int) (*gorm.DB, error) {
-	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%d sslmode=disable TimeZone=Asia/Shanghai",
-		host, user, password, dbname, port)
+type Config struct {
+	DSN string
+	// Password 在每次建立新连接时调用，返回最新的数据库密码，为 nil 或返回空字符串时使用 DSN 中的密码。
+	// 密码轮换后新建的连接会自动使用新密码，无需重启服务
+	Password        func() string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
 
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+func InitDB(cfg Config) (*gorm.DB, error) {
+	connConfig, err := pgx.ParseConfig(cfg.DSN)
 	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+		return nil, err
 	}
 
+	sqlDB := stdlib.OpenDB(*connConfig, stdlib.OptionBeforeConnect(func(_ context.Context, cc *pgx.ConnConfig) error {
+		if cfg.Password == nil {
+			return nil
+		}
+		if password := cfg.Password(); password != "" {
+			cc.Password = password
+		}
+		return nil
+	}))
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{})
+	if err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
 	return db, nil
 }
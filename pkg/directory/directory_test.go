@@ -0,0 +1,139 @@
+package directory
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/shenjing023/rbac-api-gateway/pkg/directory/directorytest"
+)
+
+const (
+	testServiceDN = "cn=gateway,ou=services,dc=example,dc=com"
+	testAdminsDN  = "cn=Admins,ou=groups,dc=example,dc=com"
+)
+
+func newTestDirectory(t *testing.T) *directorytest.Server {
+	t.Helper()
+	person := func(uid, password string, groups ...string) directorytest.Entry {
+		return directorytest.Entry{
+			DN:       "uid=" + uid + ",ou=people,dc=example,dc=com",
+			Password: password,
+			Attributes: map[string][]string{
+				"objectClass": {"person"},
+				"uid":         {uid},
+				"memberOf":    groups,
+			},
+		}
+	}
+	srv, err := directorytest.NewServer(
+		directorytest.Entry{DN: testServiceDN, Password: "service-pass"},
+		person("alice", "alice-pass", testAdminsDN),
+		person("bob", "bob-pass"),
+		// 两个条目的 uid 相同，按用户名查找时结果不唯一
+		person("carol", "carol-pass"),
+		directorytest.Entry{DN: "uid=carol,ou=contractors,dc=example,dc=com", Password: "carol-pass", Attributes: map[string][]string{"uid": {"carol"}}},
+		// 没有用户名属性的条目在同步时被忽略
+		directorytest.Entry{DN: "cn=printer,ou=people,dc=example,dc=com", Attributes: map[string][]string{"objectClass": {"person"}}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newTestClient(t *testing.T, url, bindPassword string) *Client {
+	t.Helper()
+	c, err := NewClient(Config{
+		URL:               url,
+		BindDN:            testServiceDN,
+		BindPassword:      bindPassword,
+		BaseDN:            "dc=example,dc=com",
+		UserFilter:        "(uid={username})",
+		SyncFilter:        "(objectClass=person)",
+		UsernameAttribute: "uid",
+		GroupAttribute:    "memberOf",
+		Timeout:           time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	return c
+}
+
+func TestAuthenticate(t *testing.T) {
+	srv := newTestDirectory(t)
+
+	tests := []struct {
+		name         string
+		bindPassword string
+		username     string
+		password     string
+		wantErr      error // 为 nil 且 wantAnyErr 为 false 表示认证成功
+		wantAnyErr   bool
+		wantGroups   []string
+	}{
+		{name: "valid password", bindPassword: "service-pass", username: "alice", password: "alice-pass", wantGroups: []string{testAdminsDN}},
+		{name: "user without groups", bindPassword: "service-pass", username: "bob", password: "bob-pass"},
+		{name: "wrong password", bindPassword: "service-pass", username: "alice", password: "bob-pass", wantErr: ErrInvalidCredentials},
+		{name: "unknown user", bindPassword: "service-pass", username: "mallory", password: "x", wantErr: ErrInvalidCredentials},
+		{name: "empty password", bindPassword: "service-pass", username: "alice", password: "", wantErr: ErrInvalidCredentials},
+		{name: "ambiguous username", bindPassword: "service-pass", username: "carol", password: "carol-pass", wantErr: ErrInvalidCredentials},
+		{name: "wildcard username", bindPassword: "service-pass", username: "*", password: "alice-pass", wantErr: ErrInvalidCredentials},
+		{name: "service bind fails", bindPassword: "wrong", username: "alice", password: "alice-pass", wantAnyErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, err := newTestClient(t, srv.URL, tt.bindPassword).Authenticate(tt.username, tt.password)
+			if tt.wantErr != nil || tt.wantAnyErr {
+				if err == nil {
+					t.Fatalf("expected error, got entry %+v", entry)
+				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Authenticate error = %v, want %v", err, tt.wantErr)
+				}
+				// 服务账号配置错误不能被当作用户密码错误
+				if tt.wantAnyErr && errors.Is(err, ErrInvalidCredentials) {
+					t.Fatalf("Authenticate error = %v, must not be ErrInvalidCredentials", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate: %v", err)
+			}
+			if entry.Username != tt.username || !slices.Equal(entry.Groups, tt.wantGroups) {
+				t.Errorf("entry = %+v, want username %s groups %v", entry, tt.username, tt.wantGroups)
+			}
+		})
+	}
+}
+
+func TestAuthenticateUnavailable(t *testing.T) {
+	srv := newTestDirectory(t)
+	client := newTestClient(t, srv.URL, "service-pass")
+	srv.Close()
+
+	_, err := client.Authenticate("alice", "alice-pass")
+	if err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Authenticate error = %v, want a connection error", err)
+	}
+}
+
+func TestUsers(t *testing.T) {
+	srv := newTestDirectory(t)
+
+	entries, err := newTestClient(t, srv.URL, "service-pass").Users()
+	if err != nil {
+		t.Fatalf("Users: %v", err)
+	}
+	var usernames []string
+	for _, e := range entries {
+		usernames = append(usernames, e.Username)
+	}
+	slices.Sort(usernames)
+	if want := []string{"alice", "bob", "carol"}; !slices.Equal(usernames, want) {
+		t.Errorf("Users = %v, want %v", usernames, want)
+	}
+}
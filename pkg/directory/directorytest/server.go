@@ -0,0 +1,218 @@
+// Package directorytest 提供一个内存中的 LDAP 服务器，用于测试 directory.Client。
+// 只实现简单绑定，以及由 and、or、相等和存在条件组成的查询，不支持 TLS
+package directorytest
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Entry 是目录中的一个条目，Password 不为空时可以用 DN 和 Password 绑定
+type Entry struct {
+	DN         string
+	Password   string
+	Attributes map[string][]string
+}
+
+// Server 在本地回环地址上监听，URL 例如 ldap://127.0.0.1:38211
+type Server struct {
+	URL string
+
+	ln      net.Listener
+	mu      sync.Mutex
+	entries []Entry
+	wg      sync.WaitGroup
+}
+
+func NewServer(entries ...Entry) (*Server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{URL: "ldap://" + ln.Addr().String(), ln: ln, entries: entries}
+	s.wg.Add(1)
+	go s.accept()
+	return s, nil
+}
+
+// SetEntries 替换目录中的所有条目
+func (s *Server) SetEntries(entries ...Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = entries
+}
+
+// Close 停止监听，之后的连接都会失败
+func (s *Server) Close() {
+	s.ln.Close()
+	s.wg.Wait()
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.serve(conn)
+	}
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+	var bound bool
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id, _ := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			bound = s.bind(op)
+			code := ldap.LDAPResultSuccess
+			if !bound {
+				code = ldap.LDAPResultInvalidCredentials
+			}
+			if !write(conn, id, result(ldap.ApplicationBindResponse, code)) {
+				return
+			}
+		case ldap.ApplicationSearchRequest:
+			if !bound {
+				if !write(conn, id, result(ldap.ApplicationSearchResultDone, ldap.LDAPResultInsufficientAccessRights)) {
+					return
+				}
+				continue
+			}
+			for _, e := range s.search(op) {
+				if !write(conn, id, searchEntry(e)) {
+					return
+				}
+			}
+			if !write(conn, id, result(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess)) {
+				return
+			}
+		default:
+			// unbind 和不支持的操作都直接关闭连接
+			return
+		}
+	}
+}
+
+func (s *Server) bind(op *ber.Packet) bool {
+	if len(op.Children) < 3 || op.Children[2].Tag != 0 {
+		return false
+	}
+	dn, _ := op.Children[1].Value.(string)
+	password := op.Children[2].Data.String()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.entries {
+		if strings.EqualFold(e.DN, dn) {
+			return e.Password != "" && e.Password == password
+		}
+	}
+	return false
+}
+
+func (s *Server) search(op *ber.Packet) []Entry {
+	if len(op.Children) < 7 {
+		return nil
+	}
+	base, _ := op.Children[0].Value.(string)
+	filter := op.Children[6]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var matched []Entry
+	for _, e := range s.entries {
+		if strings.HasSuffix(strings.ToLower(e.DN), strings.ToLower(base)) && match(e, filter) {
+			matched = append(matched, e)
+		}
+	}
+	return matched
+}
+
+// match 判断条目是否满足过滤条件，属性名和值都不区分大小写
+func match(e Entry, filter *ber.Packet) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !match(e, child) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			if match(e, child) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterEqualityMatch:
+		if len(filter.Children) != 2 {
+			return false
+		}
+		for _, v := range attribute(e, filter.Children[0].Data.String()) {
+			if strings.EqualFold(v, filter.Children[1].Data.String()) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterPresent:
+		return len(attribute(e, filter.Data.String())) > 0
+	}
+	return false
+}
+
+func attribute(e Entry, name string) []string {
+	for k, v := range e.Attributes {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return nil
+}
+
+func write(conn net.Conn, id int64, op *ber.Packet) bool {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "MessageID"))
+	packet.AppendChild(op)
+	_, err := conn.Write(packet.Bytes())
+	return err == nil
+}
+
+func result(tag ber.Tag, code int) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	return op
+}
+
+func searchEntry(e Entry) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.DN, "DN"))
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for name, values := range e.Attributes {
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, v := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "Value"))
+		}
+		attr.AppendChild(set)
+		attrs.AppendChild(attr)
+	}
+	op.AppendChild(attrs)
+	return op
+}
@@ -0,0 +1,175 @@
+package directory
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ErrInvalidCredentials 表示用户不存在或密码错误，两种情况不做区分
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Config 是连接 LDAP / Active Directory 的配置
+type Config struct {
+	// URL 例如 ldaps://dc.example.com:636 或 ldap://dc.example.com:389
+	URL      string
+	StartTLS bool
+	// CAFile 用于校验服务器证书，为空时使用系统根证书
+	CAFile string
+	// BindDN 和 BindPassword 是用于查找用户的服务账号
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// UserFilter 中的 {username} 会被替换为转义后的用户名
+	UserFilter string
+	// SyncFilter 是同步时列出所有用户的过滤条件
+	SyncFilter        string
+	UsernameAttribute string
+	GroupAttribute    string
+	Timeout           time.Duration
+}
+
+// Entry 是目录中的用户和其所属的组（组的 DN）
+type Entry struct {
+	DN       string
+	Username string
+	Groups   []string
+}
+
+// Client 每次操作建立新的连接，登录和同步的频率都不高，不需要连接池
+type Client struct {
+	cfg       Config
+	tlsConfig *tls.Config
+}
+
+func NewClient(cfg Config) (*Client, error) {
+	if cfg.URL == "" || cfg.BaseDN == "" {
+		return nil, errors.New("ldap url and base dn are required")
+	}
+	if !strings.Contains(cfg.UserFilter, "{username}") {
+		return nil, errors.New("ldap user filter must contain {username}")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+
+	host := cfg.URL
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	tlsConfig := &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return &Client{cfg: cfg, tlsConfig: tlsConfig}, nil
+}
+
+// Authenticate 用服务账号查找用户，再以用户的 DN 和密码绑定校验密码
+func (c *Client) Authenticate(username, password string) (*Entry, error) {
+	// 空密码的简单绑定在很多服务器上会被当作匿名绑定而成功，必须拒绝
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	filter := strings.ReplaceAll(c.cfg.UserFilter, "{username}", ldap.EscapeFilter(username))
+	result, err := conn.Search(c.searchRequest(filter, 2))
+	if err != nil {
+		return nil, fmt.Errorf("search user: %w", err)
+	}
+	if len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+
+	entry := result.Entries[0]
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("bind user: %w", err)
+	}
+	return c.entry(entry), nil
+}
+
+// Users 列出 SyncFilter 匹配的所有用户，使用分页查询以支持大目录
+func (c *Client) Users() ([]Entry, error) {
+	conn, err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	result, err := conn.SearchWithPaging(c.searchRequest(c.cfg.SyncFilter, 0), 500)
+	if err != nil {
+		return nil, fmt.Errorf("search users: %w", err)
+	}
+	entries := make([]Entry, 0, len(result.Entries))
+	for _, e := range result.Entries {
+		if entry := c.entry(e); entry.Username != "" {
+			entries = append(entries, *entry)
+		}
+	}
+	return entries, nil
+}
+
+// connect 建立连接并以服务账号绑定
+func (c *Client) connect() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(c.cfg.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: c.cfg.Timeout}),
+		ldap.DialWithTLSConfig(c.tlsConfig))
+	if err != nil {
+		return nil, fmt.Errorf("connect ldap: %w", err)
+	}
+	conn.SetTimeout(c.cfg.Timeout)
+
+	if c.cfg.StartTLS {
+		if err := conn.StartTLS(c.tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ldap starttls: %w", err)
+		}
+	}
+	if c.cfg.BindDN != "" {
+		if err := conn.Bind(c.cfg.BindDN, c.cfg.BindPassword); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ldap service bind: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+func (c *Client) searchRequest(filter string, sizeLimit int) *ldap.SearchRequest {
+	return ldap.NewSearchRequest(c.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		sizeLimit, int(c.cfg.Timeout.Seconds()), false, filter,
+		[]string{c.cfg.UsernameAttribute, c.cfg.GroupAttribute}, nil)
+}
+
+func (c *Client) entry(e *ldap.Entry) *Entry {
+	return &Entry{
+		DN:       e.DN,
+		Username: e.GetAttributeValue(c.cfg.UsernameAttribute),
+		Groups:   e.GetAttributeValues(c.cfg.GroupAttribute),
+	}
+}
@@ -1,38 +1,221 @@
 package jwt
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 )
 
-var secretKey = []byte("your-secret-key") // 在实际应用中，应该使用环境变量存储密钥
+// MinSecretLength 是 HS256 签名密钥的最小长度
+const MinSecretLength = 32
+
+// Config 是签发和校验 token 使用的配置，需要在服务启动时通过 Init 设置
+type Config struct {
+	Secret     []byte
+	Issuer     string
+	Expiration time.Duration
+}
+
+// keySet 是当前的签名密钥，以及轮换前的旧密钥。
+// 旧密钥只用于校验，在轮换后的一个 token 有效期内仍然接受，避免已签发的 token 立即失效
+type keySet struct {
+	current   []byte
+	previous  []byte
+	rotatedAt time.Time
+}
+
+var (
+	config Config
+	keys   atomic.Pointer[keySet]
+)
+
+var (
+	// ErrNotConfigured 表示尚未调用 Init 设置签名密钥
+	ErrNotConfigured = errors.New("jwt secret is not configured")
+	// ErrMFAPending 表示 token 还没有完成多因素认证，不能访问 API
+	ErrMFAPending = errors.New("token is pending multi-factor authentication")
+	// ErrPurposeMismatch 表示 token 不是为当前用途签发的
+	ErrPurposeMismatch = errors.New("token purpose mismatch")
+)
+
+func Init(cfg Config) error {
+	if err := checkSecret(cfg.Secret); err != nil {
+		return err
+	}
+	config = cfg
+	keys.Store(&keySet{current: cfg.Secret})
+	return nil
+}
+
+// SetSecret 轮换签名密钥，新签发的 token 使用新密钥，旧密钥在一个 token 有效期内仍可用于校验
+func SetSecret(secret []byte) error {
+	if err := checkSecret(secret); err != nil {
+		return err
+	}
+	old := keys.Load()
+	if old == nil {
+		return ErrNotConfigured
+	}
+	keys.Store(&keySet{current: secret, previous: old.current, rotatedAt: time.Now()})
+	return nil
+}
+
+func checkSecret(secret []byte) error {
+	if len(secret) < MinSecretLength {
+		return fmt.Errorf("jwt secret must be at least %d bytes", MinSecretLength)
+	}
+	return nil
+}
+
+// Actor 是 RFC 8693 的 act 声明：token 的主体是被模拟的用户，Actor 是实际执行操作的用户
+type Actor struct {
+	UserID   uint   `json:"user_id"`
+	Username string `json:"username"`
+}
 
 type Claims struct {
 	UserID   uint   `json:"user_id"`
 	Username string `json:"username"`
 	Role     string `json:"role"`
+	// Scopes 限制凭据可执行的操作，为空表示不限制
+	Scopes []string `json:"scopes,omitempty"`
+	// ClientID 是 OAuth2 访问令牌所属的客户端
+	ClientID string `json:"client_id,omitempty"`
+	// AMR 是 RFC 8176 定义的认证方式，例如 pwd、otp、mfa，策略可以据此要求多因素认证
+	AMR []string `json:"amr,omitempty"`
+	// MFAPending 表示只完成了第一步认证，这种 token 只能用于提交第二因素
+	MFAPending bool `json:"mfa_pending,omitempty"`
+	// Purpose 不为空的 token 只能用于对应的用途，例如邮箱验证链接，不能访问 API
+	Purpose string `json:"purpose,omitempty"`
+	// Act 不为空表示这是管理员模拟用户的 token，权限按主体（被模拟的用户）判断
+	Act *Actor `json:"act,omitempty"`
+	// CSRF 只在保存在浏览器会话 cookie 中的 token 上设置，使用 cookie 的状态变更请求必须在请求头中回传它
+	CSRF string `json:"csrf,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// 不经过交互式登录的凭据在 AMR 中使用的认证方式，策略据此决定它们能否代替多因素认证
+const (
+	// AMRKey 表示 API key、OAuth2 客户端凭据等共享密钥
+	AMRKey = "key"
+	// AMRCert 表示客户端证书
+	AMRCert = "cert"
+)
+
+// Expiration 返回登录 token 的有效期
+func Expiration() time.Duration {
+	return config.Expiration
+}
+
 func GenerateToken(userID uint, username, role string) (string, error) {
-	claims := Claims{
-		UserID:   userID,
-		Username: username,
-		Role:     role,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-		},
+	return IssueToken(Claims{UserID: userID, Username: username, Role: role}, config.Expiration)
+}
+
+// IssueToken 签发有效期为 ttl 的 token，并补全 iss、iat、exp 和 jti
+func IssueToken(claims Claims, ttl time.Duration) (string, error) {
+	ks := keys.Load()
+	if ks == nil {
+		return "", ErrNotConfigured
+	}
+
+	jti, err := NewJTI()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims.Issuer = config.Issuer
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(ttl))
+	if claims.ID == "" {
+		claims.ID = jti
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(secretKey)
+	return token.SignedString(ks.current)
+}
+
+// NewJTI 生成随机的 jti，调用方需要在签发前知道 jti 时使用（例如记录会话）
+func NewJTI() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
 }
 
+// ValidateToken 校验访问 API 使用的 token，等待多因素认证的 token 会被拒绝
 func ValidateToken(tokenString string) (*Claims, error) {
+	claims, err := validate(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.MFAPending {
+		return nil, ErrMFAPending
+	}
+	if claims.Purpose != "" {
+		return nil, ErrPurposeMismatch
+	}
+	return claims, nil
+}
+
+// ValidateMFAToken 校验登录第一步签发的、等待多因素认证的 token
+func ValidateMFAToken(tokenString string) (*Claims, error) {
+	claims, err := validate(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if !claims.MFAPending || claims.Purpose != "" {
+		return nil, errors.New("token is not pending multi-factor authentication")
+	}
+	return claims, nil
+}
+
+// IssuePurposeToken 签发只能用于 purpose 的 token
+func IssuePurposeToken(claims Claims, purpose string, ttl time.Duration) (string, error) {
+	if purpose == "" {
+		return "", errors.New("token purpose is required")
+	}
+	claims.Purpose = purpose
+	return IssueToken(claims, ttl)
+}
+
+// ValidatePurposeToken 校验为 purpose 签发的 token
+func ValidatePurposeToken(tokenString, purpose string) (*Claims, error) {
+	claims, err := validate(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose == "" || claims.Purpose != purpose || claims.MFAPending {
+		return nil, ErrPurposeMismatch
+	}
+	return claims, nil
+}
+
+func validate(tokenString string) (*Claims, error) {
+	ks := keys.Load()
+	if ks == nil {
+		return nil, ErrNotConfigured
+	}
+
+	claims, err := parse(tokenString, ks.current)
+	if errors.Is(err, jwt.ErrSignatureInvalid) && ks.previous != nil && time.Since(ks.rotatedAt) < config.Expiration {
+		claims, err = parse(tokenString, ks.previous)
+	}
+	return claims, err
+}
+
+func parse(tokenString string, secret []byte) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return secretKey, nil
+		// 只接受 HMAC 签名，防止算法替换攻击
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return secret, nil
 	})
 
 	if err != nil {
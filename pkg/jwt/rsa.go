@@ -0,0 +1,162 @@
+package jwt
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// rsaKeySet 是签发 ID token 等需要第三方校验的 token 使用的 RSA 密钥，
+// 轮换后旧公钥仍然发布在 JWKS 中，保证已签发的 token 可以继续校验
+type rsaKeySet struct {
+	current  *rsaKey
+	previous *rsaKey
+}
+
+type rsaKey struct {
+	id  string
+	key *rsa.PrivateKey
+}
+
+var rsaKeys atomic.Pointer[rsaKeySet]
+
+// ErrNoRSAKey 表示尚未调用 SetRSAKey 设置 RSA 签名密钥
+var ErrNoRSAKey = errors.New("rsa signing key is not configured")
+
+// JWK 是 RFC 7517 定义的 RSA 公钥
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+}
+
+// SetRSAKey 设置或轮换 RS256 签名密钥
+func SetRSAKey(key *rsa.PrivateKey) error {
+	if key.N.BitLen() < 2048 {
+		return errors.New("rsa signing key must be at least 2048 bits")
+	}
+	id, err := thumbprint(&key.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	next := &rsaKeySet{current: &rsaKey{id: id, key: key}}
+	if old := rsaKeys.Load(); old != nil && old.current.id != id {
+		next.previous = old.current
+	}
+	rsaKeys.Store(next)
+	return nil
+}
+
+// ParseRSAKey 解析 PKCS#1 或 PKCS#8 格式的 PEM 私钥
+func ParseRSAKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an RSA private key")
+	}
+	return rsaKey, nil
+}
+
+// GenerateRSAKey 生成临时的 RSA 密钥，重启后已签发的 token 都无法再校验，只适合开发环境
+func GenerateRSAKey() (*rsa.PrivateKey, error) {
+	return rsa.GenerateKey(rand.Reader, 2048)
+}
+
+// SignRS256 使用当前的 RSA 密钥签名，header 中带上 kid 便于校验方在 JWKS 中查找公钥
+func SignRS256(claims jwt.Claims) (string, error) {
+	ks := rsaKeys.Load()
+	if ks == nil {
+		return "", ErrNoRSAKey
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = ks.current.id
+	return token.SignedString(ks.current.key)
+}
+
+// JWKS 返回当前和轮换前的公钥
+func JWKS() []JWK {
+	ks := rsaKeys.Load()
+	if ks == nil {
+		return []JWK{}
+	}
+	keys := []JWK{toJWK(ks.current)}
+	if ks.previous != nil {
+		keys = append(keys, toJWK(ks.previous))
+	}
+	return keys
+}
+
+func toJWK(k *rsaKey) JWK {
+	return JWK{
+		KeyType:   "RSA",
+		Use:       "sig",
+		Algorithm: "RS256",
+		KeyID:     k.id,
+		N:         base64.RawURLEncoding.EncodeToString(k.key.N.Bytes()),
+		E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.key.E)).Bytes()),
+	}
+}
+
+// thumbprint 按 RFC 7638 计算公钥指纹，作为 kid
+func thumbprint(pub *rsa.PublicKey) (string, error) {
+	// 成员必须按字典序排列且没有空白
+	data, err := json.Marshal(struct {
+		E   string `json:"e"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+	}{
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		Kty: "RSA",
+		N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// IDClaims 是 OpenID Connect ID token 中的声明
+type IDClaims struct {
+	Nonce             string `json:"nonce,omitempty"`
+	AuthorizedParty   string `json:"azp,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Name              string `json:"name,omitempty"`
+	Role              string `json:"role,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// IssueIDToken 签发 RS256 的 ID token，issuer 必须与 OIDC discovery 中发布的一致
+func IssueIDToken(claims IDClaims, issuer, audience string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims.Issuer = issuer
+	claims.Audience = jwt.ClaimStrings{audience}
+	claims.AuthorizedParty = audience
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(ttl))
+	return SignRS256(claims)
+}
@@ -0,0 +1,107 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message 是发送给用户的通知，To 是收件地址，可能为空
+type Message struct {
+	To       string `json:"to"`
+	Username string `json:"username"`
+	Subject  string `json:"subject"`
+	Body     string `json:"body"`
+}
+
+// Notifier 把通知发送给用户，例如密码重置链接
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// FileNotifier 把通知以 JSON 行追加到文件中，用于开发环境或由其他程序转发
+type FileNotifier struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileNotifier(path string) *FileNotifier {
+	return &FileNotifier{path: path}
+}
+
+func (n *FileNotifier) Notify(ctx context.Context, msg Message) error {
+	line, err := json.Marshal(struct {
+		Time time.Time `json:"time"`
+		Message
+	}{time.Now(), msg})
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	// 通知中包含重置令牌等敏感内容，只允许当前用户读取
+	f, err := os.OpenFile(n.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// SMTPConfig 是发送邮件的 SMTP 服务器配置，配置了 Username 时使用 PLAIN 认证
+type SMTPConfig struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+// SMTPNotifier 通过 SMTP 发送纯文本邮件，服务器支持时自动使用 STARTTLS
+type SMTPNotifier struct {
+	cfg SMTPConfig
+}
+
+func NewSMTPNotifier(cfg SMTPConfig) (*SMTPNotifier, error) {
+	if cfg.Addr == "" || cfg.From == "" {
+		return nil, errors.New("smtp addr and from are required")
+	}
+	if _, _, err := net.SplitHostPort(cfg.Addr); err != nil {
+		return nil, fmt.Errorf("invalid smtp addr: %w", err)
+	}
+	return &SMTPNotifier{cfg: cfg}, nil
+}
+
+func (n *SMTPNotifier) Notify(ctx context.Context, msg Message) error {
+	if msg.To == "" {
+		return fmt.Errorf("user %s has no email address", msg.Username)
+	}
+	// 防止通过收件人或主题注入邮件头
+	if strings.ContainsAny(msg.To+msg.Subject, "\r\n") {
+		return errors.New("invalid email header")
+	}
+
+	var auth smtp.Auth
+	if n.cfg.Username != "" {
+		host, _, _ := net.SplitHostPort(n.cfg.Addr)
+		auth = smtp.PlainAuth("", n.cfg.Username, n.cfg.Password, host)
+	}
+	body := "From: " + n.cfg.From + "\r\n" +
+		"To: " + msg.To + "\r\n" +
+		"Subject: " + mime.QEncoding.Encode("UTF-8", msg.Subject) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + strings.ReplaceAll(msg.Body, "\n", "\r\n")
+	return smtp.SendMail(n.cfg.Addr, auth, n.cfg.From, []string{msg.To}, []byte(body))
+}
@@ -0,0 +1,134 @@
+package opa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/open-policy-agent/opa/bundle"
+)
+
+// ErrUnknownQuery 表示引擎中没有注册该名称的查询
+var ErrUnknownQuery = errors.New("unknown policy query")
+
+// Queries 是查询名称到 Rego 查询的映射，例如 {"allow": "data.rbac.allow"}
+type Queries map[string]string
+
+// Decision 是一次策略评估的结果
+type Decision struct {
+	Result   interface{}
+	Revision string // 做出该决策的策略版本
+}
+
+// Allowed 将结果解释为布尔值，未定义的结果视为拒绝
+func (d *Decision) Allowed() (bool, error) {
+	if d == nil || d.Result == nil {
+		return false, nil
+	}
+	allowed, ok := d.Result.(bool)
+	if !ok {
+		return false, fmt.Errorf("unexpected result type %T from policy evaluation", d.Result)
+	}
+	return allowed, nil
+}
+
+// PolicyEngine 是策略评估引擎的统一接口
+type PolicyEngine interface {
+	// Eval 使用 input 评估名为 name 的查询
+	Eval(ctx context.Context, name string, input interface{}) (*Decision, error)
+}
+
+// Reloadable 是支持在运行时整体替换策略模块的引擎
+type Reloadable interface {
+	PolicyEngine
+	LoadModules(ctx context.Context, modules map[string]string, revision string) error
+	Revision() string
+}
+
+// Option 配置策略引擎
+type Option func(*options)
+
+type options struct {
+	timeout    time.Duration
+	httpClient *http.Client
+	headers    map[string]string
+	retries    int
+	backoff    time.Duration
+	fallback   FallbackMode
+	cacheTTL   time.Duration
+	cacheSize  int
+	maxConns   int
+
+	bundleKeyID            string
+	bundleKey              *bundle.KeyConfig
+	skipBundleVerification bool
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{timeout: 5 * time.Second, backoff: 50 * time.Millisecond}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithTimeout 设置单次评估的超时时间，0 表示只使用调用方 context 的截止时间
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// WithHTTPClient 设置远程引擎使用的 HTTP 客户端
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *options) {
+		o.httpClient = client
+	}
+}
+
+// WithHeader 为远程引擎的每个请求添加请求头，例如 Authorization
+func WithHeader(key, value string) Option {
+	return func(o *options) {
+		if o.headers == nil {
+			o.headers = map[string]string{}
+		}
+		o.headers[key] = value
+	}
+}
+
+// WithBundleVerification 要求 bundle 带有 .signatures.json，并用 keyID 对应的公钥校验签名。
+// key 是 PEM 格式的公钥或 HMAC 密钥，algorithm 为空时使用 RS256，scope 为空时不校验签名中的 scope
+func WithBundleVerification(keyID, key, algorithm, scope string) Option {
+	return func(o *options) {
+		if algorithm == "" {
+			algorithm = "RS256"
+		}
+		o.bundleKeyID = keyID
+		o.bundleKey = &bundle.KeyConfig{Key: key, Algorithm: algorithm, Scope: scope}
+	}
+}
+
+// WithInsecureSkipBundleVerification 加载 bundle 时不校验签名，只应该在开发环境中使用
+func WithInsecureSkipBundleVerification() Option {
+	return func(o *options) {
+		o.skipBundleVerification = true
+	}
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// dataPath 将 "data.rbac.allow" 形式的查询转换为 REST API 使用的 "rbac/allow"
+func dataPath(query string) (string, error) {
+	if query != "data" && !strings.HasPrefix(query, "data.") {
+		return "", fmt.Errorf("query %q is not a data reference", query)
+	}
+	return strings.ReplaceAll(strings.TrimPrefix(strings.TrimPrefix(query, "data"), "."), ".", "/"), nil
+}
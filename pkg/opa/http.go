@@ -0,0 +1,287 @@
+package opa
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FallbackMode 决定远程 OPA 不可用时引擎的行为
+type FallbackMode string
+
+const (
+	// FallbackError 返回错误，由调用方决定如何处理
+	FallbackError FallbackMode = "error"
+	// FallbackDeny 即 fail-closed，返回拒绝的决策
+	FallbackDeny FallbackMode = "deny"
+	// FallbackCached 使用相同输入最近一次成功的决策，没有缓存时拒绝
+	FallbackCached FallbackMode = "cached"
+)
+
+// FallbackRevision 是降级决策使用的策略版本标识
+const FallbackRevision = "fallback"
+
+// defaultCacheSize 是 FallbackCached 模式下默认最多缓存的决策数量
+const defaultCacheSize = 10000
+
+// errRetryable 标记可以重试的远程错误
+var errRetryable = errors.New("retryable OPA server error")
+
+// HTTPEngine 通过 REST API 调用远程 OPA 服务评估策略
+type HTTPEngine struct {
+	baseURL string
+	paths   map[string]string
+	opts    *options
+	client  *http.Client
+	cache   *decisionCache
+}
+
+// WithRetries 设置远程调用失败后的重试次数和首次重试的等待时间，之后每次等待时间翻倍
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.retries = retries
+		o.backoff = backoff
+	}
+}
+
+// WithFallback 设置远程 OPA 不可用时的降级方式，cacheTTL 为 FallbackCached 模式下决策的保留时间
+func WithFallback(mode FallbackMode, cacheTTL time.Duration) Option {
+	return func(o *options) {
+		o.fallback = mode
+		o.cacheTTL = cacheTTL
+	}
+}
+
+// WithCacheSize 设置 FallbackCached 模式下最多缓存的决策数量，超过时淘汰最久未使用的决策
+func WithCacheSize(n int) Option {
+	return func(o *options) {
+		o.cacheSize = n
+	}
+}
+
+// WithMaxConns 设置到远程 OPA 的连接池大小
+func WithMaxConns(n int) Option {
+	return func(o *options) {
+		o.maxConns = n
+	}
+}
+
+// NewHTTPEngine 创建远程引擎，baseURL 为 OPA 服务地址，例如 http://127.0.0.1:8181
+func NewHTTPEngine(baseURL string, queries Queries, opts ...Option) (*HTTPEngine, error) {
+	paths := make(map[string]string, len(queries))
+	for name, q := range queries {
+		p, err := dataPath(q)
+		if err != nil {
+			return nil, err
+		}
+		paths[name] = p
+	}
+
+	o := newOptions(opts)
+	switch o.fallback {
+	case "":
+		o.fallback = FallbackError
+	case FallbackError, FallbackDeny, FallbackCached:
+	default:
+		return nil, fmt.Errorf("unknown fallback mode %q", o.fallback)
+	}
+
+	client := o.httpClient
+	if client == nil {
+		client = &http.Client{Transport: newTransport(o.maxConns, o.timeout)}
+	}
+
+	e := &HTTPEngine{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		paths:   paths,
+		opts:    o,
+		client:  client,
+	}
+	if o.fallback == FallbackCached {
+		ttl := o.cacheTTL
+		if ttl <= 0 {
+			ttl = 10 * time.Minute
+		}
+		size := o.cacheSize
+		if size <= 0 {
+			size = defaultCacheSize
+		}
+		e.cache = newDecisionCache(size, ttl)
+	}
+	return e, nil
+}
+
+// newTransport 创建复用连接的 Transport，避免每次评估都重新建立连接。
+// 建立连接和等待响应头的时间与单次评估的超时时间一致，为 0 时只受调用方 context 的限制
+func newTransport(maxConns int, timeout time.Duration) *http.Transport {
+	if maxConns <= 0 {
+		maxConns = 64
+	}
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   timeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          maxConns,
+		MaxIdleConnsPerHost:   maxConns,
+		MaxConnsPerHost:       maxConns,
+		IdleConnTimeout:       90 * time.Second,
+		ResponseHeaderTimeout: timeout,
+		ForceAttemptHTTP2:     true,
+	}
+}
+
+// dataResponse 是 OPA Data API 的响应
+type dataResponse struct {
+	Result     interface{} `json:"result"`
+	DecisionID string      `json:"decision_id"`
+	Provenance *provenance `json:"provenance"`
+	Code       string      `json:"code"`
+	Message    string      `json:"message"`
+}
+
+type provenance struct {
+	Revision string `json:"revision"`
+	Bundles  map[string]struct {
+		Revision string `json:"revision"`
+	} `json:"bundles"`
+}
+
+func (e *HTTPEngine) Eval(ctx context.Context, name string, input interface{}) (*Decision, error) {
+	path, ok := e.paths[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownQuery, name)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"input": input})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal input: %w", err)
+	}
+
+	decision, err := e.evalWithRetry(ctx, path, body)
+	if err == nil {
+		if e.cache != nil {
+			e.cache.set(cacheKey(name, body), *decision)
+		}
+		return decision, nil
+	}
+
+	return e.fallback(name, body, err)
+}
+
+func (e *HTTPEngine) evalWithRetry(ctx context.Context, path string, body []byte) (*Decision, error) {
+	backoff := e.opts.backoff
+	var err error
+	for attempt := 0; ; attempt++ {
+		var decision *Decision
+		decision, err = e.eval(ctx, path, body)
+		if err == nil {
+			return decision, nil
+		}
+		if !errors.Is(err, errRetryable) || attempt >= e.opts.retries || ctx.Err() != nil {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (e *HTTPEngine) eval(ctx context.Context, path string, body []byte) (*Decision, error) {
+	ctx, cancel := withTimeout(ctx, e.opts.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.baseURL+"/v1/data/"+path+"?provenance=true", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.opts.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to call OPA server: %v", errRetryable, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read OPA response: %v", errRetryable, err)
+	}
+
+	var result dataResponse
+	if err := json.Unmarshal(data, &result); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("failed to decode OPA response: %w", err)
+	}
+	if resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests {
+		return nil, fmt.Errorf("%w: OPA server returned %d: %s %s", errRetryable, resp.StatusCode, result.Code, result.Message)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OPA server returned %d: %s %s", resp.StatusCode, result.Code, result.Message)
+	}
+
+	return &Decision{Result: result.Result, Revision: result.Provenance.revision()}, nil
+}
+
+// fallback 在远程 OPA 不可用时按配置的方式降级
+func (e *HTTPEngine) fallback(name string, body []byte, cause error) (*Decision, error) {
+	// 请求本身有问题（例如查询不存在）时不降级，避免掩盖配置错误
+	if !errors.Is(cause, errRetryable) && !errors.Is(cause, context.DeadlineExceeded) {
+		return nil, cause
+	}
+
+	switch e.opts.fallback {
+	case FallbackDeny:
+		log.Printf("OPA server unavailable, denying request: %v", cause)
+		return &Decision{Result: false, Revision: FallbackRevision}, nil
+	case FallbackCached:
+		if decision, ok := e.cache.get(cacheKey(name, body)); ok {
+			log.Printf("OPA server unavailable, using cached decision from revision %s: %v", decision.Revision, cause)
+			decision.Revision = FallbackRevision + ":" + decision.Revision
+			return &decision, nil
+		}
+		log.Printf("OPA server unavailable and no cached decision, denying request: %v", cause)
+		return &Decision{Result: false, Revision: FallbackRevision}, nil
+	default:
+		return nil, cause
+	}
+}
+
+func cacheKey(name string, body []byte) string {
+	sum := sha256.Sum256(body)
+	return name + ":" + hex.EncodeToString(sum[:])
+}
+
+// revision 返回远程策略的版本，多个 bundle 时按名称拼接
+func (p *provenance) revision() string {
+	if p == nil {
+		return ""
+	}
+	if p.Revision != "" {
+		return p.Revision
+	}
+	var revisions []string
+	for name, b := range p.Bundles {
+		revisions = append(revisions, name+"@"+b.Revision)
+	}
+	sort.Strings(revisions)
+	return strings.Join(revisions, ",")
+}
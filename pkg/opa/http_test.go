@@ -0,0 +1,233 @@
+package opa
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeOPA 模拟 OPA 的 Data API：前 failures 次请求返回 status，之后返回 allow=input.user=="alice"
+type fakeOPA struct {
+	status   int
+	failures int32
+	delay    time.Duration // 每次返回响应之前等待的时间
+	calls    atomic.Int32
+	down     atomic.Bool
+}
+
+func (f *fakeOPA) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := f.calls.Add(1)
+	time.Sleep(f.delay)
+	if f.down.Load() || n <= f.failures {
+		w.WriteHeader(f.status)
+		fmt.Fprint(w, `{"code":"internal_error","message":"unavailable"}`)
+		return
+	}
+	if r.URL.Path != "/v1/data/test/allow" || r.URL.Query().Get("provenance") != "true" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"code":"unauthorized","message":"missing token"}`)
+		return
+	}
+
+	var body struct {
+		Input struct {
+			User string `json:"user"`
+		} `json:"input"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"result":     body.Input.User == "alice",
+		"provenance": map[string]interface{}{"bundles": map[string]interface{}{"rbac": map[string]string{"revision": "r7"}}},
+	})
+}
+
+func newTestHTTPEngine(t *testing.T, opa *fakeOPA, opts ...Option) *HTTPEngine {
+	t.Helper()
+	srv := httptest.NewServer(opa)
+	t.Cleanup(srv.Close)
+	opts = append([]Option{WithHeader("Authorization", "Bearer token"), WithRetries(2, time.Millisecond)}, opts...)
+	e, err := NewHTTPEngine(srv.URL, testQueries, opts...)
+	if err != nil {
+		t.Fatalf("NewHTTPEngine: %v", err)
+	}
+	return e
+}
+
+func TestHTTPEngineRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		failures  int32
+		wantErr   bool
+		wantCalls int32
+	}{
+		{"success", http.StatusOK, 0, false, 1},
+		{"recovers after 503", http.StatusServiceUnavailable, 2, false, 3},
+		{"recovers after 429", http.StatusTooManyRequests, 1, false, 2},
+		{"gives up after retries", http.StatusBadGateway, 10, true, 3},
+		{"client error is not retried", http.StatusBadRequest, 10, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opa := &fakeOPA{status: tt.status, failures: tt.failures}
+			e := newTestHTTPEngine(t, opa)
+
+			decision, err := e.Eval(context.Background(), "allow", map[string]string{"user": "alice"})
+			if got := opa.calls.Load(); got != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", got, tt.wantCalls)
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Eval: %v", err)
+			}
+			if allowed, _ := decision.Allowed(); !allowed {
+				t.Error("expected alice to be allowed")
+			}
+			if decision.Revision != "rbac@r7" {
+				t.Errorf("Revision = %q, want rbac@r7", decision.Revision)
+			}
+		})
+	}
+}
+
+func TestHTTPEngineTimeout(t *testing.T) {
+	tests := []struct {
+		name         string
+		timeout      time.Duration
+		delay        time.Duration
+		wantRevision string
+	}{
+		{"response within timeout", time.Second, 50 * time.Millisecond, "rbac@r7"},
+		{"response after timeout", 50 * time.Millisecond, 200 * time.Millisecond, FallbackRevision},
+		// 超过 5s 的超时时间不能被 Transport 截断
+		{"long timeout", 8 * time.Second, 0, "rbac@r7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestHTTPEngine(t, &fakeOPA{delay: tt.delay}, WithTimeout(tt.timeout), WithRetries(0, 0), WithFallback(FallbackDeny, 0))
+			if got := e.client.Transport.(*http.Transport).ResponseHeaderTimeout; got != tt.timeout {
+				t.Errorf("ResponseHeaderTimeout = %v, want %v", got, tt.timeout)
+			}
+			decision, err := e.Eval(context.Background(), "allow", map[string]string{"user": "alice"})
+			if err != nil {
+				t.Fatalf("Eval: %v", err)
+			}
+			if decision.Revision != tt.wantRevision {
+				t.Errorf("Revision = %q, want %q", decision.Revision, tt.wantRevision)
+			}
+		})
+	}
+}
+
+func TestHTTPEngineFallback(t *testing.T) {
+	tests := []struct {
+		name         string
+		mode         FallbackMode
+		warm         bool // OPA 不可用之前是否评估过相同输入
+		wantErr      bool
+		wantAllowed  bool
+		wantRevision string
+	}{
+		{"error", FallbackError, true, true, false, ""},
+		{"deny", FallbackDeny, true, false, false, FallbackRevision},
+		{"cached hit", FallbackCached, true, false, true, FallbackRevision + ":rbac@r7"},
+		{"cached miss denies", FallbackCached, false, false, false, FallbackRevision},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opa := &fakeOPA{status: http.StatusServiceUnavailable}
+			e := newTestHTTPEngine(t, opa, WithFallback(tt.mode, time.Minute))
+			input := map[string]string{"user": "alice"}
+
+			if tt.warm {
+				if _, err := e.Eval(context.Background(), "allow", input); err != nil {
+					t.Fatalf("warm up: %v", err)
+				}
+			}
+			opa.down.Store(true)
+
+			decision, err := e.Eval(context.Background(), "allow", input)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Eval: %v", err)
+			}
+			if allowed, _ := decision.Allowed(); allowed != tt.wantAllowed {
+				t.Errorf("allowed = %v, want %v", allowed, tt.wantAllowed)
+			}
+			if decision.Revision != tt.wantRevision {
+				t.Errorf("Revision = %q, want %q", decision.Revision, tt.wantRevision)
+			}
+		})
+	}
+}
+
+func TestHTTPEngineFallbackIgnoresClientErrors(t *testing.T) {
+	opa := &fakeOPA{}
+	srv := httptest.NewServer(opa)
+	defer srv.Close()
+	// 没有 Authorization 头，OPA 返回 401，这是配置错误，不能被降级掩盖
+	e, err := NewHTTPEngine(srv.URL, testQueries, WithFallback(FallbackDeny, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Eval(context.Background(), "allow", map[string]string{"user": "alice"}); err == nil {
+		t.Fatal("expected error for 401 response")
+	}
+}
+
+func TestDecisionCacheIsBounded(t *testing.T) {
+	c := newDecisionCache(3, time.Minute)
+	for i := 0; i < 10; i++ {
+		c.set(fmt.Sprint(i), Decision{Result: true})
+	}
+	if got := c.len(); got != 3 {
+		t.Fatalf("len = %d, want 3", got)
+	}
+	if _, ok := c.get("0"); ok {
+		t.Error("oldest entry should have been evicted")
+	}
+
+	// 最近读取过的条目不会被淘汰
+	c.get("7")
+	c.set("10", Decision{})
+	if _, ok := c.get("7"); !ok {
+		t.Error("recently used entry was evicted")
+	}
+	if _, ok := c.get("8"); ok {
+		t.Error("least recently used entry should have been evicted")
+	}
+}
+
+func TestDecisionCacheExpires(t *testing.T) {
+	c := newDecisionCache(10, time.Millisecond)
+	c.set("k", Decision{Result: true})
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := c.get("k"); ok {
+		t.Error("expired entry should not be returned")
+	}
+	if got := c.len(); got != 0 {
+		t.Errorf("len = %d, want 0", got)
+	}
+}
@@ -0,0 +1,155 @@
+package opa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/open-policy-agent/opa/bundle"
+	"github.com/open-policy-agent/opa/loader"
+	"github.com/open-policy-agent/opa/rego"
+)
+
+// RegoEngine 在进程内评估 Rego 策略，策略可以在运行时原子地替换
+type RegoEngine struct {
+	queries Queries
+	opts    *options
+	state   atomic.Pointer[regoState]
+}
+
+// regoState 是一组编译好的查询及其对应的策略版本，整体替换以保证一致
+type regoState struct {
+	prepared map[string]rego.PreparedEvalQuery
+	revision string
+}
+
+// NewRegoEngine 使用内存中的策略模块创建引擎，modules 为文件名到策略内容的映射
+func NewRegoEngine(queries Queries, modules map[string]string, revision string, opts ...Option) (*RegoEngine, error) {
+	e := &RegoEngine{queries: queries, opts: newOptions(opts)}
+	if err := e.LoadModules(context.Background(), modules, revision); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// LoadModules 编译新的策略模块并替换当前策略，编译失败时保留原策略
+func (e *RegoEngine) LoadModules(ctx context.Context, modules map[string]string, revision string) error {
+	var loaders []func(*rego.Rego)
+	for name, content := range modules {
+		loaders = append(loaders, rego.Module(name, content))
+	}
+	return e.load(ctx, revision, loaders...)
+}
+
+// Revision 返回当前生效的策略版本
+func (e *RegoEngine) Revision() string {
+	if s := e.state.Load(); s != nil {
+		return s.revision
+	}
+	return ""
+}
+
+func (e *RegoEngine) Eval(ctx context.Context, name string, input interface{}) (*Decision, error) {
+	state := e.state.Load()
+	if state == nil {
+		return nil, fmt.Errorf("policy engine is not initialized")
+	}
+	query, ok := state.prepared[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownQuery, name)
+	}
+
+	ctx, cancel := withTimeout(ctx, e.opts.timeout)
+	defer cancel()
+
+	results, err := query.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate policy: %w", err)
+	}
+
+	decision := &Decision{Revision: state.revision}
+	if len(results) > 0 && len(results[0].Expressions) > 0 {
+		decision.Result = results[0].Expressions[0].Value
+	}
+	return decision, nil
+}
+
+func (e *RegoEngine) load(ctx context.Context, revision string, loaders ...func(*rego.Rego)) error {
+	prepared := make(map[string]rego.PreparedEvalQuery, len(e.queries))
+	for name, q := range e.queries {
+		query, err := rego.New(append([]func(*rego.Rego){rego.Query(q)}, loaders...)...).PrepareForEval(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to prepare query %s: %w", name, err)
+		}
+		prepared[name] = query
+	}
+
+	e.state.Store(&regoState{prepared: prepared, revision: revision})
+	return nil
+}
+
+// FileEngine 从磁盘上的策略文件、目录或 bundle 加载策略。策略由磁盘上的内容决定，
+// 不实现 Reloadable，只能通过 Reload 重新读取
+type FileEngine struct {
+	engine *RegoEngine
+	paths  []string
+	bundle bool
+}
+
+// NewFileEngine 从 .rego/.json 文件或目录加载策略
+func NewFileEngine(queries Queries, paths []string, opts ...Option) (*FileEngine, error) {
+	e := &FileEngine{engine: &RegoEngine{queries: queries, opts: newOptions(opts)}, paths: paths}
+	if err := e.Reload(context.Background()); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// NewBundleEngine 从 bundle 目录或 .tar.gz 文件加载策略，策略版本取自 bundle 的 manifest。
+// 必须通过 WithBundleVerification 提供校验签名的公钥，或者用 WithInsecureSkipBundleVerification 明确跳过校验
+func NewBundleEngine(queries Queries, path string, opts ...Option) (*FileEngine, error) {
+	o := newOptions(opts)
+	if o.bundleKey == nil && !o.skipBundleVerification {
+		return nil, errors.New("bundle verification key is required")
+	}
+	e := &FileEngine{engine: &RegoEngine{queries: queries, opts: o}, paths: []string{path}, bundle: true}
+	if err := e.Reload(context.Background()); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *FileEngine) Eval(ctx context.Context, name string, input interface{}) (*Decision, error) {
+	return e.engine.Eval(ctx, name, input)
+}
+
+// Revision 返回当前生效的策略版本，bundle 引擎为 manifest 中的 revision
+func (e *FileEngine) Revision() string {
+	return e.engine.Revision()
+}
+
+// Reload 重新从磁盘读取策略，读取或校验失败时保留原策略
+func (e *FileEngine) Reload(ctx context.Context) error {
+	if !e.bundle {
+		return e.engine.load(ctx, "", rego.Load(e.paths, nil))
+	}
+
+	fl := loader.NewFileLoader()
+	if o := e.engine.opts; o.skipBundleVerification {
+		fl = fl.WithSkipBundleVerification(true)
+	} else {
+		keys := map[string]*bundle.KeyConfig{o.bundleKeyID: o.bundleKey}
+		fl = fl.WithBundleVerificationConfig(bundle.NewVerificationConfig(keys, o.bundleKeyID, o.bundleKey.Scope, nil))
+	}
+	b, err := fl.AsBundle(e.paths[0])
+	if err != nil {
+		return fmt.Errorf("failed to load bundle %s: %w", e.paths[0], err)
+	}
+	return e.engine.load(ctx, b.Manifest.Revision, rego.ParsedBundle(e.paths[0], b))
+}
+
+var (
+	_ Reloadable   = (*RegoEngine)(nil)
+	_ PolicyEngine = (*FileEngine)(nil)
+)
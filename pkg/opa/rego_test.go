@@ -0,0 +1,110 @@
+package opa
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/bundle"
+)
+
+const testPolicy = `package test
+
+import rego.v1
+
+default allow := false
+
+allow if input.user == "alice"
+`
+
+var testQueries = Queries{"allow": "data.test.allow"}
+
+// writeBundle 把 testPolicy 打包成 .tar.gz，signingKey 不为空时用 HS256 签名
+func writeBundle(t *testing.T, signingKey string) string {
+	t.Helper()
+	b := bundle.Bundle{
+		Manifest: bundle.Manifest{Revision: "v1"},
+		Modules: []bundle.ModuleFile{{
+			URL:    "/test.rego",
+			Path:   "/test.rego",
+			Raw:    []byte(testPolicy),
+			Parsed: ast.MustParseModuleWithOpts(testPolicy, ast.ParserOptions{RegoVersion: ast.RegoV1}),
+		}},
+		Data: map[string]interface{}{},
+	}
+	if signingKey != "" {
+		if err := b.GenerateSignature(bundle.NewSigningConfig(signingKey, "HS256", ""), "default", false); err != nil {
+			t.Fatalf("sign bundle: %v", err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := bundle.NewWriter(f).Write(b); err != nil {
+		t.Fatalf("write bundle: %v", err)
+	}
+	return path
+}
+
+func TestBundleEngineVerification(t *testing.T) {
+	signed := writeBundle(t, "signing-secret")
+	unsigned := writeBundle(t, "")
+
+	tests := []struct {
+		name    string
+		path    string
+		opts    []Option
+		wantErr bool
+	}{
+		{"no key configured", signed, nil, true},
+		{"signed with matching key", signed, []Option{WithBundleVerification("default", "signing-secret", "HS256", "")}, false},
+		{"signed with wrong key", signed, []Option{WithBundleVerification("default", "other-secret", "HS256", "")}, true},
+		{"unsigned rejected", unsigned, []Option{WithBundleVerification("default", "signing-secret", "HS256", "")}, true},
+		{"unsigned with insecure skip", unsigned, []Option{WithInsecureSkipBundleVerification()}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := NewBundleEngine(testQueries, tt.path, tt.opts...)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewBundleEngine: %v", err)
+			}
+			if got := e.Revision(); got != "v1" {
+				t.Errorf("Revision() = %q, want v1", got)
+			}
+			decision, err := e.Eval(context.Background(), "allow", map[string]interface{}{"user": "alice"})
+			if err != nil {
+				t.Fatalf("Eval: %v", err)
+			}
+			if allowed, _ := decision.Allowed(); !allowed {
+				t.Error("expected alice to be allowed")
+			}
+		})
+	}
+}
+
+func TestFileEngineIsNotReloadable(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "test.rego"), []byte(testPolicy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewFileEngine(testQueries, []string{dir})
+	if err != nil {
+		t.Fatalf("NewFileEngine: %v", err)
+	}
+	// 策略由磁盘上的文件决定，不能被数据库中的策略版本替换
+	if _, ok := interface{}(e).(Reloadable); ok {
+		t.Error("FileEngine must not implement Reloadable")
+	}
+}
@@ -0,0 +1,70 @@
+package password
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// BreachedList 判断密码是否出现在已泄露的密码中
+type BreachedList interface {
+	Contains(ctx context.Context, password string) (bool, error)
+}
+
+// RangeDir 是离线的 Pwned Passwords 数据，按 SHA-1 的前 5 位十六进制分为 <PREFIX>.txt 文件，
+// 每行是 SUFFIX:COUNT，与 https://api.pwnedpasswords.com/range/<PREFIX> 的响应格式相同（k-匿名），
+// 可以用 haveibeenpwned-downloader 生成。每次只读取一个前缀对应的文件
+type RangeDir struct {
+	dir string
+	// minCount 是判定为泄露所需的最少出现次数
+	minCount int
+}
+
+func NewRangeDir(dir string, minCount int) (*RangeDir, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, errors.New(dir + " is not a directory")
+	}
+	return &RangeDir{dir: dir, minCount: max(minCount, 1)}, nil
+}
+
+func (d *RangeDir) Contains(ctx context.Context, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	f, err := os.Open(filepath.Join(d.dir, prefix+".txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		s, count, _ := strings.Cut(line, ":")
+		if !strings.EqualFold(s, suffix) {
+			continue
+		}
+		// 没有次数的行视为出现过一次
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			n = 1
+		}
+		return n >= d.minCount, nil
+	}
+	return false, scanner.Err()
+}
@@ -0,0 +1,51 @@
+package password
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/nbutton23/zxcvbn-go"
+)
+
+// ErrPolicyViolation 是密码不符合策略时返回的错误，具体原因附加在错误信息中
+var ErrPolicyViolation = errors.New("密码不符合要求")
+
+// Policy 是设置密码时的要求，零值表示不做限制
+type Policy struct {
+	// MinLength 按字符计算
+	MinLength int
+	// MaxLength 按字节计算，bcrypt 只使用前 72 个字节
+	MaxLength int
+	// MinStrength 是 zxcvbn 评分（0-4）的下限
+	MinStrength int
+	// Breached 不为 nil 时拒绝出现在泄露数据中的密码
+	Breached BreachedList
+}
+
+// Validate 检查密码是否符合策略，userInputs 是用户名、邮箱等不应该出现在密码中的内容
+func (p *Policy) Validate(ctx context.Context, password string, userInputs ...string) error {
+	if p == nil {
+		return nil
+	}
+	if n := utf8.RuneCountInString(password); n < p.MinLength {
+		return fmt.Errorf("%w：长度至少为 %d 个字符", ErrPolicyViolation, p.MinLength)
+	}
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		return fmt.Errorf("%w：长度不能超过 %d 个字节", ErrPolicyViolation, p.MaxLength)
+	}
+	if p.MinStrength > 0 && zxcvbn.PasswordStrength(password, userInputs).Score < p.MinStrength {
+		return fmt.Errorf("%w：密码太容易被猜到，请使用更长或更不常见的密码", ErrPolicyViolation)
+	}
+	if p.Breached != nil {
+		breached, err := p.Breached.Contains(ctx, password)
+		if err != nil {
+			return err
+		}
+		if breached {
+			return fmt.Errorf("%w：该密码出现在已泄露的密码中", ErrPolicyViolation)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package password
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// breachedSet 是内存中的泄露密码列表，err 不为 nil 时查询失败
+type breachedSet struct {
+	passwords map[string]bool
+	err       error
+}
+
+func (b breachedSet) Contains(ctx context.Context, password string) (bool, error) {
+	return b.passwords[password], b.err
+}
+
+func TestPolicyValidate(t *testing.T) {
+	lookupErr := errors.New("lookup failed")
+	breached := breachedSet{passwords: map[string]bool{"correct horse battery staple": true}}
+
+	tests := []struct {
+		name       string
+		policy     *Policy
+		password   string
+		userInputs []string
+		wantErr    error
+	}{
+		{"nil policy", nil, "", nil, nil},
+		{"zero policy", &Policy{}, "a", nil, nil},
+		{"too short", &Policy{MinLength: 8}, "short", nil, ErrPolicyViolation},
+		// 长度按字符计算，多字节字符不会因为字节数多而通过
+		{"multibyte too short", &Policy{MinLength: 8}, "密码密码密码", nil, ErrPolicyViolation},
+		{"min length", &Policy{MinLength: 8}, "12345678", nil, nil},
+		// 最大长度按字节计算，与 bcrypt 的限制一致
+		{"too long in bytes", &Policy{MaxLength: 72}, strings.Repeat("密", 25), nil, ErrPolicyViolation},
+		{"max length", &Policy{MaxLength: 72}, strings.Repeat("a", 72), nil, nil},
+		{"weak", &Policy{MinStrength: 3}, "password1", nil, ErrPolicyViolation},
+		{"contains username", &Policy{MinStrength: 3}, "alice.wonderland", []string{"alice", "wonderland"}, ErrPolicyViolation},
+		{"strong", &Policy{MinStrength: 3}, "tangerine-Quasar-41-ladle", nil, nil},
+		{"breached", &Policy{Breached: breached}, "correct horse battery staple", nil, ErrPolicyViolation},
+		{"not breached", &Policy{Breached: breached}, "tangerine-Quasar-41-ladle", nil, nil},
+		// 查询失败不是策略问题，原样返回
+		{"breached lookup error", &Policy{Breached: breachedSet{err: lookupErr}}, "anything", nil, lookupErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Validate(context.Background(), tt.password, tt.userInputs...)
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil) != (err == nil) {
+				t.Errorf("Validate(%q) = %v, want %v", tt.password, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRangeDir(t *testing.T) {
+	dir := t.TempDir()
+	hash := func(password string) string {
+		sum := sha1.Sum([]byte(password))
+		return strings.ToUpper(hex.EncodeToString(sum[:]))
+	}
+	// 同一个前缀的文件中写入几个不同次数的后缀，后缀不区分大小写
+	leaked, rare, noCount := hash("leaked"), hash("rare"), hash("nocount")
+	files := map[string][]string{
+		leaked[:5]:  {leaked[5:] + ":100"},
+		rare[:5]:    {strings.ToLower(rare[5:]) + ":1"},
+		noCount[:5]: {noCount[5:]},
+	}
+	for prefix, lines := range files {
+		if err := os.WriteFile(filepath.Join(dir, prefix+".txt"), []byte(strings.Join(lines, "\r\n")+"\r\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d, err := NewRangeDir(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{"leaked", true},
+		// 出现次数低于 minCount 的不算泄露
+		{"rare", false},
+		{"nocount", false},
+		{"unknown", false},
+	}
+	for _, tt := range tests {
+		got, err := d.Contains(context.Background(), tt.password)
+		if err != nil || got != tt.want {
+			t.Errorf("Contains(%q) = %v (%v), want %v", tt.password, got, err, tt.want)
+		}
+	}
+
+	if _, err := NewRangeDir(filepath.Join(dir, leaked[:5]+".txt"), 1); err == nil {
+		t.Error("NewRangeDir accepted a file")
+	}
+}
@@ -0,0 +1,35 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// FileProvider 从文件读取密钥，适用于 Kubernetes Secret 挂载和 Docker secrets。
+// 文件末尾的换行符会被去掉
+type FileProvider struct{}
+
+func (FileProvider) Fetch(_ context.Context, path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(data, "\r\n"), nil
+}
+
+// EnvProvider 从环境变量读取密钥
+type EnvProvider struct{}
+
+func (EnvProvider) Fetch(_ context.Context, name string) ([]byte, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return []byte(value), nil
+}
@@ -0,0 +1,159 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotFound 表示密钥不存在
+	ErrNotFound = errors.New("secret not found")
+	// ErrNoProvider 表示引用的来源没有注册，例如没有配置 Vault 时使用了 vault:// 引用
+	ErrNoProvider = errors.New("secret provider not configured")
+)
+
+// reservedSchemes 中的 scheme 总是表示引用，即使对应的 Provider 没有注册也不会被当作字面量
+var reservedSchemes = []string{"file", "env", "vault"}
+
+// Provider 从某一种来源读取密钥，path 是引用中 "scheme://" 之后的部分
+type Provider interface {
+	Fetch(ctx context.Context, path string) ([]byte, error)
+}
+
+// ProviderFunc 把普通函数适配为 Provider
+type ProviderFunc func(ctx context.Context, path string) ([]byte, error)
+
+func (f ProviderFunc) Fetch(ctx context.Context, path string) ([]byte, error) {
+	return f(ctx, path)
+}
+
+// Manager 按引用的 scheme 把请求分发给对应的 Provider，例如：
+//
+//	file:///run/secrets/jwt-secret
+//	env://DB_PASSWORD
+//	vault://secret/gateway#jwt_secret
+//
+// 不是引用的值按字面量处理，兼容直接写在配置里的密钥。file、env 和 vault 是保留的 scheme，
+// 使用了没有注册的保留 scheme 时返回 ErrNoProvider，而不是把引用本身当作密钥
+type Manager struct {
+	interval time.Duration
+
+	mu        sync.RWMutex
+	providers map[string]Provider
+}
+
+// NewManager 创建默认注册了 file 和 env 的 Manager，interval 是 Watch 轮询的间隔
+func NewManager(interval time.Duration) *Manager {
+	m := &Manager{interval: interval, providers: map[string]Provider{}}
+	m.Register("file", FileProvider{})
+	m.Register("env", EnvProvider{})
+	return m
+}
+
+// Register 注册或替换 scheme 对应的 Provider
+func (m *Manager) Register(scheme string, provider Provider) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.providers[scheme] = provider
+}
+
+// IsRef 判断 value 是否是指向已注册来源的引用
+func (m *Manager) IsRef(value string) bool {
+	_, _, ok := m.lookup(value)
+	return ok
+}
+
+// Resolve 读取 ref 指向的密钥，字面量原样返回
+func (m *Manager) Resolve(ctx context.Context, ref string) ([]byte, error) {
+	provider, path, ok := m.lookup(ref)
+	if !ok {
+		if scheme, ok := reservedScheme(ref); ok {
+			return nil, fmt.Errorf("读取密钥 %s 失败: %w: %s", redact(ref), ErrNoProvider, scheme)
+		}
+		return []byte(ref), nil
+	}
+	value, err := provider.Fetch(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("读取密钥 %s 失败: %w", redact(ref), err)
+	}
+	return value, nil
+}
+
+// Watch 读取 ref 当前的值，之后按间隔轮询来源，值变化时调用 onChange。
+// 字面量不会变化，不启动轮询；ctx 取消后停止轮询
+func (m *Manager) Watch(ctx context.Context, ref string, onChange func([]byte)) ([]byte, error) {
+	current, err := m.Resolve(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	if !m.IsRef(ref) || m.interval <= 0 {
+		return current, nil
+	}
+
+	go func() {
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+
+		last := current
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			value, err := m.Resolve(ctx, ref)
+			if err != nil {
+				// 来源暂时不可用时继续使用旧值
+				log.Printf("secrets: %v", err)
+				continue
+			}
+			if bytes.Equal(value, last) {
+				continue
+			}
+			last = value
+			log.Printf("secrets: %s changed, reloading", redact(ref))
+			onChange(value)
+		}
+	}()
+	return current, nil
+}
+
+func (m *Manager) lookup(ref string) (Provider, string, bool) {
+	scheme, path, ok := strings.Cut(ref, "://")
+	if !ok {
+		return nil, "", false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	provider, ok := m.providers[scheme]
+	return provider, path, ok
+}
+
+// reservedScheme 返回 ref 使用的保留 scheme
+func reservedScheme(ref string) (string, bool) {
+	scheme, _, ok := strings.Cut(ref, "://")
+	if !ok {
+		return "", false
+	}
+	for _, s := range reservedSchemes {
+		if scheme == s {
+			return scheme, true
+		}
+	}
+	return "", false
+}
+
+// redact 去掉引用中可能出现的查询参数，日志中只保留来源和路径
+func redact(ref string) string {
+	if i := strings.IndexByte(ref, '?'); i >= 0 {
+		return ref[:i]
+	}
+	return ref
+}
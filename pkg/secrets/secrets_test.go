@@ -0,0 +1,141 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestManagerResolve(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "secret")
+	if err := os.WriteFile(file, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_SECRET", "from-env")
+
+	m := NewManager(0)
+	tests := []struct {
+		name    string
+		ref     string
+		want    string
+		wantErr error
+	}{
+		{"literal", "plain-secret", "plain-secret", nil},
+		{"unknown scheme is literal", "https://example.com", "https://example.com", nil},
+		{"file trims newline", "file://" + file, "from-file", nil},
+		{"missing file", "file://" + filepath.Join(dir, "missing"), "", ErrNotFound},
+		{"env", "env://TEST_SECRET", "from-env", nil},
+		{"missing env", "env://TEST_SECRET_MISSING", "", ErrNotFound},
+		{"vault without provider", "vault://secret/gateway#jwt_secret", "", ErrNoProvider},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := m.Resolve(context.Background(), tt.ref)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Resolve error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Resolve = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestManagerWatch(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(file, []byte("v1"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := make(chan string, 1)
+	m := NewManager(10 * time.Millisecond)
+	current, err := m.Watch(ctx, "file://"+file, func(v []byte) { changed <- string(v) })
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	if string(current) != "v1" {
+		t.Fatalf("Watch = %q, want v1", current)
+	}
+
+	if err := os.WriteFile(file, []byte("v2"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case v := <-changed:
+		if v != "v2" {
+			t.Errorf("onChange got %q, want v2", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("onChange was not called after the file changed")
+	}
+}
+
+func TestVaultProvider(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/gateway":
+			w.Write([]byte(`{"data":{"data":{"jwt_secret":"kv2-secret","value":"default-key"}}}`))
+		case "/v1/kv1/gateway":
+			w.Write([]byte(`{"data":{"jwt_secret":"kv1-secret"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name      string
+		kvVersion int
+		token     string
+		ref       string
+		want      string
+		wantErr   bool
+	}{
+		{"kv2", 2, "root", "secret/gateway#jwt_secret", "kv2-secret", false},
+		{"kv2 default key", 2, "root", "secret/gateway", "default-key", false},
+		{"kv1", 1, "root", "kv1/gateway#jwt_secret", "kv1-secret", false},
+		{"missing key", 2, "root", "secret/gateway#missing", "", true},
+		{"missing path", 2, "root", "secret/other#jwt_secret", "", true},
+		{"bad token", 2, "wrong", "secret/gateway#jwt_secret", "", true},
+		{"invalid path", 2, "root", "secret#jwt_secret", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewVaultProvider(VaultConfig{Address: srv.URL, Token: tt.token, KVVersion: tt.kvVersion, Timeout: time.Second})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := p.Fetch(context.Background(), tt.ref)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Fetch: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Fetch = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
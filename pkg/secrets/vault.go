@@ -0,0 +1,137 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// VaultConfig 是访问 HashiCorp Vault KV 引擎的配置
+type VaultConfig struct {
+	Address string
+	// Token 和 TokenFile 二选一，TokenFile 每次请求都会重新读取，以支持 Vault Agent 轮换 token
+	Token     string
+	TokenFile string
+	Namespace string
+	KVVersion int // 1 或 2，默认为 2
+	Timeout   time.Duration
+}
+
+// VaultProvider 从 Vault KV 引擎读取密钥，引用格式为 vault://<mount>/<path>#<key>，
+// 例如 vault://secret/gateway#jwt_secret。省略 #<key> 时读取 value 字段
+type VaultProvider struct {
+	cfg    VaultConfig
+	client *http.Client
+}
+
+func NewVaultProvider(cfg VaultConfig) (*VaultProvider, error) {
+	if cfg.Address == "" {
+		return nil, fmt.Errorf("vault address is required")
+	}
+	if cfg.Token == "" && cfg.TokenFile == "" {
+		return nil, fmt.Errorf("vault token or token file is required")
+	}
+	if cfg.KVVersion == 0 {
+		cfg.KVVersion = 2
+	}
+	if cfg.KVVersion != 1 && cfg.KVVersion != 2 {
+		return nil, fmt.Errorf("unsupported vault kv version %d", cfg.KVVersion)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	cfg.Address = strings.TrimRight(cfg.Address, "/")
+	return &VaultProvider{cfg: cfg, client: &http.Client{Timeout: cfg.Timeout}}, nil
+}
+
+func (p *VaultProvider) Fetch(ctx context.Context, ref string) ([]byte, error) {
+	path, key, ok := strings.Cut(ref, "#")
+	if !ok {
+		key = "value"
+	}
+	mount, secretPath, ok := strings.Cut(strings.Trim(path, "/"), "/")
+	if !ok || mount == "" || secretPath == "" {
+		return nil, fmt.Errorf("invalid vault secret path %q", path)
+	}
+
+	apiPath := mount + "/" + secretPath
+	if p.cfg.KVVersion == 2 {
+		apiPath = mount + "/data/" + secretPath
+	}
+
+	token, err := p.token()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.cfg.Address+"/v1/"+(&url.URL{Path: apiPath}).EscapedPath(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if p.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.cfg.Namespace)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, path)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault returned %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+
+	var result struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("decode vault response: %w", err)
+	}
+	data := result.Data
+	if p.cfg.KVVersion == 2 {
+		var v2 struct {
+			Data json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(data, &v2); err != nil {
+			return nil, fmt.Errorf("decode vault response: %w", err)
+		}
+		data = v2.Data
+	}
+
+	var values map[string]interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("decode vault response: %w", err)
+	}
+	value, ok := values[key].(string)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s#%s", ErrNotFound, path, key)
+	}
+	return []byte(value), nil
+}
+
+func (p *VaultProvider) token() (string, error) {
+	if p.cfg.TokenFile == "" {
+		return p.cfg.Token, nil
+	}
+	data, err := os.ReadFile(p.cfg.TokenFile)
+	if err != nil {
+		return "", fmt.Errorf("read vault token: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
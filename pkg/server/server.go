@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// Config 是 HTTP 服务的监听、超时和优雅退出配置
+type Config struct {
+	Addr              string
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// ShutdownDelay 是收到退出信号后、停止接受新连接前的等待时间，
+	// 这段时间内 ReadyHandler 返回 503，让负载均衡器把实例摘除
+	ShutdownDelay time.Duration
+	// ShutdownTimeout 是等待进行中的请求完成的最长时间
+	ShutdownTimeout time.Duration
+	// H2C 在未启用 TLS 时支持明文 HTTP/2，适用于前面有 TLS 终止代理的部署
+	H2C bool
+	TLS *TLSConfig
+}
+
+// Server 管理 HTTP 服务的生命周期：启动、证书热加载和收到信号后的优雅退出
+type Server struct {
+	cfg      Config
+	srv      *http.Server
+	certs    *certReloader
+	draining atomic.Bool
+}
+
+func New(cfg Config, handler http.Handler) (*Server, error) {
+	s := &Server{cfg: cfg}
+
+	if cfg.TLS != nil {
+		certs, err := newCertReloader(*cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		s.certs = certs
+	} else if cfg.H2C {
+		handler = h2c.NewHandler(handler, &http2.Server{IdleTimeout: cfg.IdleTimeout})
+	}
+
+	s.srv = &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           handler,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+	if s.certs != nil {
+		s.srv.TLSConfig = s.certs.tlsConfig()
+		// 自定义 TLSConfig 时需要显式启用 HTTP/2
+		if err := http2.ConfigureServer(s.srv, &http2.Server{IdleTimeout: cfg.IdleTimeout}); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Run 启动服务并阻塞到 ctx 取消，然后停止接受新请求并等待进行中的请求完成
+func (s *Server) Run(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.cfg.Addr)
+	if err != nil {
+		return err
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		if s.certs != nil {
+			go s.certs.watch(ctx)
+			log.Printf("server: listening on %s (TLS)", ln.Addr())
+			errCh <- s.srv.Serve(tls.NewListener(ln, s.srv.TLSConfig))
+		} else {
+			log.Printf("server: listening on %s", ln.Addr())
+			errCh <- s.srv.Serve(ln)
+		}
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	// 先标记为不可用，等负载均衡器摘除实例后再关闭监听
+	s.draining.Store(true)
+	log.Printf("server: shutting down, draining for %s", s.cfg.ShutdownDelay)
+	time.Sleep(s.cfg.ShutdownDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+	defer cancel()
+	if err := s.srv.Shutdown(shutdownCtx); err != nil {
+		// 超时后强制关闭剩余连接
+		s.srv.Close()
+		return err
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	log.Printf("server: stopped")
+	return nil
+}
+
+// Draining 返回服务是否正在退出
+func (s *Server) Draining() bool {
+	return s.draining.Load()
+}
+
+// ReadyHandler 是就绪检查接口，服务开始退出后返回 503
+func (s *Server) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.Draining() {
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// freeAddr 返回一个当前没有被占用的本地地址
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// startServer 在后台运行服务，返回取消函数和 Run 的返回值
+func startServer(t *testing.T, cfg Config, mux *http.ServeMux) (context.CancelFunc, <-chan error) {
+	t.Helper()
+	s, err := New(cfg, mux)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux.Handle("/readyz", s.ReadyHandler())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for readyStatus(cfg.Addr) != http.StatusOK {
+		if time.Now().After(deadline) {
+			t.Fatal("server did not become ready")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return cancel, done
+}
+
+// readyStatus 使用新的连接请求就绪检查，连接失败时返回 0
+func readyStatus(addr string) int {
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}, Timeout: time.Second}
+	resp, err := client.Get("http://" + addr + "/readyz")
+	if err != nil {
+		return 0
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// slowHandler 在 release 关闭之前不返回，started 在请求开始处理时关闭
+func slowHandler(started, release chan struct{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "done")
+	}
+}
+
+func TestRunDrainsInFlightRequests(t *testing.T) {
+	cfg := Config{Addr: freeAddr(t), ShutdownDelay: 300 * time.Millisecond, ShutdownTimeout: 5 * time.Second}
+	started, release := make(chan struct{}), make(chan struct{})
+	mux := http.NewServeMux()
+	mux.Handle("/slow", slowHandler(started, release))
+	cancel, done := startServer(t, cfg, mux)
+
+	result := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + cfg.Addr + "/slow")
+		if err == nil {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(body) != "done" {
+				err = errors.New("unexpected body " + string(body))
+			}
+		}
+		result <- err
+	}()
+	<-started
+	cancel()
+
+	// 退出等待期间仍然接受新连接，但就绪检查返回 503
+	deadline := time.Now().Add(cfg.ShutdownDelay)
+	for readyStatus(cfg.Addr) != http.StatusServiceUnavailable {
+		if time.Now().After(deadline) {
+			t.Fatal("readyz did not return 503 while draining")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 进行中的请求完成后 Run 才返回
+	select {
+	case err := <-done:
+		t.Fatalf("Run returned %v before the in-flight request finished", err)
+	case <-time.After(cfg.ShutdownDelay + 100*time.Millisecond):
+	}
+	close(release)
+	if err := <-result; err != nil {
+		t.Errorf("in-flight request: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Run = %v, want nil", err)
+	}
+	if readyStatus(cfg.Addr) != 0 {
+		t.Error("server still accepts connections after Run returned")
+	}
+}
+
+func TestRunShutdownTimeout(t *testing.T) {
+	cfg := Config{Addr: freeAddr(t), ShutdownTimeout: 100 * time.Millisecond}
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	mux := http.NewServeMux()
+	mux.Handle("/slow", slowHandler(started, release))
+	cancel, done := startServer(t, cfg, mux)
+
+	go http.Get("http://" + cfg.Addr + "/slow")
+	<-started
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Run = %v, want %v", err, context.DeadlineExceeded)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after the shutdown timeout")
+	}
+}
+
+func TestRunListenError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	s, err := New(Config{Addr: ln.Addr().String()}, http.NewServeMux())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run(context.Background()); err == nil {
+		t.Error("Run on an address in use returned nil")
+	}
+}
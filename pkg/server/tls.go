@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// TLSConfig 是 TLS 证书和客户端证书校验配置，证书文件变化后自动重新加载
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile 是校验客户端证书使用的 CA，配置后启用 mTLS
+	ClientCAFile string
+	// ClientAuth 控制是否要求客户端证书，只在配置了 ClientCAFile 时生效
+	ClientAuth     tls.ClientAuthType
+	MinVersion     uint16
+	ReloadInterval time.Duration
+}
+
+type certState struct {
+	cert *tls.Certificate
+	// clientConfig 是配置了客户端 CA 时握手使用的 tls.Config，每次加载证书时创建一次
+	clientConfig *tls.Config
+	modTimes     []time.Time
+}
+
+// certReloader 定期检查证书文件的修改时间，变化后重新加载，新的握手使用新证书
+type certReloader struct {
+	cfg   TLSConfig
+	state atomic.Pointer[certState]
+}
+
+// NewTLSConfig 返回证书文件变化后自动重新加载的 tls.Config，供 gRPC 等 HTTP 以外的服务使用，
+// ctx 取消后停止检查证书文件
+func NewTLSConfig(ctx context.Context, cfg TLSConfig) (*tls.Config, error) {
+	r, err := newCertReloader(cfg)
+	if err != nil {
+		return nil, err
+	}
+	go r.watch(ctx)
+	return r.tlsConfig(), nil
+}
+
+func newCertReloader(cfg TLSConfig) (*certReloader, error) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, errors.New("tls cert file and key file are required")
+	}
+	if cfg.MinVersion == 0 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+	r := &certReloader{cfg: cfg}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) files() []string {
+	files := []string{r.cfg.CertFile, r.cfg.KeyFile}
+	if r.cfg.ClientCAFile != "" {
+		files = append(files, r.cfg.ClientCAFile)
+	}
+	return files
+}
+
+func (r *certReloader) load() error {
+	modTimes, err := r.modTimes()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("load tls certificate: %w", err)
+	}
+
+	state := &certState{cert: &cert, modTimes: modTimes}
+	if r.cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.cfg.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("read client ca: %w", err)
+		}
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", r.cfg.ClientCAFile)
+		}
+		// 没有设置会话票据密钥，握手时使用基础配置的密钥，重新加载证书后已有的会话仍然可以恢复
+		state.clientConfig = &tls.Config{
+			MinVersion:     r.cfg.MinVersion,
+			NextProtos:     nextProtos,
+			GetCertificate: r.getCertificate,
+			ClientCAs:      clientCAs,
+			ClientAuth:     r.cfg.ClientAuth,
+		}
+	}
+	r.state.Store(state)
+	return nil
+}
+
+func (r *certReloader) modTimes() ([]time.Time, error) {
+	var times []time.Time
+	for _, f := range r.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		times = append(times, info.ModTime())
+	}
+	return times, nil
+}
+
+func (r *certReloader) changed() bool {
+	times, err := r.modTimes()
+	if err != nil {
+		// 证书轮换过程中文件可能暂时不存在，下次再检查
+		return false
+	}
+	old := r.state.Load().modTimes
+	for i := range times {
+		if !times[i].Equal(old[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *certReloader) watch(ctx context.Context) {
+	if r.cfg.ReloadInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(r.cfg.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !r.changed() {
+			continue
+		}
+		if err := r.load(); err != nil {
+			log.Printf("server: reload tls certificate failed, keeping the old one: %v", err)
+			continue
+		}
+		log.Printf("server: tls certificate reloaded")
+	}
+}
+
+// nextProtos 是服务端支持的 ALPN 协议
+var nextProtos = []string{"h2", "http/1.1"}
+
+// tlsConfig 返回服务端使用的 tls.Config，每次握手都使用最新的证书。
+// 只有配置了客户端 CA 时才通过 GetConfigForClient 切换到最新加载的 CA，
+// 返回的是加载证书时创建好的配置，不会为每次握手创建新的 tls.Config
+func (r *certReloader) tlsConfig() *tls.Config {
+	cfg := &tls.Config{
+		MinVersion:     r.cfg.MinVersion,
+		NextProtos:     nextProtos,
+		GetCertificate: r.getCertificate,
+	}
+	if r.cfg.ClientCAFile != "" {
+		cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.state.Load().clientConfig, nil
+		}
+	}
+	return cfg
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.state.Load().cert, nil
+}
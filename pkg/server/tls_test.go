@@ -0,0 +1,209 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	tls  tls.Certificate
+}
+
+// newTestCert 创建 cn 的证书，parent 为 nil 时创建自签名的 CA
+func newTestCert(t *testing.T, cn string, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, tls: tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}}
+}
+
+// write 把证书和私钥写入 dir，并把修改时间设为 modTime
+func (c *testCert) write(t *testing.T, dir string, modTime time.Time) (certFile, keyFile string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writePEM(t, certFile, "CERTIFICATE", c.cert.Raw, modTime)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER, modTime)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, file, blockType string, der []byte, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serveTLS 接受连接、完成握手后写入一个字节，客户端读取时会收到 TLS 1.3 的会话票据
+func serveTLS(t *testing.T, cfg *tls.Config) string {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if err := conn.(*tls.Conn).Handshake(); err == nil {
+					conn.Write([]byte{1})
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// dial 完成握手并返回连接状态
+func dial(t *testing.T, addr string, cfg *tls.Config) tls.ConnectionState {
+	t.Helper()
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: time.Second}, "tcp", addr, cfg)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Read(make([]byte, 1)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return conn.ConnectionState()
+}
+
+func TestCertReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "ca", nil)
+	client := newTestCert(t, "client", ca)
+	start := time.Now().Add(-time.Minute)
+	caFile := filepath.Join(dir, "ca.pem")
+	writePEM(t, caFile, "CERTIFICATE", ca.cert.Raw, start)
+
+	tests := []struct {
+		name         string
+		clientCAFile string
+		clientCerts  []tls.Certificate
+	}{
+		{"tls", "", nil},
+		{"mtls", caFile, []tls.Certificate{client.tls}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certFile, keyFile := newTestCert(t, "server-1", ca).write(t, dir, start)
+			r, err := newCertReloader(TLSConfig{
+				CertFile: certFile, KeyFile: keyFile, ClientCAFile: tt.clientCAFile,
+				ClientAuth: tls.RequireAndVerifyClientCert, ReloadInterval: 10 * time.Millisecond,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go r.watch(ctx)
+			addr := serveTLS(t, r.tlsConfig())
+
+			roots := x509.NewCertPool()
+			roots.AddCert(ca.cert)
+			clientCfg := &tls.Config{
+				RootCAs: roots, ServerName: "localhost", Certificates: tt.clientCerts,
+				ClientSessionCache: tls.NewLRUClientSessionCache(8),
+			}
+			if got := dial(t, addr, clientCfg).PeerCertificates[0].Subject.CommonName; got != "server-1" {
+				t.Fatalf("server certificate = %s, want server-1", got)
+			}
+			// 基础配置和客户端 CA 的配置共用会话票据密钥，会话可以恢复
+			if !dial(t, addr, clientCfg).DidResume {
+				t.Error("second connection did not resume the session")
+			}
+
+			newTestCert(t, "server-2", ca).write(t, dir, start.Add(time.Second))
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				// 不使用会话缓存，每次都完整握手
+				fresh := clientCfg.Clone()
+				fresh.ClientSessionCache = nil
+				if dial(t, addr, fresh).PeerCertificates[0].Subject.CommonName == "server-2" {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatal("certificate was not reloaded")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			// 重新加载证书后，之前的会话仍然可以恢复
+			if !dial(t, addr, clientCfg).DidResume {
+				t.Error("session was not resumed after the certificate reload")
+			}
+		})
+	}
+}
+
+func TestCertReloadKeepsOldCertOnError(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now().Add(-time.Minute)
+	certFile, keyFile := newTestCert(t, "server-1", nil).write(t, dir, start)
+	r, err := newCertReloader(TLSConfig{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 证书轮换到一半：新证书已经写入，私钥还是旧的
+	writePEM(t, certFile, "CERTIFICATE", newTestCert(t, "server-2", nil).cert.Raw, start.Add(time.Second))
+	if !r.changed() {
+		t.Fatal("changed() = false after the certificate file was modified")
+	}
+	if err := r.load(); err == nil {
+		t.Fatal("load succeeded with a mismatched key")
+	}
+	cert, _ := r.getCertificate(nil)
+	if leaf, _ := x509.ParseCertificate(cert.Certificate[0]); leaf.Subject.CommonName != "server-1" {
+		t.Errorf("certificate after failed reload = %s, want server-1", leaf.Subject.CommonName)
+	}
+}